@@ -0,0 +1,7 @@
+// Package admin implements the operations that change an instance's pools,
+// roles and settings, shared by the HTTP handlers and the command line so
+// both apply the same validation and record the same history.
+//
+// Errors the caller caused are returned as *models.RequestError; a missing
+// pool is reported as store.ErrNotFound unless noted otherwise.
+package admin
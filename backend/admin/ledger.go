@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"pool-party-api/ledgerchain"
+	"pool-party-api/models"
+	"pool-party-api/store"
+)
+
+// VerifyLedger checks the hash chain of one consistent snapshot of the ledger.
+func VerifyLedger(ctx context.Context, s store.Store) (models.LedgerVerification, error) {
+	entries, err := ledgerSnapshot(ctx, s)
+	if err != nil {
+		return models.LedgerVerification{}, err
+	}
+	return ledgerchain.Verify(entries), nil
+}
+
+func ledgerSnapshot(ctx context.Context, s store.Store) ([]models.LedgerEntry, error) {
+	var entries []models.LedgerEntry
+	err := s.WithSnapshot(ctx, func(tx store.Store) error {
+		var err error
+		entries, err = tx.ListLedgerEntries(ctx)
+		return err
+	})
+	return entries, err
+}
+
+// LedgerMonitor verifies the ledger again and again, remembering the head of
+// the chain it last verified. A chain can still verify after entries are
+// removed from its end, or after it is rehashed from some entry on; both are
+// caught by checking that the chain still reaches the last verified head.
+// A LedgerMonitor is not safe for concurrent use.
+type LedgerMonitor struct {
+	checked  int
+	headID   int
+	headHash string
+}
+
+// LedgerRegression is the error Check returns when the chain no longer
+// extends the one it last verified.
+type LedgerRegression struct {
+	Reason string
+}
+
+func (e *LedgerRegression) Error() string {
+	return "ledger hash chain regressed: " + e.Reason
+}
+
+// Check verifies the ledger like VerifyLedger. If the chain verified has fewer
+// entries than last time, or no longer contains the head verified last time
+// with the same hash, it also returns a *LedgerRegression, and keeps
+// comparing with the old head.
+func (m *LedgerMonitor) Check(ctx context.Context, s store.Store) (models.LedgerVerification, error) {
+	entries, err := ledgerSnapshot(ctx, s)
+	if err != nil {
+		return models.LedgerVerification{}, err
+	}
+	result := ledgerchain.Verify(entries)
+
+	if result.Checked < m.checked {
+		return result, &LedgerRegression{Reason: fmt.Sprintf("%d entries verified, down from %d", result.Checked, m.checked)}
+	}
+	if m.checked > 0 {
+		var hash string
+		for _, e := range entries {
+			if e.ID == m.headID {
+				hash = e.Hash
+			}
+		}
+		if hash != m.headHash {
+			return result, &LedgerRegression{Reason: fmt.Sprintf("entry %d, the last verified head, was removed or rehashed", m.headID)}
+		}
+	}
+
+	if result.Checked > 0 {
+		for _, e := range entries {
+			if e.Hash == result.HeadHash {
+				m.headID = e.ID
+			}
+		}
+		m.checked, m.headHash = result.Checked, result.HeadHash
+	}
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"pool-party-api/database"
+	"pool-party-api/migrations"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"testing"
+)
+
+func openSQLite(t *testing.T) (store.Store, *sql.DB) {
+	t.Helper()
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeSQLite
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "pool-party.db")
+	db, err := database.Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("database.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(context.Background(), db, migrations.SQLite); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	return store.NewSQLite(db), db
+}
+
+func TestLedgerMonitor(t *testing.T) {
+	s, db := openSQLite(t)
+	ctx := context.Background()
+	poolID, err := s.CreatePool(ctx, &models.CreateFundingPoolRequest{Name: "Books", GoalAmount: 100, OverflowPolicy: models.OverflowReject})
+	if err != nil {
+		t.Fatalf("CreatePool: %v", err)
+	}
+	deposit := func() int {
+		t.Helper()
+		id, err := s.CreateLedgerEntry(ctx, store.LedgerEntryData{
+			Amount:          10,
+			TransactionType: "deposit",
+			Allocations:     []models.AllocationRequest{{FundingPoolID: poolID, Amount: 10}},
+		})
+		if err != nil {
+			t.Fatalf("CreateLedgerEntry: %v", err)
+		}
+		return id
+	}
+	check := func(m *LedgerMonitor) error {
+		t.Helper()
+		result, err := m.Check(ctx, s)
+		var regression *LedgerRegression
+		if err != nil && !errors.As(err, &regression) {
+			t.Fatalf("Check: %v", err)
+		}
+		if !result.Valid {
+			t.Errorf("Check = %+v, want a valid chain", result)
+		}
+		return err
+	}
+
+	var m LedgerMonitor
+	deposit()
+	deposit()
+	if err := check(&m); err != nil {
+		t.Errorf("first check = %v", err)
+	}
+	last := deposit()
+	if err := check(&m); err != nil {
+		t.Errorf("check after a deposit = %v", err)
+	}
+
+	// Removing the last entry leaves a valid, but shorter, chain.
+	if _, err := db.Exec(`DELETE FROM allocation WHERE ledger_id = $1`, last); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM ledger WHERE id = $1`, last); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(&m); err == nil {
+		t.Error("check after removing the head found nothing")
+	}
+
+	// Appending a new entry in its place restores the length, but not the head.
+	deposit()
+	if err := check(&m); err == nil {
+		t.Error("check after replacing the head found nothing")
+	}
+}
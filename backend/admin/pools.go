@@ -0,0 +1,187 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"pool-party-api/models"
+	"pool-party-api/store"
+)
+
+// ValidatePool checks a pool's editable fields and normalizes its overflow
+// settings: the policy defaults to reject, and an overflow pool is only kept
+// when redirecting.
+func ValidatePool(req *models.CreateFundingPoolRequest) error {
+	if req.Name == "" {
+		return models.NewRequestError("Pool name is required", http.StatusBadRequest)
+	}
+	if req.GoalAmount <= 0 {
+		return models.NewRequestError("Goal amount must be a positive number", http.StatusBadRequest)
+	}
+	if req.CapAmount != nil && *req.CapAmount <= 0 {
+		return models.NewRequestError("Cap amount must be a positive number", http.StatusBadRequest)
+	}
+
+	switch req.OverflowPolicy {
+	case "":
+		req.OverflowPolicy = models.OverflowReject
+		req.OverflowPoolID = nil
+	case models.OverflowReject, models.OverflowSpread:
+		req.OverflowPoolID = nil // Only meaningful when redirecting.
+	case models.OverflowRedirect:
+		if req.OverflowPoolID == nil {
+			return models.NewRequestError("An overflow pool is required to redirect excess donations", http.StatusBadRequest)
+		}
+	default:
+		return models.NewRequestError("Overflow policy must be one of reject, redirect or spread", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// validateOverflowPool checks that a redirect target exists and is not the
+// pool being saved. poolID is 0 when creating a new pool.
+func validateOverflowPool(ctx context.Context, s store.Store, req *models.CreateFundingPoolRequest, poolID int) error {
+	if req.OverflowPoolID == nil {
+		return nil
+	}
+	if *req.OverflowPoolID == poolID {
+		return models.NewRequestError("A pool cannot redirect excess donations to itself", http.StatusBadRequest)
+	}
+
+	exists, err := s.PoolExists(ctx, *req.OverflowPoolID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return models.NewRequestError("Overflow pool not found", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// CreatePool validates and creates a funding pool, recording it in the pool's
+// revision history, and returns its ID.
+func CreatePool(ctx context.Context, s store.Store, moderatorGoogleID string, req *models.CreateFundingPoolRequest) (int, error) {
+	if err := ValidatePool(req); err != nil {
+		return 0, err
+	}
+	if err := validateOverflowPool(ctx, s, req, 0); err != nil {
+		return 0, err
+	}
+
+	var id int
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		var err error
+		id, err = tx.CreatePool(ctx, req)
+		if err != nil {
+			return err
+		}
+		return recordPoolRevision(ctx, tx, id, models.RevisionCreate, moderatorGoogleID, nil, snapshotFromRequest(req, nil))
+	})
+	return id, err
+}
+
+// UpdatePool validates and saves a funding pool's editable fields, recording
+// what changed. It returns store.ErrNotFound if the pool does not exist.
+func UpdatePool(ctx context.Context, s store.Store, moderatorGoogleID string, id int, req *models.CreateFundingPoolRequest) error {
+	if err := ValidatePool(req); err != nil {
+		return err
+	}
+	if err := validateOverflowPool(ctx, s, req, id); err != nil {
+		return err
+	}
+
+	return s.WithTx(ctx, func(tx store.Store) error {
+		before, err := lockPoolSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdatePool(ctx, id, req); err != nil {
+			return err
+		}
+		return recordPoolRevision(ctx, tx, id, models.RevisionUpdate, moderatorGoogleID, before, snapshotFromRequest(req, before))
+	})
+}
+
+// DeletePool deletes a funding pool that has never received an allocation,
+// recording the deletion in its revision history, and returns the deleted
+// pool so the caller can remove its images.
+func DeletePool(ctx context.Context, s store.Store, moderatorGoogleID string, id int) (*store.Pool, error) {
+	var deleted *store.Pool
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		hasAllocations, err := tx.PoolHasAllocations(ctx, id)
+		if err != nil {
+			return err
+		}
+		if hasAllocations {
+			return models.NewRequestError("Cannot delete funding pool with existing donations", http.StatusBadRequest)
+		}
+
+		deleted, err = tx.LockPool(ctx, id)
+		if err == store.ErrNotFound {
+			return models.NewRequestError("Funding pool not found", http.StatusNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.DeletePool(ctx, id); err != nil {
+			return err
+		}
+		return recordPoolRevision(ctx, tx, id, models.RevisionDelete, moderatorGoogleID, snapshotOf(deleted), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// SetPoolArchived archives or restores a funding pool and records the change
+// in its revision history. Doing either twice is a no-op. It returns
+// store.ErrNotFound if the pool does not exist.
+func SetPoolArchived(ctx context.Context, s store.Store, moderatorGoogleID string, id int, archived bool) error {
+	return s.WithTx(ctx, func(tx store.Store) error {
+		before, err := lockPoolSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if before.Archived == archived {
+			return nil
+		}
+
+		action := models.RevisionUnarchive
+		if archived {
+			action = models.RevisionArchive
+		}
+		if err := tx.SetPoolArchived(ctx, id, archived); err != nil {
+			return err
+		}
+
+		after := *before
+		after.Archived = archived
+		return recordPoolRevision(ctx, tx, id, action, moderatorGoogleID, before, &after)
+	})
+}
+
+// SetPoolImage points a pool at new image keys, or clears them when the keys
+// are nil, and records the change in the pool's revision history. It returns
+// the keys that were replaced so the caller can delete those objects, or
+// store.ErrNotFound if the pool does not exist.
+func SetPoolImage(ctx context.Context, s store.Store, moderatorGoogleID string, id int, imageKey, thumbnailKey *string) (*string, *string, error) {
+	var oldImageKey, oldThumbnailKey *string
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		pool, err := tx.LockPool(ctx, id)
+		if err != nil {
+			return err
+		}
+		oldImageKey, oldThumbnailKey = pool.ImageKey, pool.ThumbnailKey
+
+		if err := tx.SetPoolImage(ctx, id, imageKey, thumbnailKey); err != nil {
+			return err
+		}
+
+		before := snapshotOf(pool)
+		after := *before
+		after.ImageKey = imageKey
+		return recordPoolRevision(ctx, tx, id, models.RevisionUpdate, moderatorGoogleID, before, &after)
+	})
+	return oldImageKey, oldThumbnailKey, err
+}
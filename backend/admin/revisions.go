@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"reflect"
+)
+
+// poolSnapshot is the moderator-editable state of a funding pool that is
+// tracked in its revision history.
+type poolSnapshot struct {
+	Name           string   `json:"name"`
+	Description    *string  `json:"description"`
+	GoalAmount     float64  `json:"goal_amount"`
+	CapAmount      *float64 `json:"cap_amount"`
+	OverflowPolicy string   `json:"overflow_policy"`
+	OverflowPoolID *int     `json:"overflow_pool_id"`
+	ImageKey       *string  `json:"image_key"`
+	Archived       bool     `json:"archived"`
+}
+
+// snapshotFromRequest builds the snapshot a pool will have once req is saved,
+// carrying over the fields the request does not edit from current.
+func snapshotFromRequest(req *models.CreateFundingPoolRequest, current *poolSnapshot) *poolSnapshot {
+	s := &poolSnapshot{
+		Name:           req.Name,
+		Description:    req.Description,
+		GoalAmount:     req.GoalAmount,
+		CapAmount:      req.CapAmount,
+		OverflowPolicy: req.OverflowPolicy,
+		OverflowPoolID: req.OverflowPoolID,
+	}
+	if current != nil {
+		s.ImageKey = current.ImageKey
+		s.Archived = current.Archived
+	}
+	return s
+}
+
+// snapshotOf returns the snapshot of a stored pool.
+func snapshotOf(p *store.Pool) *poolSnapshot {
+	return &poolSnapshot{
+		Name:           p.Name,
+		Description:    p.Description,
+		GoalAmount:     p.GoalAmount,
+		CapAmount:      p.CapAmount,
+		OverflowPolicy: p.OverflowPolicy,
+		OverflowPoolID: p.OverflowPoolID,
+		ImageKey:       p.ImageKey,
+		Archived:       p.ArchivedAt != nil,
+	}
+}
+
+// lockPoolSnapshot loads a pool's current snapshot and locks its row until the
+// transaction ends. It returns store.ErrNotFound if the pool does not exist.
+func lockPoolSnapshot(ctx context.Context, tx store.PoolStore, id int) (*poolSnapshot, error) {
+	p, err := tx.LockPool(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotOf(p), nil
+}
+
+// snapshotFields flattens a snapshot into its JSON field values. A nil
+// snapshot, for a pool that does not exist, has no fields.
+func snapshotFields(s *poolSnapshot) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if s == nil {
+		return fields, nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(b, &fields)
+}
+
+// diffPoolSnapshots returns the fields that differ between two snapshots.
+// Pass a nil before snapshot for a creation and a nil after one for a deletion.
+func diffPoolSnapshots(before, after *poolSnapshot) (map[string]models.FieldChange, error) {
+	oldFields, err := snapshotFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := snapshotFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]models.FieldChange)
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok {
+			newFields[name] = nil
+		}
+	}
+	for name, newValue := range newFields {
+		oldValue := oldFields[name]
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes[name] = models.FieldChange{Old: oldValue, New: newValue}
+		}
+	}
+	return changes, nil
+}
+
+// recordPoolRevision appends an entry to a pool's revision history. Updates
+// that change nothing are not recorded.
+func recordPoolRevision(ctx context.Context, tx store.PoolStore, poolID int, action, moderatorGoogleID string, before, after *poolSnapshot) error {
+	changes, err := diffPoolSnapshots(before, after)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && action == models.RevisionUpdate {
+		return nil
+	}
+
+	return tx.RecordPoolRevision(ctx, store.NewPoolRevision{
+		FundingPoolID:     poolID,
+		Action:            action,
+		ModeratorGoogleID: moderatorGoogleID,
+		Changes:           changes,
+	})
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"pool-party-api/store"
+)
+
+// GrantRole grants a role to a user and returns the roles they now hold.
+// Granting a role the user already holds is a no-op and is not logged.
+// Donate-only users cannot hold roles, so granting them one is refused.
+func GrantRole(ctx context.Context, s store.Store, actorGoogleID, targetGoogleID, role string) ([]string, error) {
+	if !auth.ValidRole(role) {
+		return nil, models.NewRequestError("Unknown role", http.StatusBadRequest)
+	}
+
+	var roles []string
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		user, err := tx.GetUser(ctx, targetGoogleID)
+		if err == store.ErrNotFound {
+			return models.NewRequestError("User not found", http.StatusNotFound)
+		} else if err != nil {
+			return err
+		}
+		if user.DonateOnly {
+			return models.NewRequestError("User is restricted to donating and cannot hold roles", http.StatusConflict)
+		}
+
+		granted, err := tx.GrantRole(ctx, targetGoogleID, role, actorGoogleID)
+		if err != nil {
+			return err
+		}
+		if granted {
+			if err := tx.RecordAudit(ctx, actorGoogleID, models.AuditRoleGrant, targetGoogleID, map[string]string{"role": role}); err != nil {
+				return err
+			}
+		}
+
+		roles, err = tx.GrantedRoles(ctx, targetGoogleID)
+		return err
+	})
+	return roles, err
+}
+
+// RevokeRole revokes a role from a user and ends all of their sessions. The
+// last admin who is not donate-only cannot be revoked, so there is always
+// someone left who can manage users.
+func RevokeRole(ctx context.Context, s store.Store, actorGoogleID, targetGoogleID, role string) error {
+	if !auth.ValidRole(role) {
+		return models.NewRequestError("Unknown role", http.StatusBadRequest)
+	}
+
+	return s.WithTx(ctx, func(tx store.Store) error {
+		if auth.Role(role) == auth.RoleAdmin {
+			// Lock the admin grants so two admins cannot revoke each other at once.
+			admins, err := tx.LockRoleHolders(ctx, string(auth.RoleAdmin))
+			if err != nil {
+				return err
+			}
+			if len(admins) == 1 && admins[0] == targetGoogleID {
+				return models.NewRequestError("Cannot revoke the last admin", http.StatusConflict)
+			}
+		}
+
+		revokedRole, err := tx.RevokeRole(ctx, targetGoogleID, role)
+		if err != nil {
+			return err
+		}
+		if !revokedRole {
+			return models.NewRequestError("User does not have this role", http.StatusNotFound)
+		}
+
+		// Sign the user out everywhere, so the revoked role stops applying to
+		// anything they had open, not just to their next login.
+		revoked, err := tx.RevokeUserSessions(ctx, targetGoogleID)
+		if err != nil {
+			return err
+		}
+
+		details := map[string]interface{}{"role": role, "sessions_revoked": revoked}
+		return tx.RecordAudit(ctx, actorGoogleID, models.AuditRoleRevoke, targetGoogleID, details)
+	})
+}
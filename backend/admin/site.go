@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSiteTitleLength is the longest site title the database holds.
+const maxSiteTitleLength = 255
+
+// SetSiteTitle changes the site's title and records the change in the audit
+// log.
+func SetSiteTitle(ctx context.Context, s store.Store, actorGoogleID, title string) error {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return models.NewRequestError("Site title is required", http.StatusBadRequest)
+	}
+	if utf8.RuneCountInString(title) > maxSiteTitleLength {
+		return models.NewRequestError("Site title must be at most 255 characters", http.StatusBadRequest)
+	}
+
+	return s.WithTx(ctx, func(tx store.Store) error {
+		site, err := tx.GetSite(ctx)
+		if err != nil {
+			return err
+		}
+		if site.SiteTitle == title {
+			return nil
+		}
+		if err := tx.SetSiteTitle(ctx, title); err != nil {
+			return err
+		}
+		details := map[string]string{"old_title": site.SiteTitle, "new_title": title}
+		return tx.RecordAudit(ctx, actorGoogleID, models.AuditSiteUpdate, "", details)
+	})
+}
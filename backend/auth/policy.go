@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"pool-party-api/oidc"
+)
+
+// Access is the level of access a login policy grants an account.
+type Access int
+
+const (
+	// AccessDenied refuses the login.
+	AccessDenied Access = iota
+	// AccessDonateOnly lets the user sign in and donate under their name, but
+	// they can never act as a moderator, even if flagged as one.
+	AccessDonateOnly
+	// AccessFull grants normal access.
+	AccessFull
+)
+
+// String returns the configuration name of the access level.
+func (a Access) String() string {
+	switch a {
+	case AccessDenied:
+		return "deny"
+	case AccessDonateOnly:
+		return "donate"
+	case AccessFull:
+		return "full"
+	default:
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+}
+
+// GoogleProvider is the provider name recorded for Google Sign-In
+// identities, whose domain membership is confirmed by the "hd" claim.
+const GoogleProvider = "google"
+
+// LoginPolicy decides who may sign in, based on an allowlist of email domains
+// and addresses. An empty allowlist lets everyone in with full access.
+type LoginPolicy struct {
+	// AllowedDomains are email domains, e.g. "example.com", whose accounts
+	// get full access.
+	AllowedDomains []string `yaml:"allowed_domains"`
+	// AllowedEmails are individual addresses that get full access.
+	AllowedEmails []string `yaml:"allowed_emails"`
+	// Unlisted is the access given to accounts that are not on the
+	// allowlist: AccessDenied or AccessDonateOnly.
+	Unlisted Access `yaml:"unlisted_access"`
+}
+
+// ParseAccess parses the access given to accounts that are not on the
+// allowlist: "deny", the default, or "donate".
+func ParseAccess(s string) (Access, error) {
+	switch s {
+	case "", "deny":
+		return AccessDenied, nil
+	case "donate":
+		return AccessDonateOnly, nil
+	default:
+		return AccessDenied, fmt.Errorf("unlisted access must be \"deny\" or \"donate\", got %q", s)
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using ParseAccess, so the
+// policy can be read from a config file.
+func (a *Access) UnmarshalText(text []byte) error {
+	access, err := ParseAccess(string(text))
+	if err != nil {
+		return err
+	}
+	*a = access
+	return nil
+}
+
+// Normalize trims and lowercases the allowlist and drops empty entries, as
+// Evaluate expects.
+func (p *LoginPolicy) Normalize() {
+	p.AllowedDomains = normalizeList(p.AllowedDomains)
+	p.AllowedEmails = normalizeList(p.AllowedEmails)
+}
+
+func normalizeList(list []string) []string {
+	var items []string
+	for _, item := range list {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Evaluate returns the access granted to an account signing in through
+// provider with the given claims. Only verified email addresses can match the
+// allowlist. For Google accounts, a domain also has to match the "hd" claim,
+// since anyone can create a personal Google account with a company address.
+// A nil policy grants full access to everyone.
+func (p *LoginPolicy) Evaluate(provider string, claims *oidc.Claims) Access {
+	if p == nil || (len(p.AllowedDomains) == 0 && len(p.AllowedEmails) == 0) {
+		return AccessFull
+	}
+	if !claims.EmailVerified {
+		return p.Unlisted
+	}
+
+	email := strings.ToLower(claims.Email)
+	for _, allowed := range p.AllowedEmails {
+		if email == allowed {
+			return AccessFull
+		}
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return p.Unlisted
+	}
+	domain := email[at+1:]
+	for _, allowed := range p.AllowedDomains {
+		if domain != allowed {
+			continue
+		}
+		if provider == GoogleProvider && strings.ToLower(claims.HostedDomain) != allowed {
+			continue
+		}
+		return AccessFull
+	}
+	return p.Unlisted
+}
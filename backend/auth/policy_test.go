@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+
+	"pool-party-api/oidc"
+)
+
+func TestLoginPolicyEvaluate(t *testing.T) {
+	restrictive := &LoginPolicy{
+		AllowedDomains: []string{"example.com"},
+		AllowedEmails:  []string{"contractor@gmail.com"},
+		Unlisted:       AccessDenied,
+	}
+	donateOnly := &LoginPolicy{
+		AllowedDomains: []string{"example.com"},
+		Unlisted:       AccessDonateOnly,
+	}
+
+	tests := []struct {
+		name     string
+		policy   *LoginPolicy
+		provider string
+		claims   oidc.Claims
+		want     Access
+	}{
+		{
+			name:     "nil policy allows everyone",
+			policy:   nil,
+			provider: "google",
+			claims:   oidc.Claims{Email: "anyone@gmail.com"},
+			want:     AccessFull,
+		},
+		{
+			name:     "empty allowlist allows everyone",
+			policy:   &LoginPolicy{Unlisted: AccessDenied},
+			provider: "google",
+			claims:   oidc.Claims{Email: "anyone@gmail.com"},
+			want:     AccessFull,
+		},
+		{
+			name:     "google workspace account in allowed domain",
+			policy:   restrictive,
+			provider: "google",
+			claims:   oidc.Claims{Email: "ada@example.com", EmailVerified: true, HostedDomain: "example.com"},
+			want:     AccessFull,
+		},
+		{
+			name:     "domain match is case insensitive",
+			policy:   restrictive,
+			provider: "google",
+			claims:   oidc.Claims{Email: "Ada@Example.COM", EmailVerified: true, HostedDomain: "example.com"},
+			want:     AccessFull,
+		},
+		{
+			name:     "personal google account using a company address",
+			policy:   restrictive,
+			provider: "google",
+			claims:   oidc.Claims{Email: "ada@example.com", EmailVerified: true},
+			want:     AccessDenied,
+		},
+		{
+			name:     "google account with mismatched hosted domain",
+			policy:   restrictive,
+			provider: "google",
+			claims:   oidc.Claims{Email: "ada@example.com", EmailVerified: true, HostedDomain: "other.com"},
+			want:     AccessDenied,
+		},
+		{
+			name:     "oidc provider does not need hd claim",
+			policy:   restrictive,
+			provider: "okta",
+			claims:   oidc.Claims{Email: "ada@example.com", EmailVerified: true},
+			want:     AccessFull,
+		},
+		{
+			name:     "unverified email never matches",
+			policy:   restrictive,
+			provider: "okta",
+			claims:   oidc.Claims{Email: "ada@example.com", EmailVerified: false},
+			want:     AccessDenied,
+		},
+		{
+			name:     "subdomain does not match",
+			policy:   restrictive,
+			provider: "okta",
+			claims:   oidc.Claims{Email: "ada@evil.example.com", EmailVerified: true},
+			want:     AccessDenied,
+		},
+		{
+			name:     "lookalike domain does not match",
+			policy:   restrictive,
+			provider: "okta",
+			claims:   oidc.Claims{Email: "ada@notexample.com", EmailVerified: true},
+			want:     AccessDenied,
+		},
+		{
+			name:     "individually allowed address",
+			policy:   restrictive,
+			provider: "google",
+			claims:   oidc.Claims{Email: "contractor@gmail.com", EmailVerified: true},
+			want:     AccessFull,
+		},
+		{
+			name:     "unlisted address is refused",
+			policy:   restrictive,
+			provider: "google",
+			claims:   oidc.Claims{Email: "stranger@gmail.com", EmailVerified: true},
+			want:     AccessDenied,
+		},
+		{
+			name:     "unlisted address may donate",
+			policy:   donateOnly,
+			provider: "google",
+			claims:   oidc.Claims{Email: "stranger@gmail.com", EmailVerified: true},
+			want:     AccessDonateOnly,
+		},
+		{
+			name:     "unverified address may donate",
+			policy:   donateOnly,
+			provider: "google",
+			claims:   oidc.Claims{Email: "ada@example.com", HostedDomain: "example.com"},
+			want:     AccessDonateOnly,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Evaluate(tt.provider, &tt.claims); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginPolicyConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		domains     []string
+		emails      []string
+		unlisted    string
+		wantDomains []string
+		wantEmails  []string
+		wantAccess  Access
+		wantErr     bool
+	}{
+		{name: "defaults", wantAccess: AccessDenied},
+		{
+			name:        "lists are trimmed and lowercased",
+			domains:     []string{" Example.com", "example.org ", ""},
+			emails:      []string{"Contractor@Gmail.com"},
+			unlisted:    "donate",
+			wantDomains: []string{"example.com", "example.org"},
+			wantEmails:  []string{"contractor@gmail.com"},
+			wantAccess:  AccessDonateOnly,
+		},
+		{name: "invalid unlisted access", unlisted: "maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := LoginPolicy{AllowedDomains: tt.domains, AllowedEmails: tt.emails}
+			err := p.Unlisted.UnmarshalText([]byte(tt.unlisted))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalText(%q) error = %v, wantErr %v", tt.unlisted, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			p.Normalize()
+			if !slices.Equal(p.AllowedDomains, tt.wantDomains) || !slices.Equal(p.AllowedEmails, tt.wantEmails) || p.Unlisted != tt.wantAccess {
+				t.Errorf("normalized policy = %+v", p)
+			}
+		})
+	}
+}
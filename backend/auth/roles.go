@@ -0,0 +1,81 @@
+package auth
+
+import "sort"
+
+// Permission is a single action a user may be allowed to perform.
+type Permission string
+
+const (
+	// PermViewAdmin allows reading the user list and the audit log.
+	PermViewAdmin Permission = "view_admin"
+	// PermWithdraw allows recording withdrawals from pools.
+	PermWithdraw Permission = "withdraw"
+	// PermExternalDonation allows recording cash and other off-site donations.
+	PermExternalDonation Permission = "external_donation"
+	// PermManagePools allows creating, editing, archiving and deleting pools.
+	PermManagePools Permission = "manage_pools"
+	// PermManageUsers allows granting and revoking roles.
+	PermManageUsers Permission = "manage_users"
+)
+
+// Role is a named bundle of permissions that can be granted to a user.
+type Role string
+
+const (
+	RoleViewer      Role = "viewer"
+	RoleTreasurer   Role = "treasurer"
+	RolePoolManager Role = "pool_manager"
+	RoleAdmin       Role = "admin"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:      {PermViewAdmin},
+	RoleTreasurer:   {PermViewAdmin, PermWithdraw, PermExternalDonation},
+	RolePoolManager: {PermViewAdmin, PermManagePools},
+	RoleAdmin:       {PermViewAdmin, PermWithdraw, PermExternalDonation, PermManagePools, PermManageUsers},
+}
+
+// Roles returns every known role, sorted by name.
+func Roles() []Role {
+	roles := make([]Role, 0, len(rolePermissions))
+	for role := range rolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i] < roles[j] })
+	return roles
+}
+
+// ValidRole reports whether name is a known role.
+func ValidRole(name string) bool {
+	_, ok := rolePermissions[Role(name)]
+	return ok
+}
+
+// PermissionsFor returns the sorted, de-duplicated permissions granted by
+// the given roles. Unknown roles grant nothing.
+func PermissionsFor(roles []Role) []Permission {
+	seen := make(map[Permission]bool)
+	perms := []Permission{}
+	for _, role := range roles {
+		for _, perm := range rolePermissions[role] {
+			if !seen[perm] {
+				seen[perm] = true
+				perms = append(perms, perm)
+			}
+		}
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i] < perms[j] })
+	return perms
+}
+
+// HasPermission reports whether any of the roles grants perm.
+func HasPermission(roles []Role, perm Permission) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
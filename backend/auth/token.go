@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// tokenPrefix marks personal access tokens, so leaked tokens are easy to
+// recognise in logs and by secret scanners.
+const tokenPrefix = "ppt_"
+
+// TokenScopes are the permissions a personal access token may carry. Managing
+// users and viewing admin data are deliberately left to signed-in sessions.
+var TokenScopes = []Permission{PermWithdraw, PermExternalDonation, PermManagePools}
+
+// ValidTokenScope reports whether name is a scope tokens may be given.
+func ValidTokenScope(name string) bool {
+	for _, scope := range TokenScopes {
+		if string(scope) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewToken generates a personal access token. Only the hash is meant to be
+// stored; the token itself is shown to the user once. The returned display
+// prefix identifies the token in listings without revealing it.
+func NewToken() (token, hash, display string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), token[:len(tokenPrefix)+6], nil
+}
+
+// HashToken returns the hex-encoded SHA-256 hash under which a token is
+// stored. Tokens carry 256 bits of randomness, so a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeToken reports whether s has the shape of a personal access token.
+func LooksLikeToken(s string) bool {
+	return strings.HasPrefix(s, tokenPrefix) && len(s) > len(tokenPrefix)
+}
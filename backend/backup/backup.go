@@ -0,0 +1,309 @@
+// Package backup copies an instance's data to a portable, versioned JSON
+// archive and restores one into an empty database, so an instance can be
+// moved between hosts or between Postgres and SQLite.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"pool-party-api/auth"
+	"pool-party-api/ledgerchain"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"sort"
+	"time"
+)
+
+// ErrNotEmpty is returned when importing into a database that already has
+// users, pools or ledger entries.
+var ErrNotEmpty = errors.New("the database is not empty; archives are only restored into an empty one")
+
+// Export reads the instance's data from one consistent snapshot.
+func Export(ctx context.Context, s store.Store) (*models.BackupArchive, error) {
+	a := &models.BackupArchive{
+		Format:    models.BackupFormat,
+		Version:   models.BackupVersion,
+		CreatedAt: time.Now().UTC(),
+	}
+	err := s.WithSnapshot(ctx, func(tx store.Store) error {
+		site, err := tx.GetSite(ctx)
+		if err != nil {
+			return err
+		}
+		a.Site = *site
+
+		pools, err := tx.ListPools(ctx, true)
+		if err != nil {
+			return err
+		}
+		a.Pools = make([]models.BackupPool, 0, len(pools))
+		for _, p := range pools {
+			a.Pools = append(a.Pools, models.BackupPool{FundingPool: p.FundingPool, ImageKey: p.ImageKey, ThumbnailKey: p.ThumbnailKey})
+		}
+
+		if a.Users, err = tx.BackupUsers(ctx); err != nil {
+			return err
+		}
+
+		entries, err := tx.ListLedgerEntries(ctx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+		a.Ledger = make([]models.BackupLedgerEntry, 0, len(entries))
+		for _, e := range entries {
+			a.Ledger = append(a.Ledger, models.BackupLedgerEntry{LedgerEntry: e, DonorSalt: e.DonorSalt})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Write encodes an archive as indented JSON.
+func Write(w io.Writer, a *models.BackupArchive) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+// Read decodes an archive. It does not validate it.
+func Read(r io.Reader) (*models.BackupArchive, error) {
+	var a models.BackupArchive
+	if err := json.NewDecoder(r).Decode(&a); err != nil {
+		return nil, fmt.Errorf("could not read archive: %w", err)
+	}
+	return &a, nil
+}
+
+// Validate checks that an archive can be restored: that it is a backup this
+// version reads, and that every reference in it resolves within it. All
+// problems found are reported together.
+func Validate(a *models.BackupArchive) error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if a.Format != models.BackupFormat {
+		fail("not a backup archive (format %q)", a.Format)
+		return errors.Join(errs...)
+	}
+	// Version 1 archives are version 2 archives without donor commitments.
+	if a.Version < 1 || a.Version > models.BackupVersion {
+		fail("unsupported archive version %d (this server reads versions 1 to %d)", a.Version, models.BackupVersion)
+		return errors.Join(errs...)
+	}
+	if a.Site.SiteTitle == "" {
+		fail("site: title is empty")
+	}
+
+	pools := make(map[int]bool)
+	poolNames := make(map[string]bool)
+	for _, p := range a.Pools {
+		if p.ID <= 0 {
+			fail("pool %q: invalid ID %d", p.Name, p.ID)
+		} else if pools[p.ID] {
+			fail("pool %d: duplicate ID", p.ID)
+		}
+		pools[p.ID] = true
+		if p.Name == "" {
+			fail("pool %d: name is empty", p.ID)
+		} else if poolNames[p.Name] {
+			fail("pool %d: duplicate name %q", p.ID, p.Name)
+		}
+		poolNames[p.Name] = true
+		switch p.OverflowPolicy {
+		case models.OverflowReject, models.OverflowRedirect, models.OverflowSpread:
+		default:
+			fail("pool %d: unknown overflow policy %q", p.ID, p.OverflowPolicy)
+		}
+	}
+	for _, p := range a.Pools {
+		if p.OverflowPoolID != nil && !pools[*p.OverflowPoolID] {
+			fail("pool %d: overflow pool %d is not in the archive", p.ID, *p.OverflowPoolID)
+		}
+	}
+
+	users := make(map[string]bool)
+	emails := make(map[string]bool)
+	identities := make(map[string]bool)
+	for _, u := range a.Users {
+		if u.GoogleID == "" {
+			fail("user %q: ID is empty", u.Email)
+		} else if users[u.GoogleID] {
+			fail("user %s: duplicate ID", u.GoogleID)
+		}
+		users[u.GoogleID] = true
+		if u.Email == "" {
+			fail("user %s: email is empty", u.GoogleID)
+		} else if emails[u.Email] {
+			fail("user %s: duplicate email %q", u.GoogleID, u.Email)
+		}
+		emails[u.Email] = true
+		for _, i := range u.Identities {
+			key := i.Provider + "\x00" + i.Subject
+			if identities[key] {
+				fail("user %s: identity %s/%s belongs to more than one user", u.GoogleID, i.Provider, i.Subject)
+			}
+			identities[key] = true
+		}
+		roles := make(map[string]bool)
+		for _, r := range u.Roles {
+			if !auth.ValidRole(r.Role) {
+				fail("user %s: unknown role %q", u.GoogleID, r.Role)
+			} else if roles[r.Role] {
+				fail("user %s: role %q granted twice", u.GoogleID, r.Role)
+			}
+			roles[r.Role] = true
+		}
+	}
+
+	entries := make(map[int]bool)
+	allocations := make(map[int]bool)
+	for _, e := range a.Ledger {
+		if e.ID <= 0 {
+			fail("ledger entry: invalid ID %d", e.ID)
+		} else if entries[e.ID] {
+			fail("ledger entry %d: duplicate ID", e.ID)
+		}
+		entries[e.ID] = true
+		switch e.TransactionType {
+		case "deposit", "withdrawal":
+		case "anonymized":
+			if e.AnonymizedLedgerID == nil || !entries[*e.AnonymizedLedgerID] || *e.AnonymizedLedgerID >= e.ID {
+				fail("ledger entry %d: anonymizes no earlier entry in the archive", e.ID)
+			}
+		default:
+			fail("ledger entry %d: unknown transaction type %q", e.ID, e.TransactionType)
+		}
+		if e.UserGoogleID != nil && !users[*e.UserGoogleID] {
+			fail("ledger entry %d: user %s is not in the archive", e.ID, *e.UserGoogleID)
+		}
+		for _, alloc := range e.Allocations {
+			if alloc.ID <= 0 || allocations[alloc.ID] {
+				fail("ledger entry %d: invalid or duplicate allocation ID %d", e.ID, alloc.ID)
+			}
+			allocations[alloc.ID] = true
+			if !pools[alloc.FundingPoolID] {
+				fail("ledger entry %d: pool %d is not in the archive", e.ID, alloc.FundingPoolID)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Result summarizes a restore.
+type Result struct {
+	Pools         int
+	Users         int
+	LedgerEntries int
+	// Ledger is the restored ledger's hash chain, which matches the archive's.
+	// It is not valid if the chain was already broken when the backup was
+	// taken.
+	Ledger models.LedgerVerification
+}
+
+// Import validates an archive and restores it into s, which must be empty.
+// After restoring, it checks in the same transaction that every pool's
+// balance and the ledger's hash chain came out as archived, and rolls back
+// if not.
+func Import(ctx context.Context, s store.Store, a *models.BackupArchive) (*Result, error) {
+	if err := Validate(a); err != nil {
+		return nil, fmt.Errorf("invalid archive:\n%w", err)
+	}
+	want := ledgerchain.Verify(archivedEntries(a))
+
+	result := &Result{Pools: len(a.Pools), Users: len(a.Users), LedgerEntries: len(a.Ledger)}
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		empty, err := tx.IsEmpty(ctx)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return ErrNotEmpty
+		}
+
+		if err := tx.Restore(ctx, a); err != nil {
+			return fmt.Errorf("restore failed: %w", err)
+		}
+		if err := checkBalances(ctx, tx, a); err != nil {
+			return err
+		}
+
+		entries, err := tx.ListLedgerEntries(ctx)
+		if err != nil {
+			return err
+		}
+		result.Ledger = ledgerchain.Verify(entries)
+		if !sameVerification(result.Ledger, want) {
+			return fmt.Errorf("restored ledger chain differs from the archive's (head %s, want %s)", result.Ledger.HeadHash, want.HeadHash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// archivedEntries returns the archive's ledger entries with their salts.
+func archivedEntries(a *models.BackupArchive) []models.LedgerEntry {
+	entries := make([]models.LedgerEntry, 0, len(a.Ledger))
+	for _, e := range a.Ledger {
+		entry := e.LedgerEntry
+		entry.DonorSalt = e.DonorSalt
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// checkBalances compares each restored pool's balance to the archived one,
+// and the maintained balances to the ledger.
+func checkBalances(ctx context.Context, tx store.Store, a *models.BackupArchive) error {
+	pools, err := tx.ListPools(ctx, true)
+	if err != nil {
+		return err
+	}
+	restored := make(map[int]float64, len(pools))
+	for _, p := range pools {
+		restored[p.ID] = p.CurrentAmount
+	}
+
+	var errs []error
+	for _, p := range a.Pools {
+		if cents(restored[p.ID]) != cents(p.CurrentAmount) {
+			errs = append(errs, fmt.Errorf("pool %d: restored balance %.2f, archived %.2f", p.ID, restored[p.ID], p.CurrentAmount))
+		}
+	}
+	drift, err := tx.CheckPoolBalances(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		errs = append(errs, fmt.Errorf("pool %d: balance %.2f does not match its ledger total %.2f", d.FundingPoolID, d.Stored, d.Ledger))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("balances do not match after restore:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// sameVerification reports whether two verifications found the same chain.
+func sameVerification(a, b models.LedgerVerification) bool {
+	if a.Valid != b.Valid || a.Checked != b.Checked || a.HeadHash != b.HeadHash {
+		return false
+	}
+	return (a.Broken == nil) == (b.Broken == nil) && (a.Broken == nil || *a.Broken == *b.Broken)
+}
+
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
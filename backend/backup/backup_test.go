@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"pool-party-api/database"
+	"pool-party-api/migrations"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func openSQLite(t *testing.T) store.Store {
+	t.Helper()
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeSQLite
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "pool-party.db")
+	db, err := database.Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("database.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(context.Background(), db, migrations.SQLite); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	return store.NewSQLite(db)
+}
+
+// populate fills s with a little of everything a backup holds.
+func populate(t *testing.T, s store.Store) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.UpsertUser(ctx, store.User{GoogleID: "g1", Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace"}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	if err := s.UpsertIdentity(ctx, "google", "g1", "g1", "ada@example.com"); err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if _, err := s.GrantRole(ctx, "g1", "treasurer", ""); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	snacks, err := s.CreatePool(ctx, &models.CreateFundingPoolRequest{Name: "Snacks", GoalAmount: 50, OverflowPolicy: models.OverflowReject})
+	if err != nil {
+		t.Fatalf("CreatePool: %v", err)
+	}
+	books, err := s.CreatePool(ctx, &models.CreateFundingPoolRequest{Name: "Books", GoalAmount: 100, OverflowPolicy: models.OverflowRedirect, OverflowPoolID: &snacks})
+	if err != nil {
+		t.Fatalf("CreatePool: %v", err)
+	}
+	if err := s.SetPoolArchived(ctx, snacks, true); err != nil {
+		t.Fatalf("SetPoolArchived: %v", err)
+	}
+	entries := []store.LedgerEntryData{
+		{Amount: 10.3, TransactionType: "deposit", UserGoogleID: sql.NullString{String: "g1", Valid: true}, FirstName: sql.NullString{String: "Ada", Valid: true},
+			TransactionID: sql.NullString{String: "PAY-1", Valid: true}, Allocations: []models.AllocationRequest{{FundingPoolID: books, Amount: 10.1}, {FundingPoolID: snacks, Amount: 0.2}}},
+		{Amount: 2.5, TransactionType: "withdrawal", Description: sql.NullString{String: "Paperbacks", Valid: true}, Allocations: []models.AllocationRequest{{FundingPoolID: books, Amount: 2.5}}},
+	}
+	for _, e := range entries {
+		if _, err := s.CreateLedgerEntry(ctx, e); err != nil {
+			t.Fatalf("CreateLedgerEntry: %v", err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	stores := []struct {
+		name string
+		open func(t *testing.T) store.Store
+	}{
+		{"Memory", func(t *testing.T) store.Store { return store.NewMemory() }},
+		{"SQLite", openSQLite},
+	}
+	for _, from := range stores {
+		for _, to := range stores {
+			t.Run(from.name+"To"+to.name, func(t *testing.T) {
+				src := from.open(t)
+				populate(t, src)
+				want, err := Export(ctx, src)
+				if err != nil {
+					t.Fatalf("Export: %v", err)
+				}
+
+				var buf bytes.Buffer
+				if err := Write(&buf, want); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+				archive, err := Read(&buf)
+				if err != nil {
+					t.Fatalf("Read: %v", err)
+				}
+
+				dst := to.open(t)
+				result, err := Import(ctx, dst, archive)
+				if err != nil {
+					t.Fatalf("Import: %v", err)
+				}
+				if result.Pools != 2 || result.Users != 1 || result.LedgerEntries != 2 || !result.Ledger.Valid {
+					t.Errorf("Import = %+v", result)
+				}
+
+				got, err := Export(ctx, dst)
+				if err != nil {
+					t.Fatalf("Export after import: %v", err)
+				}
+				var wantJSON, gotJSON bytes.Buffer
+				got.CreatedAt = want.CreatedAt
+				Write(&wantJSON, want)
+				Write(&gotJSON, got)
+				if wantJSON.String() != gotJSON.String() {
+					t.Errorf("restored archive differs:\ngot  %s\nwant %s", gotJSON.String(), wantJSON.String())
+				}
+
+				// A database with data in it is never overwritten.
+				if _, err := Import(ctx, dst, archive); !errors.Is(err, ErrNotEmpty) {
+					t.Errorf("Import into a restored database error = %v, want ErrNotEmpty", err)
+				}
+			})
+		}
+	}
+}
+
+func TestImportRollsBackOnMismatch(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewMemory()
+	populate(t, src)
+	archive, err := Export(ctx, src)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	archive.Pools[0].CurrentAmount += 1
+
+	dst := openSQLite(t)
+	if _, err := Import(ctx, dst, archive); err == nil || !strings.Contains(err.Error(), "balances do not match") {
+		t.Fatalf("Import error = %v, want a balance mismatch", err)
+	}
+	if empty, err := dst.IsEmpty(ctx); err != nil || !empty {
+		t.Errorf("IsEmpty after a failed import = %v, %v; want it rolled back", empty, err)
+	}
+}
+
+// An archive taken after an account was deleted restores the erased donors'
+// commitments, so its chain still verifies.
+func TestRoundTripAnonymized(t *testing.T) {
+	ctx := context.Background()
+	src := store.NewMemory()
+	populate(t, src)
+	if _, err := src.AnonymizeUserLedger(ctx, "g1"); err != nil {
+		t.Fatalf("AnonymizeUserLedger: %v", err)
+	}
+	archive, err := Export(ctx, src)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if archive.Ledger[0].DonorSalt != "" || archive.Ledger[1].DonorSalt == "" {
+		t.Errorf("archived salts = %q, %q; want only the erased donor's gone", archive.Ledger[0].DonorSalt, archive.Ledger[1].DonorSalt)
+	}
+
+	result, err := Import(ctx, openSQLite(t), archive)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if result.LedgerEntries != 3 || !result.Ledger.Valid || result.Ledger.Checked != 3 {
+		t.Errorf("Import = %+v, want 3 valid entries", result)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(&models.BackupArchive{Format: "something-else"}); err == nil {
+		t.Error("Validate accepted another format")
+	}
+	if err := Validate(&models.BackupArchive{Format: models.BackupFormat, Version: models.BackupVersion + 1}); err == nil {
+		t.Error("Validate accepted a newer version")
+	}
+
+	src := store.NewMemory()
+	populate(t, src)
+	a, err := Export(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if err := Validate(a); err != nil {
+		t.Fatalf("Validate(export) = %v", err)
+	}
+
+	a.Pools[1].OverflowPoolID = new(int)
+	a.Users = append(a.Users, a.Users[0])
+	a.Users[0].Roles[0].Role = "owner"
+	a.Ledger[0].Allocations[0].FundingPoolID = 99
+	a.Ledger[1].TransactionType = "refund"
+	later := a.Ledger[1].ID + 1
+	a.Ledger = append(a.Ledger, models.BackupLedgerEntry{LedgerEntry: models.LedgerEntry{ID: later + 1, TransactionType: "anonymized", AnonymizedLedgerID: &later}})
+	err = Validate(a)
+	if err == nil {
+		t.Fatal("Validate accepted a broken archive")
+	}
+	var problems []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		problems = append(problems, e.Error())
+	}
+	want := []string{
+		fmt.Sprintf("pool %d: overflow pool 0 is not in the archive", a.Pools[1].ID),
+		"user g1: unknown role \"owner\"",
+		"user g1: duplicate ID",
+		"user g1: duplicate email \"ada@example.com\"",
+		"user g1: identity google/g1 belongs to more than one user",
+		"user g1: unknown role \"owner\"",
+		fmt.Sprintf("ledger entry %d: pool 99 is not in the archive", a.Ledger[0].ID),
+		fmt.Sprintf("ledger entry %d: unknown transaction type \"refund\"", a.Ledger[1].ID),
+		fmt.Sprintf("ledger entry %d: anonymizes no earlier entry in the archive", later+1),
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("Validate problems =\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"pool-party-api/admin"
+	"pool-party-api/backup"
+	"pool-party-api/config"
+	"pool-party-api/migrations"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// instance is the loaded configuration, an open database and the store over
+// it, shared by the commands.
+type instance struct {
+	config  *config.Config
+	db      *sql.DB
+	dialect migrations.Dialect
+	store   store.Store
+}
+
+// commands maps each command name to its implementation. Changes made from
+// the command line go through the same admin package as the API, and are
+// recorded in the audit log and revision history without an actor.
+var commands = map[string]func(inst *instance, args []string) error{
+	"serve":   runServe,
+	"migrate": runMigrate,
+	"backup":  runBackup,
+	"user":    runUser,
+	"pool":    runPool,
+	"ledger":  runLedger,
+	"site":    runSite,
+}
+
+const usage = `Usage: pool-party [flags] <command> [arguments]
+
+Commands:
+  serve                          serve the API and the frontend (the default)
+  migrate [up|down [N]|status]   manage the database schema
+  backup export [FILE]           write a backup archive (default stdout)
+  backup import FILE             restore an archive into an empty database
+  user list                      list users and their roles
+  user promote USER ROLE         grant a role to a user, by email or Google ID
+  user demote USER ROLE          revoke a role from a user
+  pool create -name NAME -goal AMOUNT [-cap AMOUNT] [-description TEXT]
+              [-overflow reject|redirect|spread] [-overflow-pool ID]
+                                 create a funding pool
+  pool archive ID                archive a funding pool
+  ledger verify                  check the ledger's hash chain
+  ledger export [FILE]           write the ledger as JSON, oldest first
+  site set-title TITLE           change the site's title
+
+Flags:
+` + config.Flags + `
+Settings are read from the config file, then the environment, then the
+flags, each overriding the last. All commands share the database settings.
+`
+
+// runMigrate implements the "migrate" command:
+//
+//	migrate [up]      apply all pending migrations
+//	migrate down [N]  roll back the last N migrations (default 1)
+//	migrate status    list migrations and when they were applied
+func runMigrate(inst *instance, args []string) error {
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		n, err := migrations.Up(ctx, inst.db, inst.dialect)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s).\n", n)
+	case "down":
+		steps, err := downSteps(args[1:])
+		if err != nil {
+			return err
+		}
+		n, err := migrations.Down(ctx, inst.db, inst.dialect, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d migration(s).\n", n)
+	case "status":
+		statuses, err := migrations.List(ctx, inst.db, inst.dialect)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or status)", command)
+	}
+	return nil
+}
+
+// downSteps returns how many migrations "migrate down" should roll back,
+// given the arguments after "down".
+func downSteps(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	if len(args) > 1 {
+		return 0, fmt.Errorf("usage: migrate down [N]")
+	}
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps < 1 {
+		return 0, fmt.Errorf("invalid number of migrations to roll back: %q", args[0])
+	}
+	return steps, nil
+}
+
+// runBackup implements the "backup" command:
+//
+//	backup export [FILE]  write a backup archive to FILE (default stdout)
+//	backup import FILE    restore an archive into an empty database
+//
+// Importing brings the schema up to date first.
+func runBackup(inst *instance, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup command (want export or import)")
+	}
+
+	switch args[0] {
+	case "export":
+		archive, err := backup.Export(ctx, inst.store)
+		if err != nil {
+			return err
+		}
+		if len(args) < 2 || args[1] == "-" {
+			return backup.Write(os.Stdout, archive)
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		if err := backup.Write(f, archive); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d pool(s), %d user(s) and %d ledger entries to %s.\n", len(archive.Pools), len(archive.Users), len(archive.Ledger), args[1])
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("missing archive file to import")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		archive, err := backup.Read(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if _, err := migrations.Up(ctx, inst.db, inst.dialect); err != nil {
+			return fmt.Errorf("could not apply database migrations: %w", err)
+		}
+		result, err := backup.Import(ctx, inst.store, archive)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d pool(s), %d user(s) and %d ledger entries.\n", result.Pools, result.Users, result.LedgerEntries)
+		if !result.Ledger.Valid {
+			fmt.Printf("Warning: the archived ledger's hash chain was already broken at entry %d: %s\n", result.Ledger.Broken.LedgerID, result.Ledger.Broken.Reason)
+		}
+	default:
+		return fmt.Errorf("unknown backup command %q (want export or import)", args[0])
+	}
+	return nil
+}
+
+// runUser implements the "user" command.
+func runUser(inst *instance, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("missing user command (want list, promote or demote)")
+	}
+
+	switch args[0] {
+	case "list":
+		users, err := inst.store.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "EMAIL\tNAME\tGOOGLE ID\tROLES\tSINCE")
+		for _, u := range users {
+			roles := strings.Join(u.Roles, ",")
+			if u.DonateOnly {
+				roles = "(donate only)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.Email, strings.TrimSpace(u.FirstName+" "+u.LastName), u.GoogleID, roles, u.CreatedAt.Format(time.DateOnly))
+		}
+		return tw.Flush()
+	case "promote", "demote":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: user %s USER ROLE", args[0])
+		}
+		googleID, err := findUser(ctx, inst.store, args[1])
+		if err != nil {
+			return err
+		}
+		role := args[2]
+		if args[0] == "demote" {
+			if err := admin.RevokeRole(ctx, inst.store, "", googleID, role); err != nil {
+				return err
+			}
+			fmt.Printf("Revoked %s from %s and signed them out.\n", role, args[1])
+			return nil
+		}
+		roles, err := admin.GrantRole(ctx, inst.store, "", googleID, role)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s now has the roles: %s.\n", args[1], strings.Join(roles, ", "))
+	default:
+		return fmt.Errorf("unknown user command %q (want list, promote or demote)", args[0])
+	}
+	return nil
+}
+
+// findUser returns the Google ID of the user with an email address, or of
+// the user with that Google ID.
+func findUser(ctx context.Context, s store.Store, user string) (string, error) {
+	if strings.Contains(user, "@") {
+		googleID, err := s.UserIDForEmail(ctx, user)
+		if err == store.ErrNotFound {
+			return "", fmt.Errorf("no user has the email address %s", user)
+		}
+		return googleID, err
+	}
+	if _, err := s.GetUser(ctx, user); err == store.ErrNotFound {
+		return "", fmt.Errorf("no user has the Google ID %s", user)
+	} else if err != nil {
+		return "", err
+	}
+	return user, nil
+}
+
+// runPool implements the "pool" command.
+func runPool(inst *instance, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("missing pool command (want create or archive)")
+	}
+
+	switch args[0] {
+	case "create":
+		req, err := poolRequest(args[1:])
+		if err != nil {
+			return err
+		}
+		id, err := admin.CreatePool(ctx, inst.store, "", req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created pool %d, %q.\n", id, req.Name)
+	case "archive":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: pool archive ID")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid pool ID %q", args[1])
+		}
+		err = admin.SetPoolArchived(ctx, inst.store, "", id, true)
+		if err == store.ErrNotFound {
+			return fmt.Errorf("pool %d not found", id)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Archived pool %d.\n", id)
+	default:
+		return fmt.Errorf("unknown pool command %q (want create or archive)", args[0])
+	}
+	return nil
+}
+
+// poolRequest parses the flags of "pool create" into the request to create
+// the pool. Optional settings are only set when their flag is given.
+func poolRequest(args []string) (*models.CreateFundingPoolRequest, error) {
+	flags := flag.NewFlagSet("pool create", flag.ContinueOnError)
+	name := flags.String("name", "", "the pool's name")
+	goal := flags.Float64("goal", 0, "the pool's goal amount")
+	capAmount := flags.Float64("cap", 0, "the most the pool accepts (default no cap)")
+	description := flags.String("description", "", "the pool's description, in Markdown")
+	overflow := flags.String("overflow", models.OverflowReject, "what to do with donations over the cap: reject, redirect or spread")
+	overflowPool := flags.Int("overflow-pool", 0, "the pool to redirect donations over the cap to")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q; give the name with -name", flags.Args())
+	}
+
+	req := &models.CreateFundingPoolRequest{Name: *name, GoalAmount: *goal, OverflowPolicy: *overflow}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cap":
+			req.CapAmount = capAmount
+		case "description":
+			req.Description = description
+		case "overflow-pool":
+			req.OverflowPoolID = overflowPool
+		}
+	})
+	return req, nil
+}
+
+// errLedgerBroken makes "ledger verify" exit with an error once it has
+// reported a broken chain.
+var errLedgerBroken = errors.New("the ledger's hash chain is broken")
+
+// runLedger implements the "ledger" command.
+func runLedger(inst *instance, args []string) error {
+	ctx := context.Background()
+	if len(args) == 0 {
+		return fmt.Errorf("missing ledger command (want verify or export)")
+	}
+
+	switch args[0] {
+	case "verify":
+		return verifyLedger(ctx, inst.store, os.Stdout)
+	case "export":
+		var entries []models.LedgerEntry
+		err := inst.store.WithSnapshot(ctx, func(tx store.Store) error {
+			var err error
+			entries, err = tx.ListLedgerEntries(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+		return writeJSON(args[1:], entries)
+	default:
+		return fmt.Errorf("unknown ledger command %q (want verify or export)", args[0])
+	}
+}
+
+// verifyLedger checks the ledger's hash chain and reports the result to w,
+// returning errLedgerBroken if the chain is broken.
+func verifyLedger(ctx context.Context, s store.Store, w io.Writer) error {
+	result, err := admin.VerifyLedger(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		fmt.Fprintf(w, "Broken at entry %d: %s\n%d entries verified before it; last good hash %s\n", result.Broken.LedgerID, result.Broken.Reason, result.Checked, result.HeadHash)
+		return errLedgerBroken
+	}
+	fmt.Fprintf(w, "Ledger intact: %d entries verified; head hash %s\n", result.Checked, result.HeadHash)
+	return nil
+}
+
+// writeJSON writes v as indented JSON to the file named in args, or to
+// stdout if there is none or it is "-".
+func writeJSON(args []string, v interface{}) error {
+	if len(args) == 0 || args[0] == "-" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runSite implements the "site" command.
+func runSite(inst *instance, args []string) error {
+	if len(args) == 0 || args[0] != "set-title" {
+		return fmt.Errorf("usage: site set-title TITLE")
+	}
+	title := strings.Join(args[1:], " ")
+	if err := admin.SetSiteTitle(context.Background(), inst.store, "", title); err != nil {
+		return err
+	}
+	fmt.Printf("Site title set to %q.\n", strings.TrimSpace(title))
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"pool-party-api/database"
+	"pool-party-api/migrations"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindUser(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	if _, err := s.UpsertUser(ctx, store.User{GoogleID: "ada", Email: "Ada@example.com"}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+
+	tests := []struct {
+		user    string
+		want    string
+		wantErr string
+	}{
+		{"ada", "ada", ""},
+		{"ada@example.com", "ada", ""},
+		{"ADA@EXAMPLE.COM", "ada", ""},
+		{"grace", "", "no user has the Google ID grace"},
+		{"grace@example.com", "", "no user has the email address grace@example.com"},
+	}
+	for _, tt := range tests {
+		got, err := findUser(ctx, s, tt.user)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("findUser(%q) = %q, %v, want error %q", tt.user, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("findUser(%q) = %q, %v, want %q", tt.user, got, err, tt.want)
+		}
+	}
+}
+
+func TestPoolRequest(t *testing.T) {
+	capAmount, description, overflowPool := 150.0, "For *books*", 7
+	tests := []struct {
+		args    []string
+		want    *models.CreateFundingPoolRequest
+		wantErr bool
+	}{
+		{
+			args: []string{"-name", "Books", "-goal", "100"},
+			want: &models.CreateFundingPoolRequest{Name: "Books", GoalAmount: 100, OverflowPolicy: models.OverflowReject},
+		},
+		{
+			args: []string{"-name", "Books", "-goal", "100", "-cap", "150", "-description", "For *books*", "-overflow", models.OverflowRedirect, "-overflow-pool", "7"},
+			want: &models.CreateFundingPoolRequest{
+				Name:           "Books",
+				GoalAmount:     100,
+				CapAmount:      &capAmount,
+				Description:    &description,
+				OverflowPolicy: models.OverflowRedirect,
+				OverflowPoolID: &overflowPool,
+			},
+		},
+		{args: []string{"-goal", "100", "Books"}, wantErr: true},
+		{args: []string{"-name", "Books", "-goal", "lots"}, wantErr: true},
+		{args: []string{"-colour", "blue"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := poolRequest(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("poolRequest(%q) = %+v, want an error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("poolRequest(%q): %v", tt.args, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("poolRequest(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestDownSteps(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{nil, 1, false},
+		{[]string{"1"}, 1, false},
+		{[]string{"3"}, 3, false},
+		{[]string{"0"}, 0, true},
+		{[]string{"-2"}, 0, true},
+		{[]string{"all"}, 0, true},
+		{[]string{"2", "3"}, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := downSteps(tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("downSteps(%q) = %d, %v, want %d (error %v)", tt.args, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVerifyLedger(t *testing.T) {
+	ctx := context.Background()
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeSQLite
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "pool-party.db")
+	db, err := database.Open(ctx, cfg)
+	if err != nil {
+		t.Fatalf("database.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(ctx, db, migrations.SQLite); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	s := store.NewSQLite(db)
+
+	poolID, err := s.CreatePool(ctx, &models.CreateFundingPoolRequest{Name: "Books", GoalAmount: 100, OverflowPolicy: models.OverflowReject})
+	if err != nil {
+		t.Fatalf("CreatePool: %v", err)
+	}
+	var last int
+	for range 2 {
+		last, err = s.CreateLedgerEntry(ctx, store.LedgerEntryData{
+			Amount:          10,
+			TransactionType: "deposit",
+			Allocations:     []models.AllocationRequest{{FundingPoolID: poolID, Amount: 10}},
+		})
+		if err != nil {
+			t.Fatalf("CreateLedgerEntry: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := verifyLedger(ctx, s, &out); err != nil {
+		t.Fatalf("verifyLedger on an intact ledger = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Ledger intact: 2 entries verified") {
+		t.Errorf("verifyLedger reported %q", out.String())
+	}
+
+	if _, err := db.Exec(`UPDATE allocation SET amount = 5 WHERE ledger_id = $1`, last); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := verifyLedger(ctx, s, &out); !errors.Is(err, errLedgerBroken) {
+		t.Errorf("verifyLedger on a tampered ledger = %v, want errLedgerBroken", err)
+	}
+	if !strings.HasPrefix(out.String(), "Broken at entry ") {
+		t.Errorf("verifyLedger reported %q", out.String())
+	}
+}
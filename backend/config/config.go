@@ -0,0 +1,145 @@
+// Package config loads the server's configuration once at startup: from a
+// YAML file, then environment variables, then command-line flags, each
+// overriding the last. The environment variables keep the names used before
+// the config file existed, so existing deployments need no changes.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"pool-party-api/auth"
+	"pool-party-api/database"
+	"pool-party-api/models"
+	"pool-party-api/oidc"
+	"pool-party-api/paypal"
+	"pool-party-api/sessionstore"
+	"time"
+)
+
+// Config is the complete configuration of an instance. The yaml keys are
+// those of the config file.
+type Config struct {
+	Server   Server           `yaml:"server"`
+	Database database.Config  `yaml:"database"`
+	Session  Session          `yaml:"session"`
+	Google   Google           `yaml:"google"`
+	PayPal   paypal.Config    `yaml:"paypal"`
+	Login    auth.LoginPolicy `yaml:"login"`
+	// OIDC lists the OpenID Connect login providers offered besides Google.
+	OIDC []oidc.Config `yaml:"oidc"`
+}
+
+// Server configures the HTTP server and the work it does in the background.
+type Server struct {
+	Port int `yaml:"port"`
+	// MigrateOnStart brings the schema up to date before serving. Turn it
+	// off to run "pool-party migrate" separately.
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+	// AllowedOrigins are origins, besides the site's own, that may send
+	// cookie-authenticated requests that change state.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// ImageStorageDir is where uploaded pool images are stored.
+	ImageStorageDir string `yaml:"image_storage_dir"`
+	// DefaultAllocationStrategy splits donations whose donor did not pick a
+	// strategy. Empty means shortfall.
+	DefaultAllocationStrategy string `yaml:"default_allocation_strategy"`
+	// LedgerVerifyInterval is how often the ledger's hash chain is checked.
+	// Zero turns the check off.
+	LedgerVerifyInterval time.Duration `yaml:"ledger_verify_interval"`
+}
+
+// Session configures session cookies and their keys.
+type Session struct {
+	sessionstore.KeyConfig `yaml:",inline"`
+	// IdleTimeout signs out sessions that have not been used for this long.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// CookieInsecure lets session cookies be sent over plain HTTP. It is
+	// only meant for local development.
+	CookieInsecure bool `yaml:"cookie_insecure"`
+}
+
+// Google configures Google Sign-In.
+type Google struct {
+	// ClientID is the OAuth client ID of the frontend's Google Sign-In
+	// button. Google Sign-In is refused when it is empty.
+	ClientID string `yaml:"client_id"`
+	// LinkByEmail signs a new Google identity in to the existing account with
+	// the same verified email address, like oidc.Config.LinkByEmail.
+	LinkByEmail bool `yaml:"link_by_email"`
+}
+
+// Default returns the configuration used for anything not set in the file,
+// the environment or the flags.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:                 8000,
+			MigrateOnStart:       true,
+			ImageStorageDir:      "./uploads",
+			LedgerVerifyInterval: time.Hour,
+		},
+		Database: database.DefaultConfig(),
+		Session: Session{
+			KeyConfig:   sessionstore.KeyConfig{GracePeriod: sessionstore.DefaultGracePeriod},
+			IdleTimeout: sessionstore.DefaultIdleTimeout,
+		},
+	}
+}
+
+// PayPalEnabled reports whether any PayPal credentials are set. Donations
+// through PayPal are refused without them.
+func (c *Config) PayPalEnabled() bool {
+	return c.PayPal != paypal.Config{}
+}
+
+// Validate checks everything the server needs, reporting all problems
+// together. Commands that only use the database need only Database to be
+// valid.
+func (c *Config) Validate() error {
+	var errs []error
+	if err := c.Database.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port (PORT) must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.Server.ImageStorageDir == "" {
+		errs = append(errs, errors.New("server.image_storage_dir (IMAGE_STORAGE_DIR) is required"))
+	}
+	switch c.Server.DefaultAllocationStrategy {
+	case "", models.AllocationStrategyShortfall, models.AllocationStrategyLowestPercent, models.AllocationStrategyEven:
+	default:
+		errs = append(errs, fmt.Errorf("server.default_allocation_strategy (DEFAULT_ALLOCATION_STRATEGY) must be %q, %q or %q, got %q",
+			models.AllocationStrategyShortfall, models.AllocationStrategyLowestPercent, models.AllocationStrategyEven, c.Server.DefaultAllocationStrategy))
+	}
+	if c.Server.LedgerVerifyInterval < 0 {
+		errs = append(errs, errors.New("server.ledger_verify_interval (LEDGER_VERIFY_INTERVAL) cannot be negative"))
+	}
+
+	// Loading the keys checks that they are present and well formed.
+	if _, err := sessionstore.LoadKeyring(c.Session.KeyConfig); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Session.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("session.idle_timeout (SESSION_IDLE_TIMEOUT) must be positive"))
+	}
+
+	if c.PayPalEnabled() {
+		if err := c.PayPal.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, p := range c.OIDC {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if names[p.Name] {
+			errs = append(errs, fmt.Errorf("OIDC provider %s is configured twice", p.Name))
+		}
+		names[p.Name] = true
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"pool-party-api/auth"
+	"pool-party-api/database"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pool-party.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func envFrom(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+server:
+  port: 9000
+  image_storage_dir: /var/lib/pool-party/images
+  ledger_verify_interval: 15m
+database:
+  mode: sqlite
+  sqlite_path: /var/lib/pool-party/file.db
+session:
+  secret: from-the-file
+  idle_timeout: 24h
+login:
+  allowed_domains: [" Example.com "]
+  unlisted_access: donate
+oidc:
+  - name: okta
+    issuer: https://example.okta.com
+    client_id: okta-client
+    redirect_url: https://pool.example.com/api/auth/oidc/okta/callback
+`)
+	env := map[string]string{
+		"PORT":             "9100",
+		"SQLITE_PATH":      "/var/lib/pool-party/env.db",
+		"PAYPAL_CLIENT_ID": "paypal-client",
+		"ALLOWED_ORIGINS":  "https://a.example.com, https://b.example.com",
+	}
+	cfg, args, err := Load([]string{"-config", path, "-sqlite-path", "/tmp/flag.db", "backup", "export"}, envFrom(env))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !slices.Equal(args, []string{"backup", "export"}) {
+		t.Errorf("args = %q", args)
+	}
+	if cfg.Server.Port != 9100 {
+		t.Errorf("port = %d, want the environment's 9100", cfg.Server.Port)
+	}
+	if cfg.Database.Mode != database.ModeSQLite || cfg.Database.SQLitePath != "/tmp/flag.db" {
+		t.Errorf("database = %+v, want the flag's SQLite path", cfg.Database)
+	}
+	if cfg.Database.MaxOpenConns != database.DefaultConfig().MaxOpenConns {
+		t.Errorf("max open conns = %d, want the default", cfg.Database.MaxOpenConns)
+	}
+	if cfg.Server.LedgerVerifyInterval != 15*time.Minute || cfg.Session.IdleTimeout != 24*time.Hour {
+		t.Errorf("durations from the file not applied: %+v %+v", cfg.Server, cfg.Session)
+	}
+	if !slices.Equal(cfg.Server.AllowedOrigins, []string{"https://a.example.com", "https://b.example.com"}) {
+		t.Errorf("allowed origins = %q", cfg.Server.AllowedOrigins)
+	}
+	if !slices.Equal(cfg.Login.AllowedDomains, []string{"example.com"}) || cfg.Login.Unlisted != auth.AccessDonateOnly {
+		t.Errorf("login policy = %+v", cfg.Login)
+	}
+	if len(cfg.OIDC) != 1 || cfg.OIDC[0].DisplayName != "okta" || len(cfg.OIDC[0].Scopes) == 0 {
+		t.Errorf("OIDC providers = %+v", cfg.OIDC)
+	}
+
+	// Only part of the PayPal credentials came from the environment.
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "paypal.client_id") {
+		t.Errorf("Validate() = %v, want an error about the PayPal credentials", err)
+	}
+}
+
+func TestLoadEnvOnly(t *testing.T) {
+	env := map[string]string{
+		"DATABASE_URL":                "postgres://localhost/pool_party",
+		"SESSION_SECRET":              "a-long-random-string",
+		"MIGRATE_ON_START":            "false",
+		"GOOGLE_CLIENT_ID":            "pool-party.apps.googleusercontent.com",
+		"OIDC_PROVIDERS":              "entra-id",
+		"OIDC_ENTRA_ID_ISSUER":        "https://login.microsoftonline.com/tenant/v2.0",
+		"OIDC_ENTRA_ID_CLIENT_ID":     "entra-client",
+		"OIDC_ENTRA_ID_SCOPES":        "email",
+		"OIDC_ENTRA_ID_LINK_BY_EMAIL": "true",
+		"OIDC_ENTRA_ID_REDIRECT_URL":  "https://pool.example.com/api/auth/oidc/entra-id/callback",
+	}
+	cfg, _, err := Load(nil, envFrom(env))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if cfg.Database.Mode != database.ModeURL || cfg.Server.MigrateOnStart || cfg.Server.Port != 8000 {
+		t.Errorf("config = %+v", cfg)
+	}
+	if len(cfg.OIDC) != 1 || cfg.OIDC[0].Name != "entra-id" || !slices.Equal(cfg.OIDC[0].Scopes, []string{"email"}) || !cfg.OIDC[0].LinkByEmail {
+		t.Errorf("OIDC providers = %+v", cfg.OIDC)
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	env := map[string]string{
+		"DB_MAX_OPEN_CONNS":     "many",
+		"SESSION_IDLE_TIMEOUT":  "a week",
+		"LOGIN_UNLISTED_ACCESS": "maybe",
+	}
+	_, _, err := Load(nil, envFrom(env))
+	if err == nil {
+		t.Fatal("Load succeeded with invalid values")
+	}
+	for _, name := range []string{"DB_MAX_OPEN_CONNS", "SESSION_IDLE_TIMEOUT", "LOGIN_UNLISTED_ACCESS"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error does not mention %s: %v", name, err)
+		}
+	}
+
+	cfg, _, err := Load([]string{"-port", "0"}, envFrom(map[string]string{"DB_MODE": "url", "DEFAULT_ALLOCATION_STRATEGY": "random"}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	err = cfg.Validate()
+	for _, want := range []string{"database.url", "server.port", "server.default_allocation_strategy", "no session keys"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	path := writeFile(t, "server:\n  prot: 9000\n")
+	if _, _, err := Load([]string{"-config", path}, envFrom(nil)); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("Load with an unknown key = %v, want an error naming it", err)
+	}
+
+	path = writeFile(t, "login:\n  unlisted_access: maybe\n")
+	if _, _, err := Load(nil, envFrom(map[string]string{FileEnv: path})); err == nil {
+		t.Error("Load with an invalid unlisted access succeeded")
+	}
+
+	if _, _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, envFrom(nil)); err == nil {
+		t.Error("Load with a missing config file succeeded")
+	}
+
+	if _, _, err := Load([]string{"-h"}, envFrom(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Load(-h) = %v, want flag.ErrHelp", err)
+	}
+}
+
+// The example config file in the repository must stay loadable.
+func TestExampleFile(t *testing.T) {
+	cfg, _, err := Load([]string{"-config", "../pool-party.example.yaml"}, envFrom(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"pool-party-api/oidc"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the config file when the -config flag is not given.
+const FileEnv = "POOL_PARTY_CONFIG"
+
+// Flags describes the command-line flags Load accepts before the command.
+const Flags = `  -config FILE         read settings from a YAML config file (or $` + FileEnv + `)
+  -port N              port to serve on (PORT)
+  -migrate-on-start    apply migrations before serving (MIGRATE_ON_START)
+  -db-mode MODE        url, socket, cloudsql or sqlite (DB_MODE)
+  -database-url URL    Postgres URL, for url mode (DATABASE_URL)
+  -sqlite-path FILE    database file, for sqlite mode (SQLITE_PATH)
+`
+
+// Load reads the configuration for a command line: the defaults, then the
+// config file, then the environment as read by getenv, then the flags at the
+// start of args. It returns the arguments after the flags, and
+// flag.ErrHelp if help was asked for. Values that cannot be parsed are
+// reported together; the result still needs to be validated.
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	flags := flag.NewFlagSet("pool-party", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	path := flags.String("config", "", "")
+	port := flags.Int("port", 0, "")
+	migrateOnStart := flags.Bool("migrate-on-start", false, "")
+	dbMode := flags.String("db-mode", "", "")
+	databaseURL := flags.String("database-url", "", "")
+	sqlitePath := flags.String("sqlite-path", "", "")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+	if *path == "" {
+		*path = getenv(FileEnv)
+	}
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err := cfg.applyEnv(getenv)
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = *port
+		case "migrate-on-start":
+			cfg.Server.MigrateOnStart = *migrateOnStart
+		case "db-mode":
+			cfg.Database.Mode = *dbMode
+		case "database-url":
+			cfg.Database.URL = *databaseURL
+		case "sqlite-path":
+			cfg.Database.SQLitePath = *sqlitePath
+		}
+	})
+
+	cfg.Database.SetDefaultMode()
+	cfg.Login.Normalize()
+	for i := range cfg.OIDC {
+		cfg.OIDC[i].SetDefaults()
+	}
+	return cfg, flags.Args(), err
+}
+
+// readFile reads a YAML config file over c. Unknown keys are rejected, so
+// that a misspelled setting is not silently ignored.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides c with the environment variables that are set.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v := getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	intVar := func(name string, dst *int) {
+		if v := getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolVar := func(name string, dst *bool) {
+		if v := getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid boolean %q", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	durationVar := func(name string, dst *time.Duration) {
+		if v := getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid duration %q", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	listVar := func(name string, dst *[]string, sep func(rune) bool) {
+		if v := getenv(name); v != "" {
+			*dst = strings.FieldsFunc(v, sep)
+		}
+	}
+
+	intVar("PORT", &c.Server.Port)
+	boolVar("MIGRATE_ON_START", &c.Server.MigrateOnStart)
+	listVar("ALLOWED_ORIGINS", &c.Server.AllowedOrigins, func(r rune) bool { return r == ',' || r == ' ' })
+	str("IMAGE_STORAGE_DIR", &c.Server.ImageStorageDir)
+	str("DEFAULT_ALLOCATION_STRATEGY", &c.Server.DefaultAllocationStrategy)
+	durationVar("LEDGER_VERIFY_INTERVAL", &c.Server.LedgerVerifyInterval)
+
+	str("DB_MODE", &c.Database.Mode)
+	str("DATABASE_URL", &c.Database.URL)
+	str("DB_USER", &c.Database.User)
+	str("DB_PASS", &c.Database.Password)
+	str("DB_NAME", &c.Database.Name)
+	str("DB_SOCKET_DIR", &c.Database.SocketDir)
+	str("INSTANCE_CONNECTION_NAME", &c.Database.InstanceConnectionName)
+	boolVar("DB_PRIVATE_IP", &c.Database.PrivateIP)
+	str("SQLITE_PATH", &c.Database.SQLitePath)
+	intVar("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	intVar("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	durationVar("DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	durationVar("DB_CONN_MAX_IDLE_TIME", &c.Database.ConnMaxIdleTime)
+
+	str("SESSION_KEYS_FILE", &c.Session.KeysFile)
+	str("SESSION_KEYS", &c.Session.Keys)
+	str("SESSION_SECRET", &c.Session.Secret)
+	durationVar("SESSION_KEY_GRACE_PERIOD", &c.Session.GracePeriod)
+	durationVar("SESSION_IDLE_TIMEOUT", &c.Session.IdleTimeout)
+	boolVar("SESSION_COOKIE_INSECURE", &c.Session.CookieInsecure)
+
+	str("GOOGLE_CLIENT_ID", &c.Google.ClientID)
+	boolVar("GOOGLE_LINK_BY_EMAIL", &c.Google.LinkByEmail)
+	str("PAYPAL_CLIENT_ID", &c.PayPal.ClientID)
+	str("PAYPAL_CLIENT_SECRET", &c.PayPal.ClientSecret)
+	str("PAYPAL_API_BASE", &c.PayPal.APIBase)
+
+	isComma := func(r rune) bool { return r == ',' }
+	listVar("LOGIN_ALLOWED_DOMAINS", &c.Login.AllowedDomains, isComma)
+	listVar("LOGIN_ALLOWED_EMAILS", &c.Login.AllowedEmails, isComma)
+	if v := getenv("LOGIN_UNLISTED_ACCESS"); v != "" {
+		if err := c.Login.Unlisted.UnmarshalText([]byte(v)); err != nil {
+			errs = append(errs, fmt.Errorf("LOGIN_UNLISTED_ACCESS: %w", err))
+		}
+	}
+
+	// OIDC_PROVIDERS replaces the providers in the config file. Each is then
+	// configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID,
+	// OIDC_<NAME>_CLIENT_SECRET and OIDC_<NAME>_REDIRECT_URL, and optionally
+	// OIDC_<NAME>_DISPLAY_NAME, OIDC_<NAME>_SCOPES (space-separated) and
+	// OIDC_<NAME>_LINK_BY_EMAIL.
+	if v := getenv("OIDC_PROVIDERS"); v != "" {
+		c.OIDC = nil
+		for _, name := range strings.FieldsFunc(v, isComma) {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			c.OIDC = append(c.OIDC, oidc.Config{
+				Name:         name,
+				DisplayName:  getenv(prefix + "DISPLAY_NAME"),
+				Issuer:       getenv(prefix + "ISSUER"),
+				ClientID:     getenv(prefix + "CLIENT_ID"),
+				ClientSecret: getenv(prefix + "CLIENT_SECRET"),
+				RedirectURL:  getenv(prefix + "REDIRECT_URL"),
+				Scopes:       strings.Fields(getenv(prefix + "SCOPES")),
+			})
+			boolVar(prefix+"LINK_BY_EMAIL", &c.OIDC[len(c.OIDC)-1].LinkByEmail)
+		}
+	}
+
+	return errors.Join(errs...)
+}
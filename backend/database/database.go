@@ -3,56 +3,229 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"net"
-	"os"
+	"net/url"
+	"time"
 
 	"cloud.google.com/go/cloudsqlconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
 )
 
-// InitDB initializes and returns a database connection pool for Cloud SQL.
-func InitDB() (*sql.DB, error) {
-	// A helper function to safely get environment variables.
-	mustGetenv := func(k string) string {
-		v := os.Getenv(k)
-		if v == "" {
-			log.Fatalf("Fatal Error: %s environment variable not set.", k)
+// Connection modes.
+const (
+	// ModeURL connects with a standard Postgres URL or DSN.
+	ModeURL = "url"
+	// ModeSocket connects over a Unix socket, e.g. /cloudsql/<instance> on
+	// Cloud Run or /var/run/postgresql on a local install.
+	ModeSocket = "socket"
+	// ModeCloudSQL connects through the Cloud SQL Go connector.
+	ModeCloudSQL = "cloudsql"
+	// ModeSQLite uses a SQLite database file instead of Postgres, for small
+	// deployments served by a single instance.
+	ModeSQLite = "sqlite"
+)
+
+// Config describes how to connect to the database and how to size the pool.
+// The yaml keys are those of the database section of the config file.
+type Config struct {
+	Mode string `yaml:"mode"`
+
+	// URL is a Postgres URL or DSN, for ModeURL.
+	URL string `yaml:"url"`
+
+	// User, Password and Name are used by ModeSocket and ModeCloudSQL.
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+
+	// SocketDir is the directory containing the Postgres socket, for ModeSocket.
+	SocketDir string `yaml:"socket_dir"`
+
+	// InstanceConnectionName ("project:region:instance") and PrivateIP are
+	// used by ModeCloudSQL.
+	InstanceConnectionName string `yaml:"instance_connection_name"`
+	PrivateIP              bool   `yaml:"private_ip"`
+
+	// SQLitePath is the database file, for ModeSQLite. It is created if it
+	// does not exist.
+	SQLitePath string `yaml:"sqlite_path"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+// DefaultConfig returns a Config with the default pool settings.
+func DefaultConfig() Config {
+	return Config{
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+	}
+}
+
+// SetDefaultMode picks the connection mode when none is set: url when URL
+// is set, socket when SocketDir is set, sqlite when SQLitePath is set, and
+// otherwise cloudsql.
+func (c *Config) SetDefaultMode() {
+	if c.Mode != "" {
+		return
+	}
+	switch {
+	case c.URL != "":
+		c.Mode = ModeURL
+	case c.SocketDir != "":
+		c.Mode = ModeSocket
+	case c.SQLitePath != "":
+		c.Mode = ModeSQLite
+	default:
+		c.Mode = ModeCloudSQL
+	}
+}
+
+// Validate checks that the settings the connection mode needs are present
+// and that the pool settings make sense. Settings are named by their config
+// file key and environment variable.
+func (c Config) Validate() error {
+	var errs []error
+	require := func(value, key, env string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("database.%s (%s) is required in %s mode", key, env, c.Mode))
 		}
-		return v
 	}
 
-	// Note: Saving credentials in environment variables is convenient, but not the most
-	// secure method. For production environments, consider using a more secure solution
-	// like Google Cloud Secret Manager to protect your secrets.
-	var (
-		dbUser                 = mustGetenv("DB_USER")                  // e.g. 'postgres'
-		dbPwd                  = mustGetenv("DB_PASS")                  // e.g. 'my-secret-password'
-		dbName                 = mustGetenv("DB_NAME")                  // e.g. 'pool_party'
-		instanceConnectionName = mustGetenv("INSTANCE_CONNECTION_NAME") // e.g. 'project:region:instance'
-	)
+	switch c.Mode {
+	case ModeURL:
+		require(c.URL, "url", "DATABASE_URL")
+	case ModeSocket:
+		require(c.SocketDir, "socket_dir", "DB_SOCKET_DIR")
+		require(c.User, "user", "DB_USER")
+		require(c.Name, "name", "DB_NAME")
+	case ModeCloudSQL:
+		require(c.InstanceConnectionName, "instance_connection_name", "INSTANCE_CONNECTION_NAME")
+		require(c.User, "user", "DB_USER")
+		require(c.Password, "password", "DB_PASS")
+		require(c.Name, "name", "DB_NAME")
+	case ModeSQLite:
+		require(c.SQLitePath, "sqlite_path", "SQLITE_PATH")
+	default:
+		errs = append(errs, fmt.Errorf("database.mode (DB_MODE) must be %q, %q, %q or %q, got %q", ModeURL, ModeSocket, ModeCloudSQL, ModeSQLite, c.Mode))
+	}
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_open_conns and database.max_idle_conns cannot be negative"))
+	}
+	if c.MaxIdleConns > c.MaxOpenConns && c.MaxOpenConns > 0 {
+		errs = append(errs, fmt.Errorf("database.max_idle_conns (%d) cannot exceed database.max_open_conns (%d)", c.MaxIdleConns, c.MaxOpenConns))
+	}
+	if c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("database.conn_max_lifetime and database.conn_max_idle_time cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// pgxConfig builds the pgx connection config for the connection mode.
+func (c Config) pgxConfig(ctx context.Context) (*pgx.ConnConfig, error) {
+	switch c.Mode {
+	case ModeURL:
+		config, err := pgx.ParseConfig(c.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database URL: %w", err)
+		}
+		return config, nil
+
+	case ModeSocket:
+		config, err := pgx.ParseConfig("")
+		if err != nil {
+			return nil, fmt.Errorf("pgx.ParseConfig: %w", err)
+		}
+		config.Host = c.SocketDir
+		config.Port = 5432
+		config.User = c.User
+		config.Password = c.Password
+		config.Database = c.Name
+		config.Fallbacks = nil
+		return config, nil
+
+	case ModeCloudSQL:
+		var opts []cloudsqlconn.DialOption
+		if c.PrivateIP {
+			opts = append(opts, cloudsqlconn.WithPrivateIP())
+		}
+		d, err := cloudsqlconn.NewDialer(ctx, cloudsqlconn.WithDefaultDialOptions(opts...))
+		if err != nil {
+			return nil, fmt.Errorf("cloudsqlconn.NewDialer: %w", err)
+		}
 
-	// Create a new Cloud SQL dialer.
-	d, err := cloudsqlconn.NewDialer(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("cloudsqlconn.NewDialer: %w", err)
+		config, err := pgx.ParseConfig("")
+		if err != nil {
+			return nil, fmt.Errorf("pgx.ParseConfig: %w", err)
+		}
+		config.User = c.User
+		config.Password = c.Password
+		config.Database = c.Name
+		config.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.Dial(ctx, c.InstanceConnectionName)
+		}
+		return config, nil
 	}
+	return nil, c.Validate()
+}
+
+// sqliteDSN returns the data source name for a SQLite database file. Foreign
+// keys are enforced as in Postgres, transactions take the write lock when
+// they begin so that they serialize like the Postgres row locks the store
+// relies on, and times are written in a format SQLite's date functions and
+// text comparisons understand.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(10000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_txlock", "immediate")
+	params.Set("_time_format", "sqlite")
+	return "file:" + path + "?" + params.Encode()
+}
 
-	// Configure the pgx driver to use the Cloud SQL dialer.
-	config, err := pgx.ParseConfig(fmt.Sprintf("user=%s password=%s dbname=%s", dbUser, dbPwd, dbName))
-	if err != nil {
-		return nil, fmt.Errorf("pgx.ParseConfig: %w", err)
+// Open connects to the database as configured, sizes the pool, and pings it
+// so that a bad configuration is reported at startup.
+func Open(ctx context.Context, cfg Config) (*sql.DB, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
-	config.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
-		return d.Dial(ctx, instanceConnectionName)
+
+	var db *sql.DB
+	if cfg.Mode == ModeSQLite {
+		var err error
+		db, err = sql.Open("sqlite", sqliteDSN(cfg.SQLitePath))
+		if err != nil {
+			return nil, fmt.Errorf("sql.Open: %w", err)
+		}
+	} else {
+		config, err := cfg.pgxConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		db, err = sql.Open("pgx", stdlib.RegisterConnConfig(config))
+		if err != nil {
+			return nil, fmt.Errorf("sql.Open: %w", err)
+		}
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	// Open the database with the custom dialer.
-	db, err := sql.Open("pgx", stdlib.RegisterConnConfig(config))
-	if err != nil {
-		return nil, fmt.Errorf("sql.Open: %w", err)
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to the database (%s mode): %w", cfg.Mode, err)
 	}
 
 	return db, nil
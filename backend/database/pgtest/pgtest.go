@@ -0,0 +1,157 @@
+// Package pgtest provides empty Postgres databases for tests: a schema of
+// their own in the database at TEST_DATABASE_URL, or else in a throwaway
+// server started with a locally installed initdb and postgres.
+//
+// Without either, tests that need Postgres are skipped, unless the CI
+// environment variable is set: then they fail, so that a CI job cannot pass
+// without running them.
+package pgtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"pool-party-api/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+// URL returns the URL of an empty schema for the test, dropped when it ends.
+func URL(t testing.TB) string {
+	t.Helper()
+	return Schema(t, Server(t))
+}
+
+// Server returns the URL of a Postgres database: TEST_DATABASE_URL, or a
+// throwaway server that runs until the test ends. Tests should work in a
+// schema of their own within it; see Schema.
+func Server(t testing.TB) string {
+	t.Helper()
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		return url
+	}
+
+	initdb, err := findBinary("initdb")
+	if err != nil {
+		unavailable(t, "set TEST_DATABASE_URL or install Postgres to run the Postgres tests")
+	}
+	postgres := filepath.Join(filepath.Dir(initdb), "postgres")
+
+	// Not t.TempDir: when the server runs as another user, that user needs
+	// to reach the directory, and /tmp is open to everyone.
+	dir, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		t.Fatalf("creating the Postgres directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	data := filepath.Join(dir, "data")
+
+	// Listen only on a Unix socket in the directory, so the server cannot
+	// clash with another on the same port.
+	initCmd := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync")
+	cmd := exec.Command(postgres, "-D", data, "-k", dir, "-c", "listen_addresses=", "-F")
+	initCmd.Dir, cmd.Dir = dir, dir
+	if err := dropRoot(dir, initCmd, cmd); err != nil {
+		t.Fatalf("preparing to run Postgres: %v", err)
+	}
+
+	if out, err := initCmd.CombinedOutput(); err != nil {
+		t.Fatalf("initdb: %v\n%s", err, out)
+	}
+	var logs bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &logs, &logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting postgres: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		_ = cmd.Wait()
+	})
+
+	url := fmt.Sprintf("host=%s port=5432 user=postgres dbname=postgres sslmode=disable", dir)
+	waitForServer(t, url, &logs)
+	return url
+}
+
+// Schema creates a schema of its own for the test in the database at url
+// and returns a URL that uses it, dropping it when the test ends.
+func Schema(t testing.TB, url string) string {
+	t.Helper()
+	db, err := database.Open(context.Background(), urlConfig(url))
+	if err != nil {
+		t.Fatalf("connecting to Postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+	return WithSearchPath(url, schema)
+}
+
+// WithSearchPath adds a search_path setting to a Postgres URL or DSN.
+func WithSearchPath(url, schema string) string {
+	if !strings.HasPrefix(url, "postgres://") && !strings.HasPrefix(url, "postgresql://") {
+		return url + " search_path=" + schema
+	}
+	if strings.Contains(url, "?") {
+		return url + "&search_path=" + schema
+	}
+	return url + "?search_path=" + schema
+}
+
+// unavailable skips the test, or fails it when CI is set.
+func unavailable(t testing.TB, reason string) {
+	t.Helper()
+	if os.Getenv("CI") != "" {
+		t.Fatal(reason + " (CI is set, so the Postgres tests may not be skipped)")
+	}
+	t.Skip(reason)
+}
+
+// findBinary looks for a Postgres program on the PATH or in the usual Debian
+// and Homebrew locations.
+func findBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, pattern := range []string{"/usr/lib/postgresql/*/bin/", "/opt/homebrew/opt/postgresql*/bin/", "/usr/local/opt/postgresql*/bin/"} {
+		if matches, _ := filepath.Glob(pattern + name); len(matches) > 0 {
+			return matches[len(matches)-1], nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+func waitForServer(t testing.TB, url string, logs *bytes.Buffer) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		db, err := database.Open(context.Background(), urlConfig(url))
+		if err == nil {
+			db.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("postgres did not start: %v\n%s", err, logs)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func urlConfig(url string) database.Config {
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeURL
+	cfg.URL = url
+	return cfg
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package pgtest
+
+import "os/exec"
+
+// dropRoot does nothing where there is no root user to refuse.
+func dropRoot(dir string, cmds ...*exec.Cmd) error {
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package pgtest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropRoot makes cmds run as the nobody user if the tests run as root, since
+// Postgres refuses to run as root, and gives that user dir.
+func dropRoot(dir string, cmds ...*exec.Cmd) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	u, err := user.Lookup("nobody")
+	if err != nil {
+		return fmt.Errorf("looking up a user to run Postgres as: %w", err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(dir, int(uid), int(gid)); err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+		}
+	}
+	return nil
+}
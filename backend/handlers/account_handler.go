@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"strings"
+	"time"
+)
+
+// ExportAccount returns everything stored about the current user as a JSON
+// download: their profile, login identities, roles, tokens, sessions, ledger
+// entries, pool edits and audit log entries.
+func (env *APIEnv) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+	ctx := r.Context()
+
+	export := models.AccountExport{ExportedAt: time.Now().UTC(), UserID: googleID}
+
+	// Read everything from one snapshot.
+	err := env.Store.WithSnapshot(ctx, func(tx store.Store) error {
+		user, err := tx.GetUser(ctx, googleID)
+		if err == store.ErrNotFound {
+			return models.NewRequestError("User not found", http.StatusNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		export.CreatedAt = user.CreatedAt
+		export.DonateOnly = user.DonateOnly
+
+		profile, err := loadDonorProfile(ctx, tx, googleID)
+		if err == nil {
+			export.Profile = profile.toModel()
+			export.Identities, err = tx.UserIdentities(ctx, googleID)
+		}
+		if err == nil {
+			export.Roles, err = tx.GrantedRoles(ctx, googleID)
+		}
+		if err == nil {
+			export.APITokens, err = tx.ListAPITokens(ctx, googleID)
+		}
+		if err == nil {
+			export.Sessions, err = tx.ListUserSessions(ctx, googleID)
+		}
+		if err == nil {
+			export.LedgerEntries, err = tx.UserLedgerEntries(ctx, googleID)
+		}
+		if err == nil {
+			export.PoolRevisions, err = tx.ModeratorRevisions(ctx, googleID)
+		}
+		if err == nil {
+			export.AuditLog, err = tx.UserAuditLog(ctx, googleID)
+		}
+		return err
+	})
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error exporting data for user %s: %v", googleID, err)
+			respondError(w, http.StatusInternalServerError, "Failed to export account data")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pool-party-export-%s.json"`, export.ExportedAt.Format("2006-01-02")))
+	respondJSON(w, http.StatusOK, export)
+}
+
+// DeleteAccount permanently deletes the current user's account. Their ledger
+// entries are kept for the books, with amounts and allocations intact, but
+// are detached from them and made anonymous, and the pool revisions they made
+// no longer name them. Identities, roles, tokens and sessions are deleted with
+// the user. The request must repeat the account's email address to confirm.
+func (env *APIEnv) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+	ctx := r.Context()
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	err := env.Store.WithTx(ctx, func(tx store.Store) error {
+		user, err := tx.LockUser(ctx, googleID)
+		if err == store.ErrNotFound {
+			return models.NewRequestError("User not found", http.StatusNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(strings.TrimSpace(req.Email), user.Email) {
+			return models.NewRequestError("Confirm deletion by entering your account's email address", http.StatusBadRequest)
+		}
+
+		// Keep someone able to manage users.
+		admins, err := tx.LockRoleHolders(ctx, string(auth.RoleAdmin))
+		if err != nil {
+			return err
+		}
+		if len(admins) == 1 && admins[0] == googleID {
+			return models.NewRequestError("You are the last admin. Grant the admin role to someone else first.", http.StatusConflict)
+		}
+
+		anonymized, err := tx.AnonymizeUserLedger(ctx, googleID)
+		if err != nil {
+			return err
+		}
+		revisions, err := tx.AnonymizeModeratorRevisions(ctx, googleID)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, googleID); err != nil {
+			return err
+		}
+
+		details := map[string]int64{"ledger_entries_anonymized": anonymized, "pool_revisions_anonymized": revisions}
+		return tx.RecordAudit(ctx, "", models.AuditUserDelete, "", details)
+	})
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error deleting account for user %s: %v", googleID, err)
+			respondError(w, http.StatusInternalServerError, "Failed to delete account")
+		}
+		return
+	}
+
+	// The session row went with the user; clear the cookie too.
+	session, _ := env.SessionStore.Get(r, "pool-party-session")
+	session.Values = map[interface{}]interface{}{}
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Session clear error after account deletion: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
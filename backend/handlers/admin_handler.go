@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"pool-party-api/admin"
+	"pool-party-api/models"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ListUsers returns every user with the roles granted to them.
+func (env *APIEnv) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := env.Store.ListUsers(r.Context())
+	if err != nil {
+		log.Printf("Error querying users: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error fetching users")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, users)
+}
+
+// GrantRole grants a role to a user. Granting a role the user already holds
+// is a no-op and is not logged.
+func (env *APIEnv) GrantRole(w http.ResponseWriter, r *http.Request) {
+	targetGoogleID := mux.Vars(r)["googleID"]
+
+	var req models.GrantRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	actorGoogleID, _ := userIDFromContext(r.Context())
+
+	roles, err := admin.GrantRole(r.Context(), env.Store, actorGoogleID, targetGoogleID, req.Role)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error granting role %s to user %s: %v", req.Role, targetGoogleID, err)
+			respondError(w, http.StatusInternalServerError, "Failed to grant role")
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string][]string{"roles": roles})
+}
+
+// RevokeRole revokes a role from a user and ends all of their sessions. The
+// last admin cannot be revoked, so there is always someone left who can
+// manage users.
+func (env *APIEnv) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	targetGoogleID, role := vars["googleID"], vars["role"]
+
+	actorGoogleID, _ := userIDFromContext(r.Context())
+
+	if err := admin.RevokeRole(r.Context(), env.Store, actorGoogleID, targetGoogleID, role); err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error revoking role %s from user %s: %v", role, targetGoogleID, err)
+			respondError(w, http.StatusInternalServerError, "Failed to revoke role")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditLog returns audit log entries, newest first. It returns at most
+// "limit" entries (default 100, max 500) older than the optional "before" ID.
+func (env *APIEnv) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+	var before *int64
+	if v := r.URL.Query().Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid before ID")
+			return
+		}
+		before = &n
+	}
+
+	entries, err := env.Store.AuditLog(r.Context(), before, limit)
+	if err != nil {
+		log.Printf("Error querying audit log: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error fetching audit log")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"sort"
+)
+
+// toCents converts a dollar amount to whole cents. Allocation rules work in
+// cents so that splitting and capping never produce fractions of a cent.
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// fromCents converts whole cents back to a dollar amount.
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// poolState is the funding state of a pool that the allocation rules need.
+// All amounts are in cents.
+type poolState struct {
+	ID             int
+	Name           string
+	Goal           int64
+	Balance        int64
+	Cap            *int64
+	OverflowPolicy string
+	OverflowPoolID *int
+	Archived       bool
+}
+
+// accepting reports whether the pool can take any more money.
+func (p *poolState) accepting() bool {
+	if p.Archived {
+		return false
+	}
+	room, capped := p.room()
+	return !capped || room > 0
+}
+
+// room returns how much more the pool can accept before reaching its cap,
+// and whether the pool is capped at all.
+func (p *poolState) room() (int64, bool) {
+	if p.Cap == nil {
+		return 0, false
+	}
+	return max(0, *p.Cap-p.Balance), true
+}
+
+// shortfall returns how much the pool still needs to reach its goal.
+func (p *poolState) shortfall() int64 {
+	return max(0, p.Goal-p.Balance)
+}
+
+// loadPoolStates fetches the current funding state of every pool, keyed by ID.
+func loadPoolStates(ctx context.Context, s store.PoolStore) (map[int]*poolState, error) {
+	stored, err := s.ListPools(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make(map[int]*poolState, len(stored))
+	for _, sp := range stored {
+		p := poolState{
+			ID:             sp.ID,
+			Name:           sp.Name,
+			Goal:           toCents(sp.GoalAmount),
+			Balance:        toCents(sp.CurrentAmount),
+			OverflowPolicy: sp.OverflowPolicy,
+			OverflowPoolID: sp.OverflowPoolID,
+			Archived:       sp.ArchivedAt != nil,
+		}
+		if sp.CapAmount != nil {
+			c := toCents(*sp.CapAmount)
+			p.Cap = &c
+		}
+		pools[p.ID] = &p
+	}
+	return pools, nil
+}
+
+// allocationPlan accumulates the amounts actually applied to each pool,
+// remembering the order in which pools were first allocated to.
+type allocationPlan struct {
+	order   []int
+	amounts map[int]int64
+}
+
+func newAllocationPlan() *allocationPlan {
+	return &allocationPlan{amounts: make(map[int]int64)}
+}
+
+func (p *allocationPlan) add(poolID int, cents int64) {
+	if cents <= 0 {
+		return
+	}
+	if _, ok := p.amounts[poolID]; !ok {
+		p.order = append(p.order, poolID)
+	}
+	p.amounts[poolID] += cents
+}
+
+// requests returns the plan as allocation requests ready to be recorded.
+func (p *allocationPlan) requests() []models.AllocationRequest {
+	allocations := make([]models.AllocationRequest, 0, len(p.order))
+	for _, id := range p.order {
+		allocations = append(allocations, models.AllocationRequest{FundingPoolID: id, Amount: fromCents(p.amounts[id])})
+	}
+	return allocations
+}
+
+// applyFundingCaps enforces each pool's cap and overflow policy on the
+// requested allocations, returning the allocations that should actually be
+// recorded. The balances in pools are updated as amounts are placed, so the
+// caller must reload them before reusing the map.
+func applyFundingCaps(pools map[int]*poolState, requested []models.AllocationRequest) ([]models.AllocationRequest, error) {
+	plan := newAllocationPlan()
+	for _, alloc := range requested {
+		cents := toCents(alloc.Amount)
+		if cents <= 0 {
+			continue
+		}
+		pool, ok := pools[alloc.FundingPoolID]
+		if !ok {
+			return nil, models.NewRequestError(fmt.Sprintf("Funding pool %d does not exist", alloc.FundingPoolID), http.StatusBadRequest)
+		}
+		if pool.Archived {
+			return nil, models.NewRequestError(fmt.Sprintf("Funding pool %q is archived and no longer accepts donations", pool.Name), http.StatusBadRequest)
+		}
+		if err := placeAmount(pools, plan, pool, cents, map[int]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	return plan.requests(), nil
+}
+
+// placeAmount allocates cents to pool up to its cap, then applies the pool's
+// overflow policy to any excess. visited guards against redirect cycles.
+func placeAmount(pools map[int]*poolState, plan *allocationPlan, pool *poolState, cents int64, visited map[int]bool) error {
+	visited[pool.ID] = true
+
+	accepted := cents
+	if room, capped := pool.room(); capped && room < cents {
+		accepted = room
+	}
+	plan.add(pool.ID, accepted)
+	pool.Balance += accepted
+
+	excess := cents - accepted
+	if excess == 0 {
+		return nil
+	}
+
+	switch pool.OverflowPolicy {
+	case models.OverflowRedirect:
+		if pool.OverflowPoolID != nil {
+			if target, ok := pools[*pool.OverflowPoolID]; ok && !target.Archived && !visited[target.ID] {
+				return placeAmount(pools, plan, target, excess, visited)
+			}
+		}
+	case models.OverflowSpread:
+		if spreadExcess(pools, plan, excess, visited) {
+			return nil
+		}
+	}
+
+	msg := fmt.Sprintf("Donation exceeds the funding cap of %q by $%.2f", pool.Name, fromCents(excess))
+	return models.NewRequestError(msg, http.StatusConflict)
+}
+
+// spreadExcess places cents across the neediest pools not in exclude. Pools
+// are first topped up to their goals, largest shortfall first; anything left
+// goes to whichever pools still have room. It reports whether the whole
+// amount could be placed.
+func spreadExcess(pools map[int]*poolState, plan *allocationPlan, cents int64, exclude map[int]bool) bool {
+	var candidates []*poolState
+	for _, p := range pools {
+		if exclude[p.ID] || !p.accepting() {
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].shortfall() != candidates[j].shortfall() {
+			return candidates[i].shortfall() > candidates[j].shortfall()
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	give := func(p *poolState, limit int64) {
+		amount := min(cents, limit)
+		if room, capped := p.room(); capped {
+			amount = min(amount, room)
+		}
+		plan.add(p.ID, amount)
+		p.Balance += amount
+		cents -= amount
+	}
+
+	for _, p := range candidates {
+		give(p, p.shortfall())
+	}
+	for _, p := range candidates {
+		give(p, cents)
+	}
+	return cents == 0
+}
+
+// openPools returns the pools that can still accept money, ordered by ID.
+func openPools(pools map[int]*poolState) []*poolState {
+	var open []*poolState
+	for _, p := range pools {
+		if !p.accepting() {
+			continue
+		}
+		open = append(open, p)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].ID < open[j].ID })
+	return open
+}
+
+// distribute apportions cents across pools in proportion to weight, using the
+// largest remainder method so the shares always add up to whole cents. Pools
+// with no weight or no room are skipped, and any share clipped by a cap is
+// redistributed among the rest. It returns the cents that could not be placed.
+func distribute(plan *allocationPlan, pools []*poolState, cents int64, weight func(*poolState) int64) int64 {
+	for cents > 0 {
+		var eligible []*poolState
+		var weights []int64
+		var totalWeight int64
+		for _, p := range pools {
+			w := weight(p)
+			if room, capped := p.room(); w <= 0 || (capped && room == 0) {
+				continue
+			}
+			eligible = append(eligible, p)
+			weights = append(weights, w)
+			totalWeight += w
+		}
+		if len(eligible) == 0 {
+			break
+		}
+
+		shares := make([]int64, len(eligible))
+		order := make([]int, len(eligible))
+		remainders := make([]int64, len(eligible))
+		var assigned int64
+		for i, w := range weights {
+			shares[i] = cents * w / totalWeight
+			remainders[i] = cents * w % totalWeight
+			assigned += shares[i]
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+		for i := int64(0); i < cents-assigned; i++ {
+			shares[order[i]]++
+		}
+
+		var placed int64
+		for i, p := range eligible {
+			amount := shares[i]
+			if room, capped := p.room(); capped {
+				amount = min(amount, room)
+			}
+			plan.add(p.ID, amount)
+			p.Balance += amount
+			placed += amount
+		}
+		if placed == 0 {
+			break
+		}
+		cents -= placed
+	}
+	return cents
+}
+
+// splitDonation divides a donation of cents across the pools that can still
+// accept money, according to strategy. Whatever the strategy cannot place,
+// such as money left over once every pool has reached its goal, is split
+// evenly among the pools that still have room.
+func splitDonation(pools map[int]*poolState, cents int64, strategy string) ([]models.AllocationRequest, error) {
+	if cents <= 0 {
+		return nil, models.NewRequestError("Donation amount must be positive", http.StatusBadRequest)
+	}
+
+	candidates := openPools(pools)
+	if len(candidates) == 0 {
+		return nil, models.NewRequestError("No funding pools can accept donations right now", http.StatusConflict)
+	}
+
+	plan := newAllocationPlan()
+	remaining := cents
+	switch strategy {
+	case models.AllocationStrategyShortfall:
+		// Only what the pools still need is split by shortfall; split more
+		// and the pools would be pushed past their goals in proportion too.
+		var needed int64
+		for _, p := range candidates {
+			needed += p.shortfall()
+		}
+		toGoals := min(remaining, needed)
+		remaining -= toGoals - distribute(plan, candidates, toGoals, func(p *poolState) int64 { return p.shortfall() })
+	case models.AllocationStrategyLowestPercent:
+		byPercent := append([]*poolState(nil), candidates...)
+		sort.SliceStable(byPercent, func(i, j int) bool {
+			// Compare balance/goal without division: a/b < c/d <=> a*d < c*b.
+			return byPercent[i].Balance*byPercent[j].Goal < byPercent[j].Balance*byPercent[i].Goal
+		})
+		for _, p := range byPercent {
+			amount := min(remaining, p.shortfall())
+			if room, capped := p.room(); capped {
+				amount = min(amount, room)
+			}
+			plan.add(p.ID, amount)
+			p.Balance += amount
+			remaining -= amount
+		}
+	case models.AllocationStrategyEven:
+		// Handled by the even split below.
+	default:
+		return nil, models.NewRequestError("Allocation strategy must be one of shortfall, lowest_percent or even", http.StatusBadRequest)
+	}
+
+	remaining = distribute(plan, candidates, remaining, func(*poolState) int64 { return 1 })
+	if remaining > 0 {
+		msg := fmt.Sprintf("Donation exceeds the pools' remaining room under their funding caps by $%.2f", fromCents(remaining))
+		return nil, models.NewRequestError(msg, http.StatusConflict)
+	}
+	return plan.requests(), nil
+}
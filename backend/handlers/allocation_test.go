@@ -0,0 +1,576 @@
+package handlers
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"pool-party-api/models"
+	"reflect"
+	"testing"
+)
+
+// testPools builds the pool map the allocation rules work on. Amounts are in
+// cents.
+func testPools(specs ...poolState) map[int]*poolState {
+	pools := make(map[int]*poolState, len(specs))
+	for i := range specs {
+		p := specs[i]
+		if p.OverflowPolicy == "" {
+			p.OverflowPolicy = models.OverflowReject
+		}
+		pools[p.ID] = &p
+	}
+	return pools
+}
+
+func centsPtr(cents int64) *int64 { return &cents }
+
+func poolPtr(id int) *int { return &id }
+
+// requestStatus returns the HTTP status of a request error, or 0 for nil.
+func requestStatus(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var reqErr *models.RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("error %v is not a request error", err)
+	}
+	return reqErr.Status
+}
+
+func TestApplyFundingCaps(t *testing.T) {
+	tests := []struct {
+		name       string
+		pools      map[int]*poolState
+		requested  []models.AllocationRequest
+		want       []models.AllocationRequest
+		wantStatus int
+	}{
+		{
+			name:      "uncapped",
+			pools:     testPools(poolState{ID: 1, Name: "A", Goal: 1000}),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 25}},
+			want:      []models.AllocationRequest{{FundingPoolID: 1, Amount: 25}},
+		},
+		{
+			name:      "under the cap",
+			pools:     testPools(poolState{ID: 1, Name: "A", Goal: 1000, Balance: 500, Cap: centsPtr(1000)}),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			want:      []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+		},
+		{
+			name:      "zero amounts are dropped",
+			pools:     testPools(poolState{ID: 1, Name: "A", Goal: 1000}, poolState{ID: 2, Name: "B", Goal: 1000}),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 0}, {FundingPoolID: 2, Amount: 1}},
+			want:      []models.AllocationRequest{{FundingPoolID: 2, Amount: 1}},
+		},
+		{
+			name:       "cap hit with reject",
+			pools:      testPools(poolState{ID: 1, Name: "A", Goal: 1000, Balance: 800, Cap: centsPtr(1000)}),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "cap hit across allocations to the same pool",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(600), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000},
+			),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 4}, {FundingPoolID: 1, Amount: 4}},
+			want:      []models.AllocationRequest{{FundingPoolID: 1, Amount: 6}, {FundingPoolID: 2, Amount: 2}},
+		},
+		{
+			name: "redirect",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Balance: 800, Cap: centsPtr(1000), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000},
+			),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			want:      []models.AllocationRequest{{FundingPoolID: 1, Amount: 2}, {FundingPoolID: 2, Amount: 3}},
+		},
+		{
+			name: "redirect chain",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(3)},
+				poolState{ID: 3, Name: "C", Goal: 1000},
+			),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			want:      []models.AllocationRequest{{FundingPoolID: 1, Amount: 1}, {FundingPoolID: 2, Amount: 1}, {FundingPoolID: 3, Amount: 3}},
+		},
+		{
+			name: "redirect cycle",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(1)},
+			),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "redirect to itself",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(1)},
+			),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "redirect to an archived pool",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000, Archived: true},
+			),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "redirect to a missing pool",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(9)},
+			),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "redirect to a full pool",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000, Balance: 300, Cap: centsPtr(300)},
+			),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "redirect to a capped pool that spreads",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowRedirect, OverflowPoolID: poolPtr(2)},
+				poolState{ID: 2, Name: "B", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowSpread},
+				poolState{ID: 3, Name: "C", Goal: 1000},
+			),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			want:      []models.AllocationRequest{{FundingPoolID: 1, Amount: 1}, {FundingPoolID: 2, Amount: 1}, {FundingPoolID: 3, Amount: 3}},
+		},
+		{
+			name: "spread",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Balance: 1000, Cap: centsPtr(1000), OverflowPolicy: models.OverflowSpread},
+				poolState{ID: 2, Name: "B", Goal: 1000},
+				poolState{ID: 3, Name: "C", Goal: 1000, Balance: 500},
+			),
+			requested: []models.AllocationRequest{{FundingPoolID: 1, Amount: 12}},
+			want:      []models.AllocationRequest{{FundingPoolID: 2, Amount: 10}, {FundingPoolID: 3, Amount: 2}},
+		},
+		{
+			name: "spread exhausted",
+			pools: testPools(
+				poolState{ID: 1, Name: "A", Goal: 1000, Cap: centsPtr(100), OverflowPolicy: models.OverflowSpread},
+				poolState{ID: 2, Name: "B", Goal: 1000, Cap: centsPtr(200)},
+				poolState{ID: 3, Name: "C", Goal: 1000, Archived: true},
+			),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "unknown pool",
+			pools:      testPools(poolState{ID: 1, Name: "A", Goal: 1000}),
+			requested:  []models.AllocationRequest{{FundingPoolID: 2, Amount: 5}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "archived pool",
+			pools:      testPools(poolState{ID: 1, Name: "A", Goal: 1000, Archived: true}),
+			requested:  []models.AllocationRequest{{FundingPoolID: 1, Amount: 5}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyFundingCaps(tt.pools, tt.requested)
+			if status := requestStatus(t, err); status != tt.wantStatus {
+				t.Fatalf("applyFundingCaps() error = %v, want status %d", err, tt.wantStatus)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyFundingCaps() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpreadExcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		pools   map[int]*poolState
+		cents   int64
+		exclude map[int]bool
+		want    map[int]int64
+		wantAll bool
+	}{
+		{
+			name: "largest shortfall first",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Balance: 900},
+				poolState{ID: 2, Goal: 1000, Balance: 200},
+			),
+			cents:   500,
+			want:    map[int]int64{2: 500},
+			wantAll: true,
+		},
+		{
+			name: "ties go to the lower ID",
+			pools: testPools(
+				poolState{ID: 2, Goal: 1000},
+				poolState{ID: 1, Goal: 1000},
+			),
+			cents:   1500,
+			want:    map[int]int64{1: 1000, 2: 500},
+			wantAll: true,
+		},
+		{
+			name: "room beyond the goals once every goal is met",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Balance: 900},
+				poolState{ID: 2, Goal: 500, Balance: 500, Cap: centsPtr(600)},
+			),
+			cents:   300,
+			want:    map[int]int64{1: 300},
+			wantAll: true,
+		},
+		{
+			name: "caps limit the top-up",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Cap: centsPtr(100)},
+				poolState{ID: 2, Goal: 500},
+			),
+			cents:   400,
+			want:    map[int]int64{1: 100, 2: 300},
+			wantAll: true,
+		},
+		{
+			name: "excluded, archived and full pools are skipped",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000},
+				poolState{ID: 2, Goal: 1000, Archived: true},
+				poolState{ID: 3, Goal: 1000, Balance: 100, Cap: centsPtr(100)},
+				poolState{ID: 4, Goal: 100},
+			),
+			cents:   300,
+			exclude: map[int]bool{1: true},
+			want:    map[int]int64{4: 300},
+			wantAll: true,
+		},
+		{
+			name: "exhausted",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Cap: centsPtr(100)},
+				poolState{ID: 2, Goal: 1000, Balance: 150, Cap: centsPtr(200)},
+			),
+			cents: 500,
+			want:  map[int]int64{1: 100, 2: 50},
+		},
+		{
+			name:    "no pools",
+			pools:   testPools(poolState{ID: 1, Goal: 1000}),
+			cents:   500,
+			exclude: map[int]bool{1: true},
+			want:    map[int]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := newAllocationPlan()
+			exclude := tt.exclude
+			if exclude == nil {
+				exclude = map[int]bool{}
+			}
+			if all := spreadExcess(tt.pools, plan, tt.cents, exclude); all != tt.wantAll {
+				t.Errorf("spreadExcess() = %v, want %v", all, tt.wantAll)
+			}
+			if !reflect.DeepEqual(plan.amounts, tt.want) {
+				t.Errorf("placed %v, want %v", plan.amounts, tt.want)
+			}
+			for id, cents := range tt.want {
+				if p := tt.pools[id]; p.Cap != nil && p.Balance > *p.Cap {
+					t.Errorf("pool %d balance %d exceeds its cap %d after placing %d", id, p.Balance, *p.Cap, cents)
+				}
+			}
+		})
+	}
+}
+
+func TestDistribute(t *testing.T) {
+	tests := []struct {
+		name         string
+		pools        []poolState
+		weights      []int64 // per pool, in order
+		cents        int64
+		want         []int64 // placed per pool, in order
+		wantUnplaced int64
+	}{
+		{
+			name:    "even split gives the spare cent to the first pool",
+			pools:   []poolState{{ID: 1}, {ID: 2}, {ID: 3}},
+			weights: []int64{1, 1, 1},
+			cents:   100,
+			want:    []int64{34, 33, 33},
+		},
+		{
+			name:    "proportional",
+			pools:   []poolState{{ID: 1}, {ID: 2}},
+			weights: []int64{1, 3},
+			cents:   1000,
+			want:    []int64{250, 750},
+		},
+		{
+			name:    "largest remainders get the spare cents",
+			pools:   []poolState{{ID: 1}, {ID: 2}, {ID: 3}},
+			weights: []int64{1, 2, 4},
+			cents:   10, // 1.43, 2.86 and 5.71
+			want:    []int64{1, 3, 6},
+		},
+		{
+			name:    "fewer cents than pools",
+			pools:   []poolState{{ID: 1}, {ID: 2}, {ID: 3}},
+			weights: []int64{1, 1, 1},
+			cents:   2,
+			want:    []int64{1, 1, 0},
+		},
+		{
+			name:    "pools without weight are skipped",
+			pools:   []poolState{{ID: 1}, {ID: 2}},
+			weights: []int64{0, 5},
+			cents:   100,
+			want:    []int64{0, 100},
+		},
+		{
+			name:    "amounts clipped by a cap go to the others",
+			pools:   []poolState{{ID: 1, Cap: centsPtr(100)}, {ID: 2}, {ID: 3}},
+			weights: []int64{1, 1, 2},
+			cents:   1000,
+			want:    []int64{100, 300, 600},
+		},
+		{
+			name:    "full pools are skipped",
+			pools:   []poolState{{ID: 1, Balance: 100, Cap: centsPtr(100)}, {ID: 2}},
+			weights: []int64{1, 1},
+			cents:   100,
+			want:    []int64{0, 100},
+		},
+		{
+			name:         "no room left",
+			pools:        []poolState{{ID: 1, Cap: centsPtr(30)}, {ID: 2, Balance: 10, Cap: centsPtr(30)}},
+			weights:      []int64{1, 1},
+			cents:        100,
+			want:         []int64{30, 20},
+			wantUnplaced: 50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools := make([]*poolState, len(tt.pools))
+			weights := make(map[int]int64)
+			for i := range tt.pools {
+				p := tt.pools[i]
+				pools[i] = &p
+				weights[p.ID] = tt.weights[i]
+			}
+			plan := newAllocationPlan()
+			unplaced := distribute(plan, pools, tt.cents, func(p *poolState) int64 { return weights[p.ID] })
+			if unplaced != tt.wantUnplaced {
+				t.Errorf("distribute() = %d unplaced, want %d", unplaced, tt.wantUnplaced)
+			}
+			for i, p := range pools {
+				if got := plan.amounts[p.ID]; got != tt.want[i] {
+					t.Errorf("pool %d got %d cents, want %d", p.ID, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitDonation(t *testing.T) {
+	tests := []struct {
+		name       string
+		pools      map[int]*poolState
+		cents      int64
+		strategy   string
+		want       []models.AllocationRequest
+		wantStatus int
+	}{
+		{
+			name: "shortfall",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000},
+				poolState{ID: 2, Goal: 1000, Balance: 500},
+				poolState{ID: 3, Goal: 1000, Balance: 1000},
+			),
+			cents:    300,
+			strategy: models.AllocationStrategyShortfall,
+			want:     []models.AllocationRequest{{FundingPoolID: 1, Amount: 2}, {FundingPoolID: 2, Amount: 1}},
+		},
+		{
+			name: "shortfall splits what is beyond the goals evenly",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000},
+				poolState{ID: 2, Goal: 1000, Balance: 500},
+			),
+			cents:    2100,
+			strategy: models.AllocationStrategyShortfall,
+			want:     []models.AllocationRequest{{FundingPoolID: 1, Amount: 13}, {FundingPoolID: 2, Amount: 8}},
+		},
+		{
+			name: "shortfall redistributes what a cap clips",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Cap: centsPtr(100)},
+				poolState{ID: 2, Goal: 1000},
+			),
+			cents:    1000,
+			strategy: models.AllocationStrategyShortfall,
+			want:     []models.AllocationRequest{{FundingPoolID: 1, Amount: 1}, {FundingPoolID: 2, Amount: 9}},
+		},
+		{
+			name: "lowest percent fills the least funded pool first",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Balance: 500},
+				poolState{ID: 2, Goal: 100, Balance: 10},
+				poolState{ID: 3, Goal: 400, Balance: 400},
+			),
+			cents:    200,
+			strategy: models.AllocationStrategyLowestPercent,
+			want:     []models.AllocationRequest{{FundingPoolID: 2, Amount: 0.9}, {FundingPoolID: 1, Amount: 1.1}},
+		},
+		{
+			name: "lowest percent stops at a cap",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000, Balance: 500},
+				poolState{ID: 2, Goal: 1000, Cap: centsPtr(50)},
+			),
+			cents:    200,
+			strategy: models.AllocationStrategyLowestPercent,
+			want:     []models.AllocationRequest{{FundingPoolID: 2, Amount: 0.5}, {FundingPoolID: 1, Amount: 1.5}},
+		},
+		{
+			name: "lowest percent splits what is beyond the goals evenly",
+			pools: testPools(
+				poolState{ID: 1, Goal: 100, Balance: 50},
+				poolState{ID: 2, Goal: 100, Balance: 100},
+			),
+			cents:    250,
+			strategy: models.AllocationStrategyLowestPercent,
+			want:     []models.AllocationRequest{{FundingPoolID: 1, Amount: 1.5}, {FundingPoolID: 2, Amount: 1}},
+		},
+		{
+			name: "even",
+			pools: testPools(
+				poolState{ID: 1, Goal: 1000},
+				poolState{ID: 2, Goal: 1000, Balance: 1000},
+				poolState{ID: 3, Goal: 1000, Archived: true},
+				poolState{ID: 4, Goal: 1000, Balance: 100, Cap: centsPtr(100)},
+				poolState{ID: 5, Goal: 1000},
+			),
+			cents:    100,
+			strategy: models.AllocationStrategyEven,
+			want:     []models.AllocationRequest{{FundingPoolID: 1, Amount: 0.34}, {FundingPoolID: 2, Amount: 0.33}, {FundingPoolID: 5, Amount: 0.33}},
+		},
+		{
+			name:       "more than the caps allow",
+			pools:      testPools(poolState{ID: 1, Goal: 1000, Cap: centsPtr(100)}),
+			cents:      200,
+			strategy:   models.AllocationStrategyEven,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "no open pools",
+			pools:      testPools(poolState{ID: 1, Goal: 1000, Archived: true}),
+			cents:      200,
+			strategy:   models.AllocationStrategyEven,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "unknown strategy",
+			pools:      testPools(poolState{ID: 1, Goal: 1000}),
+			cents:      200,
+			strategy:   "random",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "nothing to split",
+			pools:      testPools(poolState{ID: 1, Goal: 1000}),
+			strategy:   models.AllocationStrategyEven,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitDonation(tt.pools, tt.cents, tt.strategy)
+			if status := requestStatus(t, err); status != tt.wantStatus {
+				t.Fatalf("splitDonation() error = %v, want status %d", err, tt.wantStatus)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitDonation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Whatever the pools look like, a split places exactly the donation, keeps
+// within the caps and leaves archived pools alone, or is refused because the
+// caps leave too little room.
+func TestSplitDonationInvariants(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	strategies := []string{models.AllocationStrategyShortfall, models.AllocationStrategyLowestPercent, models.AllocationStrategyEven}
+	for i := 0; i < 2000; i++ {
+		var specs []poolState
+		for id := 1; id <= 1+rng.Intn(5); id++ {
+			p := poolState{ID: id, Goal: 1 + rng.Int63n(100000), Archived: rng.Intn(8) == 0}
+			p.Balance = rng.Int63n(p.Goal * 2)
+			if rng.Intn(2) == 0 {
+				p.Cap = centsPtr(p.Balance + rng.Int63n(p.Goal))
+			}
+			specs = append(specs, p)
+		}
+		pools := testPools(specs...)
+		cents := 1 + rng.Int63n(200000)
+		strategy := strategies[i%len(strategies)]
+
+		var room int64
+		unlimited := false
+		for _, p := range pools {
+			if p.Archived {
+				continue
+			}
+			if r, capped := p.room(); capped {
+				room += r
+			} else {
+				unlimited = true
+			}
+		}
+		fits := unlimited || room >= cents
+
+		got, err := splitDonation(pools, cents, strategy)
+		if (err == nil) != fits {
+			t.Fatalf("case %d (%s, %d cents, %+v): error = %v, want one only if the donation does not fit", i, strategy, cents, specs, err)
+		}
+		if err != nil {
+			continue
+		}
+		var total int64
+		for _, a := range got {
+			spec := specs[a.FundingPoolID-1]
+			placed := toCents(a.Amount)
+			if placed <= 0 || spec.Archived || (spec.Cap != nil && spec.Balance+placed > *spec.Cap) {
+				t.Fatalf("case %d (%s, %+v): allocated %d cents to pool %+v", i, strategy, specs, placed, spec)
+			}
+			total += placed
+		}
+		if total != cents {
+			t.Fatalf("case %d (%s, %+v): allocated %d cents in total, want %d", i, strategy, specs, total, cents)
+		}
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"pool-party-api/oidc"
+	"pool-party-api/store"
 
+	"github.com/gorilla/sessions"
 	"google.golang.org/api/idtoken"
 )
 
@@ -20,6 +24,21 @@ type UserResponse struct {
 	FirstName   string `json:"first_name"`
 	LastName    string `json:"last_name"`
 	IsModerator bool   `json:"is_moderator"`
+	DonateOnly  bool   `json:"donate_only"`
+
+	Roles       []auth.Role       `json:"roles"`
+	Permissions []auth.Permission `json:"permissions"`
+}
+
+// setRoles fills in the user's roles and the permissions they grant.
+// IsModerator is kept for the frontend, which shows the moderator tools to
+// anyone who can act on pools or money.
+func (u *UserResponse) setRoles(roles []auth.Role) {
+	u.Roles = roles
+	u.Permissions = auth.PermissionsFor(roles)
+	u.IsModerator = auth.HasPermission(roles, auth.PermManagePools) ||
+		auth.HasPermission(roles, auth.PermWithdraw) ||
+		auth.HasPermission(roles, auth.PermExternalDonation)
 }
 
 // GoogleLogin verifies the ID token from Google Sign-In, creates or updates a user
@@ -31,15 +50,14 @@ func (env *APIEnv) GoogleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	if googleClientID == "" {
+	if env.GoogleClientID == "" {
 		respondError(w, http.StatusInternalServerError, "Google Client ID not configured")
-		log.Println("GOOGLE_CLIENT_ID environment variable not set")
+		log.Println("Google Sign-In attempted, but google.client_id is not configured")
 		return
 	}
 
 	// Validate the token
-	payload, err := idtoken.Validate(context.Background(), req.Credential, googleClientID)
+	payload, err := idtoken.Validate(context.Background(), req.Credential, env.GoogleClientID)
 	if err != nil {
 		respondError(w, http.StatusUnauthorized, "Invalid ID token")
 		log.Printf("Token validation error: %v", err)
@@ -47,28 +65,31 @@ func (env *APIEnv) GoogleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Upsert user in the database
-	var user UserResponse
-	query := `
-		INSERT INTO users (google_id, email, first_name, last_name)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (google_id) DO UPDATE
-		SET email = EXCLUDED.email, first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name
-		RETURNING google_id, email, first_name, last_name, is_moderator;
-	`
-	firstName, _ := payload.Claims["given_name"].(string)
-	lastName, _ := payload.Claims["family_name"].(string)
-
-	err = env.DB.QueryRowContext(r.Context(), query, payload.Subject, payload.Claims["email"], firstName, lastName).Scan(
-		&user.GoogleID, &user.Email, &user.FirstName, &user.LastName, &user.IsModerator,
-	)
+	claims := &oidc.Claims{Issuer: payload.Issuer, Subject: payload.Subject}
+	claims.Email, _ = payload.Claims["email"].(string)
+	claims.EmailVerified, _ = payload.Claims["email_verified"].(bool)
+	claims.GivenName, _ = payload.Claims["given_name"].(string)
+	claims.FamilyName, _ = payload.Claims["family_name"].(string)
+	claims.HostedDomain, _ = payload.Claims["hd"].(string)
+
+	user, err := env.loginUser(r.Context(), auth.GoogleProvider, claims, env.loginLink(r, env.GoogleLinkByEmail))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to save user data")
-		log.Printf("User upsert error: %v", err)
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to save user data")
+			log.Printf("User upsert error: %v", err)
+		}
 		return
 	}
 
 	// Create a session
 	session, _ := env.SessionStore.Get(r, "pool-party-session")
+	if err := env.renewSession(r, session); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save session")
+		log.Printf("Session renewal error: %v", err)
+		return
+	}
 	session.Values["google_id"] = user.GoogleID
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
@@ -80,6 +101,140 @@ func (env *APIEnv) GoogleLogin(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, user)
 }
 
+// loginUser finds or creates the user behind an identity from any provider,
+// refreshes their name and email from the claims of the identity the account
+// was created with, and records the identity. Accounts the login policy refuses are rejected, and its verdict
+// on whether the user may only donate is refreshed on every login.
+//
+// An identity seen for the first time is linked to the user already signed
+// in, if any, so people link providers explicitly. Otherwise it is linked to
+// the user with the same verified email only if link.ByEmail is set, since
+// that trusts the provider to verify addresses; a new identity whose email
+// belongs to another account is refused.
+//
+// The users table is still keyed by google_id for compatibility. Google users
+// keep their Google subject as their ID; users from other providers get
+// "<provider>:<subject>".
+func (env *APIEnv) loginUser(ctx context.Context, provider string, claims *oidc.Claims, link identityLink) (*UserResponse, error) {
+	if claims.Email == "" {
+		return nil, models.NewRequestError("Your account did not provide an email address", http.StatusForbidden)
+	}
+
+	access := env.LoginPolicy.Evaluate(provider, claims)
+	if access == auth.AccessDenied {
+		log.Printf("Refused %s login for %s: not on the login allowlist", provider, claims.Email)
+		return nil, models.NewRequestError("Your account is not allowed to sign in to this site", http.StatusForbidden)
+	}
+	donateOnly := access == auth.AccessDonateOnly
+
+	var user *UserResponse
+	err := env.Store.WithTx(ctx, func(tx store.Store) error {
+		userID, err := tx.UserIDForIdentity(ctx, provider, claims.Subject)
+		if err == store.ErrNotFound && link.SignedInAs != "" {
+			if _, err = tx.GetUser(ctx, link.SignedInAs); err == nil {
+				userID = link.SignedInAs
+			}
+		}
+		if err == store.ErrNotFound && link.ByEmail && claims.EmailVerified {
+			userID, err = tx.UserIDForEmail(ctx, claims.Email)
+		}
+		primaryID := claims.Subject
+		if provider != auth.GoogleProvider {
+			primaryID = provider + ":" + claims.Subject
+		}
+		if err == store.ErrNotFound {
+			userID = primaryID
+		} else if err != nil {
+			return err
+		}
+
+		// Another account already owns this email, and the identity was not
+		// linked to it. Its owner can link it by signing in first.
+		owner, err := tx.UserIDForEmail(ctx, claims.Email)
+		if err == nil && owner != userID {
+			return models.NewRequestError("An account with this email address already exists. Sign in to it first to link this login.", http.StatusConflict)
+		}
+		if err != nil && err != store.ErrNotFound {
+			return err
+		}
+
+		record := store.User{
+			GoogleID:   userID,
+			Email:      claims.Email,
+			FirstName:  claims.GivenName,
+			LastName:   claims.FamilyName,
+			DonateOnly: donateOnly,
+		}
+		// Only the identity the account was created with keeps its email
+		// and name up to date. A linked identity's email is recorded on the
+		// identity alone, so logging in through it changes neither.
+		existing, err := tx.GetUser(ctx, userID)
+		if err == nil && userID != primaryID {
+			record.Email, record.FirstName, record.LastName = existing.Email, existing.FirstName, existing.LastName
+		} else if err != nil && err != store.ErrNotFound {
+			return err
+		}
+
+		u, err := tx.UpsertUser(ctx, record)
+		if err != nil {
+			return err
+		}
+		user = userResponse(u)
+
+		roles, err := tx.UserRoles(ctx, user.GoogleID)
+		if err != nil {
+			return err
+		}
+		user.setRoles(roles)
+
+		return tx.UpsertIdentity(ctx, provider, claims.Subject, user.GoogleID, claims.Email)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// renewSession gives a session a new token before a user is signed in with
+// it, against session fixation. Only stores that keep sessions on the server
+// need to: a cookie store's cookie holds the session itself, and is replaced
+// when it is saved.
+func (env *APIEnv) renewSession(r *http.Request, session *sessions.Session) error {
+	if renewer, ok := env.SessionStore.(interface {
+		Renew(*http.Request, *sessions.Session) error
+	}); ok {
+		return renewer.Renew(r, session)
+	}
+	return nil
+}
+
+// identityLink says which existing user an identity that is not linked to any
+// user yet may be linked to.
+type identityLink struct {
+	// SignedInAs is the user already signed in, who links the identity to
+	// their account by using it.
+	SignedInAs string
+	// ByEmail links the identity to the user with the same verified email.
+	ByEmail bool
+}
+
+// loginLink returns how a login on r through a provider may link a new
+// identity.
+func (env *APIEnv) loginLink(r *http.Request, linkByEmail bool) identityLink {
+	signedInAs, _ := env.sessionUserID(r)
+	return identityLink{SignedInAs: signedInAs, ByEmail: linkByEmail}
+}
+
+func userResponse(u *store.User) *UserResponse {
+	return &UserResponse{
+		GoogleID:   u.GoogleID,
+		Email:      u.Email,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		DonateOnly: u.DonateOnly,
+	}
+}
+
 // GetCurrentUser checks the session and returns the current user's data if authenticated.
 func (env *APIEnv) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	session, _ := env.SessionStore.Get(r, "pool-party-session")
@@ -89,15 +244,20 @@ func (env *APIEnv) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user UserResponse
-	query := `SELECT google_id, email, first_name, last_name, is_moderator FROM users WHERE google_id = $1`
-	err := env.DB.QueryRowContext(r.Context(), query, googleID).Scan(
-		&user.GoogleID, &user.Email, &user.FirstName, &user.LastName, &user.IsModerator,
-	)
+	u, err := env.Store.GetUser(r.Context(), googleID)
 	if err != nil {
 		respondError(w, http.StatusNotFound, "User not found")
 		return
 	}
+	user := userResponse(u)
+
+	roles, err := env.Store.UserRoles(r.Context(), googleID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load user roles")
+		log.Printf("Role lookup error: %v", err)
+		return
+	}
+	user.setRoles(roles)
 
 	respondJSON(w, http.StatusOK, user)
 }
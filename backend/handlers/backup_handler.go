@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"pool-party-api/backup"
+	"pool-party-api/models"
+)
+
+// ExportBackup downloads a backup archive of the whole instance. Since it
+// holds every user's email address, each export is recorded in the audit log.
+func (env *APIEnv) ExportBackup(w http.ResponseWriter, r *http.Request) {
+	actorGoogleID, _ := userIDFromContext(r.Context())
+
+	archive, err := backup.Export(r.Context(), env.Store)
+	if err != nil {
+		log.Printf("Error exporting backup: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to export backup")
+		return
+	}
+	details := map[string]interface{}{"pools": len(archive.Pools), "users": len(archive.Users), "ledger_entries": len(archive.Ledger)}
+	if err := env.Store.RecordAudit(r.Context(), actorGoogleID, models.AuditBackupExport, "", details); err != nil {
+		log.Printf("Error recording backup export: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to export backup")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pool-party-backup-%s.json"`, archive.CreatedAt.Format("2006-01-02")))
+	respondJSON(w, http.StatusOK, archive)
+}
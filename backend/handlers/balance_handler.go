@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"pool-party-api/models"
+	"pool-party-api/store"
+)
+
+// CheckPoolBalances recomputes every pool's balance from the ledger and
+// reports any pool whose maintained balance has drifted from it, without
+// changing anything.
+func (env *APIEnv) CheckPoolBalances(w http.ResponseWriter, r *http.Request) {
+	drift, err := env.Store.CheckPoolBalances(r.Context())
+	if err != nil {
+		log.Printf("Error checking pool balances: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to check pool balances")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.PoolBalanceReport{Consistent: len(drift) == 0, Drift: drift})
+}
+
+// ReconcilePoolBalances resets any drifted pool balance to the one recomputed
+// from the ledger, and reports what it found. Donations and withdrawals wait
+// until it is done. Corrections are recorded in the audit log.
+func (env *APIEnv) ReconcilePoolBalances(w http.ResponseWriter, r *http.Request) {
+	actorGoogleID, _ := userIDFromContext(r.Context())
+
+	var drift []models.PoolBalanceDrift
+	err := env.Store.WithTx(r.Context(), func(tx store.Store) error {
+		if err := tx.LockAllPools(r.Context()); err != nil {
+			return err
+		}
+
+		var err error
+		drift, err = tx.ReconcilePoolBalances(r.Context())
+		if err != nil || len(drift) == 0 {
+			return err
+		}
+		return tx.RecordAudit(r.Context(), actorGoogleID, models.AuditBalanceReconcile, "", map[string]interface{}{"drift": drift})
+	})
+	if err != nil {
+		log.Printf("Error reconciling pool balances: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to reconcile pool balances")
+		return
+	}
+	for _, d := range drift {
+		log.Printf("Reconciled balance of pool %d from %.2f to %.2f", d.FundingPoolID, d.Stored, d.Ledger)
+	}
+
+	respondJSON(w, http.StatusOK, models.PoolBalanceReport{Consistent: len(drift) == 0, Reconciled: len(drift) > 0, Drift: drift})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	env := &APIEnv{AllowedOrigins: []string{"https://admin.example.org/"}}
+	handler := env.CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"GET from another site", http.MethodGet, map[string]string{"Origin": "https://evil.example"}, http.StatusNoContent},
+		{"HEAD without headers", http.MethodHead, nil, http.StatusNoContent},
+		{"same-origin POST", http.MethodPost, map[string]string{"Origin": "https://pool.example.com"}, http.StatusNoContent},
+		{"same-origin DELETE by referer", http.MethodDelete, map[string]string{"Referer": "https://pool.example.com/manage/3"}, http.StatusNoContent},
+		{"allowed origin", http.MethodPut, map[string]string{"Origin": "https://ADMIN.example.org"}, http.StatusNoContent},
+		{"bearer token from anywhere", http.MethodPost, map[string]string{"Authorization": "Bearer ppt_abc", "Origin": "https://evil.example"}, http.StatusNoContent},
+		{"cross-site POST", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"cross-site PUT", http.MethodPut, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"cross-site DELETE by referer", http.MethodDelete, map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+		{"lookalike host", http.MethodPost, map[string]string{"Origin": "https://pool.example.com.evil.example"}, http.StatusForbidden},
+		{"null origin", http.MethodPost, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"no origin or referer", http.MethodPost, nil, http.StatusForbidden},
+		{"Sec-Fetch-Site cross-site", http.MethodPost, map[string]string{"Origin": "https://pool.example.com", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "https://pool.example.com/api/withdrawals", strings.NewReader("{}"))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionCookieOptions(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	store.Options = SessionCookieOptions(true)
+
+	req := httptest.NewRequest(http.MethodPost, "https://pool.example.com/api/auth/google/callback", nil)
+	rec := httptest.NewRecorder()
+	session, _ := store.Get(req, "pool-party-session")
+	session.Values["google_id"] = "123"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	c := cookies[0]
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+		t.Errorf("cookie %q has HttpOnly=%v Secure=%v SameSite=%v Path=%q", c.Name, c.HttpOnly, c.Secure, c.SameSite, c.Path)
+	}
+}
@@ -8,16 +8,33 @@ import (
 	"math"
 	"net/http"
 	"pool-party-api/models"
-	"pool-party-api/paypal"
+	"pool-party-api/store"
 	"strconv"
 )
 
 // CaptureDonationRequest is the expected request body for capturing a donation.
+// If IsAnonymous is omitted, a signed-in donor's default-anonymous preference
+// applies.
+// When AutoAllocate is set, Allocations must be empty and the server splits
+// Amount across the pools using Strategy.
 type CaptureDonationRequest struct {
-	OrderID     string                     `json:"orderID"`
+	OrderID      string                     `json:"orderID"`
+	Allocations  []models.AllocationRequest `json:"allocations"`
+	Description  string                     `json:"description,omitempty"`
+	IsAnonymous  *bool                      `json:"isAnonymous"`
+	AutoAllocate bool                       `json:"autoAllocate"`
+	Amount       float64                    `json:"amount,omitempty"`
+	Strategy     string                     `json:"strategy,omitempty"`
+}
+
+// CaptureDonationResponse is returned once a donation has been captured and
+// recorded. It extends PayPal's capture response with the allocations that
+// were actually applied, which may differ from those requested when a pool's
+// funding cap redirected part of the donation.
+type CaptureDonationResponse struct {
+	*models.OrderCaptureResponse
+	LedgerID    int                        `json:"ledger_id"`
 	Allocations []models.AllocationRequest `json:"allocations"`
-	Description string                     `json:"description,omitempty"`
-	IsAnonymous bool                       `json:"isAnonymous"`
 }
 
 // ExternalDonationRequest is the expected request body for creating an external donation.
@@ -26,58 +43,25 @@ type ExternalDonationRequest struct {
 	Description string                     `json:"description"`
 }
 
-// LedgerEntryData is a struct for passing all necessary data to create a ledger entry and its allocations.
-type LedgerEntryData struct {
-	TransactionID   sql.NullString
-	Amount          float64
-	TransactionType string
-	UserGoogleID    sql.NullString
-	FirstName       sql.NullString
-	LastInitial     sql.NullString
-	Anonymous       bool
-	Description     sql.NullString
-	Allocations     []models.AllocationRequest
-}
-
-// CreateLedgerEntriesInTx is a helper function to record a transaction and its allocations in the database
-// using an existing transaction. It does not commit or rollback the transaction.
-func (env *APIEnv) CreateLedgerEntriesInTx(ctx context.Context, tx *sql.Tx, data LedgerEntryData) (int, error) {
-	var ledgerID int
-	ledgerQuery := `
-		INSERT INTO ledger (transaction_id, amount, transaction_type, user_google_id, first_name, last_initial, anonymous, description)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id`
-	err := tx.QueryRowContext(ctx, ledgerQuery, data.TransactionID, data.Amount, data.TransactionType, data.UserGoogleID, data.FirstName, data.LastInitial, data.Anonymous, data.Description).Scan(&ledgerID)
-	if err != nil {
-		return 0, err
+// allocationStrategy returns the strategy to use when the donor did not pick one.
+func (env *APIEnv) allocationStrategy(requested string) string {
+	if requested != "" {
+		return requested
 	}
-
-	for _, alloc := range data.Allocations {
-		if alloc.Amount > 0 {
-			if _, err := tx.ExecContext(ctx, "INSERT INTO allocation (ledger_id, funding_pool_id, amount) VALUES ($1, $2, $3)", ledgerID, alloc.FundingPoolID, alloc.Amount); err != nil {
-				return 0, err
-			}
-		}
+	if env.DefaultAllocationStrategy != "" {
+		return env.DefaultAllocationStrategy
 	}
-
-	return ledgerID, nil
+	return models.AllocationStrategyShortfall
 }
 
-// createLedgerEntries is a wrapper for CreateLedgerEntriesInTx that handles its own transaction.
-func (env *APIEnv) createLedgerEntries(ctx context.Context, data LedgerEntryData) (int, error) {
-	tx, err := env.DB.BeginTx(ctx, nil)
-	if err != nil {
-		log.Printf("Failed to start database transaction: %v", err)
-		return 0, err
+// planDonation returns the allocations to record for a donation of amount,
+// either splitting it automatically or applying the pools' funding caps to
+// the donor's own allocations.
+func (env *APIEnv) planDonation(pools map[int]*poolState, req *CaptureDonationRequest, amount float64) ([]models.AllocationRequest, error) {
+	if req.AutoAllocate {
+		return splitDonation(pools, toCents(amount), env.allocationStrategy(req.Strategy))
 	}
-	defer tx.Rollback()
-
-	ledgerID, err := env.CreateLedgerEntriesInTx(ctx, tx, data)
-	if err != nil {
-		return 0, err
-	}
-
-	return ledgerID, tx.Commit()
+	return applyFundingCaps(pools, req.Allocations)
 }
 
 // CaptureDonation verifies a PayPal order, captures the payment, and records the transaction.
@@ -93,10 +77,48 @@ func (env *APIEnv) CaptureDonation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a new PayPal client
-	ppClient, err := paypal.NewClient()
+	// Calculate total from frontend allocations, or take the donor's total
+	// when the server is splitting it.
+	var totalAllocation float64
+	if req.AutoAllocate {
+		if len(req.Allocations) > 0 {
+			respondError(w, http.StatusBadRequest, "Provide either allocations or autoAllocate, not both.")
+			return
+		}
+		if req.Amount <= 0 {
+			respondError(w, http.StatusBadRequest, "Donation amount must be positive.")
+			return
+		}
+		totalAllocation = req.Amount
+	}
+	for _, alloc := range req.Allocations {
+		if alloc.Amount < 0 {
+			respondError(w, http.StatusBadRequest, "Donation amounts cannot be negative.")
+			return
+		}
+		totalAllocation += alloc.Amount
+	}
+
+	// Check the requested allocations against the pools' funding caps before
+	// any money moves, so a donation that would be rejected is never captured.
+	pools, err := loadPoolStates(r.Context(), env.Store)
 	if err != nil {
-		log.Printf("Error creating PayPal client: %v", err)
+		log.Printf("Error loading funding pools for order %s: %v", req.OrderID, err)
+		respondError(w, http.StatusInternalServerError, "Could not verify funding pools")
+		return
+	}
+	if _, err := env.planDonation(pools, &req, totalAllocation); err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	ppClient := env.PayPal
+	if ppClient == nil {
+		log.Printf("Cannot capture order %s: PayPal is not configured", req.OrderID)
 		respondError(w, http.StatusInternalServerError, "PayPal client configuration error")
 		return
 	}
@@ -139,16 +161,6 @@ func (env *APIEnv) CaptureDonation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Calculate total from frontend allocations
-	var totalAllocation float64
-	for _, alloc := range req.Allocations {
-		if alloc.Amount < 0 {
-			respondError(w, http.StatusBadRequest, "Donation amounts cannot be negative.")
-			return
-		}
-		totalAllocation += alloc.Amount
-	}
-
 	// Security Check: Verify that the amount captured by PayPal matches the
 	// total allocation amount from the frontend. Use a small tolerance for float comparison.
 	if math.Abs(capturedAmount-totalAllocation) > 0.01 {
@@ -164,7 +176,7 @@ func (env *APIEnv) CaptureDonation(w http.ResponseWriter, r *http.Request) {
 
 	// Determine user's name and anonymity status
 	var userGoogleID, firstName, lastInitial sql.NullString
-	anonymous := req.IsAnonymous
+	anonymous := req.IsAnonymous != nil && *req.IsAnonymous
 
 	session, _ := env.SessionStore.Get(r, "pool-party-session")
 	if googleID, ok := session.Values["google_id"].(string); ok {
@@ -172,22 +184,17 @@ func (env *APIEnv) CaptureDonation(w http.ResponseWriter, r *http.Request) {
 		userGoogleID.String = googleID
 		userGoogleID.Valid = true
 
-		// Only fetch their name if the donation is not anonymous.
-		if !anonymous {
-			var dbFirstName, lastName string
-			userQuery := `SELECT first_name, last_name FROM users WHERE google_id = $1`
-			err := env.DB.QueryRowContext(r.Context(), userQuery, googleID).Scan(&dbFirstName, &lastName)
-			if err != nil {
-				log.Printf("Could not find logged-in user %s for donation, will use PayPal name if available: %v", googleID, err)
-			} else {
-				if len(dbFirstName) > 0 {
-					firstName.String = dbFirstName
-					firstName.Valid = true
-				}
-				if len(lastName) > 0 {
-					lastInitial.String = string(lastName[0])
-					lastInitial.Valid = true
-				}
+		profile, err := loadDonorProfile(r.Context(), env.Store, googleID)
+		if err != nil {
+			log.Printf("Could not find logged-in user %s for donation, will use PayPal name if available: %v", googleID, err)
+		} else {
+			// Without an explicit choice, fall back to the user's preference.
+			if req.IsAnonymous == nil {
+				anonymous = profile.DefaultAnonymous
+			}
+			// Only use their name if the donation is not anonymous.
+			if !anonymous {
+				firstName, lastInitial = profile.ledgerName()
 			}
 		}
 	}
@@ -197,10 +204,7 @@ func (env *APIEnv) CaptureDonation(w http.ResponseWriter, r *http.Request) {
 	if !anonymous && !firstName.Valid && captureResponse != nil && captureResponse.Payer.Name.GivenName != "" {
 		firstName.String = captureResponse.Payer.Name.GivenName
 		firstName.Valid = true
-		if captureResponse.Payer.Name.Surname != "" {
-			lastInitial.String = string(captureResponse.Payer.Name.Surname[0])
-			lastInitial.Valid = true
-		}
+		lastInitial = initialOf(captureResponse.Payer.Name.Surname)
 	}
 
 	var transactionID sql.NullString
@@ -213,34 +217,105 @@ func (env *APIEnv) CaptureDonation(w http.ResponseWriter, r *http.Request) {
 		description.Valid = true
 	}
 
-	ledgerData := LedgerEntryData{
-		TransactionID:   transactionID,
-		Amount:          capturedAmount,
-		TransactionType: "deposit",
-		UserGoogleID:    userGoogleID,
-		FirstName:       firstName,
-		LastInitial:     lastInitial,
-		Anonymous:       anonymous,
-		Description:     description,
-		Allocations:     req.Allocations,
-	}
+	var allocations []models.AllocationRequest
+	var ledgerID int
+	capsFilled := false
+	err = env.Store.WithTx(r.Context(), func(tx store.Store) error {
+		// Lock the pools so concurrent donations cannot both fill the same
+		// remaining room under a cap, then apply the caps to current balances.
+		if err := tx.LockAllPools(r.Context()); err != nil {
+			return err
+		}
+		pools, err := loadPoolStates(r.Context(), tx)
+		if err != nil {
+			return err
+		}
+		allocations, err = env.planDonation(pools, &req, capturedAmount)
+		if err != nil {
+			capsFilled = true
+			return err
+		}
 
-	ledgerID, err := env.createLedgerEntries(r.Context(), ledgerData)
+		ledgerID, err = tx.CreateLedgerEntry(r.Context(), store.LedgerEntryData{
+			TransactionID:   transactionID,
+			Amount:          capturedAmount,
+			TransactionType: "deposit",
+			UserGoogleID:    userGoogleID,
+			FirstName:       firstName,
+			LastInitial:     lastInitial,
+			Anonymous:       anonymous,
+			Description:     description,
+			Allocations:     allocations,
+		})
+		return err
+	})
+	if capsFilled {
+		// The pools filled up after the check above, but the payment has
+		// already been captured, so give the money back. The refund goes
+		// ahead even if the donor has stopped waiting for the response.
+		log.Printf("Funding caps rejected captured order %s, refunding it: %v", req.OrderID, err)
+		if _, err := ppClient.RefundCapture(context.WithoutCancel(r.Context()), transactionID.String, accessToken); err != nil {
+			log.Printf("CRITICAL: Could not refund captured order %s (capture %s): %v", req.OrderID, transactionID.String, err)
+			respondError(w, http.StatusConflict, "A funding pool filled up while your donation was processed. Please contact support.")
+			return
+		}
+		respondError(w, http.StatusConflict, "A funding pool filled up while your donation was processed, so your payment has been refunded. Please try again.")
+		return
+	}
 	if err != nil {
+		log.Printf("Failed to record transaction for PayPal order %s: %v", req.OrderID, err)
 		respondError(w, http.StatusInternalServerError, "Failed to record transaction")
 		return
 	}
 
 	log.Printf("Successfully recorded transaction for PayPal order %s. Ledger ID: %d", req.OrderID, ledgerID)
 
-	respondJSON(w, http.StatusOK, captureResponse)
+	respondJSON(w, http.StatusOK, CaptureDonationResponse{
+		OrderCaptureResponse: captureResponse,
+		LedgerID:             ledgerID,
+		Allocations:          allocations,
+	})
+}
+
+// PreviewDonationSplit shows how a donation of the given amount would be split
+// across the pools if the donor lets the server allocate it. It takes the
+// "amount" and optional "strategy" query parameters.
+func (env *APIEnv) PreviewDonationSplit(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseFloat(r.URL.Query().Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		respondError(w, http.StatusBadRequest, "A positive amount is required")
+		return
+	}
+	strategy := env.allocationStrategy(r.URL.Query().Get("strategy"))
+
+	pools, err := loadPoolStates(r.Context(), env.Store)
+	if err != nil {
+		log.Printf("Error loading funding pools for donation preview: %v", err)
+		respondError(w, http.StatusInternalServerError, "Could not load funding pools")
+		return
+	}
+
+	allocations, err := splitDonation(pools, toCents(amount), strategy)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.AllocationPreview{
+		Amount:      fromCents(toCents(amount)),
+		Strategy:    strategy,
+		Allocations: allocations,
+	})
 }
 
 // CreateExternalDonation handles the manual creation of a donation by a moderator.
 func (env *APIEnv) CreateExternalDonation(w http.ResponseWriter, r *http.Request) {
-	// Step 1: Get Moderator ID from session (middleware already confirmed they are a mod)
-	session, _ := env.SessionStore.Get(r, "pool-party-session")
-	moderatorGoogleID, ok := session.Values["google_id"].(string)
+	// Step 1: Get Moderator ID set by the middleware (which already confirmed their permission)
+	moderatorGoogleID, ok := userIDFromContext(r.Context())
 	if !ok {
 		// This should not happen if middleware is working correctly
 		respondError(w, http.StatusUnauthorized, "Not authenticated")
@@ -276,7 +351,7 @@ func (env *APIEnv) CreateExternalDonation(w http.ResponseWriter, r *http.Request
 	description.String = req.Description
 	description.Valid = true
 
-	ledgerData := LedgerEntryData{
+	ledgerData := store.LedgerEntryData{
 		Amount:          totalDonation,
 		TransactionType: "deposit",
 		UserGoogleID:    sql.NullString{String: moderatorGoogleID, Valid: true},
@@ -287,8 +362,9 @@ func (env *APIEnv) CreateExternalDonation(w http.ResponseWriter, r *http.Request
 		Allocations: req.Allocations,
 	}
 
-	ledgerID, err := env.createLedgerEntries(r.Context(), ledgerData)
+	ledgerID, err := env.Store.CreateLedgerEntry(r.Context(), ledgerData)
 	if err != nil {
+		log.Printf("Failed to record external donation: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to record external donation")
 		return
 	}
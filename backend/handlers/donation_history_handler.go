@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"pool-party-api/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseYear reads the optional "year" query parameter.
+func parseYear(r *http.Request) (*int, error) {
+	v := r.URL.Query().Get("year")
+	if v == "" {
+		return nil, nil
+	}
+	year, err := strconv.Atoi(v)
+	if err != nil || year < 2000 || year > 9999 {
+		return nil, models.NewRequestError("Invalid year", http.StatusBadRequest)
+	}
+	return &year, nil
+}
+
+// summarizeDonations totals donations overall and per pool, largest first.
+func summarizeDonations(donations []models.UserDonation) (float64, []models.PoolDonationTotal) {
+	var total int64
+	byPool := make(map[int]*models.PoolDonationTotal)
+	poolCents := make(map[int]int64)
+	for _, d := range donations {
+		total += toCents(d.Amount)
+		for _, a := range d.Allocations {
+			if _, ok := byPool[a.FundingPoolID]; !ok {
+				byPool[a.FundingPoolID] = &models.PoolDonationTotal{FundingPoolID: a.FundingPoolID, FundingPoolName: a.FundingPoolName}
+			}
+			poolCents[a.FundingPoolID] += toCents(a.Amount)
+		}
+	}
+
+	pools := make([]models.PoolDonationTotal, 0, len(byPool))
+	for id, p := range byPool {
+		p.Total = fromCents(poolCents[id])
+		pools = append(pools, *p)
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		if pools[i].Total != pools[j].Total {
+			return pools[i].Total > pools[j].Total
+		}
+		return pools[i].FundingPoolID < pools[j].FundingPoolID
+	})
+	return fromCents(total), pools
+}
+
+// csvSafe stops spreadsheet programs from treating user-entered text, such as
+// a donation description, as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// GetMyDonations returns the current user's own donations with per-pool and
+// per-year totals. The optional "year" parameter limits the donations and
+// per-pool totals to that calendar year.
+func (env *APIEnv) GetMyDonations(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	year, err := parseYear(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	donations, err := env.Store.UserDonations(r.Context(), googleID, year)
+	if err != nil {
+		log.Printf("Error querying donations for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching donations")
+		return
+	}
+	years, err := env.Store.UserDonationYears(r.Context(), googleID)
+	if err != nil {
+		log.Printf("Error querying donation totals for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching donations")
+		return
+	}
+
+	history := models.DonationHistory{Year: year, Donations: donations, ByYear: years}
+	history.Total, history.ByPool = summarizeDonations(donations)
+	respondJSON(w, http.StatusOK, history)
+}
+
+// GetDonationStatement returns the current user's donations for one calendar
+// year as a downloadable CSV statement, e.g. for expense reports.
+func (env *APIEnv) GetDonationStatement(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	year, err := parseYear(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+	if year == nil {
+		respondError(w, http.StatusBadRequest, "year is required")
+		return
+	}
+
+	profile, err := loadDonorProfile(r.Context(), env.Store, googleID)
+	if err != nil {
+		log.Printf("Error loading profile for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error generating statement")
+		return
+	}
+	donations, err := env.Store.UserDonations(r.Context(), googleID, year)
+	if err != nil {
+		log.Printf("Error querying donations for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error generating statement")
+		return
+	}
+
+	siteTitle := "Pool Party"
+	if site, err := env.Store.GetSite(r.Context()); err != nil {
+		log.Printf("Error loading site title for statement: %v", err)
+	} else {
+		siteTitle = site.SiteTitle
+	}
+
+	total, byPool := summarizeDonations(donations)
+	fullName := strings.TrimSpace(profile.FirstName.String + " " + profile.LastName.String)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="donations-%d.csv"`, *year))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	records := [][]string{
+		{"Donation statement", csvSafe(siteTitle)},
+		{"Donor", csvSafe(fullName)},
+		{"Email", csvSafe(profile.Email)},
+		{"Period", fmt.Sprintf("%d-01-01 to %d-12-31 (UTC)", *year, *year)},
+		{},
+		{"Date", "Transaction ID", "Amount", "Pools", "Description", "Anonymous"},
+	}
+	for i := len(donations) - 1; i >= 0; i-- { // oldest first
+		d := donations[i]
+		pools := make([]string, 0, len(d.Allocations))
+		for _, a := range d.Allocations {
+			pools = append(pools, fmt.Sprintf("%s (%.2f)", a.FundingPoolName, a.Amount))
+		}
+		var description string
+		if d.Description != nil {
+			description = *d.Description
+		}
+		records = append(records, []string{
+			d.Timestamp.UTC().Format("2006-01-02"), d.TransactionID, fmt.Sprintf("%.2f", d.Amount),
+			csvSafe(strings.Join(pools, "; ")), csvSafe(description), strconv.FormatBool(d.Anonymous),
+		})
+	}
+	records = append(records, []string{}, []string{"Pool", "Total"})
+	for _, p := range byPool {
+		records = append(records, []string{csvSafe(p.FundingPoolName), fmt.Sprintf("%.2f", p.Total)})
+	}
+	records = append(records, []string{"Total", fmt.Sprintf("%.2f", total)})
+
+	if err := cw.WriteAll(records); err != nil {
+		log.Printf("Error writing donation statement: %v", err)
+	}
+}
@@ -1,14 +1,40 @@
 package handlers
 
 import (
-	"database/sql"
+	"pool-party-api/auth"
+	"pool-party-api/oidc"
+	"pool-party-api/paypal"
+	"pool-party-api/storage"
+	"pool-party-api/store"
 
 	"github.com/gorilla/sessions"
 )
 
-// APIEnv holds application-wide dependencies, such as the database connection
-// pool and session store, making them available to all handlers.
+// APIEnv holds application-wide dependencies, such as the data store and
+// session store, making them available to all handlers.
 type APIEnv struct {
-	DB           *sql.DB
+	Store        store.Store
 	SessionStore sessions.Store
+	Storage      storage.Storage
+	OIDC         *oidc.Registry
+	LoginPolicy  *auth.LoginPolicy
+
+	// GoogleClientID is the OAuth client ID that Google Sign-In tokens must
+	// be issued to. Google Sign-In is refused when it is empty.
+	GoogleClientID string
+	// GoogleLinkByEmail links new Google identities to the existing account
+	// with the same verified email.
+	GoogleLinkByEmail bool
+
+	// PayPal captures donations. PayPal donations are refused when it is nil.
+	PayPal *paypal.Client
+
+	// AllowedOrigins are origins, besides the site's own, that may send
+	// cookie-authenticated requests that change state, e.g. a separately
+	// hosted frontend.
+	AllowedOrigins []string
+
+	// DefaultAllocationStrategy is used for donations the server splits
+	// across pools when the donor does not pick a strategy.
+	DefaultAllocationStrategy string
 }
@@ -1,11 +1,13 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"pool-party-api/admin"
+	"pool-party-api/markdown"
 	"pool-party-api/models"
+	"pool-party-api/store"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -42,73 +44,83 @@ func getIDFromRequest(r *http.Request) (int, error) {
 	return id, nil
 }
 
-// decodeAndValidateFundingPoolRequest decodes the request body and validates common fields.
-func decodeAndValidateFundingPoolRequest(r *http.Request) (*models.CreateFundingPoolRequest, error) {
+// decodeFundingPoolRequest decodes a pool from the request body. It is
+// validated by the admin package when saved.
+func decodeFundingPoolRequest(r *http.Request) (*models.CreateFundingPoolRequest, error) {
 	var req models.CreateFundingPoolRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return nil, models.NewRequestError("Invalid request body", http.StatusBadRequest)
 	}
+	return &req, nil
+}
 
-	if req.Name == "" {
-		return nil, models.NewRequestError("Pool name is required", http.StatusBadRequest)
+// imageURL returns the public URL at which a stored image can be fetched.
+func imageURL(key string) string {
+	return "/api/images/" + key
+}
+
+// populatePoolPresentation fills in the derived, display-only fields of a
+// funding pool: the rendered description and the image URLs.
+func populatePoolPresentation(p *models.FundingPool, imageKey, thumbnailKey *string) {
+	if p.Description != nil {
+		html, err := markdown.Render(*p.Description)
+		if err != nil {
+			log.Printf("Error rendering description for funding pool %d: %v", p.ID, err)
+		} else {
+			p.DescriptionHTML = &html
+		}
 	}
-	if req.GoalAmount <= 0 {
-		return nil, models.NewRequestError("Goal amount must be a positive number", http.StatusBadRequest)
+	if imageKey != nil {
+		url := imageURL(*imageKey)
+		p.ImageURL = &url
 	}
-	return &req, nil
+	if thumbnailKey != nil {
+		url := imageURL(*thumbnailKey)
+		p.ThumbnailURL = &url
+	}
+}
+
+// presentPool returns a stored pool with its presentation fields filled in.
+func presentPool(p store.Pool) models.FundingPool {
+	pool := p.FundingPool
+	populatePoolPresentation(&pool, p.ImageKey, p.ThumbnailKey)
+	return pool
 }
 
 // getFundingPoolQuery fetches funding pool(s) based on an optional ID.
-// If id is 0, it fetches all pools. Otherwise, it fetches the pool with the given ID.
+// If id is 0, it fetches all pools, leaving out archived pools unless the
+// include_archived query parameter is "true". Otherwise, it fetches the pool
+// with the given ID.
 func (env *APIEnv) getFundingPoolQuery(r *http.Request, id int) ([]models.FundingPool, error) {
-	query := `
-        SELECT
-            fp.id,
-            fp.name,
-            fp.description,
-            fp.goal_amount,
-            COALESCE(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END), 0) as current_amount
-        FROM
-            funding_pool fp
-        LEFT JOIN
-            allocation a ON fp.id = a.funding_pool_id
-		LEFT JOIN
-			ledger l ON a.ledger_id = l.id
-        `
-	var args []interface{}
+	var stored []store.Pool
 	if id != 0 {
-		query += ` WHERE fp.id = $1`
-		args = append(args, id)
-	}
-	query += ` GROUP BY fp.id ORDER BY fp.id;`
-
-	rows, err := env.DB.QueryContext(r.Context(), query, args...)
-	if err != nil {
-		log.Printf("Error querying funding pools: %v", err)
-		return nil, models.NewInternalError("Error fetching funding pools")
-	}
-	defer rows.Close()
-
-	pools := make([]models.FundingPool, 0)
-	for rows.Next() {
-		var p models.FundingPool
-		var description sql.NullString
-		if err := rows.Scan(&p.ID, &p.Name, &description, &p.GoalAmount, &p.CurrentAmount); err != nil {
-			log.Printf("Error scanning funding pool row: %v", err)
-			return nil, models.NewInternalError("Error scanning funding pool data")
+		p, err := env.Store.GetPool(r.Context(), id)
+		if err != nil && err != store.ErrNotFound {
+			log.Printf("Error querying funding pool %d: %v", id, err)
+			return nil, models.NewInternalError("Error fetching funding pools")
+		}
+		if p != nil {
+			stored = append(stored, *p)
 		}
-		if description.Valid {
-			p.Description = &description.String
+	} else {
+		var err error
+		stored, err = env.Store.ListPools(r.Context(), r.URL.Query().Get("include_archived") == "true")
+		if err != nil {
+			log.Printf("Error querying funding pools: %v", err)
+			return nil, models.NewInternalError("Error fetching funding pools")
 		}
-		pools = append(pools, p)
 	}
 
+	pools := make([]models.FundingPool, 0, len(stored))
+	for _, p := range stored {
+		pools = append(pools, presentPool(p))
+	}
 	return pools, nil
 }
 
 // --- Handler Functions ---
 
-// GetFundingPools fetches all funding pools.
+// GetFundingPools fetches all active funding pools.
 func (env *APIEnv) GetFundingPools(w http.ResponseWriter, r *http.Request) {
 	pools, err := env.getFundingPoolQuery(r, 0) // Fetch all pools
 	if err != nil {
@@ -153,7 +165,7 @@ func (env *APIEnv) GetFundingPool(w http.ResponseWriter, r *http.Request) {
 
 // CreateFundingPool handles the creation of a new funding pool.
 func (env *APIEnv) CreateFundingPool(w http.ResponseWriter, r *http.Request) {
-	req, err := decodeAndValidateFundingPoolRequest(r)
+	req, err := decodeFundingPoolRequest(r)
 	if err != nil {
 		if reqErr, ok := err.(*models.RequestError); ok {
 			respondError(w, reqErr.Status, reqErr.Message)
@@ -161,27 +173,30 @@ func (env *APIEnv) CreateFundingPool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `
-        INSERT INTO funding_pool (name, description, goal_amount)
-        VALUES ($1, $2, $3)
-        RETURNING id`
+	moderatorGoogleID, _ := userIDFromContext(r.Context())
 
-	var newID int
-	err = env.DB.QueryRowContext(r.Context(), query, req.Name, req.Description, req.GoalAmount).Scan(&newID)
+	newID, err := admin.CreatePool(r.Context(), env.Store, moderatorGoogleID, req)
 	if err != nil {
-		// Consider adding more specific error handling for unique constraint violations etc.
-		log.Printf("Error inserting new funding pool: %v", err)
-		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error inserting new funding pool: %v", err)
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
 		return
 	}
 
 	newPool := models.FundingPool{
-		ID:            newID,
-		Name:          req.Name,
-		Description:   req.Description,
-		GoalAmount:    req.GoalAmount,
-		CurrentAmount: 0,
+		ID:             newID,
+		Name:           req.Name,
+		Description:    req.Description,
+		GoalAmount:     req.GoalAmount,
+		CurrentAmount:  0,
+		CapAmount:      req.CapAmount,
+		OverflowPolicy: req.OverflowPolicy,
+		OverflowPoolID: req.OverflowPoolID,
 	}
+	populatePoolPresentation(&newPool, nil, nil)
 
 	respondJSON(w, http.StatusCreated, newPool)
 }
@@ -198,7 +213,7 @@ func (env *APIEnv) UpdateFundingPool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, err := decodeAndValidateFundingPoolRequest(r)
+	req, err := decodeFundingPoolRequest(r)
 	if err != nil {
 		if reqErr, ok := err.(*models.RequestError); ok {
 			respondError(w, reqErr.Status, reqErr.Message)
@@ -206,19 +221,22 @@ func (env *APIEnv) UpdateFundingPool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateQuery := `UPDATE funding_pool SET name = $1, description = $2, goal_amount = $3 WHERE id = $4`
-	result, err := env.DB.ExecContext(r.Context(), updateQuery, req.Name, req.Description, req.GoalAmount, id)
-	if err != nil {
-		log.Printf("Error updating funding pool with ID %d: %v", id, err)
-		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-		return
-	}
+	moderatorGoogleID, _ := userIDFromContext(r.Context())
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	err = admin.UpdatePool(r.Context(), env.Store, moderatorGoogleID, id, req)
+	if err == store.ErrNotFound {
 		respondError(w, http.StatusNotFound, "Funding pool not found")
 		return
 	}
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error updating funding pool with ID %d: %v", id, err)
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
 
 	// To return the full updated object, fetch it after update.
 	// You could also construct it from the request body if you're certain it reflects the DB state.
@@ -250,47 +268,75 @@ func (env *APIEnv) DeleteFundingPool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, err := env.DB.BeginTx(r.Context(), nil)
+	moderatorGoogleID, _ := userIDFromContext(r.Context())
+
+	deleted, err := admin.DeletePool(r.Context(), env.Store, moderatorGoogleID, id)
 	if err != nil {
-		log.Printf("Error starting transaction: %v", err)
-		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error deleting funding pool with ID %d: %v", id, err)
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
 		return
 	}
-	defer tx.Rollback() // Will be rolled back if Commit() is not called
 
-	var allocationExists int
-	checkQuery := `SELECT 1 FROM allocation WHERE funding_pool_id = $1 LIMIT 1`
-	err = tx.QueryRowContext(r.Context(), checkQuery, id).Scan(&allocationExists)
+	// Images are only removed once the pool row is gone for good.
+	env.deleteImages(r.Context(), deleted.ImageKey, deleted.ThumbnailKey)
+
+	w.WriteHeader(http.StatusNoContent) // No content to return for successful deletion
+}
+
+// ArchiveFundingPool archives a funding pool. Archived pools keep their
+// history and balance but are hidden from the home page and no longer accept
+// donations. Unlike deletion, this is allowed for pools with donations.
+func (env *APIEnv) ArchiveFundingPool(w http.ResponseWriter, r *http.Request) {
+	env.setFundingPoolArchived(w, r, true)
+}
+
+// UnarchiveFundingPool restores an archived funding pool.
+func (env *APIEnv) UnarchiveFundingPool(w http.ResponseWriter, r *http.Request) {
+	env.setFundingPoolArchived(w, r, false)
+}
 
-	if err == nil { // Row found, allocations exist
-		respondError(w, http.StatusBadRequest, "Cannot delete funding pool with existing donations")
+// setFundingPoolArchived archives or restores a funding pool and records the
+// change in its revision history.
+func (env *APIEnv) setFundingPoolArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	id, err := getIDFromRequest(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
 		return
 	}
-	if err != sql.ErrNoRows { // Unexpected DB error
-		log.Printf("Error checking for allocations for pool ID %d: %v", id, err)
-		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+
+	moderatorGoogleID, _ := userIDFromContext(r.Context())
+
+	err = admin.SetPoolArchived(r.Context(), env.Store, moderatorGoogleID, id, archived)
+	if err == store.ErrNotFound {
+		respondError(w, http.StatusNotFound, "Funding pool not found")
 		return
 	}
-
-	deleteQuery := `DELETE FROM funding_pool WHERE id = $1`
-	result, err := tx.ExecContext(r.Context(), deleteQuery, id)
 	if err != nil {
-		log.Printf("Error deleting funding pool with ID %d: %v", id, err)
+		log.Printf("Error archiving funding pool with ID %d: %v", id, err)
 		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		respondError(w, http.StatusNotFound, "Funding pool not found")
+	pools, err := env.getFundingPoolQuery(r, id)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
 		return
 	}
-
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction for deleting pool ID %d: %v", id, err)
-		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	if len(pools) == 0 {
+		respondError(w, http.StatusNotFound, "Funding pool not found")
 		return
 	}
-
-	w.WriteHeader(http.StatusNoContent) // No content to return for successful deletion
+	respondJSON(w, http.StatusOK, pools[0])
 }
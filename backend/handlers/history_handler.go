@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"pool-party-api/models"
+	"time"
+)
+
+// historyGranularities maps the accepted granularity parameter values to a
+// function truncating a day to the start of its period, and the step between
+// periods in days and months.
+var historyGranularities = map[string]struct {
+	trunc        func(time.Time) time.Time
+	days, months int
+}{
+	"daily":   {truncDay, 1, 0},
+	"weekly":  {truncWeek, 7, 0},
+	"monthly": {truncMonth, 0, 1},
+}
+
+func truncDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// truncWeek returns the Monday starting t's ISO week.
+func truncWeek(t time.Time) time.Time {
+	d := truncDay(t)
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
+
+func truncMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// parseHistoryDate parses an optional YYYY-MM-DD query parameter.
+func parseHistoryDate(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, models.NewRequestError("Dates must be formatted as YYYY-MM-DD", http.StatusBadRequest)
+	}
+	return &t, nil
+}
+
+// GetFundingPoolHistory returns a pool's balance over time, along with the
+// deposits and withdrawals in each period. It reads the pool's daily totals,
+// which ledger writes keep up to date, so the cost depends on the number of
+// days with activity rather than the size of the ledger.
+//
+// Query parameters: granularity (daily, weekly or monthly; default daily),
+// and optional from and to dates bounding the periods returned.
+func (env *APIEnv) GetFundingPoolHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDFromRequest(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = "daily"
+	}
+	period, ok := historyGranularities[granularity]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Granularity must be one of daily, weekly or monthly")
+		return
+	}
+
+	from, err := parseHistoryDate(r, "from")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	to, err := parseHistoryDate(r, "to")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	exists, err := env.Store.PoolExists(r.Context(), id)
+	if err != nil {
+		log.Printf("Error checking funding pool %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if !exists {
+		respondError(w, http.StatusNotFound, "Funding pool not found")
+		return
+	}
+
+	days, err := env.Store.PoolDailyTotals(r.Context(), id)
+	if err != nil {
+		log.Printf("Error querying history for funding pool %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching funding pool history")
+		return
+	}
+
+	history := models.PoolHistory{
+		FundingPoolID: id,
+		Granularity:   granularity,
+		Points:        []models.PoolHistoryPoint{},
+	}
+	if len(days) == 0 {
+		respondJSON(w, http.StatusOK, history)
+		return
+	}
+
+	type totals struct{ deposits, withdrawals int64 }
+	buckets := make(map[time.Time]*totals)
+	for _, d := range days {
+		start := period.trunc(d.Day)
+		b, ok := buckets[start]
+		if !ok {
+			b = &totals{}
+			buckets[start] = b
+		}
+		b.deposits += toCents(d.Deposits)
+		b.withdrawals += toCents(d.Withdrawals)
+	}
+
+	// Every period from the first activity up to the current one is returned,
+	// including quiet periods, so the balance series has no gaps. The running
+	// balance is computed before the from/to filter so it always includes the
+	// pool's full history.
+	var balance int64
+	last := period.trunc(time.Now().UTC())
+	for start := period.trunc(days[0].Day); !start.After(last); start = start.AddDate(0, period.months, period.days) {
+		var b totals
+		if bucket, ok := buckets[start]; ok {
+			b = *bucket
+		}
+		balance += b.deposits - b.withdrawals
+
+		if (from != nil && start.Before(period.trunc(*from))) || (to != nil && start.After(*to)) {
+			continue
+		}
+		history.Points = append(history.Points, models.PoolHistoryPoint{
+			Period:      start.Format(time.DateOnly),
+			Deposits:    fromCents(b.deposits),
+			Withdrawals: fromCents(b.withdrawals),
+			Balance:     fromCents(balance),
+		})
+	}
+
+	respondJSON(w, http.StatusOK, history)
+}
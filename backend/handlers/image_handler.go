@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"pool-party-api/admin"
+	"pool-party-api/imaging"
+	"pool-party-api/models"
+	"pool-party-api/storage"
+	"pool-party-api/store"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxImageUploadBytes is the largest image file accepted for a funding pool.
+const MaxImageUploadBytes = 5 << 20 // 5 MiB
+
+// imageContentTypes maps stored image extensions to their content type.
+var imageContentTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
+
+// newImageKeys generates unique storage keys for a pool's cover image and
+// thumbnail. A random component ensures a replaced image never shares a URL
+// with the one it replaces, so caches cannot serve a stale image.
+func newImageKeys(poolID int, ext string) (string, string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	base := fmt.Sprintf("pool-%d-%s", poolID, hex.EncodeToString(b))
+	return base + ext, base + "-thumb" + ext, nil
+}
+
+// deleteImages removes the given objects from storage, logging rather than
+// failing on error since the database no longer references them.
+func (env *APIEnv) deleteImages(ctx context.Context, keys ...*string) {
+	for _, key := range keys {
+		if key == nil {
+			continue
+		}
+		if err := env.Storage.Delete(ctx, *key); err != nil {
+			log.Printf("Error deleting image %s: %v", *key, err)
+		}
+	}
+}
+
+// UploadFundingPoolImage accepts a multipart upload in the "image" field,
+// validates it, and stores a resized cover image and thumbnail for the pool.
+func (env *APIEnv) UploadFundingPoolImage(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDFromRequest(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	// Allow a little headroom over the file limit for the multipart framing.
+	r.Body = http.MaxBytesReader(w, r.Body, MaxImageUploadBytes+64<<10)
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			respondError(w, http.StatusRequestEntityTooLarge, "Image must be 5 MB or smaller")
+			return
+		}
+		respondError(w, http.StatusBadRequest, "An image file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxImageUploadBytes+1))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read image")
+		return
+	}
+	if len(data) > MaxImageUploadBytes {
+		respondError(w, http.StatusRequestEntityTooLarge, "Image must be 5 MB or smaller")
+		return
+	}
+
+	processed, err := imaging.Process(data)
+	if err != nil {
+		respondError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	exists, err := env.Store.PoolExists(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching funding pool %d for image upload: %v", id, err)
+		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if !exists {
+		respondError(w, http.StatusNotFound, "Funding pool not found")
+		return
+	}
+
+	imageKey, thumbnailKey, err := newImageKeys(id, processed.Extension)
+	if err != nil {
+		log.Printf("Error generating image keys: %v", err)
+		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	if err := env.Storage.Put(r.Context(), imageKey, bytes.NewReader(processed.Cover)); err != nil {
+		log.Printf("Error storing image for funding pool %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Failed to store image")
+		return
+	}
+	if err := env.Storage.Put(r.Context(), thumbnailKey, bytes.NewReader(processed.Thumbnail)); err != nil {
+		log.Printf("Error storing thumbnail for funding pool %d: %v", id, err)
+		env.deleteImages(r.Context(), &imageKey)
+		respondError(w, http.StatusInternalServerError, "Failed to store image")
+		return
+	}
+
+	moderatorGoogleID, _ := userIDFromContext(r.Context())
+	oldImageKey, oldThumbnailKey, err := admin.SetPoolImage(r.Context(), env.Store, moderatorGoogleID, id, &imageKey, &thumbnailKey)
+	if err != nil {
+		env.deleteImages(r.Context(), &imageKey, &thumbnailKey)
+		if err == store.ErrNotFound {
+			respondError(w, http.StatusNotFound, "Funding pool not found")
+			return
+		}
+		log.Printf("Error saving image for funding pool %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	env.deleteImages(r.Context(), oldImageKey, oldThumbnailKey)
+
+	pools, err := env.getFundingPoolQuery(r, id)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+	if len(pools) == 0 {
+		respondError(w, http.StatusNotFound, "Funding pool not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, pools[0])
+}
+
+// DeleteFundingPoolImage removes a funding pool's cover image and thumbnail.
+func (env *APIEnv) DeleteFundingPoolImage(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDFromRequest(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	moderatorGoogleID, _ := userIDFromContext(r.Context())
+	imageKey, thumbnailKey, err := admin.SetPoolImage(r.Context(), env.Store, moderatorGoogleID, id, nil, nil)
+	if err == store.ErrNotFound {
+		respondError(w, http.StatusNotFound, "Funding pool not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error removing image for funding pool %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	env.deleteImages(r.Context(), imageKey, thumbnailKey)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetImage serves a stored image by its key.
+func (env *APIEnv) GetImage(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	contentType, ok := imageContentTypes[filepath.Ext(key)]
+	if !ok || storage.ValidateKey(key) != nil {
+		respondError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+
+	obj, err := env.Storage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "Image not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading image %s: %v", key, err)
+		respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+	defer obj.Close()
+
+	// Keys are never reused, so the content behind a URL never changes.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, obj); err != nil {
+		log.Printf("Error writing image %s: %v", key, err)
+	}
+}
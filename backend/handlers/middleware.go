@@ -1,27 +1,131 @@
 package handlers
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"strings"
 )
 
-// ModeratorRequired is a middleware that checks if the user is authenticated and is a moderator.
-func (env *APIEnv) ModeratorRequired(next http.HandlerFunc) http.HandlerFunc {
+type contextKey int
+
+const userIDKey contextKey = iota
+
+// withUserID returns a copy of ctx carrying the authenticated user's ID.
+func withUserID(ctx context.Context, googleID string) context.Context {
+	return context.WithValue(ctx, userIDKey, googleID)
+}
+
+// userIDFromContext returns the ID of the user authenticated by the
+// middleware, whether by session cookie or by personal access token.
+func userIDFromContext(ctx context.Context) (string, bool) {
+	googleID, ok := ctx.Value(userIDKey).(string)
+	return googleID, ok && googleID != ""
+}
+
+// sessionUserID returns the ID of the user signed in with the session cookie.
+func (env *APIEnv) sessionUserID(r *http.Request) (string, bool) {
+	session, _ := env.SessionStore.Get(r, "pool-party-session")
+	googleID, ok := session.Values["google_id"].(string)
+	authenticated, _ := session.Values["authenticated"].(bool)
+	return googleID, ok && authenticated
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// authenticateToken looks up an active personal access token, records that it
+// was used, and returns its owner and scopes.
+func (env *APIEnv) authenticateToken(ctx context.Context, token string) (string, []auth.Permission, error) {
+	if !auth.LooksLikeToken(token) {
+		return "", nil, models.NewRequestError("Invalid API token", http.StatusUnauthorized)
+	}
+
+	googleID, scopes, err := env.Store.UseAPIToken(ctx, auth.HashToken(token))
+	if err == store.ErrNotFound {
+		return "", nil, models.NewRequestError("Invalid API token", http.StatusUnauthorized)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	var perms []auth.Permission
+	for _, scope := range scopes {
+		perms = append(perms, auth.Permission(scope))
+	}
+	return googleID, perms, nil
+}
+
+// SessionRequired is a middleware that only admits users signed in with the
+// session cookie. It guards actions, such as creating API tokens, that a token
+// must not be able to perform on its own.
+func (env *APIEnv) SessionRequired(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session, _ := env.SessionStore.Get(r, "pool-party-session")
-		googleID, ok := session.Values["google_id"].(string)
-		if !ok || !session.Values["authenticated"].(bool) {
+		googleID, ok := env.sessionUserID(r)
+		if !ok {
 			respondError(w, http.StatusUnauthorized, "Not authenticated")
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(withUserID(r.Context(), googleID)))
+	}
+}
+
+// RequirePermission is a middleware that checks if the user is authenticated
+// and holds a role granting perm. Requests may authenticate with the session
+// cookie or with a personal access token, which must also carry perm as a scope.
+func (env *APIEnv) RequirePermission(perm auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var googleID string
+		if token, ok := bearerToken(r); ok {
+			id, scopes, err := env.authenticateToken(r.Context(), token)
+			if err != nil {
+				if reqErr, ok := err.(*models.RequestError); ok {
+					respondError(w, reqErr.Status, reqErr.Message)
+				} else {
+					respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+					log.Printf("API token lookup error: %v", err)
+				}
+				return
+			}
+			if !hasScope(scopes, perm) {
+				respondError(w, http.StatusForbidden, "API token does not have the "+string(perm)+" scope")
+				return
+			}
+			googleID = id
+		} else {
+			id, ok := env.sessionUserID(r)
+			if !ok {
+				respondError(w, http.StatusUnauthorized, "Not authenticated")
+				return
+			}
+			googleID = id
+		}
 
-		var isModerator bool
-		err := env.DB.QueryRowContext(r.Context(), "SELECT is_moderator FROM users WHERE google_id = $1", googleID).Scan(&isModerator)
-		if err != nil || !isModerator {
-			respondError(w, http.StatusForbidden, "User is not a moderator")
+		roles, err := env.Store.UserRoles(r.Context(), googleID)
+		if err != nil || !auth.HasPermission(roles, perm) {
+			respondError(w, http.StatusForbidden, "You do not have permission to do this")
 			return
 		}
 
 		// If authorized, call the next handler in the chain.
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(withUserID(r.Context(), googleID)))
+	}
+}
+
+func hasScope(scopes []auth.Permission, perm auth.Permission) bool {
+	for _, scope := range scopes {
+		if scope == perm {
+			return true
+		}
 	}
+	return false
 }
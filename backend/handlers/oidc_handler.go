@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"pool-party-api/models"
+
+	"github.com/gorilla/mux"
+)
+
+// AuthProviderResponse describes a login option shown on the frontend.
+type AuthProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+// redirectLoginError sends the browser back to the frontend with an error
+// code it can display, since the OIDC callback is a full-page navigation.
+func redirectLoginError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, "/?login_error="+url.QueryEscape(code), http.StatusFound)
+}
+
+// ListAuthProviders returns the configured OpenID Connect providers.
+func (env *APIEnv) ListAuthProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]AuthProviderResponse, 0)
+	for _, p := range env.OIDC.Providers() {
+		providers = append(providers, AuthProviderResponse{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			LoginURL:    "/api/auth/oidc/" + p.Name + "/login",
+		})
+	}
+	respondJSON(w, http.StatusOK, providers)
+}
+
+// OIDCLogin starts the authorization code flow with PKCE by redirecting the
+// browser to the provider. The state, nonce and PKCE verifier are kept in the
+// session until the provider redirects back to OIDCCallback.
+func (env *APIEnv) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := env.OIDC.Get(mux.Vars(r)["provider"])
+	if !ok {
+		respondError(w, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	authReq, err := provider.NewAuthRequest(r.Context())
+	if err != nil {
+		log.Printf("Error starting %s login: %v", provider.Name, err)
+		respondError(w, http.StatusBadGateway, "Login provider is unavailable")
+		return
+	}
+
+	session, _ := env.SessionStore.Get(r, "pool-party-session")
+	session.Values["oidc_provider"] = provider.Name
+	session.Values["oidc_state"] = authReq.State
+	session.Values["oidc_nonce"] = authReq.Nonce
+	session.Values["oidc_verifier"] = authReq.Verifier
+	if err := session.Save(r, w); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save session")
+		log.Printf("Session save error: %v", err)
+		return
+	}
+
+	http.Redirect(w, r, authReq.URL, http.StatusFound)
+}
+
+// OIDCCallback completes a login started by OIDCLogin: it checks the state,
+// redeems the authorization code, verifies the ID token, and signs the user in.
+func (env *APIEnv) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := env.OIDC.Get(mux.Vars(r)["provider"])
+	if !ok {
+		respondError(w, http.StatusNotFound, "Unknown login provider")
+		return
+	}
+
+	session, _ := env.SessionStore.Get(r, "pool-party-session")
+	expectedProvider, _ := session.Values["oidc_provider"].(string)
+	state, _ := session.Values["oidc_state"].(string)
+	nonce, _ := session.Values["oidc_nonce"].(string)
+	verifier, _ := session.Values["oidc_verifier"].(string)
+
+	// The login secrets are single use, whatever the outcome.
+	delete(session.Values, "oidc_provider")
+	delete(session.Values, "oidc_state")
+	delete(session.Values, "oidc_nonce")
+	delete(session.Values, "oidc_verifier")
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		log.Printf("%s login returned error %q: %s", provider.Name, errCode, q.Get("error_description"))
+		_ = session.Save(r, w)
+		redirectLoginError(w, r, "provider_error")
+		return
+	}
+	if state == "" || expectedProvider != provider.Name || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		_ = session.Save(r, w)
+		redirectLoginError(w, r, "invalid_state")
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		log.Printf("Error completing %s login: %v", provider.Name, err)
+		_ = session.Save(r, w)
+		redirectLoginError(w, r, "invalid_token")
+		return
+	}
+
+	user, err := env.loginUser(r.Context(), provider.Name, claims, env.loginLink(r, provider.LinkByEmail))
+	if err != nil {
+		_ = session.Save(r, w)
+		if _, ok := err.(*models.RequestError); ok {
+			redirectLoginError(w, r, "account_rejected")
+		} else {
+			log.Printf("User upsert error: %v", err)
+			redirectLoginError(w, r, "server_error")
+		}
+		return
+	}
+
+	if err := env.renewSession(r, session); err != nil {
+		log.Printf("Session renewal error: %v", err)
+		redirectLoginError(w, r, "server_error")
+		return
+	}
+	session.Values["google_id"] = user.GoogleID
+	session.Values["authenticated"] = true
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Session save error: %v", err)
+		redirectLoginError(w, r, "server_error")
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDisplayNameLength is the longest display name, in characters, a user may set.
+const maxDisplayNameLength = 50
+
+// donorProfile is the part of a user's profile that decides how they are
+// named on the ledger.
+type donorProfile struct {
+	store.DonorProfile
+}
+
+func loadDonorProfile(ctx context.Context, s store.UserStore, googleID string) (*donorProfile, error) {
+	p, err := s.DonorProfile(ctx, googleID)
+	if err != nil {
+		return nil, err
+	}
+	return &donorProfile{*p}, nil
+}
+
+// ledgerName returns the first_name and last_initial to record on the ledger
+// for this user. A display name, or the full name for users who opted in, is
+// recorded as the first name alone; otherwise it is their first name and last
+// initial.
+func (p *donorProfile) ledgerName() (firstName, lastInitial sql.NullString) {
+	if p.DisplayName.Valid && p.DisplayName.String != "" {
+		return sql.NullString{String: p.DisplayName.String, Valid: true}, sql.NullString{}
+	}
+	if p.ShowFullName {
+		full := strings.TrimSpace(p.FirstName.String + " " + p.LastName.String)
+		if full != "" {
+			return sql.NullString{String: full, Valid: true}, sql.NullString{}
+		}
+	}
+	if p.FirstName.String != "" {
+		firstName = sql.NullString{String: p.FirstName.String, Valid: true}
+	}
+	return firstName, initialOf(p.LastName.String)
+}
+
+// initialOf returns the first character of a name, or NULL for an empty name.
+func initialOf(name string) sql.NullString {
+	r, size := utf8.DecodeRuneInString(name)
+	if size == 0 || r == utf8.RuneError {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(r), Valid: true}
+}
+
+// publicName formats a ledger name the way the ledger page shows it.
+func publicName(firstName, lastInitial sql.NullString) string {
+	if firstName.Valid && lastInitial.Valid {
+		return firstName.String + " " + lastInitial.String + "."
+	}
+	if firstName.Valid {
+		return firstName.String
+	}
+	return "Anonymous"
+}
+
+func (p *donorProfile) toModel() models.UserProfile {
+	first, last := p.ledgerName()
+	profile := models.UserProfile{
+		Email:            p.Email,
+		FirstName:        p.FirstName.String,
+		LastName:         p.LastName.String,
+		DefaultAnonymous: p.DefaultAnonymous,
+		ShowFullName:     p.ShowFullName,
+		PublicName:       publicName(first, last),
+	}
+	if p.DisplayName.Valid {
+		profile.DisplayName = &p.DisplayName.String
+	}
+	return profile
+}
+
+// GetProfile returns the current user's profile and privacy settings.
+func (env *APIEnv) GetProfile(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	p, err := loadDonorProfile(r.Context(), env.Store, googleID)
+	if err == store.ErrNotFound {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading profile for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching profile")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, p.toModel())
+}
+
+// UpdateProfile replaces the current user's profile settings. They apply to
+// future donations and withdrawals; existing ledger entries keep the name
+// they were recorded with.
+func (env *APIEnv) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	var displayName *string
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength {
+			respondError(w, http.StatusBadRequest, "Display name must be at most 50 characters")
+			return
+		}
+		if name != "" {
+			displayName = &name
+		}
+	}
+
+	err := env.Store.UpdateProfile(r.Context(), googleID, displayName, req.DefaultAnonymous, req.ShowFullName)
+	if err == store.ErrNotFound {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating profile for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to update profile")
+		return
+	}
+
+	p, err := loadDonorProfile(r.Context(), env.Store, googleID)
+	if err != nil {
+		log.Printf("Error loading profile for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching profile")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, p.toModel())
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"pool-party-api/models"
+)
+
+// GetFundingPoolRevisions returns a funding pool's edit history, newest first.
+// The history is kept even after the pool itself has been deleted.
+func (env *APIEnv) GetFundingPoolRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := getIDFromRequest(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	stored, err := env.Store.ListPoolRevisions(r.Context(), id)
+	if err != nil {
+		log.Printf("Error querying revisions for funding pool %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching funding pool revisions")
+		return
+	}
+
+	revisions := make([]models.FundingPoolRevision, 0, len(stored))
+	for _, s := range stored {
+		rev := s.FundingPoolRevision
+		// Name moderators the way the ledger names donors, honouring their
+		// display name and whether they show their full name.
+		if s.Moderator != nil {
+			if first, last := (&donorProfile{*s.Moderator}).ledgerName(); first.Valid {
+				name := publicName(first, last)
+				rev.ModeratorName = &name
+			}
+		}
+		revisions = append(revisions, rev)
+	}
+
+	respondJSON(w, http.StatusOK, revisions)
+}
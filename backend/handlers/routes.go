@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"pool-party-api/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// NewRouter returns a router serving the API under /api. Other paths are
+// left for the caller, e.g. to serve the frontend.
+func NewRouter(env *APIEnv) *mux.Router {
+	router := mux.NewRouter()
+
+	// Define the FundingPool routes
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.Use(env.CSRFProtect)
+	apiRouter.HandleFunc("/funding-pools", env.GetFundingPools).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/funding-pools/{id}", env.GetFundingPool).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/funding-pools/{id}/history", env.GetFundingPoolHistory).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/funding-pools/{id}/revisions", env.GetFundingPoolRevisions).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/funding-pools", env.RequirePermission(auth.PermManagePools, env.CreateFundingPool)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/funding-pools/{id}", env.RequirePermission(auth.PermManagePools, env.UpdateFundingPool)).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/funding-pools/{id}", env.RequirePermission(auth.PermManagePools, env.DeleteFundingPool)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/funding-pools/{id}/archive", env.RequirePermission(auth.PermManagePools, env.ArchiveFundingPool)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/funding-pools/{id}/unarchive", env.RequirePermission(auth.PermManagePools, env.UnarchiveFundingPool)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/funding-pools/{id}/image", env.RequirePermission(auth.PermManagePools, env.UploadFundingPoolImage)).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/funding-pools/{id}/image", env.RequirePermission(auth.PermManagePools, env.DeleteFundingPoolImage)).Methods(http.MethodDelete)
+
+	// Define the Image routes
+	apiRouter.HandleFunc("/images/{key}", env.GetImage).Methods(http.MethodGet)
+
+	// Define the Auth routes
+	apiRouter.HandleFunc("/auth/google/callback", env.GoogleLogin).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/auth/me", env.GetCurrentUser).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/auth/logout", env.Logout).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/auth/providers", env.ListAuthProviders).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/auth/oidc/{provider}/login", env.OIDCLogin).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/auth/oidc/{provider}/callback", env.OIDCCallback).Methods(http.MethodGet)
+
+	// Define the Ledger routes
+	apiRouter.HandleFunc("/ledger", env.GetLedgerEntries).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/ledger/verify", env.VerifyLedger).Methods(http.MethodGet)
+
+	// Define the Donation routes
+	apiRouter.HandleFunc("/donations/capture", env.CaptureDonation).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/donations/preview", env.PreviewDonationSplit).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/donations/external", env.RequirePermission(auth.PermExternalDonation, env.CreateExternalDonation)).Methods(http.MethodPost)
+
+	// Define the Withdrawal routes
+	apiRouter.HandleFunc("/withdrawals", env.RequirePermission(auth.PermWithdraw, env.MakeWithdrawal)).Methods(http.MethodPost)
+
+	// Define the SiteInstance route
+	apiRouter.HandleFunc("/site-instance", env.GetSiteInstance).Methods(http.MethodGet)
+
+	// Define the profile routes
+	apiRouter.HandleFunc("/me/profile", env.SessionRequired(env.GetProfile)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me/profile", env.SessionRequired(env.UpdateProfile)).Methods(http.MethodPut)
+
+	// Define the account data routes
+	apiRouter.HandleFunc("/me/export", env.SessionRequired(env.ExportAccount)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me", env.SessionRequired(env.DeleteAccount)).Methods(http.MethodDelete)
+
+	// Define the personal donation history routes
+	apiRouter.HandleFunc("/me/donations", env.SessionRequired(env.GetMyDonations)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me/donations/statement", env.SessionRequired(env.GetDonationStatement)).Methods(http.MethodGet)
+
+	// Define the personal access token routes
+	apiRouter.HandleFunc("/me/tokens", env.SessionRequired(env.ListAPITokens)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/me/tokens", env.SessionRequired(env.CreateAPIToken)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/me/tokens/{id}", env.SessionRequired(env.RevokeAPIToken)).Methods(http.MethodDelete)
+
+	// Define the Admin routes
+	apiRouter.HandleFunc("/admin/users", env.RequirePermission(auth.PermViewAdmin, env.ListUsers)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/admin/users/{googleID}/roles", env.RequirePermission(auth.PermManageUsers, env.GrantRole)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/admin/users/{googleID}/roles/{role}", env.RequirePermission(auth.PermManageUsers, env.RevokeRole)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/admin/users/{googleID}/sessions", env.RequirePermission(auth.PermViewAdmin, env.ListUserSessions)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/admin/users/{googleID}/sessions", env.RequirePermission(auth.PermManageUsers, env.RevokeUserSessions)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/admin/users/{googleID}/sessions/{id}", env.RequirePermission(auth.PermManageUsers, env.RevokeUserSessions)).Methods(http.MethodDelete)
+	apiRouter.HandleFunc("/admin/audit-log", env.RequirePermission(auth.PermViewAdmin, env.GetAuditLog)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/admin/pool-balances", env.RequirePermission(auth.PermViewAdmin, env.CheckPoolBalances)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/admin/pool-balances/reconcile", env.RequirePermission(auth.PermWithdraw, env.ReconcilePoolBalances)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/admin/backup", env.RequirePermission(auth.PermManageUsers, env.ExportBackup)).Methods(http.MethodGet)
+
+	return router
+}
@@ -0,0 +1,830 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"pool-party-api/oidc"
+	"pool-party-api/oidc/oidctest"
+	"pool-party-api/paypal"
+	"pool-party-api/paypal/paypaltest"
+	"pool-party-api/storage"
+	"pool-party-api/store"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+const testOrigin = "https://pool.example.com"
+
+// testServer serves the API from an in-memory store.
+type testServer struct {
+	t      *testing.T
+	env    *APIEnv
+	store  *store.Memory
+	router *mux.Router
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	images, err := storage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	mem := store.NewMemory()
+	env := &APIEnv{
+		Store:        mem,
+		SessionStore: sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef")),
+		Storage:      images,
+		LoginPolicy:  &auth.LoginPolicy{},
+	}
+	return &testServer{t: t, env: env, store: mem, router: NewRouter(env)}
+}
+
+// addUser creates a user with the given roles.
+func (s *testServer) addUser(googleID string, roles ...auth.Role) {
+	s.t.Helper()
+	ctx := context.Background()
+	u := store.User{GoogleID: googleID, Email: googleID + "@example.com", FirstName: "Test", LastName: "User"}
+	if _, err := s.store.UpsertUser(ctx, u); err != nil {
+		s.t.Fatalf("UpsertUser: %v", err)
+	}
+	for _, role := range roles {
+		if _, err := s.store.GrantRole(ctx, googleID, string(role), ""); err != nil {
+			s.t.Fatalf("GrantRole: %v", err)
+		}
+	}
+}
+
+// addPool creates a funding pool directly in the store.
+func (s *testServer) addPool(name string, goal float64) int {
+	s.t.Helper()
+	id, err := s.store.CreatePool(context.Background(), &models.CreateFundingPoolRequest{Name: name, GoalAmount: goal, OverflowPolicy: models.OverflowReject})
+	if err != nil {
+		s.t.Fatalf("CreatePool: %v", err)
+	}
+	return id
+}
+
+// newRequest builds a same-origin request. A non-nil body that is not an
+// io.Reader is sent as JSON.
+func (s *testServer) newRequest(method, path string, body interface{}) *http.Request {
+	s.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		r = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("marshal request: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, testOrigin+path, r)
+	req.Header.Set("Origin", testOrigin)
+	return req
+}
+
+// signIn adds a session cookie for googleID to req.
+func (s *testServer) signIn(req *http.Request, googleID string) {
+	s.t.Helper()
+	rec := httptest.NewRecorder()
+	session, _ := s.env.SessionStore.New(httptest.NewRequest(http.MethodGet, testOrigin, nil), "pool-party-session")
+	session.Values["google_id"] = googleID
+	session.Values["authenticated"] = true
+	if err := session.Save(req, rec); err != nil {
+		s.t.Fatalf("save session: %v", err)
+	}
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+}
+
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// do sends a request, signed in as googleID unless it is empty.
+func (s *testServer) do(method, path string, body interface{}, googleID string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	req := s.newRequest(method, path, body)
+	if googleID != "" {
+		s.signIn(req, googleID)
+	}
+	return s.serve(req)
+}
+
+// expect fails the test unless rec has the wanted status, then decodes the
+// JSON body into v if v is not nil.
+// oidcLogin logs in through the mock provider registered as name, carrying
+// the session cookie along, and returns the callback's response. The login
+// starts signed in as googleID unless it is empty.
+func (s *testServer) oidcLogin(provider *oidctest.Server, name, googleID string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	login := s.do(http.MethodGet, "/api/auth/oidc/"+name+"/login", nil, googleID)
+	expect(s.t, login, http.StatusFound, nil)
+	client := provider.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := client.Get(login.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatalf("authorize request failed: %v", err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		s.t.Fatalf("invalid redirect: %v", err)
+	}
+
+	req := s.newRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, c := range login.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := s.serve(req)
+	expect(s.t, rec, http.StatusFound, nil)
+	return rec
+}
+
+func expect(t *testing.T, rec *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode response: %v; body: %s", err, rec.Body.String())
+		}
+	}
+}
+
+// pngUpload returns a multipart body with a small PNG in the "image" field.
+func pngUpload(t *testing.T) (io.Reader, string) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, err := mw.CreateFormFile("image", "cover.png")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	if err := png.Encode(part, img); err != nil {
+		t.Fatalf("encode PNG: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close multipart: %v", err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+// newFakePayPal starts a fake PayPal API and points the server's PayPal
+// client at it.
+func (s *testServer) newFakePayPal() *paypaltest.Server {
+	s.t.Helper()
+	server := paypaltest.NewServer("client", "secret")
+	s.t.Cleanup(server.Close)
+	client, err := paypal.NewClient(paypal.Config{ClientID: server.ClientID, ClientSecret: server.ClientSecret, APIBase: server.URL})
+	if err != nil {
+		s.t.Fatalf("paypal.NewClient: %v", err)
+	}
+	s.env.PayPal = client
+	return server
+}
+
+func TestFundingPoolRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("manager", auth.RolePoolManager)
+	s.addUser("viewer", auth.RoleViewer)
+
+	create := models.CreateFundingPoolRequest{Name: "Library", GoalAmount: 500}
+	expect(t, s.do(http.MethodPost, "/api/funding-pools", create, ""), http.StatusUnauthorized, nil)
+	expect(t, s.do(http.MethodPost, "/api/funding-pools", create, "viewer"), http.StatusForbidden, nil)
+
+	var pool models.FundingPool
+	expect(t, s.do(http.MethodPost, "/api/funding-pools", create, "manager"), http.StatusCreated, &pool)
+	if pool.Name != "Library" || pool.OverflowPolicy != models.OverflowReject {
+		t.Errorf("created pool = %+v", pool)
+	}
+	poolPath := "/api/funding-pools/" + strconv.Itoa(pool.ID)
+
+	var pools []models.FundingPool
+	expect(t, s.do(http.MethodGet, "/api/funding-pools", nil, ""), http.StatusOK, &pools)
+	if len(pools) != 1 {
+		t.Fatalf("got %d pools, want 1", len(pools))
+	}
+	expect(t, s.do(http.MethodGet, poolPath, nil, ""), http.StatusOK, &pool)
+	expect(t, s.do(http.MethodGet, "/api/funding-pools/999", nil, ""), http.StatusNotFound, nil)
+
+	create.Name = "Lending Library"
+	expect(t, s.do(http.MethodPut, poolPath, create, "manager"), http.StatusOK, &pool)
+	if pool.Name != "Lending Library" {
+		t.Errorf("updated name = %q", pool.Name)
+	}
+
+	expect(t, s.do(http.MethodPost, poolPath+"/archive", nil, "manager"), http.StatusOK, &pool)
+	if pool.ArchivedAt == nil {
+		t.Error("archived pool has no archived_at")
+	}
+	var unarchived models.FundingPool
+	expect(t, s.do(http.MethodPost, poolPath+"/unarchive", nil, "manager"), http.StatusOK, &unarchived)
+	if unarchived.ArchivedAt != nil {
+		t.Error("unarchived pool still has archived_at")
+	}
+
+	var revisions []models.FundingPoolRevision
+	expect(t, s.do(http.MethodGet, poolPath+"/revisions", nil, ""), http.StatusOK, &revisions)
+	if len(revisions) != 4 {
+		t.Errorf("got %d revisions, want 4", len(revisions))
+	}
+	expect(t, s.do(http.MethodGet, poolPath+"/history?interval=day", nil, ""), http.StatusOK, nil)
+
+	body, contentType := pngUpload(t)
+	req := s.newRequest(http.MethodPut, poolPath+"/image", body)
+	req.Header.Set("Content-Type", contentType)
+	s.signIn(req, "manager")
+	expect(t, s.serve(req), http.StatusOK, &pool)
+	if pool.ImageURL == nil {
+		t.Fatal("pool has no image after upload")
+	}
+	rec := s.do(http.MethodGet, *pool.ImageURL, nil, "")
+	expect(t, rec, http.StatusOK, nil)
+	if rec.Header().Get("Content-Type") == "" {
+		t.Error("image served without a content type")
+	}
+	expect(t, s.do(http.MethodDelete, poolPath+"/image", nil, "manager"), http.StatusNoContent, nil)
+	expect(t, s.do(http.MethodGet, *pool.ImageURL, nil, ""), http.StatusNotFound, nil)
+
+	expect(t, s.do(http.MethodDelete, poolPath, nil, "manager"), http.StatusNoContent, nil)
+	expect(t, s.do(http.MethodGet, poolPath, nil, ""), http.StatusNotFound, nil)
+	expect(t, s.do(http.MethodDelete, poolPath, nil, "manager"), http.StatusNotFound, nil)
+}
+
+// Revisions name moderators the way the ledger names donors and never
+// reveal their IDs.
+func TestRevisionModeratorNames(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	poolID := s.addPool("Garden", 100)
+	for _, u := range []store.User{
+		{GoogleID: "ada", Email: "ada@example.com", FirstName: "Ada", LastName: "Östlund"},
+		{GoogleID: "grace", Email: "grace@example.com", FirstName: "Grace", LastName: "Hopper"},
+		{GoogleID: "alan", Email: "alan@example.com", FirstName: "Alan", LastName: "Turing"},
+	} {
+		if _, err := s.store.UpsertUser(ctx, u); err != nil {
+			t.Fatalf("UpsertUser: %v", err)
+		}
+	}
+	displayName := "The Admiral"
+	if err := s.store.UpdateProfile(ctx, "grace", &displayName, false, false); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if err := s.store.UpdateProfile(ctx, "alan", nil, false, true); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	for _, moderator := range []string{"ada", "grace", "alan", "deleted", ""} {
+		rev := store.NewPoolRevision{FundingPoolID: poolID, Action: models.RevisionUpdate, ModeratorGoogleID: moderator}
+		if err := s.store.RecordPoolRevision(ctx, rev); err != nil {
+			t.Fatalf("RecordPoolRevision: %v", err)
+		}
+	}
+
+	rec := s.do(http.MethodGet, fmt.Sprintf("/api/funding-pools/%d/revisions", poolID), nil, "")
+	for _, id := range []string{"ada", "grace", "alan", "deleted", "moderator_google_id"} {
+		if strings.Contains(rec.Body.String(), `"`+id+`"`) {
+			t.Errorf("revisions reveal %q: %s", id, rec.Body)
+		}
+	}
+	var revisions []models.FundingPoolRevision
+	expect(t, rec, http.StatusOK, &revisions)
+	var names []string
+	for _, rev := range revisions {
+		if rev.ModeratorName == nil {
+			names = append(names, "")
+		} else {
+			names = append(names, *rev.ModeratorName)
+		}
+	}
+	want := []string{"", "", "Alan Turing", "The Admiral", "Ada Ö."}
+	if !slices.Equal(names, want) {
+		t.Errorf("moderator names = %q, want %q", names, want)
+	}
+}
+
+func TestDonationRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("donor")
+	s.addUser("treasurer", auth.RoleTreasurer)
+	poolID := s.addPool("Garden", 100)
+	fakePayPal := s.newFakePayPal()
+	fakePayPal.AddOrder("ORDER-1", paypaltest.Order{Amount: "25.00"})
+	fakePayPal.AddOrder("ORDER-2", paypaltest.Order{Amount: "25.00"})
+
+	var preview models.AllocationPreview
+	expect(t, s.do(http.MethodGet, "/api/donations/preview?amount=10", nil, ""), http.StatusOK, &preview)
+	expect(t, s.do(http.MethodGet, "/api/donations/preview?amount=-1", nil, ""), http.StatusBadRequest, nil)
+
+	capture := CaptureDonationRequest{OrderID: "ORDER-1", Allocations: []models.AllocationRequest{{FundingPoolID: poolID, Amount: 25}}}
+	var captured CaptureDonationResponse
+	expect(t, s.do(http.MethodPost, "/api/donations/capture", capture, "donor"), http.StatusOK, &captured)
+	if captured.LedgerID == 0 || len(captured.Allocations) != 1 {
+		t.Errorf("capture response = %+v", captured)
+	}
+	mismatch := CaptureDonationRequest{OrderID: "ORDER-2", Allocations: []models.AllocationRequest{{FundingPoolID: poolID, Amount: 30}}}
+	expect(t, s.do(http.MethodPost, "/api/donations/capture", mismatch, ""), http.StatusBadRequest, nil)
+
+	external := ExternalDonationRequest{Description: "Bake sale", Allocations: []models.AllocationRequest{{FundingPoolID: poolID, Amount: 10}}}
+	expect(t, s.do(http.MethodPost, "/api/donations/external", external, "donor"), http.StatusForbidden, nil)
+	expect(t, s.do(http.MethodPost, "/api/donations/external", external, "treasurer"), http.StatusCreated, nil)
+
+	withdrawal := models.WithdrawalRequest{Description: "Seeds", Allocations: []models.AllocationRequest{{FundingPoolID: poolID, Amount: 5}}}
+	expect(t, s.do(http.MethodPost, "/api/withdrawals", withdrawal, "treasurer"), http.StatusCreated, nil)
+	withdrawal.Allocations[0].Amount = 1000
+	expect(t, s.do(http.MethodPost, "/api/withdrawals", withdrawal, "treasurer"), http.StatusBadRequest, nil)
+
+	var ledger struct {
+		Transactions     []models.LedgerEntry `json:"transactions"`
+		TotalDonations   float64              `json:"total_donations"`
+		TotalWithdrawals float64              `json:"total_withdrawals"`
+	}
+	expect(t, s.do(http.MethodGet, "/api/ledger", nil, ""), http.StatusOK, &ledger)
+	if len(ledger.Transactions) != 3 || ledger.TotalDonations != 35 || ledger.TotalWithdrawals != 5 {
+		t.Errorf("ledger = %d entries, %.2f donated, %.2f withdrawn", len(ledger.Transactions), ledger.TotalDonations, ledger.TotalWithdrawals)
+	}
+
+	var pool models.FundingPool
+	expect(t, s.do(http.MethodGet, fmt.Sprintf("/api/funding-pools/%d", poolID), nil, ""), http.StatusOK, &pool)
+	if pool.CurrentAmount != 30 {
+		t.Errorf("pool balance = %.2f, want 30", pool.CurrentAmount)
+	}
+
+	var history models.DonationHistory
+	expect(t, s.do(http.MethodGet, "/api/me/donations", nil, "donor"), http.StatusOK, &history)
+	if len(history.Donations) != 1 {
+		t.Errorf("got %d donations, want 1", len(history.Donations))
+	}
+	year := strconv.Itoa(time.Now().UTC().Year())
+	rec := s.do(http.MethodGet, "/api/me/donations/statement?year="+year, nil, "donor")
+	expect(t, rec, http.StatusOK, nil)
+	if !strings.Contains(rec.Body.String(), "CAPTURE-ORDER-1") {
+		t.Errorf("statement is missing the donation:\n%s", rec.Body.String())
+	}
+	expect(t, s.do(http.MethodGet, "/api/me/donations/statement", nil, "donor"), http.StatusBadRequest, nil)
+	expect(t, s.do(http.MethodGet, "/api/me/donations", nil, ""), http.StatusUnauthorized, nil)
+}
+
+// A donation whose pool fills up after the cap check but before it is
+// recorded has already been captured, so it is refunded.
+func TestCaptureRefundedWhenCapFills(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	capAmount := 30.0
+	poolID, err := s.store.CreatePool(ctx, &models.CreateFundingPoolRequest{Name: "Garden", GoalAmount: 30, CapAmount: &capAmount, OverflowPolicy: models.OverflowReject})
+	if err != nil {
+		t.Fatalf("CreatePool: %v", err)
+	}
+	fakePayPal := s.newFakePayPal()
+	fakePayPal.AddOrder("ORDER-1", paypaltest.Order{Amount: "20.00", OnCapture: func() {
+		_, err := s.store.CreateLedgerEntry(ctx, store.LedgerEntryData{
+			Amount:          20,
+			TransactionType: "deposit",
+			Allocations:     []models.AllocationRequest{{FundingPoolID: poolID, Amount: 20}},
+		})
+		if err != nil {
+			t.Errorf("CreateLedgerEntry: %v", err)
+		}
+	}})
+
+	capture := CaptureDonationRequest{OrderID: "ORDER-1", Allocations: []models.AllocationRequest{{FundingPoolID: poolID, Amount: 20}}}
+	rec := s.do(http.MethodPost, "/api/donations/capture", capture, "")
+	expect(t, rec, http.StatusConflict, nil)
+	if !fakePayPal.Refunded("ORDER-1") {
+		t.Error("captured order was not refunded")
+	}
+	if !strings.Contains(rec.Body.String(), "refunded") {
+		t.Errorf("response does not mention the refund: %s", rec.Body)
+	}
+	if balance, err := s.store.PoolBalance(ctx, poolID); err != nil || balance != 20 {
+		t.Errorf("pool balance = %.2f, %v; want only the other donation's 20", balance, err)
+	}
+}
+
+func TestAuthRoutes(t *testing.T) {
+	s := newTestServer(t)
+	provider := oidctest.NewServer("pool-party", "secret")
+	t.Cleanup(provider.Close)
+	provider.SetUser(oidctest.User{Subject: "abc", Email: "ada@example.com", EmailVerified: true, GivenName: "Ada", FamilyName: "Lovelace"})
+	s.env.OIDC = oidc.NewRegistry([]oidc.Config{{
+		Name:         "mock",
+		DisplayName:  "Mock",
+		Issuer:       provider.Issuer(),
+		ClientID:     "pool-party",
+		ClientSecret: "secret",
+		RedirectURL:  testOrigin + "/api/auth/oidc/mock/callback",
+		Scopes:       []string{"email", "profile"},
+	}}, provider.Client())
+
+	var providers []AuthProviderResponse
+	expect(t, s.do(http.MethodGet, "/api/auth/providers", nil, ""), http.StatusOK, &providers)
+	if len(providers) != 1 || providers[0].LoginURL != "/api/auth/oidc/mock/login" {
+		t.Fatalf("providers = %+v", providers)
+	}
+	expect(t, s.do(http.MethodGet, "/api/auth/oidc/other/login", nil, ""), http.StatusNotFound, nil)
+
+	rec := s.oidcLogin(provider, "mock", "")
+	if loc := rec.Header().Get("Location"); loc != "/" {
+		t.Fatalf("callback redirected to %q", loc)
+	}
+	cookies := rec.Result().Cookies()
+
+	var user UserResponse
+	req := s.newRequest(http.MethodGet, "/api/auth/me", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	expect(t, s.serve(req), http.StatusOK, &user)
+	if user.Email != "ada@example.com" || user.FirstName != "Ada" {
+		t.Errorf("current user = %+v", user)
+	}
+
+	req = s.newRequest(http.MethodPost, "/api/auth/logout", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec = s.serve(req)
+	expect(t, rec, http.StatusOK, nil)
+	req = s.newRequest(http.MethodGet, "/api/auth/me", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	expect(t, s.serve(req), http.StatusUnauthorized, nil)
+
+	expect(t, s.do(http.MethodPost, "/api/auth/google/callback", map[string]string{"credential": "not-a-token"}, ""), http.StatusInternalServerError, nil)
+	s.env.GoogleClientID = "pool-party.apps.googleusercontent.com"
+	expect(t, s.do(http.MethodPost, "/api/auth/google/callback", map[string]string{"credential": "not-a-token"}, ""), http.StatusUnauthorized, nil)
+}
+
+// A new identity is only linked to an existing account by its owner signing
+// in first, or by email when the provider is trusted to verify addresses.
+func TestIdentityLinking(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("ada")
+	provider := oidctest.NewServer("pool-party", "secret")
+	t.Cleanup(provider.Close)
+	var configs []oidc.Config
+	for _, name := range []string{"mock", "trusted"} {
+		configs = append(configs, oidc.Config{
+			Name:         name,
+			Issuer:       provider.Issuer(),
+			ClientID:     "pool-party",
+			ClientSecret: "secret",
+			RedirectURL:  testOrigin + "/api/auth/oidc/" + name + "/callback",
+			LinkByEmail:  name == "trusted",
+		})
+	}
+	s.env.OIDC = oidc.NewRegistry(configs, provider.Client())
+	ctx := context.Background()
+
+	linkedTo := func(name, subject string) string {
+		t.Helper()
+		userID, err := s.store.UserIDForIdentity(ctx, name, subject)
+		if err != nil && err != store.ErrNotFound {
+			t.Fatalf("UserIDForIdentity: %v", err)
+		}
+		return userID
+	}
+
+	// A matching verified email is not enough by default.
+	provider.SetUser(oidctest.User{Subject: "abc", Email: "ada@example.com", EmailVerified: true})
+	rec := s.oidcLogin(provider, "mock", "")
+	if loc := rec.Header().Get("Location"); loc != "/?login_error=account_rejected" {
+		t.Errorf("login with another account's email redirected to %q", loc)
+	}
+	if userID := linkedTo("mock", "abc"); userID != "" {
+		t.Errorf("identity linked to %q", userID)
+	}
+
+	// Signed in, the owner links the identity explicitly.
+	rec = s.oidcLogin(provider, "mock", "ada")
+	if loc := rec.Header().Get("Location"); loc != "/" {
+		t.Errorf("linking login redirected to %q", loc)
+	}
+	if userID := linkedTo("mock", "abc"); userID != "ada" {
+		t.Errorf("identity linked to %q, want ada", userID)
+	}
+
+	// A provider trusted with emails links by a verified email, and only then.
+	provider.SetUser(oidctest.User{Subject: "def", Email: "ada@example.com"})
+	if loc := s.oidcLogin(provider, "trusted", "").Header().Get("Location"); loc != "/?login_error=account_rejected" {
+		t.Errorf("login with an unverified email redirected to %q", loc)
+	}
+	provider.SetUser(oidctest.User{Subject: "def", Email: "ada@example.com", EmailVerified: true})
+	if loc := s.oidcLogin(provider, "trusted", "").Header().Get("Location"); loc != "/" {
+		t.Errorf("login with a verified email redirected to %q", loc)
+	}
+	if userID := linkedTo("trusted", "def"); userID != "ada" {
+		t.Errorf("identity linked to %q, want ada", userID)
+	}
+
+	// Logging in through a linked identity records its email on the
+	// identity, and leaves the account's email and name alone.
+	provider.SetUser(oidctest.User{Subject: "abc", Email: "ada@work.example", EmailVerified: true, GivenName: "Augusta", FamilyName: "King"})
+	if loc := s.oidcLogin(provider, "mock", "").Header().Get("Location"); loc != "/" {
+		t.Errorf("login through a linked identity redirected to %q", loc)
+	}
+	user, err := s.store.GetUser(ctx, "ada")
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Email != "ada@example.com" || user.FirstName != "Test" || user.LastName != "User" {
+		t.Errorf("linked login changed the account to %+v", user)
+	}
+	identities, err := s.store.UserIdentities(ctx, "ada")
+	if err != nil {
+		t.Fatalf("UserIdentities: %v", err)
+	}
+	for _, identity := range identities {
+		if identity.Provider == "mock" && (identity.Email == nil || *identity.Email != "ada@work.example") {
+			t.Errorf("mock identity email = %v, want ada@work.example", identity.Email)
+		}
+	}
+
+	// The identity an account was created with keeps it up to date.
+	provider.SetUser(oidctest.User{Subject: "ghi", Email: "grace@example.com", EmailVerified: true, GivenName: "Grace"})
+	s.oidcLogin(provider, "mock", "")
+	provider.SetUser(oidctest.User{Subject: "ghi", Email: "grace@navy.example", EmailVerified: true, GivenName: "Grace", FamilyName: "Hopper"})
+	s.oidcLogin(provider, "mock", "")
+	if user, err := s.store.GetUser(ctx, "mock:ghi"); err != nil || user.Email != "grace@navy.example" || user.LastName != "Hopper" {
+		t.Errorf("primary login left the account as %+v, %v", user, err)
+	}
+}
+
+func TestSiteInstanceRoute(t *testing.T) {
+	s := newTestServer(t)
+	var site models.SiteInstance
+	expect(t, s.do(http.MethodGet, "/api/site-instance", nil, ""), http.StatusOK, &site)
+	if site.SiteTitle != "Pool Party" {
+		t.Errorf("site title = %q", site.SiteTitle)
+	}
+}
+
+func TestAccountRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("ada")
+
+	expect(t, s.do(http.MethodGet, "/api/me/profile", nil, ""), http.StatusUnauthorized, nil)
+	var profile models.UserProfile
+	expect(t, s.do(http.MethodGet, "/api/me/profile", nil, "ada"), http.StatusOK, &profile)
+
+	name := "Countess"
+	update := models.UpdateProfileRequest{DisplayName: &name, DefaultAnonymous: true}
+	expect(t, s.do(http.MethodPut, "/api/me/profile", update, "ada"), http.StatusOK, &profile)
+	if profile.DisplayName == nil || *profile.DisplayName != "Countess" || !profile.DefaultAnonymous {
+		t.Errorf("updated profile = %+v", profile)
+	}
+
+	var export models.AccountExport
+	expect(t, s.do(http.MethodGet, "/api/me/export", nil, "ada"), http.StatusOK, &export)
+
+	ctx := context.Background()
+	poolID := s.addPool("Garden", 100)
+	if err := s.store.RecordPoolRevision(ctx, store.NewPoolRevision{FundingPoolID: poolID, Action: models.RevisionCreate, ModeratorGoogleID: "ada"}); err != nil {
+		t.Fatalf("RecordPoolRevision: %v", err)
+	}
+
+	expect(t, s.do(http.MethodDelete, "/api/me", models.DeleteAccountRequest{Email: "someone@example.com"}, "ada"), http.StatusBadRequest, nil)
+	expect(t, s.do(http.MethodDelete, "/api/me", models.DeleteAccountRequest{Email: "ada@example.com"}, "ada"), http.StatusNoContent, nil)
+	expect(t, s.do(http.MethodGet, "/api/me/profile", nil, "ada"), http.StatusNotFound, nil)
+	if revisions, err := s.store.ModeratorRevisions(ctx, "ada"); err != nil || len(revisions) != 0 {
+		t.Errorf("revisions still tied to the deleted account: %+v, %v", revisions, err)
+	}
+
+	// A donate-only admin cannot manage users, so does not keep the last
+	// admin from leaving, and can leave themselves.
+	s.addUser("admin", auth.RoleAdmin)
+	s.addUser("dora", auth.RoleAdmin)
+	if _, err := s.store.UpsertUser(ctx, store.User{GoogleID: "dora", Email: "dora@example.com", DonateOnly: true}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	expect(t, s.do(http.MethodDelete, "/api/me", models.DeleteAccountRequest{Email: "admin@example.com"}, "admin"), http.StatusConflict, nil)
+	expect(t, s.do(http.MethodDelete, "/api/me", models.DeleteAccountRequest{Email: "dora@example.com"}, "dora"), http.StatusNoContent, nil)
+}
+
+func TestTokenRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("manager", auth.RolePoolManager)
+	s.addUser("viewer", auth.RoleViewer)
+
+	create := models.CreateAPITokenRequest{Name: "sync", Scopes: []string{string(auth.PermManagePools)}}
+	var created models.CreateAPITokenResponse
+	expect(t, s.do(http.MethodPost, "/api/me/tokens", create, "manager"), http.StatusCreated, &created)
+	expect(t, s.do(http.MethodPost, "/api/me/tokens", create, "viewer"), http.StatusForbidden, nil)
+	expect(t, s.do(http.MethodPost, "/api/me/tokens", models.CreateAPITokenRequest{Name: "x", Scopes: []string{string(auth.PermViewAdmin)}}, "manager"), http.StatusBadRequest, nil)
+
+	var tokens []models.APIToken
+	expect(t, s.do(http.MethodGet, "/api/me/tokens", nil, "manager"), http.StatusOK, &tokens)
+	if len(tokens) != 1 || tokens[0].Name != "sync" {
+		t.Fatalf("tokens = %+v", tokens)
+	}
+
+	// Bearer requests are exempt from the CSRF check, so they carry no Origin.
+	withToken := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		req := s.newRequest(method, path, body)
+		req.Header.Del("Origin")
+		req.Header.Set("Authorization", "Bearer "+created.Token)
+		return s.serve(req)
+	}
+	pool := models.CreateFundingPoolRequest{Name: "Tools", GoalAmount: 50}
+	expect(t, withToken(http.MethodPost, "/api/funding-pools", pool), http.StatusCreated, nil)
+	expect(t, withToken(http.MethodPost, "/api/withdrawals", nil), http.StatusForbidden, nil)
+
+	tokenPath := "/api/me/tokens/" + strconv.Itoa(created.ID)
+	expect(t, s.do(http.MethodDelete, tokenPath, nil, "viewer"), http.StatusNotFound, nil)
+	expect(t, s.do(http.MethodDelete, tokenPath, nil, "manager"), http.StatusNoContent, nil)
+	pool.Name = "More tools"
+	expect(t, withToken(http.MethodPost, "/api/funding-pools", pool), http.StatusUnauthorized, nil)
+	expect(t, s.do(http.MethodDelete, tokenPath, nil, "manager"), http.StatusNotFound, nil)
+}
+
+func TestAdminRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("admin", auth.RoleAdmin)
+	s.addUser("bob")
+
+	expect(t, s.do(http.MethodGet, "/api/admin/users", nil, "bob"), http.StatusForbidden, nil)
+	var users []models.AdminUser
+	expect(t, s.do(http.MethodGet, "/api/admin/users", nil, "admin"), http.StatusOK, &users)
+	if len(users) != 2 {
+		t.Errorf("got %d users, want 2", len(users))
+	}
+
+	rolesPath := "/api/admin/users/bob/roles"
+	var granted map[string][]string
+	expect(t, s.do(http.MethodPost, rolesPath, models.GrantRoleRequest{Role: string(auth.RoleTreasurer)}, "admin"), http.StatusOK, &granted)
+	if len(granted["roles"]) != 1 || granted["roles"][0] != string(auth.RoleTreasurer) {
+		t.Errorf("roles = %v", granted["roles"])
+	}
+	expect(t, s.do(http.MethodPost, rolesPath, models.GrantRoleRequest{Role: "overlord"}, "admin"), http.StatusBadRequest, nil)
+	expect(t, s.do(http.MethodPost, "/api/admin/users/nobody/roles", models.GrantRoleRequest{Role: string(auth.RoleViewer)}, "admin"), http.StatusNotFound, nil)
+	expect(t, s.do(http.MethodDelete, rolesPath+"/treasurer", nil, "admin"), http.StatusNoContent, nil)
+	expect(t, s.do(http.MethodDelete, rolesPath+"/treasurer", nil, "admin"), http.StatusNotFound, nil)
+	expect(t, s.do(http.MethodDelete, "/api/admin/users/admin/roles/admin", nil, "admin"), http.StatusConflict, nil)
+
+	// A donate-only admin cannot manage users, so does not count as another
+	// admin, and their grant can be revoked.
+	s.addUser("dora", auth.RoleAdmin)
+	if _, err := s.store.UpsertUser(context.Background(), store.User{GoogleID: "dora", Email: "dora@example.com", DonateOnly: true}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+	expect(t, s.do(http.MethodDelete, "/api/admin/users/admin/roles/admin", nil, "admin"), http.StatusConflict, nil)
+	expect(t, s.do(http.MethodDelete, "/api/admin/users/dora/roles/admin", nil, "admin"), http.StatusNoContent, nil)
+	// Nor can they be granted a role, which would have no effect.
+	expect(t, s.do(http.MethodPost, "/api/admin/users/dora/roles", models.GrantRoleRequest{Role: string(auth.RoleAdmin)}, "admin"), http.StatusConflict, nil)
+	if roles, err := s.store.GrantedRoles(context.Background(), "dora"); err != nil || len(roles) != 0 {
+		t.Errorf("donate-only user was granted roles: %v, %v", roles, err)
+	}
+
+	now := time.Now()
+	first := s.store.AddSession("bob", models.UserSession{CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	s.store.AddSession("bob", models.UserSession{CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	sessionsPath := "/api/admin/users/bob/sessions"
+	var userSessions []models.UserSession
+	expect(t, s.do(http.MethodGet, sessionsPath, nil, "admin"), http.StatusOK, &userSessions)
+	if len(userSessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(userSessions))
+	}
+	expect(t, s.do(http.MethodDelete, sessionsPath+"/"+strconv.Itoa(first), nil, "admin"), http.StatusNoContent, nil)
+	expect(t, s.do(http.MethodDelete, sessionsPath+"/"+strconv.Itoa(first), nil, "admin"), http.StatusNotFound, nil)
+	expect(t, s.do(http.MethodDelete, sessionsPath, nil, "admin"), http.StatusNoContent, nil)
+	expect(t, s.do(http.MethodGet, sessionsPath, nil, "admin"), http.StatusOK, &userSessions)
+	if len(userSessions) != 0 {
+		t.Errorf("got %d sessions after revoking all, want 0", len(userSessions))
+	}
+
+	var entries []models.AuditLogEntry
+	expect(t, s.do(http.MethodGet, "/api/admin/audit-log?limit=2", nil, "admin"), http.StatusOK, &entries)
+	if len(entries) != 2 || entries[0].Action != models.AuditSessionRevoke {
+		t.Errorf("audit log = %+v", entries)
+	}
+	expect(t, s.do(http.MethodGet, "/api/admin/audit-log?limit=0", nil, "admin"), http.StatusBadRequest, nil)
+}
+
+func TestPoolBalanceRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("admin", auth.RoleAdmin)
+	s.addUser("viewer", auth.RoleViewer)
+	pool := s.addPool("Lanes", 100)
+	expect(t, s.do(http.MethodPost, "/api/donations/external", ExternalDonationRequest{
+		Description: "Cash", Allocations: []models.AllocationRequest{{FundingPoolID: pool, Amount: 40}},
+	}, "admin"), http.StatusCreated, nil)
+
+	var report models.PoolBalanceReport
+	expect(t, s.do(http.MethodGet, "/api/admin/pool-balances", nil, "viewer"), http.StatusOK, &report)
+	if !report.Consistent || len(report.Drift) != 0 {
+		t.Errorf("report = %+v, want consistent", report)
+	}
+
+	s.store.SetStoredBalance(pool, 55)
+	var got models.FundingPool
+	expect(t, s.do(http.MethodGet, "/api/funding-pools/"+strconv.Itoa(pool), nil, ""), http.StatusOK, &got)
+	if got.CurrentAmount != 55 {
+		t.Errorf("current_amount = %v, want the maintained balance of 55", got.CurrentAmount)
+	}
+	expect(t, s.do(http.MethodGet, "/api/admin/pool-balances", nil, "viewer"), http.StatusOK, &report)
+	want := []models.PoolBalanceDrift{{FundingPoolID: pool, Stored: 55, Ledger: 40}}
+	if report.Consistent || report.Reconciled || !reflect.DeepEqual(report.Drift, want) {
+		t.Errorf("report = %+v, want drift %+v", report, want)
+	}
+
+	expect(t, s.do(http.MethodPost, "/api/admin/pool-balances/reconcile", nil, "viewer"), http.StatusForbidden, nil)
+	expect(t, s.do(http.MethodPost, "/api/admin/pool-balances/reconcile", nil, "admin"), http.StatusOK, &report)
+	if !report.Reconciled || !reflect.DeepEqual(report.Drift, want) {
+		t.Errorf("report = %+v, want reconciled drift %+v", report, want)
+	}
+	expect(t, s.do(http.MethodGet, "/api/funding-pools/"+strconv.Itoa(pool), nil, ""), http.StatusOK, &got)
+	if got.CurrentAmount != 40 {
+		t.Errorf("current_amount = %v after reconciling, want 40", got.CurrentAmount)
+	}
+
+	var entries []models.AuditLogEntry
+	expect(t, s.do(http.MethodGet, "/api/admin/audit-log?limit=1", nil, "admin"), http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].Action != models.AuditBalanceReconcile {
+		t.Errorf("audit log = %+v", entries)
+	}
+	expect(t, s.do(http.MethodPost, "/api/admin/pool-balances/reconcile", nil, "admin"), http.StatusOK, &report)
+	if !report.Consistent || report.Reconciled {
+		t.Errorf("second reconcile = %+v, want nothing to do", report)
+	}
+}
+
+func TestLedgerVerifyRoute(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("treasurer", auth.RoleTreasurer)
+	pool := s.addPool("Lanes", 100)
+	var ids []int
+	for _, amount := range []float64{10, 20, 30} {
+		var created map[string]interface{}
+		expect(t, s.do(http.MethodPost, "/api/donations/external", ExternalDonationRequest{
+			Description: "Cash", Allocations: []models.AllocationRequest{{FundingPoolID: pool, Amount: amount}},
+		}, "treasurer"), http.StatusCreated, &created)
+		ids = append(ids, int(created["ledgerID"].(float64)))
+	}
+
+	var result models.LedgerVerification
+	expect(t, s.do(http.MethodGet, "/api/ledger/verify", nil, ""), http.StatusOK, &result)
+	if !result.Valid || result.Checked != 3 || result.Broken != nil {
+		t.Errorf("verify = %+v, want 3 valid entries", result)
+	}
+
+	s.store.SetLedgerAmount(ids[1], 2000)
+	expect(t, s.do(http.MethodGet, "/api/ledger/verify", nil, ""), http.StatusOK, &result)
+	if result.Valid || result.Checked != 1 || result.Broken == nil || result.Broken.LedgerID != ids[1] {
+		t.Errorf("verify after an edit = %+v, want broken at %d", result, ids[1])
+	}
+}
+
+func TestBackupRoute(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser("admin", auth.RoleAdmin)
+	s.addUser("treasurer", auth.RoleTreasurer)
+	pool := s.addPool("Lanes", 100)
+	expect(t, s.do(http.MethodPost, "/api/donations/external", ExternalDonationRequest{
+		Description: "Cash", Allocations: []models.AllocationRequest{{FundingPoolID: pool, Amount: 40}},
+	}, "treasurer"), http.StatusCreated, nil)
+
+	expect(t, s.do(http.MethodGet, "/api/admin/backup", nil, "treasurer"), http.StatusForbidden, nil)
+	rec := s.do(http.MethodGet, "/api/admin/backup", nil, "admin")
+	var archive models.BackupArchive
+	expect(t, rec, http.StatusOK, &archive)
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), `attachment; filename="pool-party-backup-`) {
+		t.Errorf("Content-Disposition = %q", rec.Header().Get("Content-Disposition"))
+	}
+	if archive.Format != models.BackupFormat || len(archive.Pools) != 1 || archive.Pools[0].CurrentAmount != 40 || len(archive.Users) != 2 || len(archive.Ledger) != 1 {
+		t.Errorf("archive = %+v", archive)
+	}
+
+	var entries []models.AuditLogEntry
+	expect(t, s.do(http.MethodGet, "/api/admin/audit-log?limit=1", nil, "admin"), http.StatusOK, &entries)
+	if len(entries) != 1 || entries[0].Action != models.AuditBackupExport || entries[0].ActorGoogleID == nil || *entries[0].ActorGoogleID != "admin" {
+		t.Errorf("audit log = %+v", entries)
+	}
+}
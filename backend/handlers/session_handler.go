@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// ListUserSessions returns a user's unexpired sessions, most recently used first.
+func (env *APIEnv) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	googleID := mux.Vars(r)["googleID"]
+
+	userSessions, err := env.Store.ListUserSessions(r.Context(), googleID)
+	if err != nil {
+		log.Printf("Error querying sessions for user %s: %v", googleID, err)
+		respondError(w, http.StatusInternalServerError, "Error fetching sessions")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, userSessions)
+}
+
+// RevokeUserSessions signs a user out of all their sessions, or only the one
+// given by the optional {id} path parameter.
+func (env *APIEnv) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	targetGoogleID := vars["googleID"]
+	actorGoogleID, _ := userIDFromContext(r.Context())
+
+	var sessionID *int
+	if idStr, ok := vars["id"]; ok {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid ID format")
+			return
+		}
+		sessionID = &id
+	}
+
+	err := env.Store.WithTx(r.Context(), func(tx store.Store) error {
+		details := map[string]interface{}{}
+		if sessionID != nil {
+			revoked, err := tx.RevokeUserSession(r.Context(), targetGoogleID, *sessionID)
+			if err != nil {
+				return err
+			}
+			if !revoked {
+				return models.NewRequestError("Session not found", http.StatusNotFound)
+			}
+			details["session_id"] = *sessionID
+		} else {
+			n, err := tx.RevokeUserSessions(r.Context(), targetGoogleID)
+			if err != nil {
+				return err
+			}
+			details["sessions_revoked"] = n
+		}
+		return tx.RecordAudit(r.Context(), actorGoogleID, models.AuditSessionRevoke, targetGoogleID, details)
+	})
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error revoking sessions for user %s: %v", targetGoogleID, err)
+			respondError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,22 +1,16 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
-	"pool-party-api/models"
+	"pool-party-api/store"
 )
 
 // GetSiteInstance fetches the site's configuration details.
 func (env *APIEnv) GetSiteInstance(w http.ResponseWriter, r *http.Request) {
-	var instance models.SiteInstance
-	var headline sql.NullString
-
-	query := `SELECT site_title, site_headline FROM site_instance WHERE id = 1`
-	err := env.DB.QueryRowContext(r.Context(), query).Scan(&instance.SiteTitle, &headline)
-
+	instance, err := env.Store.GetSite(r.Context())
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			log.Println("Critical: site_instance table not seeded with id=1")
 			respondError(w, http.StatusInternalServerError, "Site configuration not found.")
 		} else {
@@ -26,9 +20,5 @@ func (env *APIEnv) GetSiteInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if headline.Valid {
-		instance.SiteHeadline = &headline.String
-	}
-
 	respondJSON(w, http.StatusOK, instance)
 }
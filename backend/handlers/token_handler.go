@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"pool-party-api/store"
+	"strings"
+	"time"
+)
+
+// maxTokenLifetimeDays caps how far ahead a token's expiry may be set.
+const maxTokenLifetimeDays = 366
+
+// ListAPITokens returns the current user's personal access tokens, including
+// revoked and expired ones, newest first.
+func (env *APIEnv) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	tokens, err := env.Store.ListAPITokens(r.Context(), googleID)
+	if err != nil {
+		log.Printf("Error querying API tokens: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error fetching API tokens")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, tokens)
+}
+
+// CreateAPIToken creates a personal access token for the current user. Users
+// can only give a token scopes their roles allow, and a token stops working
+// for any scope the user later loses. The token is returned once and only its
+// hash is stored.
+func (env *APIEnv) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		respondError(w, http.StatusBadRequest, "Token name is required and must be at most 100 characters")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		respondError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenLifetimeDays {
+		respondError(w, http.StatusBadRequest, "expires_in_days must be between 0 and 366")
+		return
+	}
+
+	roles, err := env.Store.UserRoles(r.Context(), googleID)
+	if err != nil {
+		log.Printf("Role lookup error: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+	seen := make(map[string]bool)
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !auth.ValidTokenScope(scope) {
+			respondError(w, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+		if !auth.HasPermission(roles, auth.Permission(scope)) {
+			respondError(w, http.StatusForbidden, "You do not have the "+scope+" permission")
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token, hash, prefix, err := auth.NewToken()
+	if err != nil {
+		log.Printf("Error generating API token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	var created *models.APIToken
+	err = env.Store.WithTx(r.Context(), func(tx store.Store) error {
+		created, err = tx.CreateAPIToken(r.Context(), googleID, store.NewAPIToken{
+			Name:      req.Name,
+			Hash:      hash,
+			Prefix:    prefix,
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			return err
+		}
+		details := map[string]interface{}{"token_id": created.ID, "name": req.Name, "scopes": scopes}
+		return tx.RecordAudit(r.Context(), googleID, models.AuditTokenCreate, googleID, details)
+	})
+	if err != nil {
+		log.Printf("Error creating API token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create API token")
+		return
+	}
+
+	resp := models.CreateAPITokenResponse{APIToken: *created, Token: token}
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// RevokeAPIToken revokes one of the current user's personal access tokens.
+// Revoked tokens are kept so the owner can still see when they were used.
+func (env *APIEnv) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	googleID, _ := userIDFromContext(r.Context())
+
+	id, err := getIDFromRequest(r)
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			respondError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}
+		return
+	}
+
+	err = env.Store.WithTx(r.Context(), func(tx store.Store) error {
+		revoked, err := tx.RevokeAPIToken(r.Context(), googleID, id)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return models.NewRequestError("API token not found", http.StatusNotFound)
+		}
+		return tx.RecordAudit(r.Context(), googleID, models.AuditTokenRevoke, googleID, map[string]int{"token_id": id})
+	})
+	if err != nil {
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Error revoking API token %d: %v", id, err)
+			respondError(w, http.StatusInternalServerError, "Failed to revoke API token")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -7,14 +7,14 @@ import (
 	"log"
 	"net/http"
 	"pool-party-api/models"
+	"pool-party-api/store"
 )
 
 // MakeWithdrawal handles recording a withdrawal transaction in the ledger.
 // This is a moderator-only action.
 func (env *APIEnv) MakeWithdrawal(w http.ResponseWriter, r *http.Request) {
-	// Step 1: Get Moderator ID from session (middleware already confirmed they are a mod)
-	session, _ := env.SessionStore.Get(r, "pool-party-session")
-	googleID, ok := session.Values["google_id"].(string)
+	// Step 1: Get Moderator ID set by the middleware (which already confirmed their permission)
+	googleID, ok := userIDFromContext(r.Context())
 	if !ok {
 		// This should not happen if middleware is working correctly
 		respondError(w, http.StatusUnauthorized, "Not authenticated")
@@ -47,80 +47,57 @@ func (env *APIEnv) MakeWithdrawal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Step 3: Database Transaction
-	tx, err := env.DB.BeginTx(r.Context(), nil)
-	if err != nil {
-		log.Printf("Failed to start database transaction: %v", err)
-		respondError(w, http.StatusInternalServerError, "Database error")
-		return
-	}
-	defer tx.Rollback()
+	var ledgerID int
+	err := env.Store.WithTx(r.Context(), func(tx store.Store) error {
+		// Step 4: Validate that withdrawal doesn't exceed each pool's balance
+		for _, alloc := range req.Allocations {
+			poolBalance, err := tx.PoolBalance(r.Context(), alloc.FundingPoolID)
+			if err != nil {
+				log.Printf("Failed to get balance for pool %d: %v", alloc.FundingPoolID, err)
+				return models.NewRequestError("Could not verify pool funds", http.StatusInternalServerError)
+			}
+			if alloc.Amount > poolBalance {
+				msg := fmt.Sprintf("Withdrawal amount for a pool exceeds its balance of $%.2f", poolBalance)
+				return models.NewRequestError(msg, http.StatusBadRequest)
+			}
+		}
 
-	// Step 4: Validate that withdrawal doesn't exceed each pool's balance
-	for _, alloc := range req.Allocations {
-		var poolBalance float64
-		poolQuery := `
-            SELECT COALESCE(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount ELSE -a.amount END), 0)
-            FROM allocation a
-            JOIN ledger l ON a.ledger_id = l.id
-            WHERE a.funding_pool_id = $1
-        `
-		err := tx.QueryRowContext(r.Context(), poolQuery, alloc.FundingPoolID).Scan(&poolBalance)
+		// Step 5: Get moderator user info, named as their profile asks
+		profile, err := loadDonorProfile(r.Context(), tx, googleID)
 		if err != nil {
-			log.Printf("Failed to get balance for pool %d: %v", alloc.FundingPoolID, err)
-			respondError(w, http.StatusInternalServerError, "Could not verify pool funds")
-			return
+			// This is unlikely if middleware passed, but handle it.
+			log.Printf("Failed to get moderator info for google_id %s: %v", googleID, err)
+			return models.NewRequestError("Could not retrieve moderator information", http.StatusInternalServerError)
 		}
-		if alloc.Amount > poolBalance {
-			msg := fmt.Sprintf("Withdrawal amount for a pool exceeds its balance of $%.2f", poolBalance)
-			respondError(w, http.StatusBadRequest, msg)
-			return
-		}
-	}
+		userFirstName, lastNameInitial := profile.ledgerName()
 
-	// Step 5: Get moderator user info
-	var userFirstName, userLastName sql.NullString
-	userQuery := `SELECT first_name, last_name FROM users WHERE google_id = $1`
-	err = tx.QueryRowContext(r.Context(), userQuery, googleID).Scan(&userFirstName, &userLastName)
-	if err != nil {
-		// This is unlikely if middleware passed, but handle it.
-		log.Printf("Failed to get moderator info for google_id %s: %v", googleID, err)
-		respondError(w, http.StatusInternalServerError, "Could not retrieve moderator information")
-		return
-	}
-
-	var lastNameInitial sql.NullString
-	if userLastName.Valid && len(userLastName.String) > 0 {
-		lastNameInitial.String = string(userLastName.String[0])
-		lastNameInitial.Valid = true
-	}
+		// Step 6: Prepare data and create ledger entries
+		var description sql.NullString
+		description.String = req.Description
+		description.Valid = true
 
-	// Step 6: Prepare data and create ledger entries
-	var description sql.NullString
-	description.String = req.Description
-	description.Valid = true
-
-	ledgerData := LedgerEntryData{
-		Amount:          totalWithdrawal,
-		TransactionType: "withdrawal",
-		UserGoogleID:    sql.NullString{String: googleID, Valid: true},
-		FirstName:       userFirstName,
-		LastInitial:     lastNameInitial,
-		Anonymous:       false,
-		Description:     description,
-		Allocations:     req.Allocations,
-	}
+		ledgerData := store.LedgerEntryData{
+			Amount:          totalWithdrawal,
+			TransactionType: "withdrawal",
+			UserGoogleID:    sql.NullString{String: googleID, Valid: true},
+			FirstName:       userFirstName,
+			LastInitial:     lastNameInitial,
+			Anonymous:       false,
+			Description:     description,
+			Allocations:     req.Allocations,
+		}
 
-	ledgerID, err := env.CreateLedgerEntriesInTx(r.Context(), tx, ledgerData)
+		ledgerID, err = tx.CreateLedgerEntry(r.Context(), ledgerData)
+		return err
+	})
+	// Step 7: The transaction is committed only if every step succeeded
 	if err != nil {
-		log.Printf("Failed to insert withdrawal into ledger: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to record withdrawal")
-		return
-	}
-
-	// Step 7: Commit Transaction
-	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit withdrawal transaction: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to finalize withdrawal")
+		if reqErr, ok := err.(*models.RequestError); ok {
+			respondError(w, reqErr.Status, reqErr.Message)
+		} else {
+			log.Printf("Failed to insert withdrawal into ledger: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to record withdrawal")
+		}
 		return
 	}
 
@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// MaxPixels bounds the decoded size of an upload to guard against
+	// decompression bombs: small files that expand to huge bitmaps.
+	MaxPixels = 40_000_000
+
+	// CoverMaxDimension is the longest edge, in pixels, of a stored cover image.
+	CoverMaxDimension = 1600
+
+	// ThumbnailMaxDimension is the longest edge, in pixels, of a thumbnail.
+	ThumbnailMaxDimension = 400
+)
+
+// ErrUnsupportedType is returned when the upload is not an accepted image format.
+var ErrUnsupportedType = errors.New("unsupported image type; use JPEG, PNG or GIF")
+
+// ErrTooLarge is returned when the decoded image exceeds MaxPixels.
+var ErrTooLarge = errors.New("image dimensions are too large")
+
+// allowedTypes maps accepted sniffed content types to the format name
+// reported by image.Decode.
+var allowedTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// Processed holds the re-encoded cover image and its thumbnail.
+type Processed struct {
+	Cover       []byte
+	Thumbnail   []byte
+	ContentType string
+	Extension   string
+}
+
+// Process validates an uploaded image, then re-encodes it as a cover image and
+// a thumbnail. Re-encoding strips any embedded metadata such as EXIF location
+// data. GIFs are flattened to their first frame and stored as PNG.
+func Process(data []byte) (*Processed, error) {
+	contentType := http.DetectContentType(data)
+	format, ok := allowedTypes[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	var img image.Image
+	switch format {
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+
+	encode := encodePNG
+	result := &Processed{ContentType: "image/png", Extension: ".png"}
+	if format == "jpeg" {
+		encode = encodeJPEG
+		result.ContentType = "image/jpeg"
+		result.Extension = ".jpg"
+	}
+
+	if result.Cover, err = encode(Fit(img, CoverMaxDimension)); err != nil {
+		return nil, err
+	}
+	if result.Thumbnail, err = encode(Fit(img, ThumbnailMaxDimension)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Fit scales img down so that neither edge exceeds maxDimension, preserving
+// the aspect ratio. Images that already fit are returned unchanged.
+func Fit(img image.Image, maxDimension int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDimension && h <= maxDimension {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*maxDimension/w)
+		w = maxDimension
+	} else {
+		w = max(1, w*maxDimension/h)
+		h = maxDimension
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
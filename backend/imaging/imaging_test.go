@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encoded returns a w×h image in format.
+func encoded(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encoding %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG that declares itself w×h, which is
+// all DecodeConfig reads: enough to test the size limit without the pixels.
+func pngHeader(t *testing.T, w, h int) []byte {
+	t.Helper()
+	data := encoded(t, "png", 1, 1)
+	// The 13-byte IHDR chunk follows the 8-byte signature and its own
+	// length; its type and data are covered by the checksum after them.
+	ihdr := data[12:29]
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(h))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestProcessRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("just some text, not an image"), ErrUnsupportedType},
+		{"html", []byte("<html><script>alert(1)</script></html>"), ErrUnsupportedType},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), ErrUnsupportedType},
+		{"empty", nil, ErrUnsupportedType},
+		{"too many pixels", pngHeader(t, 10_000, 10_000), ErrTooLarge},
+		{"too wide", pngHeader(t, MaxPixels+1, 1), ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Process = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		format                 string
+		w, h                   int
+		contentType            string
+		coverW, coverH         int
+		thumbnailW, thumbnailH int
+	}{
+		{"jpeg", 2000, 1000, "image/jpeg", 1600, 800, 400, 200},
+		{"png", 500, 1000, "image/png", 500, 1000, 200, 400},
+		{"gif", 300, 200, "image/png", 300, 200, 300, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			p, err := Process(encoded(t, tt.format, tt.w, tt.h))
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if p.ContentType != tt.contentType {
+				t.Errorf("ContentType = %q, want %q", p.ContentType, tt.contentType)
+			}
+			wantSize := func(name string, data []byte, w, h int) {
+				t.Helper()
+				cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("decoding %s: %v", name, err)
+				}
+				if "image/"+format != tt.contentType {
+					t.Errorf("%s is %s, want %s", name, format, tt.contentType)
+				}
+				if cfg.Width != w || cfg.Height != h {
+					t.Errorf("%s is %d×%d, want %d×%d", name, cfg.Width, cfg.Height, w, h)
+				}
+			}
+			wantSize("cover", p.Cover, tt.coverW, tt.coverH)
+			wantSize("thumbnail", p.Thumbnail, tt.thumbnailW, tt.thumbnailH)
+		})
+	}
+}
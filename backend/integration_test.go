@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"pool-party-api/config"
+	"pool-party-api/database"
+	"pool-party-api/database/pgtest"
+	"pool-party-api/handlers"
+	"pool-party-api/migrations"
+	"pool-party-api/models"
+	"pool-party-api/oidc/oidctest"
+	"pool-party-api/paypal/paypaltest"
+	"pool-party-api/store"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The integration tests run the real server, as wired by newHandler, against
+// a real database, with fake PayPal and OpenID Connect providers. Users sign
+// in through the provider, and every request goes over HTTP.
+//
+// Postgres comes from package pgtest: TEST_DATABASE_URL, or else a throwaway
+// server started with a locally installed Postgres. Without either, the
+// Postgres tests are skipped, or fail when CI is set; the SQLite tests always
+// run.
+
+func TestIntegrationPostgres(t *testing.T) {
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeURL
+	cfg.URL = pgtest.URL(t)
+	runIntegration(t, cfg)
+}
+
+func TestIntegrationSQLite(t *testing.T) {
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeSQLite
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "pool-party.db")
+	runIntegration(t, cfg)
+}
+
+func runIntegration(t *testing.T, cfg database.Config) {
+	a := startApp(t, cfg)
+	admin := a.signIn(t, "admin", "admin@example.com")
+	if _, err := a.store.GrantRole(context.Background(), admin.googleID, "admin", ""); err != nil {
+		t.Fatalf("granting the first admin: %v", err)
+	}
+	manager := a.signIn(t, "manager", "manager@example.com")
+	treasurer := a.signIn(t, "treasurer", "treasurer@example.com")
+	donor := a.signIn(t, "donor", "donor@example.com")
+	admin.grant(t, manager, "pool_manager")
+	admin.grant(t, treasurer, "treasurer")
+
+	t.Run("PoolLifecycle", func(t *testing.T) { testPoolLifecycle(t, a, manager, donor) })
+	t.Run("Donation", func(t *testing.T) { testDonation(t, a, manager, donor) })
+	t.Run("ExternalDonation", func(t *testing.T) { testExternalDonation(t, a, manager, treasurer, donor) })
+	t.Run("Withdrawal", func(t *testing.T) { testWithdrawal(t, a, manager, treasurer) })
+	t.Run("CommandLine", func(t *testing.T) { testCommandLine(t, a, admin, donor) })
+	t.Run("LedgerIntegrity", func(t *testing.T) { testLedgerIntegrity(t, admin) })
+	t.Run("SessionFixation", func(t *testing.T) { testSessionFixation(t, a) })
+}
+
+// app is a running server with its database and fake providers.
+type app struct {
+	server   *httptest.Server
+	store    store.Store
+	paypal   *paypaltest.Server
+	provider *oidctest.Server
+}
+
+func startApp(t *testing.T, dbConfig database.Config) *app {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	db, err := database.Open(ctx, dbConfig)
+	if err != nil {
+		t.Fatalf("database.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dialect, dataStore := storeFor(db, dbConfig)
+	if _, err := migrations.Up(ctx, db, dialect); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+
+	a := &app{
+		store:    dataStore,
+		paypal:   paypaltest.NewServer("pool-party", "paypal-secret"),
+		provider: oidctest.NewServer("pool-party", "oidc-secret"),
+	}
+	t.Cleanup(a.paypal.Close)
+	t.Cleanup(a.provider.Close)
+
+	// The handler is set once the server's URL, which the OIDC redirect
+	// needs, is known.
+	var handler http.Handler
+	a.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(a.server.Close)
+
+	// The rest of the configuration is loaded as in production, from the
+	// environment variables a deployment would set.
+	env := map[string]string{
+		"SESSION_SECRET":          "integration-test-session-secret",
+		"SESSION_COOKIE_INSECURE": "true",
+		"IMAGE_STORAGE_DIR":       t.TempDir(),
+		"OIDC_PROVIDERS":          "mock",
+		"OIDC_MOCK_ISSUER":        a.provider.Issuer(),
+		"OIDC_MOCK_CLIENT_ID":     a.provider.ClientID,
+		"OIDC_MOCK_CLIENT_SECRET": a.provider.ClientSecret,
+		"OIDC_MOCK_REDIRECT_URL":  a.server.URL + "/api/auth/oidc/mock/callback",
+		"PAYPAL_CLIENT_ID":        a.paypal.ClientID,
+		"PAYPAL_CLIENT_SECRET":    a.paypal.ClientSecret,
+		"PAYPAL_API_BASE":         a.paypal.URL,
+	}
+	cfg, _, err := config.Load(nil, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	cfg.Database = dbConfig
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+
+	handler, err = newHandler(ctx, db, dataStore, cfg)
+	if err != nil {
+		t.Fatalf("newHandler: %v", err)
+	}
+	return a
+}
+
+// client is a browser session, signed in unless googleID is empty.
+type client struct {
+	app      *app
+	http     *http.Client
+	googleID string
+}
+
+func (a *app) guest(t *testing.T) *client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New: %v", err)
+	}
+	return &client{app: a, http: &http.Client{Jar: jar}}
+}
+
+// signIn logs in through the mock OpenID Connect provider as a new user.
+func (a *app) signIn(t *testing.T, subject, email string) *client {
+	t.Helper()
+	c := a.guest(t)
+	a.provider.SetUser(oidctest.User{Subject: subject, Email: email, EmailVerified: true, GivenName: subject, FamilyName: "Tester"})
+
+	// The login redirects to the provider, which redirects back to the
+	// callback, which starts the session and redirects to the frontend.
+	res, err := c.http.Get(a.server.URL + "/api/auth/oidc/mock/login")
+	if err != nil {
+		t.Fatalf("signing in: %v", err)
+	}
+	res.Body.Close()
+
+	var user handlers.UserResponse
+	c.expect(t, c.do(t, http.MethodGet, "/api/auth/me", nil), http.StatusOK, &user)
+	if user.Email != email {
+		t.Fatalf("signed in as %+v, want %s", user, email)
+	}
+	c.googleID = user.GoogleID
+	return c
+}
+
+// testSessionFixation checks that signing in replaces the session token the
+// browser had before, so that a token planted in it is never signed in.
+func testSessionFixation(t *testing.T, a *app) {
+	c := a.guest(t)
+	a.provider.SetUser(oidctest.User{Subject: "fixation", Email: "fixation@example.com", EmailVerified: true})
+	serverURL, err := url.Parse(a.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Starting the login gives the browser a session before it signs in.
+	c.http.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := c.http.Get(a.server.URL + "/api/auth/oidc/mock/login")
+	if err != nil {
+		t.Fatalf("starting the login: %v", err)
+	}
+	res.Body.Close()
+	planted := c.http.Jar.Cookies(serverURL)
+	if len(planted) == 0 {
+		t.Fatal("starting the login set no session cookie")
+	}
+
+	c.http.CheckRedirect = nil
+	res, err = c.http.Get(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("signing in: %v", err)
+	}
+	res.Body.Close()
+	c.expect(t, c.do(t, http.MethodGet, "/api/auth/me", nil), http.StatusOK, nil)
+
+	other := a.guest(t)
+	other.http.Jar.SetCookies(serverURL, planted)
+	other.expect(t, other.do(t, http.MethodGet, "/api/auth/me", nil), http.StatusUnauthorized, nil)
+}
+
+// do sends a same-origin request with a JSON body, if body is not nil.
+func (c *client) do(t *testing.T, method, path string, body interface{}) *http.Response {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal request: %v", err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.app.server.URL+path, r)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Origin", c.app.server.URL)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.http.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return res
+}
+
+// expect fails the test unless res has the wanted status, then decodes the
+// JSON body into v if v is not nil.
+func (c *client) expect(t *testing.T, res *http.Response, want int, v interface{}) {
+	t.Helper()
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if res.StatusCode != want {
+		t.Fatalf("%s %s: status = %d, want %d; body: %s", res.Request.Method, res.Request.URL.Path, res.StatusCode, want, data)
+	}
+	if v != nil {
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("decode response: %v; body: %s", err, data)
+		}
+	}
+}
+
+func (c *client) call(t *testing.T, method, path string, body interface{}, want int, v interface{}) {
+	t.Helper()
+	c.expect(t, c.do(t, method, path, body), want, v)
+}
+
+func (c *client) grant(t *testing.T, user *client, role string) {
+	t.Helper()
+	c.call(t, http.MethodPost, "/api/admin/users/"+user.googleID+"/roles", models.GrantRoleRequest{Role: role}, http.StatusOK, nil)
+}
+
+func (c *client) createPool(t *testing.T, name string, goal float64) int {
+	t.Helper()
+	var pool models.FundingPool
+	c.call(t, http.MethodPost, "/api/funding-pools", models.CreateFundingPoolRequest{Name: name, GoalAmount: goal}, http.StatusCreated, &pool)
+	return pool.ID
+}
+
+func (c *client) pool(t *testing.T, id int) models.FundingPool {
+	t.Helper()
+	var pool models.FundingPool
+	c.call(t, http.MethodGet, poolPath(id), nil, http.StatusOK, &pool)
+	return pool
+}
+
+// totalBalance returns the sum of every pool's balance.
+func (c *client) totalBalance(t *testing.T) float64 {
+	t.Helper()
+	var pools []models.FundingPool
+	c.call(t, http.MethodGet, "/api/funding-pools", nil, http.StatusOK, &pools)
+	var total float64
+	for _, p := range pools {
+		total += p.CurrentAmount
+	}
+	return total
+}
+
+func poolPath(id int) string {
+	return "/api/funding-pools/" + strconv.Itoa(id)
+}
+
+type ledgerResponse struct {
+	Transactions     []models.LedgerEntry `json:"transactions"`
+	TotalDonations   float64              `json:"total_donations"`
+	TotalWithdrawals float64              `json:"total_withdrawals"`
+}
+
+func (c *client) ledger(t *testing.T) ledgerResponse {
+	t.Helper()
+	var ledger ledgerResponse
+	c.call(t, http.MethodGet, "/api/ledger", nil, http.StatusOK, &ledger)
+	return ledger
+}
+
+// testLedgerIntegrity runs last, checking that everything recorded by the
+// other tests, including concurrent writes, left a consistent ledger.
+func testLedgerIntegrity(t *testing.T, admin *client) {
+	var chain models.LedgerVerification
+	admin.call(t, http.MethodGet, "/api/ledger/verify", nil, http.StatusOK, &chain)
+	if n := len(admin.ledger(t).Transactions); !chain.Valid || chain.Checked != n || n == 0 {
+		t.Errorf("ledger verification = %+v, want %d valid entries", chain, n)
+	}
+
+	var balances models.PoolBalanceReport
+	admin.call(t, http.MethodGet, "/api/admin/pool-balances", nil, http.StatusOK, &balances)
+	if !balances.Consistent {
+		t.Errorf("pool balances drifted: %+v", balances.Drift)
+	}
+}
+
+// testCommandLine runs the admin commands against the same database and
+// checks their changes through the API.
+func testCommandLine(t *testing.T, a *app, admin, donor *client) {
+	inst := &instance{store: a.store}
+	run := func(name string, args ...string) error {
+		t.Helper()
+		return commands[name](inst, args)
+	}
+
+	if err := run("site", "set-title", "Office", "Fund"); err != nil {
+		t.Fatalf("site set-title: %v", err)
+	}
+	var site models.SiteInstance
+	donor.call(t, http.MethodGet, "/api/site-instance", nil, http.StatusOK, &site)
+	if site.SiteTitle != "Office Fund" {
+		t.Errorf("site title = %q", site.SiteTitle)
+	}
+
+	// Promoting takes effect on the user's next request; demoting signs them out.
+	donor.call(t, http.MethodGet, "/api/admin/users", nil, http.StatusForbidden, nil)
+	if err := run("user", "promote", "DONOR@example.com", "viewer"); err != nil {
+		t.Fatalf("user promote: %v", err)
+	}
+	donor.call(t, http.MethodGet, "/api/admin/users", nil, http.StatusOK, nil)
+	if err := run("user", "promote", "donor@example.com", "owner"); err == nil {
+		t.Error("user promote accepted an unknown role")
+	}
+	if err := run("user", "demote", admin.googleID, "admin"); err == nil || !strings.Contains(err.Error(), "last admin") {
+		t.Errorf("demoting the last admin error = %v", err)
+	}
+	if err := run("user", "demote", "donor@example.com", "viewer"); err != nil {
+		t.Fatalf("user demote: %v", err)
+	}
+	donor.call(t, http.MethodGet, "/api/auth/me", nil, http.StatusUnauthorized, nil)
+
+	// Pools are validated as they are through the API, and their history is kept.
+	if err := run("pool", "create", "-name", "Plants", "-goal", "-5"); err == nil {
+		t.Error("pool create accepted a negative goal")
+	}
+	if err := run("pool", "create", "-name", "Plants", "-goal", "80", "-cap", "100", "-description", "Ferns"); err != nil {
+		t.Fatalf("pool create: %v", err)
+	}
+	var pools []models.FundingPool
+	admin.call(t, http.MethodGet, "/api/funding-pools", nil, http.StatusOK, &pools)
+	var plants *models.FundingPool
+	for i := range pools {
+		if pools[i].Name == "Plants" {
+			plants = &pools[i]
+		}
+	}
+	if plants == nil || plants.CapAmount == nil || *plants.CapAmount != 100 || plants.Description == nil || *plants.Description != "Ferns" {
+		t.Fatalf("created pool = %+v", plants)
+	}
+	if err := run("pool", "archive", strconv.Itoa(plants.ID)); err != nil {
+		t.Fatalf("pool archive: %v", err)
+	}
+	if p := admin.pool(t, plants.ID); p.ArchivedAt == nil {
+		t.Error("archived pool has no archived_at")
+	}
+	var revisions []models.FundingPoolRevision
+	admin.call(t, http.MethodGet, poolPath(plants.ID)+"/revisions", nil, http.StatusOK, &revisions)
+	if len(revisions) != 2 || revisions[0].Action != models.RevisionArchive {
+		t.Errorf("revisions = %+v", revisions)
+	}
+
+	if err := run("ledger", "verify"); err != nil {
+		t.Errorf("ledger verify: %v", err)
+	}
+	export := filepath.Join(t.TempDir(), "ledger.json")
+	if err := run("ledger", "export", export); err != nil {
+		t.Fatalf("ledger export: %v", err)
+	}
+	data, err := os.ReadFile(export)
+	if err != nil {
+		t.Fatalf("reading export: %v", err)
+	}
+	var entries []models.LedgerEntry
+	if err := json.Unmarshal(data, &entries); err != nil || len(entries) != len(admin.ledger(t).Transactions) {
+		t.Errorf("exported %d entries, %v", len(entries), err)
+	}
+}
+
+func allocations(pairs ...float64) []models.AllocationRequest {
+	var allocs []models.AllocationRequest
+	for i := 0; i+1 < len(pairs); i += 2 {
+		allocs = append(allocs, models.AllocationRequest{FundingPoolID: int(pairs[i]), Amount: pairs[i+1]})
+	}
+	return allocs
+}
+
+func testPoolLifecycle(t *testing.T, a *app, manager, donor *client) {
+	guest := a.guest(t)
+	guest.call(t, http.MethodPost, "/api/funding-pools", models.CreateFundingPoolRequest{Name: "Nope", GoalAmount: 1}, http.StatusUnauthorized, nil)
+	donor.call(t, http.MethodPost, "/api/funding-pools", models.CreateFundingPoolRequest{Name: "Nope", GoalAmount: 1}, http.StatusForbidden, nil)
+
+	id := manager.createPool(t, "Library", 500)
+	description := "New **shelves**"
+	update := models.CreateFundingPoolRequest{Name: "Reading Room", Description: &description, GoalAmount: 750}
+	manager.call(t, http.MethodPut, poolPath(id), update, http.StatusOK, nil)
+
+	pool := guest.pool(t, id)
+	if pool.Name != "Reading Room" || pool.GoalAmount != 750 || pool.DescriptionHTML == nil || pool.CurrentAmount != 0 {
+		t.Errorf("updated pool = %+v", pool)
+	}
+
+	manager.call(t, http.MethodPost, poolPath(id)+"/archive", nil, http.StatusOK, nil)
+	if pool := guest.pool(t, id); pool.ArchivedAt == nil {
+		t.Error("archived pool has no archived_at")
+	}
+
+	// Donations to an archived pool are refused before the payment is taken.
+	a.paypal.AddOrder("ORDER-ARCHIVED", paypaltest.Order{Amount: "5.00"})
+	capture := handlers.CaptureDonationRequest{OrderID: "ORDER-ARCHIVED", Allocations: allocations(float64(id), 5)}
+	donor.call(t, http.MethodPost, "/api/donations/capture", capture, http.StatusBadRequest, nil)
+	if a.paypal.Captured("ORDER-ARCHIVED") {
+		t.Error("order for an archived pool was captured")
+	}
+
+	manager.call(t, http.MethodPost, poolPath(id)+"/unarchive", nil, http.StatusOK, nil)
+	donor.call(t, http.MethodPost, "/api/donations/capture", capture, http.StatusOK, nil)
+
+	var revisions []models.FundingPoolRevision
+	guest.call(t, http.MethodGet, poolPath(id)+"/revisions", nil, http.StatusOK, &revisions)
+	var actions []string
+	for _, r := range revisions {
+		actions = append(actions, r.Action)
+	}
+	if fmt.Sprint(actions) != "[unarchive archive update create]" {
+		t.Errorf("revision actions = %v", actions)
+	}
+
+	// Pools that hold donations cannot be deleted; empty ones can.
+	manager.call(t, http.MethodDelete, poolPath(id), nil, http.StatusBadRequest, nil)
+	empty := manager.createPool(t, "Empty", 10)
+	manager.call(t, http.MethodDelete, poolPath(empty), nil, http.StatusNoContent, nil)
+	guest.call(t, http.MethodGet, poolPath(empty), nil, http.StatusNotFound, nil)
+}
+
+func testDonation(t *testing.T, a *app, manager, donor *client) {
+	books := manager.createPool(t, "Books", 100)
+	snacks := manager.createPool(t, "Snacks", 50)
+	before, balancesBefore := donor.ledger(t), donor.totalBalance(t)
+
+	a.paypal.AddOrder("ORDER-1", paypaltest.Order{Amount: "30.00"})
+	var captured handlers.CaptureDonationResponse
+	capture := handlers.CaptureDonationRequest{OrderID: "ORDER-1", Allocations: allocations(float64(books), 20, float64(snacks), 10)}
+	donor.call(t, http.MethodPost, "/api/donations/capture", capture, http.StatusOK, &captured)
+	if captured.LedgerID == 0 || len(captured.Allocations) != 2 || !a.paypal.Captured("ORDER-1") {
+		t.Errorf("capture response = %+v", captured)
+	}
+
+	// PayPal refuses to capture an order twice, so nothing more is recorded.
+	donor.call(t, http.MethodPost, "/api/donations/capture", capture, http.StatusInternalServerError, nil)
+
+	// A guest donation split automatically across the pools.
+	a.paypal.AddOrder("ORDER-2", paypaltest.Order{Amount: "12.34"})
+	auto := handlers.CaptureDonationRequest{OrderID: "ORDER-2", AutoAllocate: true, Amount: 12.34}
+	a.guest(t).call(t, http.MethodPost, "/api/donations/capture", auto, http.StatusOK, &captured)
+	var split float64
+	for _, alloc := range captured.Allocations {
+		split += alloc.Amount
+	}
+	if fmt.Sprintf("%.2f", split) != "12.34" {
+		t.Errorf("automatic split = %+v", captured.Allocations)
+	}
+
+	// Declined payments and amounts that differ from the order record nothing.
+	a.paypal.AddOrder("ORDER-3", paypaltest.Order{Amount: "8.00", Declined: true})
+	declined := handlers.CaptureDonationRequest{OrderID: "ORDER-3", Allocations: allocations(float64(books), 8)}
+	donor.call(t, http.MethodPost, "/api/donations/capture", declined, http.StatusInternalServerError, nil)
+	a.paypal.AddOrder("ORDER-4", paypaltest.Order{Amount: "5.00"})
+	mismatch := handlers.CaptureDonationRequest{OrderID: "ORDER-4", Allocations: allocations(float64(books), 6)}
+	donor.call(t, http.MethodPost, "/api/donations/capture", mismatch, http.StatusBadRequest, nil)
+
+	ledger := donor.ledger(t)
+	if got := len(ledger.Transactions) - len(before.Transactions); got != 2 {
+		t.Errorf("recorded %d ledger entries, want 2", got)
+	}
+	if got := ledger.TotalDonations - before.TotalDonations; fmt.Sprintf("%.2f", got) != "42.34" {
+		t.Errorf("donations grew by %.2f, want 42.34", got)
+	}
+	if got := donor.totalBalance(t) - balancesBefore; fmt.Sprintf("%.2f", got) != "42.34" {
+		t.Errorf("pool balances grew by %.2f, want 42.34", got)
+	}
+	if books := donor.pool(t, books); books.CurrentAmount < 20 {
+		t.Errorf("Books balance = %.2f, want at least the 20.00 given to it", books.CurrentAmount)
+	}
+
+	var history models.DonationHistory
+	donor.call(t, http.MethodGet, "/api/me/donations", nil, http.StatusOK, &history)
+	var found bool
+	for _, d := range history.Donations {
+		found = found || d.TransactionID == "CAPTURE-ORDER-1"
+	}
+	if !found {
+		t.Errorf("donation history is missing the captured order: %+v", history.Donations)
+	}
+}
+
+func testExternalDonation(t *testing.T, a *app, manager, treasurer, donor *client) {
+	jar := manager.createPool(t, "Cash Jar", 40)
+	external := handlers.ExternalDonationRequest{Description: "Bake sale", Allocations: allocations(float64(jar), 17.5)}
+	a.guest(t).call(t, http.MethodPost, "/api/donations/external", external, http.StatusUnauthorized, nil)
+	donor.call(t, http.MethodPost, "/api/donations/external", external, http.StatusForbidden, nil)
+	treasurer.call(t, http.MethodPost, "/api/donations/external", external, http.StatusCreated, nil)
+	external.Description = ""
+	treasurer.call(t, http.MethodPost, "/api/donations/external", external, http.StatusBadRequest, nil)
+
+	if balance := donor.pool(t, jar).CurrentAmount; balance != 17.5 {
+		t.Errorf("balance = %.2f, want 17.50", balance)
+	}
+	latest := donor.ledger(t).Transactions[0]
+	if latest.TransactionType != "deposit" || latest.Description == nil || *latest.Description != "Bake sale" || latest.TransactionID != nil {
+		t.Errorf("external donation entry = %+v", latest)
+	}
+}
+
+func testWithdrawal(t *testing.T, a *app, manager, treasurer *client) {
+	id := manager.createPool(t, "Garden", 100)
+	deposit := handlers.ExternalDonationRequest{Description: "Plant sale", Allocations: allocations(float64(id), 10)}
+	treasurer.call(t, http.MethodPost, "/api/donations/external", deposit, http.StatusCreated, nil)
+
+	withdrawal := models.WithdrawalRequest{Description: "Seeds", Allocations: allocations(float64(id), 10.01)}
+	manager.call(t, http.MethodPost, "/api/withdrawals", withdrawal, http.StatusForbidden, nil)
+	treasurer.call(t, http.MethodPost, "/api/withdrawals", withdrawal, http.StatusBadRequest, nil)
+	withdrawal.Allocations[0].Amount = 1.5
+	treasurer.call(t, http.MethodPost, "/api/withdrawals", withdrawal, http.StatusCreated, nil)
+	if balance := treasurer.pool(t, id).CurrentAmount; balance != 8.5 {
+		t.Fatalf("balance = %.2f, want 8.50", balance)
+	}
+
+	// Concurrent withdrawals cannot together take more than the balance.
+	const attempts = 5
+	var wg sync.WaitGroup
+	statuses := make(chan int, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := models.WithdrawalRequest{Description: "Tools", Allocations: allocations(float64(id), 4)}
+			res := treasurer.do(t, http.MethodPost, "/api/withdrawals", body)
+			res.Body.Close()
+			statuses <- res.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	var ok int
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			ok++
+		case http.StatusBadRequest:
+		default:
+			t.Errorf("concurrent withdrawal status = %d", status)
+		}
+	}
+	if ok != 2 {
+		t.Errorf("%d of %d concurrent withdrawals succeeded, want 2", ok, attempts)
+	}
+	if balance := treasurer.pool(t, id).CurrentAmount; balance != 0.5 {
+		t.Errorf("balance after concurrent withdrawals = %.2f, want 0.50", balance)
+	}
+}
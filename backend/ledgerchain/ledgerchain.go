@@ -0,0 +1,202 @@
+// Package ledgerchain makes the ledger tamper-evident. Each entry carries a
+// SHA-256 hash of its contents, its allocations and the previous entry's
+// hash, so editing, inserting or deleting any entry breaks every link after
+// it.
+//
+// An entry's hash also covers a commitment to its donor: a salted digest of
+// the user ID, the name shown and the anonymous flag. Deleting an account
+// erases the donor and the salt from its entries, and records an
+// "anonymized" entry for each of them that carries the erased commitment, so
+// that the chain still verifies without rewriting any hash. Entries recorded
+// before commitments existed have no salt, and their hashes leave the donor
+// out.
+package ledgerchain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"pool-party-api/models"
+	"sort"
+	"time"
+)
+
+// Genesis is the previous hash of the first entry.
+const Genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// hashedEntry is what is hashed for an entry, with amounts in cents and the
+// timestamp in UTC to the microsecond, the precision Postgres keeps.
+type hashedEntry struct {
+	PrevHash        string             `json:"prev_hash"`
+	ID              int                `json:"id"`
+	TransactionID   *string            `json:"transaction_id"`
+	AmountCents     int64              `json:"amount_cents"`
+	Timestamp       string             `json:"timestamp"`
+	TransactionType string             `json:"transaction_type"`
+	Description     *string            `json:"description"`
+	Allocations     []hashedAllocation `json:"allocations"`
+	// The fields below are left out when empty, so that entries hashed
+	// before they existed keep their hashes.
+	Donor              string `json:"donor,omitempty"`
+	AnonymizedLedgerID int    `json:"anonymized_ledger_id,omitempty"`
+	AnonymizedDonor    string `json:"anonymized_donor,omitempty"`
+}
+
+// hashedDonor is what a donor commitment is a digest of.
+type hashedDonor struct {
+	Salt         string  `json:"salt"`
+	UserGoogleID *string `json:"user_google_id"`
+	FirstName    *string `json:"first_name"`
+	LastInitial  *string `json:"last_initial"`
+	Anonymous    bool    `json:"anonymous"`
+}
+
+type hashedAllocation struct {
+	ID            int   `json:"id"`
+	FundingPoolID int   `json:"funding_pool_id"`
+	AmountCents   int64 `json:"amount_cents"`
+}
+
+// NewSalt returns a random salt for a new entry's donor commitment.
+func NewSalt() (string, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+// Commitment returns the hex-encoded commitment to an entry's donor, or ""
+// if the entry has no salt: it predates commitments, or its donor was
+// erased.
+func Commitment(e models.LedgerEntry) string {
+	if e.DonorSalt == "" {
+		return ""
+	}
+	return digest(hashedDonor{
+		Salt:         e.DonorSalt,
+		UserGoogleID: e.UserGoogleID,
+		FirstName:    e.FirstName,
+		LastInitial:  e.LastInitial,
+		Anonymous:    e.Anonymous,
+	})
+}
+
+// Hash returns the hex-encoded hash of an entry that follows prevHash. The
+// entry's own hash fields are ignored.
+func Hash(prevHash string, e models.LedgerEntry) string {
+	return hash(prevHash, e, Commitment(e))
+}
+
+// hash returns the hash of an entry whose donor commitment is donor.
+func hash(prevHash string, e models.LedgerEntry, donor string) string {
+	h := hashedEntry{
+		PrevHash:        prevHash,
+		ID:              e.ID,
+		TransactionID:   e.TransactionID,
+		AmountCents:     cents(e.Amount),
+		Timestamp:       e.Timestamp.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		TransactionType: e.TransactionType,
+		Description:     e.Description,
+		Allocations:     make([]hashedAllocation, 0, len(e.Allocations)),
+		Donor:           donor,
+	}
+	if e.AnonymizedLedgerID != nil {
+		h.AnonymizedLedgerID = *e.AnonymizedLedgerID
+	}
+	if e.DonorCommitment != nil {
+		h.AnonymizedDonor = *e.DonorCommitment
+	}
+	allocs := append([]models.Allocation(nil), e.Allocations...)
+	sort.Slice(allocs, func(i, j int) bool { return allocs[i].ID < allocs[j].ID })
+	for _, a := range allocs {
+		h.Allocations = append(h.Allocations, hashedAllocation{ID: a.ID, FundingPoolID: a.FundingPoolID, AmountCents: cents(a.Amount)})
+	}
+
+	return digest(h)
+}
+
+// digest returns the hex-encoded SHA-256 of v as JSON.
+func digest(v interface{}) string {
+	// Marshaling a struct of plain values cannot fail.
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// Verify checks the chain of entries, in any order, and reports the first
+// broken link by ID. An entry whose donor was erased is checked against the
+// commitment recorded when it was anonymized.
+func Verify(entries []models.LedgerEntry) models.LedgerVerification {
+	sorted := append([]models.LedgerEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	erased := erasedDonors(sorted)
+
+	result := models.LedgerVerification{Valid: true, HeadHash: Genesis}
+	for _, e := range sorted {
+		donor, anonymized := erased[e.ID]
+		if !anonymized {
+			donor = Commitment(e)
+		}
+		var reason string
+		switch {
+		case e.Hash == "":
+			reason = "entry has no hash"
+		case e.PrevHash != result.HeadHash:
+			reason = "previous hash does not match the entry before it; an entry was removed, inserted or rehashed"
+		case e.AnonymizedLedgerID != nil && *e.AnonymizedLedgerID >= e.ID:
+			reason = "entry anonymizes an entry that does not come before it"
+		case anonymized && (e.DonorSalt != "" || e.UserGoogleID != nil || e.FirstName != nil || e.LastInitial != nil ||
+			e.TransactionType == "deposit" && !e.Anonymous):
+			reason = "entry was anonymized, but names a donor; its donor was changed"
+		case e.Hash != hash(e.PrevHash, e, donor):
+			reason = "hash does not match the entry's contents; the entry, its donor or its allocations were changed"
+		}
+		if reason != "" {
+			result.Valid = false
+			result.Broken = &models.LedgerBrokenLink{LedgerID: e.ID, Reason: reason}
+			return result
+		}
+		result.Checked++
+		result.HeadHash = e.Hash
+	}
+	return result
+}
+
+// Seal chains entries recorded before the hash chain existed, in ID order
+// from Genesis, setting their PrevHash and Hash as Verify expects them.
+func Seal(entries []models.LedgerEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	erased := erasedDonors(entries)
+	prevHash := Genesis
+	for i := range entries {
+		donor, anonymized := erased[entries[i].ID]
+		if !anonymized {
+			donor = Commitment(entries[i])
+		}
+		entries[i].PrevHash = prevHash
+		entries[i].Hash = hash(prevHash, entries[i], donor)
+		prevHash = entries[i].Hash
+	}
+}
+
+// erasedDonors returns the commitment that each anonymized entry's hash
+// covers, as carried by the entry that anonymized it, by entry ID.
+func erasedDonors(entries []models.LedgerEntry) map[int]string {
+	erased := make(map[int]string)
+	for _, e := range entries {
+		if e.AnonymizedLedgerID != nil {
+			erased[*e.AnonymizedLedgerID] = ""
+			if e.DonorCommitment != nil {
+				erased[*e.AnonymizedLedgerID] = *e.DonorCommitment
+			}
+		}
+	}
+	return erased
+}
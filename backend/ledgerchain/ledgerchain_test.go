@@ -0,0 +1,141 @@
+package ledgerchain
+
+import (
+	"pool-party-api/models"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// testLedger returns a chained donation by Ada, a withdrawal and an anonymous
+// donation, each committing to its donor.
+func testLedger() []models.LedgerEntry {
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []models.LedgerEntry{
+		{ID: 1, TransactionID: ptr("PAY-1"), Amount: 10, Timestamp: day, TransactionType: "deposit",
+			UserGoogleID: ptr("g1"), FirstName: ptr("Ada"), LastInitial: ptr("L"), DonorSalt: "01",
+			Allocations: []models.Allocation{{ID: 1, FundingPoolID: 3, Amount: 10}}},
+		{ID: 2, Amount: 4, Timestamp: day.Add(time.Hour), TransactionType: "withdrawal", Description: ptr("Books"),
+			UserGoogleID: ptr("g2"), FirstName: ptr("Bob"), DonorSalt: "02",
+			Allocations: []models.Allocation{{ID: 2, FundingPoolID: 3, Amount: 4}}},
+		{ID: 3, TransactionID: ptr("PAY-2"), Amount: 5, Timestamp: day.Add(2 * time.Hour), TransactionType: "deposit",
+			UserGoogleID: ptr("g3"), FirstName: ptr("Cy"), Anonymous: true, DonorSalt: "03",
+			Allocations: []models.Allocation{{ID: 3, FundingPoolID: 3, Amount: 5}}},
+	}
+	Seal(entries)
+	return entries
+}
+
+// anonymize erases the donor of entries[i] and appends the entry that
+// records it, as deleting an account does.
+func anonymize(entries []models.LedgerEntry, i int) []models.LedgerEntry {
+	e := &entries[i]
+	commitment := Commitment(*e)
+	e.UserGoogleID, e.FirstName, e.LastInitial, e.DonorSalt = nil, nil, nil, ""
+	e.Anonymous = e.Anonymous || e.TransactionType == "deposit"
+
+	last := entries[len(entries)-1]
+	event := models.LedgerEntry{
+		ID:                 last.ID + 1,
+		Timestamp:          last.Timestamp.Add(time.Hour),
+		TransactionType:    "anonymized",
+		DonorSalt:          "ff",
+		AnonymizedLedgerID: ptr(e.ID),
+		DonorCommitment:    &commitment,
+		PrevHash:           last.Hash,
+	}
+	event.Hash = Hash(event.PrevHash, event)
+	return append(entries, event)
+}
+
+func TestVerify(t *testing.T) {
+	if v := Verify(testLedger()); !v.Valid || v.Checked != 3 {
+		t.Fatalf("Verify = %+v, want 3 valid entries", v)
+	}
+
+	tests := []struct {
+		name   string
+		edit   func(entries []models.LedgerEntry)
+		broken int
+	}{
+		{"amount", func(e []models.LedgerEntry) { e[1].Amount = 40 }, 2},
+		{"allocation", func(e []models.LedgerEntry) { e[0].Allocations[0].FundingPoolID = 4 }, 1},
+		{"donor name", func(e []models.LedgerEntry) { e[0].FirstName = ptr("Eve") }, 1},
+		{"last initial", func(e []models.LedgerEntry) { e[0].LastInitial = nil }, 1},
+		{"donor", func(e []models.LedgerEntry) { e[1].UserGoogleID = ptr("g1") }, 2},
+		{"anonymous flag set", func(e []models.LedgerEntry) { e[0].Anonymous = true }, 1},
+		{"anonymous flag cleared", func(e []models.LedgerEntry) { e[2].Anonymous = false }, 3},
+		{"salt removed", func(e []models.LedgerEntry) { e[2].DonorSalt = "" }, 3},
+		{"entry removed", func(e []models.LedgerEntry) { e[1] = e[2] }, 3},
+		{"hash removed", func(e []models.LedgerEntry) { e[1].Hash = "" }, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := testLedger()
+			tt.edit(entries)
+			v := Verify(entries)
+			if v.Valid || v.Broken == nil || v.Broken.LedgerID != tt.broken {
+				t.Errorf("Verify = %+v, want broken at %d", v, tt.broken)
+			}
+		})
+	}
+}
+
+func TestVerifyAnonymized(t *testing.T) {
+	entries := anonymize(testLedger(), 0)
+	if v := Verify(entries); !v.Valid || v.Checked != 4 {
+		t.Fatalf("Verify after anonymizing = %+v, want 4 valid entries", v)
+	}
+	if entries[0].FirstName != nil || !entries[0].Anonymous {
+		t.Fatalf("anonymized entry = %+v", entries[0])
+	}
+
+	tests := []struct {
+		name   string
+		edit   func(entries []models.LedgerEntry)
+		broken int
+	}{
+		{"name restored", func(e []models.LedgerEntry) { e[0].FirstName = ptr("Ada") }, 1},
+		{"donation made public", func(e []models.LedgerEntry) { e[0].Anonymous = false }, 1},
+		{"record removed", func(e []models.LedgerEntry) { e[3].AnonymizedLedgerID, e[3].DonorCommitment = nil, nil }, 1},
+		{"commitment changed", func(e []models.LedgerEntry) { e[3].DonorCommitment = ptr(Commitment(e[1])) }, 1},
+		{"another entry claimed", func(e []models.LedgerEntry) { e[3].AnonymizedLedgerID = ptr(2) }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := anonymize(testLedger(), 0)
+			tt.edit(entries)
+			v := Verify(entries)
+			if v.Valid || v.Broken == nil || v.Broken.LedgerID != tt.broken {
+				t.Errorf("Verify = %+v, want broken at %d", v, tt.broken)
+			}
+		})
+	}
+
+	// Only an earlier entry can be anonymized.
+	entries = testLedger()
+	forged := models.LedgerEntry{ID: 4, TransactionType: "anonymized", AnonymizedLedgerID: ptr(4), PrevHash: entries[2].Hash}
+	forged.Hash = Hash(forged.PrevHash, forged)
+	if v := Verify(append(entries, forged)); v.Valid || v.Broken == nil || v.Broken.LedgerID != 4 {
+		t.Errorf("Verify with an entry anonymizing itself = %+v, want broken at 4", v)
+	}
+}
+
+// Entries recorded before donor commitments have no salt, and keep the
+// hashes they were given then.
+func TestHashWithoutSalt(t *testing.T) {
+	e := models.LedgerEntry{
+		ID: 7, TransactionID: ptr("PAY-1"), Amount: 10.25, Timestamp: time.Date(2025, 3, 1, 12, 30, 0, 123456789, time.UTC),
+		TransactionType: "deposit", UserGoogleID: ptr("g1"), FirstName: ptr("Ada"), LastInitial: ptr("L"), Description: ptr("Books"),
+		Allocations: []models.Allocation{{ID: 12, FundingPoolID: 3, Amount: 10.25}},
+	}
+	const want = "deffb105837902344c3b336cdd4cd65de0a0c658890c177d7c9302ecf92b245f"
+	if got := Hash(Genesis, e); got != want {
+		t.Errorf("Hash = %s, want %s", got, want)
+	}
+	e.DonorSalt = "01"
+	if got := Hash(Genesis, e); got == want {
+		t.Error("Hash ignores the donor commitment of a salted entry")
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"pool-party-api/config"
 	"pool-party-api/database"
 	"pool-party-api/handlers"
-	"strconv"
-
-	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
+	"pool-party-api/migrations"
+	"pool-party-api/oidc"
+	"pool-party-api/paypal"
+	"pool-party-api/sessionstore"
+	"pool-party-api/storage"
+	"pool-party-api/store"
+	"time"
 )
 
 // spaHandler implements the http.Handler interface, so we can use it
@@ -58,72 +65,151 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.FileServer(http.Dir(h.staticPath)).ServeHTTP(w, r)
 }
 
-func main() {
-	// Initialize the database connection.
-	db, err := database.InitDB()
+// storeFor returns the schema dialect and Store for a database opened with
+// cfg. SQLite has its own; every other mode is Postgres.
+func storeFor(db *sql.DB, cfg database.Config) (migrations.Dialect, store.Store) {
+	if cfg.Mode == database.ModeSQLite {
+		return migrations.SQLite, store.NewSQLite(db)
+	}
+	return migrations.Postgres, store.NewPostgres(db)
+}
+
+// newHandler wires the API and the frontend to a migrated database, as
+// configured by cfg. Expired sessions are cleaned up until ctx is done.
+func newHandler(ctx context.Context, db *sql.DB, dataStore store.Store, cfg *config.Config) (http.Handler, error) {
+	// Initialize session store.
+	// The keys should be stored securely in production (e.g., Google Secret Manager).
+	keyring, err := sessionstore.LoadKeyring(cfg.Session.KeyConfig)
 	if err != nil {
-		log.Fatalf("could not initialize database: %v", err)
+		return nil, fmt.Errorf("could not load session keys: %w", err)
+	}
+	cookieOptions := handlers.SessionCookieOptions(!cfg.Session.CookieInsecure)
+	sessionStore := sessionstore.New(db, cookieOptions, keyring.Codecs())
+	sessionStore.IdleTimeout = cfg.Session.IdleTimeout
+	sessionStore.StartCleanup(ctx, time.Hour)
+
+	// Initialize storage for uploaded images.
+	imageStorage, err := storage.NewLocalStorage(cfg.Server.ImageStorageDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize image storage: %w", err)
+	}
+
+	// Create an environment to hold the data store and other dependencies.
+	loginPolicy := cfg.Login
+	env := &handlers.APIEnv{
+		Store:                     dataStore,
+		SessionStore:              sessionStore,
+		Storage:                   imageStorage,
+		OIDC:                      oidc.NewRegistry(cfg.OIDC, nil),
+		LoginPolicy:               &loginPolicy,
+		GoogleClientID:            cfg.Google.ClientID,
+		GoogleLinkByEmail:         cfg.Google.LinkByEmail,
+		DefaultAllocationStrategy: cfg.Server.DefaultAllocationStrategy,
+		AllowedOrigins:            cfg.Server.AllowedOrigins,
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			log.Printf("failed to close database: %v", err)
+	if cfg.PayPalEnabled() {
+		if env.PayPal, err = paypal.NewClient(cfg.PayPal); err != nil {
+			return nil, err
 		}
-	}(db)
+	} else {
+		log.Printf("PayPal is not configured; donations through PayPal are disabled")
+	}
 
-	// Initialize session store.
-	// The key should be stored securely in production (e.g., Google Secret Manager).
-	sessionKey := os.Getenv("SESSION_SECRET")
-	if sessionKey == "" {
-		log.Fatal("SESSION_SECRET environment variable not set.")
+	// Hash any ledger entries recorded before the hash chain existed, then
+	// keep checking that the chain is intact.
+	if n, err := dataStore.SealLedger(ctx); err != nil {
+		return nil, fmt.Errorf("could not hash the ledger: %w", err)
+	} else if n > 0 {
+		log.Printf("Added %d ledger entries to the hash chain", n)
+	}
+	if cfg.Server.LedgerVerifyInterval > 0 {
+		env.StartLedgerVerification(ctx, cfg.Server.LedgerVerifyInterval)
 	}
-	store := sessions.NewCookieStore([]byte(sessionKey))
 
-	// Create an environment to hold the database connection.
-	env := &handlers.APIEnv{DB: db, SessionStore: store}
+	router := handlers.NewRouter(env)
+	spa := spaHandler{staticPath: "../frontend/build", indexPath: "index.html"}
+	router.PathPrefix("/").Handler(spa)
+	return router, nil
+}
 
-	// Create a new router
-	router := mux.NewRouter()
+// runServe implements the "serve" command, the default: it serves the API
+// and the frontend until the process is stopped.
+func runServe(inst *instance, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments")
+	}
 
-	// Define the FundingPool routes
-	apiRouter := router.PathPrefix("/api").Subrouter()
-	apiRouter.HandleFunc("/funding-pools", env.GetFundingPools).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/funding-pools/{id}", env.GetFundingPool).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/funding-pools", env.ModeratorRequired(env.CreateFundingPool)).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/funding-pools/{id}", env.ModeratorRequired(env.UpdateFundingPool)).Methods(http.MethodPut)
-	apiRouter.HandleFunc("/funding-pools/{id}", env.ModeratorRequired(env.DeleteFundingPool)).Methods(http.MethodDelete)
+	// Bring the schema up to date unless migrations are run separately.
+	if inst.config.Server.MigrateOnStart {
+		if _, err := migrations.Up(context.Background(), inst.db, inst.dialect); err != nil {
+			return fmt.Errorf("could not apply database migrations: %w", err)
+		}
+	}
 
-	// Define the Auth routes
-	apiRouter.HandleFunc("/auth/google/callback", env.GoogleLogin).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/auth/me", env.GetCurrentUser).Methods(http.MethodGet)
-	apiRouter.HandleFunc("/auth/logout", env.Logout).Methods(http.MethodPost)
+	handler, err := newHandler(context.Background(), inst.db, inst.store, inst.config)
+	if err != nil {
+		return err
+	}
 
-	// Define the Ledger routes
-	apiRouter.HandleFunc("/ledger", env.GetLedgerEntries).Methods(http.MethodGet)
+	// Start the server
+	// Cloud Run provides the PORT environment variable.
+	port := inst.config.Server.Port
+	fmt.Printf("Server listening on port %d...\n", port)
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
+}
 
-	// Define the Donation routes
-	apiRouter.HandleFunc("/donations/capture", env.CaptureDonation).Methods(http.MethodPost)
-	apiRouter.HandleFunc("/donations/external", env.ModeratorRequired(env.CreateExternalDonation)).Methods(http.MethodPost)
+func main() {
+	// Load the configuration once, before anything else runs.
+	// Note: Saving credentials in environment variables or a config file is
+	// convenient, but not the most secure method. For production environments,
+	// consider using a more secure solution like Google Cloud Secret Manager
+	// to protect your secrets.
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		fmt.Print(usage)
+		return
+	}
+	if cfg == nil {
+		fmt.Fprintf(os.Stderr, "pool-party: %v\n\n%s", err, usage)
+		os.Exit(2)
+	}
 
-	// Define the Withdrawal routes
-	apiRouter.HandleFunc("/withdrawals", env.ModeratorRequired(env.MakeWithdrawal)).Methods(http.MethodPost)
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		fmt.Print(usage)
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "pool-party: unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
 
-	// Define the SiteInstance route
-	apiRouter.HandleFunc("/site-instance", env.GetSiteInstance).Methods(http.MethodGet)
+	// Check the configuration up front and report every problem at once. Only
+	// the server needs more than the database settings.
+	validate := cfg.Database.Validate
+	if name == "serve" {
+		validate = cfg.Validate
+	}
+	if err := errors.Join(err, validate()); err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
 
-	spa := spaHandler{staticPath: "../frontend/build", indexPath: "index.html"}
-	router.PathPrefix("/").Handler(spa)
+	// Initialize the database connection.
+	db, err := database.Open(context.Background(), cfg.Database)
+	if err != nil {
+		log.Fatalf("could not initialize database: %v", err)
+	}
+	dialect, dataStore := storeFor(db, cfg.Database)
 
-	// Start the server
-	// Cloud Run provides the PORT environment variable.
-	portStr := os.Getenv("PORT")
-	if portStr == "" {
-		portStr = "8000" // Default port if not specified
+	err = run(&instance{config: cfg, db: db, dialect: dialect, store: dataStore}, args)
+	if closeErr := db.Close(); closeErr != nil {
+		log.Printf("failed to close database: %v", closeErr)
 	}
-	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		log.Fatalf("Invalid port: %v", err)
+		log.Fatalf("%s: %v", name, err)
 	}
-	fmt.Printf("Server listening on port %d...\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), router))
 }
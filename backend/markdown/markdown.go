@@ -0,0 +1,29 @@
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var (
+	// renderer converts Markdown to HTML. Raw HTML in the source is escaped
+	// by goldmark's defaults, and the output is sanitized again below.
+	renderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	// policy allows the formatting typically produced by user-authored
+	// Markdown while stripping scripts, styles and event handlers.
+	policy = bluemonday.UGCPolicy()
+)
+
+// Render converts the Markdown source to sanitized HTML that is safe to embed
+// directly in a page.
+func Render(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := renderer.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return policy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,38 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		notWant []string
+	}{
+		{"formatting", "**bold** and [a link](https://example.com)", `<strong>bold</strong>`, nil},
+		{"script tag", "hi <script>alert(1)</script>", "hi", []string{"<script", "</script"}},
+		{"javascript link", "[click](javascript:alert(1))", "click", []string{"javascript:"}},
+		{"javascript link, mixed case", "[click](JaVaScRiPt:alert(1))", "click", []string{"javascript:", "JaVaScRiPt:"}},
+		{"event handler", `<img src="x.png" onerror="alert(1)">`, "", []string{"onerror", "alert"}},
+		{"event handler on a link", `<a href="https://example.com" onclick="alert(1)">x</a>`, "", []string{"onclick", "alert"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Render(tt.source)
+			if err != nil {
+				t.Fatalf("Render: %v", err)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("Render(%q) = %q, want it to contain %q", tt.source, got, tt.want)
+			}
+			for _, bad := range tt.notWant {
+				if strings.Contains(got, bad) {
+					t.Errorf("Render(%q) = %q, contains %q", tt.source, got, bad)
+				}
+			}
+		})
+	}
+}
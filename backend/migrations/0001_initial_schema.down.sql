@@ -0,0 +1,15 @@
+-- Drops the whole schema, including all data.
+
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS api_token;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS user_role;
+DROP TABLE IF EXISTS user_identity;
+DROP TABLE IF EXISTS funding_pool_revision;
+DROP FUNCTION IF EXISTS reject_revision_changes();
+DROP TABLE IF EXISTS pool_daily_rollup;
+DROP TABLE IF EXISTS allocation;
+DROP TABLE IF EXISTS ledger;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS funding_pool;
+DROP TABLE IF EXISTS site_instance;
@@ -0,0 +1,233 @@
+-- The schema that used to be applied by hand from Postgres.sql. Every
+-- statement is idempotent, so a database created from that file at any point
+-- in its history is brought up to date and recorded as version 1.
+
+CREATE TABLE IF NOT EXISTS site_instance (
+    id SERIAL PRIMARY KEY,
+    site_title VARCHAR(255) NOT NULL,
+    site_headline TEXT
+);
+
+-- The API reads the site's configuration from the row with id 1.
+INSERT INTO site_instance (id, site_title) VALUES (1, 'Pool Party')
+ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('site_instance', 'id'), (SELECT MAX(id) FROM site_instance));
+
+CREATE TABLE IF NOT EXISTS funding_pool (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    goal_amount DECIMAL(15, 2) NOT NULL
+);
+
+-- Cover images for funding pools. Keys refer to objects in the configured
+-- image storage (see IMAGE_STORAGE_DIR).
+ALTER TABLE funding_pool
+ADD COLUMN IF NOT EXISTS image_key VARCHAR(255),
+ADD COLUMN IF NOT EXISTS thumbnail_key VARCHAR(255);
+
+-- Optional hard cap on a pool's balance, and what to do with donations that
+-- would exceed it: 'reject', 'redirect' (to overflow_pool_id) or 'spread'.
+ALTER TABLE funding_pool
+ADD COLUMN IF NOT EXISTS cap_amount DECIMAL(15, 2),
+ADD COLUMN IF NOT EXISTS overflow_policy VARCHAR(20) NOT NULL DEFAULT 'reject',
+ADD COLUMN IF NOT EXISTS overflow_pool_id INTEGER REFERENCES funding_pool(id) ON DELETE SET NULL;
+
+-- Archived pools keep their history but no longer accept donations.
+ALTER TABLE funding_pool
+ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS users (
+    google_id VARCHAR(255) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    first_name VARCHAR(255),
+    last_name VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Set on login for accounts outside the login allowlist when
+-- LOGIN_UNLISTED_ACCESS=donate. Such users can donate but never moderate.
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS donate_only BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- How users appear on the public ledger. display_name replaces their name,
+-- show_full_name opts in to the full last name instead of an initial, and
+-- default_anonymous applies when a donation does not say either way.
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS display_name VARCHAR(50),
+ADD COLUMN IF NOT EXISTS default_anonymous BOOLEAN DEFAULT FALSE NOT NULL,
+ADD COLUMN IF NOT EXISTS show_full_name BOOLEAN DEFAULT FALSE NOT NULL;
+
+CREATE TABLE IF NOT EXISTS ledger (
+    id SERIAL PRIMARY KEY,
+    transaction_id VARCHAR(255),
+    amount DECIMAL(15, 2) NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    transaction_type VARCHAR(50) NOT NULL,  -- e.g., 'deposit', 'withdrawal'
+    user_google_id VARCHAR(255),
+    first_name VARCHAR(255),
+    last_initial VARCHAR(1),
+    description TEXT,
+    anonymous BOOLEAN DEFAULT FALSE
+);
+
+-- Deleting an account detaches its ledger entries rather than failing. The
+-- application also anonymizes the entries' names before deleting the user.
+ALTER TABLE ledger DROP CONSTRAINT IF EXISTS fk_user_google_id;
+ALTER TABLE ledger
+ADD CONSTRAINT fk_user_google_id
+FOREIGN KEY (user_google_id) REFERENCES users(google_id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS allocation (
+    id SERIAL PRIMARY KEY,
+    ledger_id INTEGER REFERENCES ledger(id),
+    funding_pool_id INTEGER REFERENCES funding_pool(id),
+    amount DECIMAL(15, 2) NOT NULL
+);
+
+-- Per-pool, per-day totals backing GET /api/funding-pools/{id}/history.
+-- Ledger writes keep this up to date; days are in UTC. When the table is
+-- first created it is backfilled from any existing ledger entries.
+DO $$
+BEGIN
+    IF to_regclass('pool_daily_rollup') IS NULL THEN
+        CREATE TABLE pool_daily_rollup (
+            funding_pool_id INTEGER NOT NULL REFERENCES funding_pool(id) ON DELETE CASCADE,
+            day DATE NOT NULL,
+            deposits DECIMAL(15, 2) NOT NULL DEFAULT 0,
+            withdrawals DECIMAL(15, 2) NOT NULL DEFAULT 0,
+            PRIMARY KEY (funding_pool_id, day)
+        );
+
+        INSERT INTO pool_daily_rollup (funding_pool_id, day, deposits, withdrawals)
+        SELECT
+            a.funding_pool_id,
+            (l.timestamp AT TIME ZONE 'UTC')::date,
+            SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount ELSE 0 END),
+            SUM(CASE WHEN l.transaction_type = 'withdrawal' THEN a.amount ELSE 0 END)
+        FROM allocation a
+        JOIN ledger l ON a.ledger_id = l.id
+        GROUP BY 1, 2;
+    END IF;
+END $$;
+
+-- Append-only history of every change moderators make to a funding pool.
+-- There is deliberately no foreign key to funding_pool, so the history of a
+-- deleted pool is kept.
+CREATE TABLE IF NOT EXISTS funding_pool_revision (
+    id SERIAL PRIMARY KEY,
+    funding_pool_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,  -- 'create', 'update', 'archive', 'unarchive' or 'delete'
+    moderator_google_id VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    changes JSONB NOT NULL  -- {"field": {"old": ..., "new": ...}}
+);
+
+CREATE INDEX IF NOT EXISTS idx_funding_pool_revision_pool ON funding_pool_revision (funding_pool_id, id);
+
+CREATE OR REPLACE FUNCTION reject_revision_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'funding_pool_revision is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS funding_pool_revision_append_only ON funding_pool_revision;
+CREATE TRIGGER funding_pool_revision_append_only
+BEFORE UPDATE OR DELETE ON funding_pool_revision
+FOR EACH ROW EXECUTE FUNCTION reject_revision_changes();
+
+-- Login identities from any provider (Google, or OpenID Connect providers
+-- such as Okta, Entra ID or Keycloak), each mapped to a user. The users table
+-- remains keyed by google_id, which serves as the user's stable ID: Google
+-- users keep their Google subject, other users get '<provider>:<subject>'.
+-- Users from before this table existed were all Google users.
+DO $$
+BEGIN
+    IF to_regclass('user_identity') IS NULL THEN
+        CREATE TABLE user_identity (
+            provider VARCHAR(64) NOT NULL,
+            subject VARCHAR(255) NOT NULL,
+            user_google_id VARCHAR(255) NOT NULL REFERENCES users(google_id) ON DELETE CASCADE,
+            email VARCHAR(255),
+            created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            last_login_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (provider, subject)
+        );
+
+        CREATE INDEX idx_user_identity_user ON user_identity (user_google_id);
+
+        INSERT INTO user_identity (provider, subject, user_google_id, email)
+        SELECT 'google', google_id, google_id, email FROM users;
+    END IF;
+END $$;
+
+-- Roles granted to users. Each role maps to a set of permissions in the
+-- backend (see auth/roles.go): 'viewer', 'treasurer', 'pool_manager' or 'admin'.
+CREATE TABLE IF NOT EXISTS user_role (
+    user_google_id VARCHAR(255) NOT NULL REFERENCES users(google_id) ON DELETE CASCADE,
+    role VARCHAR(32) NOT NULL,
+    granted_by VARCHAR(255),
+    granted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_google_id, role)
+);
+
+-- Moderators from before roles existed become admins, replacing the
+-- is_moderator flag.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'is_moderator'
+    ) THEN
+        INSERT INTO user_role (user_google_id, role)
+        SELECT google_id, 'admin' FROM users WHERE is_moderator
+        ON CONFLICT DO NOTHING;
+
+        ALTER TABLE users DROP COLUMN is_moderator;
+    END IF;
+END $$;
+
+-- Administrative changes, such as role grants and revocations.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    actor_google_id VARCHAR(255),
+    action VARCHAR(64) NOT NULL,
+    target_google_id VARCHAR(255),
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Personal access tokens for automation. Only the SHA-256 hash of a token is
+-- stored. scopes is a comma-separated list of permissions.
+CREATE TABLE IF NOT EXISTS api_token (
+    id SERIAL PRIMARY KEY,
+    user_google_id VARCHAR(255) NOT NULL REFERENCES users(google_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    display_prefix VARCHAR(16) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_token_user ON api_token (user_google_id);
+
+-- Server-side sessions. The session cookie only carries the signed token, so
+-- deleting a row signs that browser out. Rows are removed once they expire or
+-- have been idle too long.
+CREATE TABLE IF NOT EXISTS sessions (
+    id SERIAL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    user_google_id VARCHAR(255) REFERENCES users(google_id) ON DELETE CASCADE,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(45)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_google_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
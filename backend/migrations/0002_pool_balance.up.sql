@@ -0,0 +1,17 @@
+-- Each pool's current balance, its deposits less its withdrawals. Ledger
+-- writes keep this up to date so that reads don't sum the whole ledger; it
+-- is backfilled from the entries recorded so far. Pools without a row have
+-- a balance of zero.
+CREATE TABLE pool_balance (
+    funding_pool_id INTEGER PRIMARY KEY REFERENCES funding_pool(id) ON DELETE CASCADE,
+    balance DECIMAL(15, 2) NOT NULL DEFAULT 0
+);
+
+INSERT INTO pool_balance (funding_pool_id, balance)
+SELECT
+    a.funding_pool_id,
+    SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END)
+FROM allocation a
+JOIN ledger l ON a.ledger_id = l.id
+WHERE a.funding_pool_id IS NOT NULL
+GROUP BY a.funding_pool_id;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS ledger_chain;
+ALTER TABLE ledger
+DROP COLUMN IF EXISTS prev_hash,
+DROP COLUMN IF EXISTS hash;
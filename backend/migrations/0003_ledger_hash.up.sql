@@ -0,0 +1,18 @@
+-- A hash chain over the ledger, making edits to past entries evident (see
+-- package ledgerchain). The application computes the hashes. The entries
+-- that predate this migration, up to ledger_chain.legacy_through, are hashed
+-- once, oldest first, when the server next starts or records an entry; once
+-- sealed is set, an entry without a hash is never hashed again and fails
+-- verification instead.
+ALTER TABLE ledger
+ADD COLUMN prev_hash CHAR(64),
+ADD COLUMN hash CHAR(64);
+
+CREATE TABLE ledger_chain (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    legacy_through INTEGER NOT NULL,
+    sealed BOOLEAN NOT NULL
+);
+
+INSERT INTO ledger_chain (id, legacy_through, sealed)
+SELECT 1, COALESCE(MAX(id), 0), COUNT(*) = 0 FROM ledger;
@@ -0,0 +1,5 @@
+CREATE OR REPLACE FUNCTION reject_revision_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'funding_pool_revision is append-only';
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,13 @@
+-- Deleting an account clears the user's ID from the pool revisions they made.
+-- That is the only change the append-only revision history allows.
+CREATE OR REPLACE FUNCTION reject_revision_changes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND OLD.moderator_google_id IS NOT NULL AND NEW.moderator_google_id IS NULL
+        AND (NEW.id, NEW.funding_pool_id, NEW.action, NEW.created_at, NEW.changes)
+            IS NOT DISTINCT FROM (OLD.id, OLD.funding_pool_id, OLD.action, OLD.created_at, OLD.changes) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'funding_pool_revision is append-only';
+END;
+$$ LANGUAGE plpgsql;
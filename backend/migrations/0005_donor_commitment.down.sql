@@ -0,0 +1,4 @@
+ALTER TABLE ledger
+DROP COLUMN IF EXISTS donor_commitment,
+DROP COLUMN IF EXISTS anonymized_ledger_id,
+DROP COLUMN IF EXISTS donor_salt;
@@ -0,0 +1,9 @@
+-- Each new ledger entry's hash covers a salted commitment to its donor (see
+-- package ledgerchain). Deleting an account erases the donor and the salt
+-- from its entries, and records an 'anonymized' entry for each of them that
+-- names it and carries the erased commitment. Entries recorded before this
+-- migration have no salt, and keep their hashes.
+ALTER TABLE ledger
+ADD COLUMN donor_salt CHAR(64),
+ADD COLUMN anonymized_ledger_id INTEGER REFERENCES ledger(id),
+ADD COLUMN donor_commitment CHAR(64);
@@ -0,0 +1,296 @@
+// Package migrations applies the versioned database schema embedded in the
+// binary. Each migration is a pair of files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql, and the versions
+// that have been applied are recorded in the schema_migrations table.
+//
+// The Postgres migrations live in this directory and the SQLite ones in
+// sqlite/. Both must have the same versions and leave equivalent schemas.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// Dialect is the SQL database the migrations are written for.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// lockID is the Postgres advisory lock held while migrating, so that several
+// instances starting at once apply each migration only once.
+const lockID int64 = 7_301_994_125_011_042
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied.
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// All returns the embedded migrations for dialect in version order.
+func All(dialect Dialect) ([]Migration, error) {
+	switch dialect {
+	case Postgres:
+		return load(files)
+	case SQLite:
+		fsys, err := fs.Sub(sqliteFiles, "sqlite")
+		if err != nil {
+			return nil, err
+		}
+		return load(fsys)
+	}
+	return nil, fmt.Errorf("unknown database dialect %q", dialect)
+}
+
+// load reads migrations from fsys. Every version must have both an up and a
+// down file.
+func load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		base := path.Base(name)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", base)
+		}
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		versionStr, label, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a version number, e.g. 0002_add_table", base)
+		}
+
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// withLock runs fn on a single connection holding the migration lock, after
+// making sure the schema_migrations table exists. SQLite has no advisory
+// locks; a SQLite database is only ever served by a single instance.
+func withLock(ctx context.Context, db *sql.DB, dialect Dialect, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timestampType := "TIMESTAMP WITH TIME ZONE"
+	if dialect == Postgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			// Use a fresh context so the lock is released even if ctx was cancelled.
+			if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+				log.Printf("Error releasing migration lock: %v", err)
+			}
+		}()
+	} else {
+		// The SQLite driver only parses columns declared as TIMESTAMP as times.
+		timestampType = "TIMESTAMP"
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at `+timestampType+` NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// applied returns when each applied version was applied.
+func applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		versions[version] = at
+	}
+	return versions, rows.Err()
+}
+
+// run executes one migration and records the result in a single transaction,
+// so a failed migration leaves nothing behind.
+func run(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	body, record := m.Down, `DELETE FROM schema_migrations WHERE version = $1`
+	if up {
+		body, record = m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	}
+	// Without arguments the statements run over the simple protocol, which
+	// allows several statements in one call.
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	args := []interface{}{m.Version}
+	if up {
+		args = append(args, m.Name)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies all pending migrations in order and returns how many were applied.
+func Up(ctx context.Context, db *sql.DB, dialect Dialect) (int, error) {
+	migrations, err := All(dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			if err := run(ctx, conn, m, true); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them,
+// and returns how many were rolled back.
+func Down(ctx context.Context, db *sql.DB, dialect Dialect, steps int) (int, error) {
+	migrations, err := All(dialect)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+			if err := run(ctx, conn, m, false); err != nil {
+				return fmt.Errorf("rolling back migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			log.Printf("Rolled back migration %d_%s", m.Version, m.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// List returns every embedded migration and when it was applied, if it was.
+func List(ctx context.Context, db *sql.DB, dialect Dialect) ([]Status, error) {
+	migrations, err := All(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	err = withLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			s := Status{Version: m.Version, Name: m.Name}
+			if at, ok := done[m.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
@@ -0,0 +1,113 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"pool-party-api/database"
+	"pool-party-api/database/pgtest"
+	"testing"
+)
+
+// TestUpDownUp applies every migration, rolls them all back and applies them
+// again, so that each down migration is known to undo its up migration.
+func TestUpDownUp(t *testing.T) {
+	dialects := []struct {
+		dialect Dialect
+		open    func(t *testing.T) database.Config
+	}{
+		{SQLite, func(t *testing.T) database.Config {
+			cfg := database.DefaultConfig()
+			cfg.Mode = database.ModeSQLite
+			cfg.SQLitePath = filepath.Join(t.TempDir(), "pool-party.db")
+			return cfg
+		}},
+		{Postgres, func(t *testing.T) database.Config {
+			cfg := database.DefaultConfig()
+			cfg.Mode = database.ModeURL
+			cfg.URL = pgtest.URL(t)
+			return cfg
+		}},
+	}
+	for _, d := range dialects {
+		t.Run(string(d.dialect), func(t *testing.T) {
+			db, err := database.Open(context.Background(), d.open(t))
+			if err != nil {
+				t.Fatalf("database.Open: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			testUpDownUp(t, db, d.dialect)
+		})
+	}
+}
+
+func testUpDownUp(t *testing.T, db *sql.DB, dialect Dialect) {
+	ctx := context.Background()
+	all, err := All(dialect)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+
+	// wantApplied checks that List reports the first n migrations as applied
+	// and the rest as pending.
+	wantApplied := func(n int) {
+		t.Helper()
+		statuses, err := List(ctx, db, dialect)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(statuses) != len(all) {
+			t.Fatalf("List returned %d migrations, want %d", len(statuses), len(all))
+		}
+		for i, s := range statuses {
+			if s.Version != all[i].Version || s.Name != all[i].Name {
+				t.Errorf("List[%d] = %d_%s, want %d_%s", i, s.Version, s.Name, all[i].Version, all[i].Name)
+			}
+			if applied := s.AppliedAt != nil; applied != (i < n) {
+				t.Errorf("migration %d_%s applied = %v, want %v", s.Version, s.Name, applied, i < n)
+			}
+		}
+	}
+	up := func(want int) {
+		t.Helper()
+		n, err := Up(ctx, db, dialect)
+		if err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if n != want {
+			t.Errorf("Up applied %d migrations, want %d", n, want)
+		}
+	}
+	down := func(steps, want int) {
+		t.Helper()
+		n, err := Down(ctx, db, dialect, steps)
+		if err != nil {
+			t.Fatalf("Down(%d): %v", steps, err)
+		}
+		if n != want {
+			t.Errorf("Down(%d) rolled back %d migrations, want %d", steps, n, want)
+		}
+	}
+
+	wantApplied(0)
+	up(len(all))
+	wantApplied(len(all))
+	up(0)
+
+	down(1, 1)
+	wantApplied(len(all) - 1)
+	up(1)
+	wantApplied(len(all))
+
+	down(len(all)+1, len(all))
+	wantApplied(0)
+	var count int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM funding_pool`).Scan(&count)
+	if err == nil {
+		t.Error("funding_pool survived rolling back every migration")
+	}
+
+	up(len(all))
+	wantApplied(len(all))
+	up(0)
+}
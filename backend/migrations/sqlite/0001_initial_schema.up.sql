@@ -0,0 +1,153 @@
+-- The SQLite equivalent of ../0001_initial_schema.up.sql. SQLite databases
+-- are always created fresh, so there is nothing to upgrade in place.
+--
+-- Differences from Postgres: ids are INTEGER PRIMARY KEY AUTOINCREMENT,
+-- JSON columns are TEXT, and times are stored as UTC text in the format the
+-- driver writes ('YYYY-MM-DD HH:MM:SS.SSS+00:00'), so they sort and compare
+-- correctly. Amounts are REAL; the store rounds them to cents.
+
+CREATE TABLE site_instance (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    site_title VARCHAR(255) NOT NULL,
+    site_headline TEXT
+);
+
+-- The API reads the site's configuration from the row with id 1.
+INSERT INTO site_instance (id, site_title) VALUES (1, 'Pool Party');
+
+CREATE TABLE funding_pool (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    goal_amount DECIMAL(15, 2) NOT NULL,
+    image_key VARCHAR(255),
+    thumbnail_key VARCHAR(255),
+    cap_amount DECIMAL(15, 2),
+    overflow_policy VARCHAR(20) NOT NULL DEFAULT 'reject',
+    overflow_pool_id INTEGER REFERENCES funding_pool(id) ON DELETE SET NULL,
+    archived_at TIMESTAMP
+);
+
+CREATE TABLE users (
+    google_id VARCHAR(255) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    first_name VARCHAR(255),
+    last_name VARCHAR(255),
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    donate_only BOOLEAN DEFAULT FALSE NOT NULL,
+    display_name VARCHAR(50),
+    default_anonymous BOOLEAN DEFAULT FALSE NOT NULL,
+    show_full_name BOOLEAN DEFAULT FALSE NOT NULL
+);
+
+CREATE TABLE ledger (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    transaction_id VARCHAR(255),
+    amount DECIMAL(15, 2) NOT NULL,
+    timestamp TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    transaction_type VARCHAR(50) NOT NULL,  -- e.g., 'deposit', 'withdrawal'
+    user_google_id VARCHAR(255) REFERENCES users(google_id) ON DELETE SET NULL,
+    first_name VARCHAR(255),
+    last_initial VARCHAR(1),
+    description TEXT,
+    anonymous BOOLEAN DEFAULT FALSE
+);
+
+CREATE TABLE allocation (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ledger_id INTEGER REFERENCES ledger(id),
+    funding_pool_id INTEGER REFERENCES funding_pool(id),
+    amount DECIMAL(15, 2) NOT NULL
+);
+
+-- Per-pool, per-day totals backing GET /api/funding-pools/{id}/history.
+CREATE TABLE pool_daily_rollup (
+    funding_pool_id INTEGER NOT NULL REFERENCES funding_pool(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    deposits DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    withdrawals DECIMAL(15, 2) NOT NULL DEFAULT 0,
+    PRIMARY KEY (funding_pool_id, day)
+);
+
+-- Append-only history of every change moderators make to a funding pool.
+CREATE TABLE funding_pool_revision (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    funding_pool_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    moderator_google_id VARCHAR(255),
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    changes TEXT NOT NULL
+);
+
+CREATE INDEX idx_funding_pool_revision_pool ON funding_pool_revision (funding_pool_id, id);
+
+CREATE TRIGGER funding_pool_revision_no_update
+BEFORE UPDATE ON funding_pool_revision
+BEGIN
+    SELECT RAISE(ABORT, 'funding_pool_revision is append-only');
+END;
+
+CREATE TRIGGER funding_pool_revision_no_delete
+BEFORE DELETE ON funding_pool_revision
+BEGIN
+    SELECT RAISE(ABORT, 'funding_pool_revision is append-only');
+END;
+
+CREATE TABLE user_identity (
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_google_id VARCHAR(255) NOT NULL REFERENCES users(google_id) ON DELETE CASCADE,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_login_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identity_user ON user_identity (user_google_id);
+
+CREATE TABLE user_role (
+    user_google_id VARCHAR(255) NOT NULL REFERENCES users(google_id) ON DELETE CASCADE,
+    role VARCHAR(32) NOT NULL,
+    granted_by VARCHAR(255),
+    granted_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    PRIMARY KEY (user_google_id, role)
+);
+
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_google_id VARCHAR(255),
+    action VARCHAR(64) NOT NULL,
+    target_google_id VARCHAR(255),
+    details TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE api_token (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_google_id VARCHAR(255) NOT NULL REFERENCES users(google_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    display_prefix VARCHAR(16) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_api_token_user ON api_token (user_google_id);
+
+CREATE TABLE sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    user_google_id VARCHAR(255) REFERENCES users(google_id) ON DELETE CASCADE,
+    data BLOB NOT NULL,
+    created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    last_seen_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+    expires_at TIMESTAMP NOT NULL,
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(45)
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_google_id);
+CREATE INDEX idx_sessions_expires_at ON sessions (expires_at);
@@ -0,0 +1,14 @@
+-- The SQLite equivalent of ../0002_pool_balance.up.sql.
+CREATE TABLE pool_balance (
+    funding_pool_id INTEGER PRIMARY KEY REFERENCES funding_pool(id) ON DELETE CASCADE,
+    balance DECIMAL(15, 2) NOT NULL DEFAULT 0
+);
+
+INSERT INTO pool_balance (funding_pool_id, balance)
+SELECT
+    a.funding_pool_id,
+    ROUND(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END), 2)
+FROM allocation a
+JOIN ledger l ON a.ledger_id = l.id
+WHERE a.funding_pool_id IS NOT NULL
+GROUP BY a.funding_pool_id;
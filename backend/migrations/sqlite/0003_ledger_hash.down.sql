@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS ledger_chain;
+ALTER TABLE ledger DROP COLUMN hash;
+ALTER TABLE ledger DROP COLUMN prev_hash;
@@ -0,0 +1,12 @@
+-- The SQLite equivalent of ../0003_ledger_hash.up.sql.
+ALTER TABLE ledger ADD COLUMN prev_hash CHAR(64);
+ALTER TABLE ledger ADD COLUMN hash CHAR(64);
+
+CREATE TABLE ledger_chain (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    legacy_through INTEGER NOT NULL,
+    sealed BOOLEAN NOT NULL
+);
+
+INSERT INTO ledger_chain (id, legacy_through, sealed)
+SELECT 1, COALESCE(MAX(id), 0), COUNT(*) = 0 FROM ledger;
@@ -0,0 +1,7 @@
+DROP TRIGGER funding_pool_revision_no_update;
+
+CREATE TRIGGER funding_pool_revision_no_update
+BEFORE UPDATE ON funding_pool_revision
+BEGIN
+    SELECT RAISE(ABORT, 'funding_pool_revision is append-only');
+END;
@@ -0,0 +1,14 @@
+-- Deleting an account clears the user's ID from the pool revisions they made.
+-- That is the only change the append-only revision history allows.
+DROP TRIGGER funding_pool_revision_no_update;
+
+CREATE TRIGGER funding_pool_revision_no_update
+BEFORE UPDATE ON funding_pool_revision
+WHEN NOT (
+    OLD.moderator_google_id IS NOT NULL AND NEW.moderator_google_id IS NULL
+    AND NEW.id IS OLD.id AND NEW.funding_pool_id IS OLD.funding_pool_id AND NEW.action IS OLD.action
+    AND NEW.created_at IS OLD.created_at AND NEW.changes IS OLD.changes
+)
+BEGIN
+    SELECT RAISE(ABORT, 'funding_pool_revision is append-only');
+END;
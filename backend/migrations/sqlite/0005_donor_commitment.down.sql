@@ -0,0 +1,3 @@
+ALTER TABLE ledger DROP COLUMN donor_commitment;
+ALTER TABLE ledger DROP COLUMN anonymized_ledger_id;
+ALTER TABLE ledger DROP COLUMN donor_salt;
@@ -0,0 +1,6 @@
+-- The SQLite equivalent of ../0005_donor_commitment.up.sql. SQLite cannot
+-- drop a column that references another table, so anonymized_ledger_id has
+-- no foreign key; ledger verification checks it instead.
+ALTER TABLE ledger ADD COLUMN donor_salt CHAR(64);
+ALTER TABLE ledger ADD COLUMN anonymized_ledger_id INTEGER;
+ALTER TABLE ledger ADD COLUMN donor_commitment CHAR(64);
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// UserIdentity is a login identity linked to a user.
+type UserIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Email       *string   `json:"email,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// AccountExport is everything stored about a user, as returned by the
+// self-service data export.
+type AccountExport struct {
+	ExportedAt    time.Time             `json:"exported_at"`
+	UserID        string                `json:"user_id"`
+	CreatedAt     time.Time             `json:"created_at"`
+	DonateOnly    bool                  `json:"donate_only"`
+	Profile       UserProfile           `json:"profile"`
+	Identities    []UserIdentity        `json:"identities"`
+	Roles         []string              `json:"roles"`
+	APITokens     []APIToken            `json:"api_tokens"`
+	Sessions      []UserSession         `json:"sessions"`
+	LedgerEntries []LedgerEntry         `json:"ledger_entries"`
+	PoolRevisions []FundingPoolRevision `json:"pool_revisions"`
+	AuditLog      []AuditLogEntry       `json:"audit_log"`
+}
+
+// DeleteAccountRequest confirms account deletion by repeating the account's
+// email address.
+type DeleteAccountRequest struct {
+	Email string `json:"email"`
+}
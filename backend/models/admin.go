@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit log actions.
+const (
+	AuditRoleGrant  = "role.grant"
+	AuditRoleRevoke = "role.revoke"
+
+	AuditTokenCreate = "token.create"
+	AuditTokenRevoke = "token.revoke"
+
+	AuditSessionRevoke = "session.revoke"
+
+	AuditUserDelete = "user.delete"
+
+	AuditBalanceReconcile = "pool_balance.reconcile"
+
+	AuditBackupExport = "backup.export"
+
+	AuditSiteUpdate = "site.update"
+)
+
+// AdminUser is a user as listed to administrators, with their granted roles.
+type AdminUser struct {
+	GoogleID   string    `json:"google_id"`
+	Email      string    `json:"email"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	DonateOnly bool      `json:"donate_only"`
+	CreatedAt  time.Time `json:"created_at"`
+	Roles      []string  `json:"roles"`
+}
+
+// GrantRoleRequest is the body of a request to grant a role to a user.
+type GrantRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AuditLogEntry records an administrative change and who made it.
+type AuditLogEntry struct {
+	ID             int             `json:"id"`
+	ActorGoogleID  *string         `json:"actor_google_id,omitempty"`
+	Action         string          `json:"action"`
+	TargetGoogleID *string         `json:"target_google_id,omitempty"`
+	Details        json.RawMessage `json:"details"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// UserSession is an active sign-in session, as listed to administrators.
+type UserSession struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
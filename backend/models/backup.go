@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// BackupFormat and BackupVersion identify an instance backup archive. The
+// version changes whenever the archive's layout does. Version 2 added the
+// ledger's donor commitments.
+const (
+	BackupFormat  = "pool-party-backup"
+	BackupVersion = 2
+)
+
+// BackupArchive is a portable copy of an instance's data: its configuration,
+// pools, users and ledger, with their original IDs and timestamps. Sessions,
+// API tokens, the audit log and pool revisions are not included.
+type BackupArchive struct {
+	Format    string              `json:"format"`
+	Version   int                 `json:"version"`
+	CreatedAt time.Time           `json:"created_at"`
+	Site      SiteInstance        `json:"site"`
+	Pools     []BackupPool        `json:"pools"`
+	Users     []BackupUser        `json:"users"`
+	Ledger    []BackupLedgerEntry `json:"ledger"` // Oldest first.
+}
+
+// BackupPool is a funding pool as archived. CurrentAmount is its balance at
+// the time of the backup, which a restore checks against the ledger.
+type BackupPool struct {
+	FundingPool
+	ImageKey     *string `json:"image_key,omitempty"`
+	ThumbnailKey *string `json:"thumbnail_key,omitempty"`
+}
+
+// BackupLedgerEntry is a ledger entry as archived, with the secret salt of
+// its donor commitment, which its hash depends on.
+type BackupLedgerEntry struct {
+	LedgerEntry
+	DonorSalt string `json:"donor_salt,omitempty"`
+}
+
+// BackupUser is a user as archived, with their profile, login identities and
+// role grants.
+type BackupUser struct {
+	GoogleID         string         `json:"google_id"`
+	Email            string         `json:"email"`
+	FirstName        string         `json:"first_name"`
+	LastName         string         `json:"last_name"`
+	DonateOnly       bool           `json:"donate_only"`
+	CreatedAt        time.Time      `json:"created_at"`
+	DisplayName      *string        `json:"display_name,omitempty"`
+	DefaultAnonymous bool           `json:"default_anonymous"`
+	ShowFullName     bool           `json:"show_full_name"`
+	Identities       []UserIdentity `json:"identities"`
+	Roles            []BackupRole   `json:"roles"`
+}
+
+// BackupRole is a role granted to a user.
+type BackupRole struct {
+	Role      string    `json:"role"`
+	GrantedBy *string   `json:"granted_by,omitempty"`
+	GrantedAt time.Time `json:"granted_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// DonationAllocation is the part of a donation that went to one pool.
+type DonationAllocation struct {
+	FundingPoolID   int     `json:"funding_pool_id"`
+	FundingPoolName string  `json:"funding_pool_name"`
+	Amount          float64 `json:"amount"`
+}
+
+// UserDonation is one of a user's own donations, including anonymous ones.
+type UserDonation struct {
+	ID            int                  `json:"id"`
+	TransactionID string               `json:"transaction_id"`
+	Amount        float64              `json:"amount"`
+	Timestamp     time.Time            `json:"timestamp"`
+	Anonymous     bool                 `json:"anonymous"`
+	Description   *string              `json:"description,omitempty"`
+	Allocations   []DonationAllocation `json:"allocations"`
+}
+
+// PoolDonationTotal is how much a user has given to one pool.
+type PoolDonationTotal struct {
+	FundingPoolID   int     `json:"funding_pool_id"`
+	FundingPoolName string  `json:"funding_pool_name"`
+	Total           float64 `json:"total"`
+}
+
+// YearDonationTotal is how much a user gave in a calendar year (UTC).
+type YearDonationTotal struct {
+	Year  int     `json:"year"`
+	Count int     `json:"count"`
+	Total float64 `json:"total"`
+}
+
+// DonationHistory is a user's giving. Donations, Total and ByPool cover the
+// requested year, or all time if none was given; ByYear always covers all time.
+type DonationHistory struct {
+	Year      *int                `json:"year,omitempty"`
+	Donations []UserDonation      `json:"donations"`
+	Total     float64             `json:"total"`
+	ByPool    []PoolDonationTotal `json:"by_pool"`
+	ByYear    []YearDonationTotal `json:"by_year"`
+}
@@ -1,20 +1,58 @@
 package models
 
+import "time"
+
+// Overflow policies decide what happens to the part of a donation that would
+// push a capped funding pool past its cap.
+const (
+	// OverflowReject refuses any donation that would exceed the cap.
+	OverflowReject = "reject"
+	// OverflowRedirect sends the excess to the pool's designated overflow pool.
+	OverflowRedirect = "redirect"
+	// OverflowSpread spreads the excess across the neediest other pools.
+	OverflowSpread = "spread"
+)
+
 // FundingPool represents the data structure for a funding pool.
 // It includes details about the pool and its funding status, designed to be
 // easily converted to JSON for API responses.
 type FundingPool struct {
-	ID            int     `json:"id"`
-	Name          string  `json:"name"`
-	Description   *string `json:"description,omitempty"` // Use a pointer to handle potential NULL values from the DB.
-	GoalAmount    float64 `json:"goal_amount"`
-	CurrentAmount float64 `json:"current_amount"`
+	ID              int        `json:"id"`
+	Name            string     `json:"name"`
+	Description     *string    `json:"description,omitempty"`      // Use a pointer to handle potential NULL values from the DB.
+	DescriptionHTML *string    `json:"description_html,omitempty"` // Description rendered from Markdown and sanitized.
+	GoalAmount      float64    `json:"goal_amount"`
+	CurrentAmount   float64    `json:"current_amount"`
+	CapAmount       *float64   `json:"cap_amount,omitempty"` // Optional hard limit on the pool's balance.
+	OverflowPolicy  string     `json:"overflow_policy"`
+	OverflowPoolID  *int       `json:"overflow_pool_id,omitempty"`
+	ImageURL        *string    `json:"image_url,omitempty"`
+	ThumbnailURL    *string    `json:"thumbnail_url,omitempty"`
+	ArchivedAt      *time.Time `json:"archived_at,omitempty"` // Archived pools no longer accept donations.
 }
 
 // CreateFundingPoolRequest defines the shape of the request body for creating a
 // new funding pool.
 type CreateFundingPoolRequest struct {
-	Name        string  `json:"name"`
-	Description *string `json:"description"`
-	GoalAmount  float64 `json:"goal_amount"`
+	Name           string   `json:"name"`
+	Description    *string  `json:"description"`
+	GoalAmount     float64  `json:"goal_amount"`
+	CapAmount      *float64 `json:"cap_amount"`
+	OverflowPolicy string   `json:"overflow_policy"`
+	OverflowPoolID *int     `json:"overflow_pool_id"`
+}
+
+// PoolBalanceDrift is a pool whose maintained balance disagrees with the sum
+// of its ledger allocations.
+type PoolBalanceDrift struct {
+	FundingPoolID int     `json:"funding_pool_id"`
+	Stored        float64 `json:"stored"`
+	Ledger        float64 `json:"ledger"`
+}
+
+// PoolBalanceReport is the result of checking or reconciling pool balances.
+type PoolBalanceReport struct {
+	Consistent bool               `json:"consistent"`
+	Reconciled bool               `json:"reconciled"`
+	Drift      []PoolBalanceDrift `json:"drift"`
 }
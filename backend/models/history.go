@@ -0,0 +1,17 @@
+package models
+
+// PoolHistoryPoint is a funding pool's activity during one period, along with
+// its balance at the end of that period.
+type PoolHistoryPoint struct {
+	Period      string  `json:"period"` // First day of the period, as YYYY-MM-DD.
+	Deposits    float64 `json:"deposits"`
+	Withdrawals float64 `json:"withdrawals"`
+	Balance     float64 `json:"balance"`
+}
+
+// PoolHistory is the time series returned by the pool history endpoint.
+type PoolHistory struct {
+	FundingPoolID int                `json:"funding_pool_id"`
+	Granularity   string             `json:"granularity"`
+	Points        []PoolHistoryPoint `json:"points"`
+}
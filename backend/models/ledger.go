@@ -25,4 +25,33 @@ type LedgerEntry struct {
 	Description     *string      `json:"description,omitempty"`
 	Anonymous       bool         `json:"anonymous"`
 	Allocations     []Allocation `json:"allocations"`
+	// PrevHash and Hash chain the entry to the one before it (see package
+	// ledgerchain).
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	// DonorSalt salts the commitment to the entry's donor that its hash
+	// covers. It is kept secret, and erased with the donor.
+	DonorSalt string `json:"-"`
+	// AnonymizedLedgerID and DonorCommitment are set on the "anonymized"
+	// entries recorded when an account is deleted: the entry whose donor was
+	// erased, and the commitment to that donor its hash covers.
+	AnonymizedLedgerID *int    `json:"anonymized_ledger_id,omitempty"`
+	DonorCommitment    *string `json:"donor_commitment,omitempty"`
+}
+
+// LedgerVerification is the result of recomputing the ledger's hash chain.
+type LedgerVerification struct {
+	Valid bool `json:"valid"`
+	// Checked is the number of entries verified before the first broken link.
+	Checked int `json:"checked"`
+	// HeadHash is the hash of the last verified entry. Publishing it lets
+	// anyone notice if entries are later removed from the end.
+	HeadHash string            `json:"head_hash"`
+	Broken   *LedgerBrokenLink `json:"broken,omitempty"`
+}
+
+// LedgerBrokenLink is the first entry whose hash does not check out.
+type LedgerBrokenLink struct {
+	LedgerID int    `json:"ledger_id"`
+	Reason   string `json:"reason"`
 }
@@ -49,3 +49,9 @@ type OrderCaptureResponse struct {
 	Payer         Payer          `json:"payer"`
 	PurchaseUnits []PurchaseUnit `json:"purchase_units"`
 }
+
+// RefundResponse is the response from PayPal after refunding a capture.
+type RefundResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// UserProfile holds how a user appears on the public ledger. FirstName and
+// LastName come from the login provider and cannot be changed here.
+type UserProfile struct {
+	Email            string  `json:"email"`
+	FirstName        string  `json:"first_name"`
+	LastName         string  `json:"last_name"`
+	DisplayName      *string `json:"display_name"`
+	DefaultAnonymous bool    `json:"default_anonymous"`
+	ShowFullName     bool    `json:"show_full_name"`
+	// PublicName previews the name shown on the ledger for non-anonymous donations.
+	PublicName string `json:"public_name"`
+}
+
+// UpdateProfileRequest replaces a user's profile settings. An empty or null
+// DisplayName clears it.
+type UpdateProfileRequest struct {
+	DisplayName      *string `json:"display_name"`
+	DefaultAnonymous bool    `json:"default_anonymous"`
+	ShowFullName     bool    `json:"show_full_name"`
+}
@@ -11,3 +11,23 @@ type WithdrawalRequest struct {
 	Allocations []AllocationRequest `json:"allocations"`
 	Description string              `json:"description"`
 }
+
+// Allocation strategies for donations the server splits across pools on the
+// donor's behalf.
+const (
+	// AllocationStrategyShortfall splits in proportion to each pool's
+	// remaining shortfall against its goal.
+	AllocationStrategyShortfall = "shortfall"
+	// AllocationStrategyLowestPercent fills the pools furthest from their
+	// goal, by percentage, first.
+	AllocationStrategyLowestPercent = "lowest_percent"
+	// AllocationStrategyEven splits evenly across all pools.
+	AllocationStrategyEven = "even"
+)
+
+// AllocationPreview is the server's proposed split of a donation.
+type AllocationPreview struct {
+	Amount      float64             `json:"amount"`
+	Strategy    string              `json:"strategy"`
+	Allocations []AllocationRequest `json:"allocations"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Funding pool revision actions.
+const (
+	RevisionCreate    = "create"
+	RevisionUpdate    = "update"
+	RevisionArchive   = "archive"
+	RevisionUnarchive = "unarchive"
+	RevisionDelete    = "delete"
+)
+
+// FieldChange records the value of a single field before and after a change.
+// Old is null for a newly created pool and New is null for a deleted one.
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// FundingPoolRevision is an entry in a funding pool's append-only edit history.
+type FundingPoolRevision struct {
+	ID            int                    `json:"id"`
+	FundingPoolID int                    `json:"funding_pool_id"`
+	Action        string                 `json:"action"`
+	ModeratorName *string                `json:"moderator_name,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Changes       map[string]FieldChange `json:"changes"`
+}
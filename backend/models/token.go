@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// APIToken is a personal access token as shown to its owner. The token itself
+// is only returned once, when it is created.
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPITokenRequest is the body of a request to create a personal access
+// token. A zero ExpiresInDays creates a token that does not expire.
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPITokenResponse returns a newly created token, including the secret.
+type CreateAPITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
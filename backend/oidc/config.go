@@ -0,0 +1,58 @@
+package oidc
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// validName restricts provider names to values that are safe in URLs and
+// environment variable names.
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// Config describes an OpenID Connect provider registered with the app.
+type Config struct {
+	// Name identifies the provider in URLs and in the user_identity table,
+	// e.g. "okta". It must be lowercase.
+	Name string `yaml:"name"`
+	// DisplayName is shown on the login button, e.g. "Okta".
+	DisplayName string `yaml:"display_name"`
+	// Issuer is the provider's issuer URL. Its discovery document must be
+	// served at Issuer + "/.well-known/openid-configuration".
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is this app's callback URL registered with the provider,
+	// e.g. https://example.com/api/auth/oidc/okta/callback.
+	RedirectURL string `yaml:"redirect_url"`
+	// Scopes requested in addition to "openid".
+	Scopes []string `yaml:"scopes"`
+	// LinkByEmail signs a new identity from this provider in to the existing
+	// account with the same verified email address. Only turn it on for
+	// providers trusted to verify the addresses they report; otherwise
+	// anyone able to register that address with the provider could take
+	// over the account. When it is off, a signed-in user links the identity
+	// by signing in with the provider.
+	LinkByEmail bool `yaml:"link_by_email"`
+}
+
+// SetDefaults fills in the display name and scopes when they are not set.
+func (c *Config) SetDefaults() {
+	if c.DisplayName == "" {
+		c.DisplayName = c.Name
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"email", "profile"}
+	}
+}
+
+// Validate checks the provider's name and that the settings it needs are
+// present.
+func (c Config) Validate() error {
+	if !validName.MatchString(c.Name) {
+		return fmt.Errorf("invalid OIDC provider name %q", c.Name)
+	}
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return fmt.Errorf("OIDC provider %s: issuer, client_id and redirect_url must be set", c.Name)
+	}
+	return nil
+}
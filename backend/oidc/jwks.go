@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+const (
+	// DefaultJWKSCacheTTL is how long fetched signing keys are trusted before
+	// they are fetched again.
+	DefaultJWKSCacheTTL = time.Hour
+
+	// minJWKSRefreshInterval limits how often an unknown key ID can force a
+	// refetch, so forged tokens cannot be used to hammer the provider.
+	minJWKSRefreshInterval = time.Minute
+)
+
+// errUnknownKey is returned when no signing key matches a token's key ID.
+var errUnknownKey = errors.New("no matching signing key")
+
+// KeySet fetches and caches a provider's JSON Web Key Set.
+type KeySet struct {
+	uri    string
+	client *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// refreshing is closed when the fetch in progress completes; it is nil
+	// when no fetch is in progress. fetchErr is the error of the last fetch.
+	refreshing chan struct{}
+	fetchErr   error
+}
+
+// NewKeySet creates a KeySet for the JWKS document at uri.
+func NewKeySet(client *http.Client, uri string, ttl time.Duration) *KeySet {
+	return &KeySet{uri: uri, client: client, ttl: ttl, now: time.Now}
+}
+
+// Key returns the public key with the given key ID. Cached keys are used
+// until the TTL expires; an unknown key ID triggers an early refetch to pick
+// up keys the provider has rotated in. The lock is not held while fetching:
+// concurrent callers wait for a single fetch instead.
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	now := ks.now()
+	key, ok := ks.keys[kid]
+	fresh := now.Sub(ks.fetchedAt) < ks.ttl
+	if ok && fresh {
+		ks.mu.Unlock()
+		return key, nil
+	}
+	if !ok && fresh && now.Sub(ks.fetchedAt) < minJWKSRefreshInterval {
+		ks.mu.Unlock()
+		return nil, errUnknownKey
+	}
+
+	done := ks.refreshing
+	leader := done == nil
+	if leader {
+		done = make(chan struct{})
+		ks.refreshing = done
+	}
+	ks.mu.Unlock()
+
+	if leader {
+		keys, err := ks.fetch(ctx)
+		ks.mu.Lock()
+		if err == nil {
+			ks.keys = keys
+			ks.fetchedAt = now
+		}
+		ks.fetchErr = err
+		ks.refreshing = nil
+		close(done)
+		ks.mu.Unlock()
+	} else {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	// A failed fetch leaves the cached keys in place, so a known key keeps
+	// working while the provider is unreachable.
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	if ks.fetchErr != nil {
+		return nil, ks.fetchErr
+	}
+	return nil, errUnknownKey
+}
+
+// fetch downloads and parses the key set. Keys that cannot be parsed, that
+// are not RSA or ECDSA public keys, or that are meant for encryption rather
+// than signing, are skipped.
+func (ks *KeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+
+	res, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS, status: %s", res.Status)
+	}
+
+	// The keys are decoded one at a time so that a single key of a type
+	// go-jose does not understand does not make the whole set unusable.
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, raw := range doc.Keys {
+		var jwk jose.JSONWebKey
+		if err := jwk.UnmarshalJSON(raw); err != nil {
+			continue
+		}
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys[jwk.KeyID] = jwk.Key
+		}
+	}
+	return keys, nil
+}
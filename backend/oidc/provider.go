@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider performs the authorization code flow with PKCE against a single
+// OpenID Connect provider. Discovery happens on first use, so the app can
+// start while a provider is temporarily unreachable.
+type Provider struct {
+	Config
+
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      *KeySet
+}
+
+// NewProvider creates a Provider. If client is nil, a client with a 10 second
+// timeout is used.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{Config: cfg, client: client, now: time.Now}
+}
+
+// endpoints returns the provider's discovery document and key set, fetching
+// the document if it has not been fetched successfully yet.
+func (p *Provider) endpoints(ctx context.Context) (*Discovery, *KeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery == nil {
+		d, err := Discover(ctx, p.client, p.Issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.discovery = d
+		p.keys = NewKeySet(p.client, d.JWKSURI, DefaultJWKSCacheTTL)
+	}
+	return p.discovery, p.keys, nil
+}
+
+func (p *Provider) oauth2Config(d *Discovery) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  p.RedirectURL,
+		Scopes:       append([]string{"openid"}, p.Scopes...),
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+	}
+}
+
+// AuthRequest is a pending login. State, Nonce and Verifier must be kept in
+// the user's session and handed back to Exchange when the provider redirects
+// to the callback.
+type AuthRequest struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewAuthRequest starts a login, returning the provider URL to redirect the
+// user to along with the secrets needed to complete it.
+func (p *Provider) NewAuthRequest(ctx context.Context) (*AuthRequest, error) {
+	d, _, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	url := p.oauth2Config(d).AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	)
+	return &AuthRequest{URL: url, State: state, Nonce: nonce, Verifier: verifier}, nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token issued with it.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, keys, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth2Config(d).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("token response did not include an ID token")
+	}
+	return verifyIDToken(ctx, keys, rawIDToken, d.Issuer, p.ClientID, nonce, p.now())
+}
+
+// randomString returns 32 bytes of randomness, base64url encoded.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Registry holds the configured providers in the order they were configured.
+type Registry struct {
+	providers []*Provider
+	byName    map[string]*Provider
+}
+
+// NewRegistry creates a Provider for each configuration.
+func NewRegistry(configs []Config, client *http.Client) *Registry {
+	r := &Registry{byName: make(map[string]*Provider)}
+	for _, cfg := range configs {
+		p := NewProvider(cfg, client)
+		r.providers = append(r.providers, p)
+		r.byName[cfg.Name] = p
+	}
+	return r
+}
+
+// Get returns the provider with the given name. It is safe to call on a nil
+// Registry, which has no providers.
+func (r *Registry) Get(name string) (*Provider, bool) {
+	if r == nil {
+		return nil, false
+	}
+	p, ok := r.byName[name]
+	return p, ok
+}
+
+// Providers returns all configured providers.
+func (r *Registry) Providers() []*Provider {
+	if r == nil {
+		return nil
+	}
+	return r.providers
+}
@@ -0,0 +1,232 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pool-party-api/oidc/oidctest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+	server := oidctest.NewServer("pool-party", "secret")
+	t.Cleanup(server.Close)
+
+	p := NewProvider(Config{
+		Name:         "mock",
+		Issuer:       server.Issuer(),
+		ClientID:     "pool-party",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/api/auth/oidc/mock/callback",
+		Scopes:       []string{"email", "profile"},
+	}, server.Client())
+	return p, server
+}
+
+// authorize follows the auth request to the mock provider and returns the
+// code and state it redirects back with.
+func authorize(t *testing.T, server *oidctest.Server, authURL string) (string, string) {
+	t.Helper()
+	client := server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p, server := newTestProvider(t)
+	server.SetUser(oidctest.User{Subject: "abc", Email: "ada@example.com", EmailVerified: true, GivenName: "Ada", FamilyName: "Lovelace"})
+	ctx := context.Background()
+
+	req, err := p.NewAuthRequest(ctx)
+	if err != nil {
+		t.Fatalf("NewAuthRequest: %v", err)
+	}
+	code, state := authorize(t, server, req.URL)
+	if state != req.State {
+		t.Fatalf("state = %q, want %q", state, req.State)
+	}
+
+	claims, err := p.Exchange(ctx, code, req.Verifier, req.Nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "abc" || claims.Email != "ada@example.com" || !claims.EmailVerified || claims.GivenName != "Ada" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	p, server := newTestProvider(t)
+	ctx := context.Background()
+
+	req, err := p.NewAuthRequest(ctx)
+	if err != nil {
+		t.Fatalf("NewAuthRequest: %v", err)
+	}
+	code, _ := authorize(t, server, req.URL)
+
+	if _, err := p.Exchange(ctx, code, "not-the-verifier", req.Nonce); err == nil {
+		t.Fatal("Exchange succeeded with the wrong PKCE verifier")
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	p, server := newTestProvider(t)
+	ctx := context.Background()
+
+	req, err := p.NewAuthRequest(ctx)
+	if err != nil {
+		t.Fatalf("NewAuthRequest: %v", err)
+	}
+	code, _ := authorize(t, server, req.URL)
+
+	if _, err := p.Exchange(ctx, code, req.Verifier, "another-nonce"); err == nil {
+		t.Fatal("Exchange succeeded with a mismatched nonce")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p, server := newTestProvider(t)
+	ctx := context.Background()
+	_, keys, err := p.endpoints(ctx)
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": server.Issuer(),
+			"sub": "abc",
+			"aud": "pool-party",
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(map[string]interface{})
+		token   func(string) string
+		wantErr bool
+	}{
+		{name: "valid", mutate: func(map[string]interface{}) {}},
+		{name: "audience array", mutate: func(c map[string]interface{}) { c["aud"] = []string{"other", "pool-party"} }},
+		{name: "wrong audience", mutate: func(c map[string]interface{}) { c["aud"] = "other" }, wantErr: true},
+		{name: "wrong issuer", mutate: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, wantErr: true},
+		{name: "expired", mutate: func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() }, wantErr: true},
+		{name: "not yet valid", mutate: func(c map[string]interface{}) { c["nbf"] = now.Add(time.Hour).Unix() }, wantErr: true},
+		{name: "missing subject", mutate: func(c map[string]interface{}) { delete(c, "sub") }, wantErr: true},
+		{
+			name:    "tampered payload",
+			mutate:  func(map[string]interface{}) {},
+			token:   func(tok string) string { return tok[:len(tok)-4] + "AAAA" },
+			wantErr: true,
+		},
+		{
+			name:   "unsigned",
+			mutate: func(map[string]interface{}) {},
+			token: func(tok string) string {
+				header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"` + server.KeyID + `"}`))
+				return header + tok[strings.Index(tok, "."):strings.LastIndex(tok, ".")+1]
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.mutate(claims)
+			token := server.Sign(claims)
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+
+			_, err := verifyIDToken(ctx, keys, token, server.Issuer(), "pool-party", "", now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A slow key set fetch must not block lookups of keys that are already
+// cached, and concurrent lookups of a new key share a single fetch.
+func TestKeySetFetchesWithoutLock(t *testing.T) {
+	var mu sync.Mutex
+	fetches := 0
+	release := make(chan struct{})
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		<-release
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer jwks.Close()
+
+	cached, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ks := NewKeySet(jwks.Client(), jwks.URL, time.Hour)
+	ks.now = func() time.Time { return now }
+	ks.keys = map[string]crypto.PublicKey{"cached": &cached.PublicKey}
+	ks.fetchedAt = now.Add(-2 * minJWKSRefreshInterval)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ks.Key(context.Background(), "rotated"); err != errUnknownKey {
+				t.Errorf("Key(rotated) = %v, want errUnknownKey", err)
+			}
+		}()
+	}
+
+	// Wait for the fetch to start, then look up the cached key during it.
+	for {
+		mu.Lock()
+		started := fetches > 0
+		mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if key, err := ks.Key(context.Background(), "cached"); err != nil || key != &cached.PublicKey {
+		t.Errorf("Key(cached) during a fetch = %v, %v", key, err)
+	}
+
+	close(release)
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("fetched the key set %d times, want 1", fetches)
+	}
+}
@@ -3,17 +3,34 @@ package paypal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"pool-party-api/models"
 	"strings"
 	"time"
 )
 
+// Config holds the credentials for the PayPal REST API.
+type Config struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// APIBase is https://api-m.sandbox.paypal.com for the sandbox, or
+	// https://api-m.paypal.com in production.
+	APIBase string `yaml:"api_base"`
+}
+
+// Validate checks that all of the credentials are set.
+func (c Config) Validate() error {
+	if c.ClientID == "" || c.ClientSecret == "" || c.APIBase == "" {
+		return errors.New("paypal.client_id, paypal.client_secret and paypal.api_base (PAYPAL_CLIENT_ID, PAYPAL_CLIENT_SECRET and PAYPAL_API_BASE) must all be set")
+	}
+	return nil
+}
+
 // Client manages communication with the PayPal API.
 type Client struct {
 	ClientID     string
@@ -23,19 +40,15 @@ type Client struct {
 }
 
 // NewClient creates a new PayPal API client.
-func NewClient() (*Client, error) {
-	clientID := os.Getenv("PAYPAL_CLIENT_ID")
-	clientSecret := os.Getenv("PAYPAL_CLIENT_SECRET")
-	baseURL := os.Getenv("PAYPAL_API_BASE")
-
-	if clientID == "" || clientSecret == "" || baseURL == "" {
-		return nil, fmt.Errorf("PAYPAL_CLIENT_ID, PAYPAL_CLIENT_SECRET, and PAYPAL_API_BASE must be set")
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
 	return &Client{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		BaseURL:      baseURL,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		BaseURL:      cfg.APIBase,
 		HTTPClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -110,3 +123,39 @@ func (c *Client) CaptureOrder(ctx context.Context, orderID string, accessToken s
 
 	return &captureResponse, nil
 }
+
+// RefundCapture refunds the full amount of a captured payment.
+func (c *Client) RefundCapture(ctx context.Context, captureID string, accessToken string) (*models.RefundResponse, error) {
+	reqURL := fmt.Sprintf("%s/v2/payments/captures/%s/refund", c.BaseURL, url.PathEscape(captureID))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, strings.NewReader("{}"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refund request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refund capture: %w", err)
+	}
+	defer res.Body.Close()
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refund response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusCreated {
+		log.Printf("PayPal refund failed for capture ID %s. Status: %s, Body: %s", captureID, res.Status, string(bodyBytes))
+		return nil, fmt.Errorf("failed to refund capture, status: %s", res.Status)
+	}
+
+	var refundResponse models.RefundResponse
+	if err := json.Unmarshal(bodyBytes, &refundResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode refund response: %w", err)
+	}
+
+	return &refundResponse, nil
+}
@@ -0,0 +1,195 @@
+// Package paypaltest provides a fake PayPal REST API for tests: the OAuth2
+// token, order capture and capture refund endpoints used by the paypal
+// package.
+package paypaltest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"pool-party-api/models"
+	"strings"
+	"sync"
+)
+
+// Order is an approved order waiting to be captured.
+type Order struct {
+	Amount    string // e.g. "25.00", in USD
+	GivenName string
+	Surname   string
+	Email     string
+	// Declined makes the capture fail as if the payment method was refused.
+	Declined bool
+	// OnCapture, if set, runs as the order is captured, for tests that change
+	// things while a payment is in flight.
+	OnCapture func()
+
+	captured bool
+	refunded bool
+}
+
+// Server is a fake PayPal API. Orders must be added with AddOrder before
+// they can be captured, and each can be captured only once, as with PayPal.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	tokens map[string]bool
+	orders map[string]*Order
+}
+
+// NewServer starts a fake PayPal API that accepts the given client credentials.
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		tokens:       make(map[string]bool),
+		orders:       make(map[string]*Order),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/oauth2/token", s.handleToken)
+	mux.HandleFunc("POST /v2/checkout/orders/{id}/capture", s.handleCapture)
+	mux.HandleFunc("POST /v2/payments/captures/{id}/refund", s.handleRefund)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddOrder approves an order, paid by Grace Hopper unless it names someone
+// else. Its capture ID is "CAPTURE-" followed by the order ID.
+func (s *Server) AddOrder(id string, order Order) {
+	if order.GivenName == "" && order.Surname == "" {
+		order.GivenName, order.Surname = "Grace", "Hopper"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[id] = &order
+}
+
+// Captured reports whether an order has been captured.
+func (s *Server) Captured(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	return ok && order.captured
+}
+
+// Refunded reports whether an order's capture has been refunded.
+func (s *Server) Refunded(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	return ok && order.refunded
+}
+
+// authorized reports whether r carries a token issued by the server. The
+// caller must hold s.mu.
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.tokens[token]
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	token := randomString()
+	s.mu.Lock()
+	s.tokens[token] = true
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, models.AccessTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		AppID:       "APP-TEST",
+		ExpiresIn:   32400,
+	})
+}
+
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "INVALID_TOKEN")
+		return
+	}
+	order, found := s.orders[id]
+	switch {
+	case !found:
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND")
+		return
+	case order.captured:
+		writeError(w, http.StatusUnprocessableEntity, "ORDER_ALREADY_CAPTURED")
+		return
+	case order.Declined:
+		writeError(w, http.StatusUnprocessableEntity, "INSTRUMENT_DECLINED")
+		return
+	}
+	order.captured = true
+	if order.OnCapture != nil {
+		order.OnCapture()
+	}
+
+	resp := models.OrderCaptureResponse{ID: id, Status: "COMPLETED"}
+	resp.Payer.Name.GivenName = order.GivenName
+	resp.Payer.Name.Surname = order.Surname
+	resp.Payer.EmailAddress = order.Email
+	resp.PurchaseUnits = []models.PurchaseUnit{{Payments: models.Payments{Captures: []models.Capture{{
+		ID:     "CAPTURE-" + id,
+		Status: "COMPLETED",
+		Amount: models.CaptureAmount{CurrencyCode: "USD", Value: order.Amount},
+	}}}}}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) handleRefund(w http.ResponseWriter, r *http.Request) {
+	orderID, ok := strings.CutPrefix(r.PathValue("id"), "CAPTURE-")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "INVALID_TOKEN")
+		return
+	}
+	order, found := s.orders[orderID]
+	switch {
+	case !ok || !found || !order.captured:
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND")
+		return
+	case order.refunded:
+		writeError(w, http.StatusUnprocessableEntity, "CAPTURE_FULLY_REFUNDED")
+		return
+	}
+	order.refunded = true
+
+	writeJSON(w, http.StatusCreated, models.RefundResponse{ID: "REFUND-" + orderID, Status: "COMPLETED"})
+}
+
+func writeError(w http.ResponseWriter, status int, name string) {
+	writeJSON(w, status, map[string]string{"name": name})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
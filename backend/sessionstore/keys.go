@@ -0,0 +1,186 @@
+package sessionstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// DefaultGracePeriod is how long a retired key is still accepted. It matches
+// the session cookie lifetime, so no session outlives its key's grace period.
+const DefaultGracePeriod = 30 * 24 * time.Hour
+
+// errKeyRetired is returned by codecs whose key is past its grace period.
+var errKeyRetired = errors.New("sessionstore: key retired")
+
+// Key is a session cookie key pair. HashKey authenticates cookies and must be
+// 32 or 64 bytes. BlockKey, if set, encrypts them and must be 16, 24 or 32
+// bytes (AES-128, AES-192 or AES-256).
+type Key struct {
+	HashKey  []byte
+	BlockKey []byte
+	// RetiredAt is when the key was replaced by a newer one. Zero for the
+	// current key.
+	RetiredAt time.Time
+}
+
+// Keyring holds the keys for session cookies, newest first. New cookies are
+// signed with the first key; retired keys are accepted until GracePeriod has
+// passed since they were retired.
+type Keyring struct {
+	Keys        []Key
+	GracePeriod time.Duration
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// keyFile is the JSON form of a keyring, as stored in KeyConfig's KeysFile
+// or Keys. Keys are base64-encoded.
+type keyFile struct {
+	Keys []struct {
+		HashKey   string     `json:"hash_key"`
+		BlockKey  string     `json:"block_key"`
+		RetiredAt *time.Time `json:"retired_at"`
+	} `json:"keys"`
+}
+
+// ParseKeys parses a JSON keyring:
+//
+//	{"keys": [
+//	  {"hash_key": "<base64>", "block_key": "<base64>"},
+//	  {"hash_key": "<base64>", "block_key": "<base64>", "retired_at": "2026-01-31T00:00:00Z"}
+//	]}
+//
+// The first key is the current one and must not be retired.
+func ParseKeys(data []byte) ([]Key, error) {
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid session keys: %w", err)
+	}
+	if len(f.Keys) == 0 {
+		return nil, errors.New("invalid session keys: no keys")
+	}
+
+	keys := make([]Key, 0, len(f.Keys))
+	for i, k := range f.Keys {
+		hashKey, err := base64.StdEncoding.DecodeString(k.HashKey)
+		if err != nil {
+			return nil, fmt.Errorf("session key %d: invalid hash_key: %w", i, err)
+		}
+		blockKey, err := base64.StdEncoding.DecodeString(k.BlockKey)
+		if err != nil {
+			return nil, fmt.Errorf("session key %d: invalid block_key: %w", i, err)
+		}
+		key := Key{HashKey: hashKey, BlockKey: blockKey}
+		if k.RetiredAt != nil {
+			key.RetiredAt = *k.RetiredAt
+		}
+		if err := key.validate(); err != nil {
+			return nil, fmt.Errorf("session key %d: %w", i, err)
+		}
+		keys = append(keys, key)
+	}
+	if !keys[0].RetiredAt.IsZero() {
+		return nil, errors.New("invalid session keys: the first key is the current key and cannot be retired")
+	}
+	return keys, nil
+}
+
+func (k Key) validate() error {
+	if n := len(k.HashKey); n != 32 && n != 64 {
+		return fmt.Errorf("hash_key must be 32 or 64 bytes, got %d", n)
+	}
+	switch len(k.BlockKey) {
+	case 0, 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("block_key must be 16, 24 or 32 bytes, got %d", len(k.BlockKey))
+	}
+}
+
+// KeyConfig says where to find the session keys.
+type KeyConfig struct {
+	// KeysFile names a JSON keyring file (see ParseKeys), e.g. a mounted
+	// secret.
+	KeysFile string `yaml:"keys_file"`
+	// Keys is a JSON keyring, used when KeysFile is not set.
+	Keys string `yaml:"keys"`
+	// Secret is a single hash key used without encryption, as in earlier
+	// versions, when neither keyring is set.
+	Secret string `yaml:"secret"`
+	// GracePeriod is how long retired keys are accepted.
+	GracePeriod time.Duration `yaml:"key_grace_period"`
+}
+
+// LoadKeyring loads the session keys from the first of cfg's KeysFile, Keys
+// and Secret that is set.
+func LoadKeyring(cfg KeyConfig) (*Keyring, error) {
+	if cfg.GracePeriod < 0 {
+		return nil, fmt.Errorf("invalid session key grace period %v", cfg.GracePeriod)
+	}
+	kr := &Keyring{GracePeriod: cfg.GracePeriod}
+
+	var err error
+	if cfg.KeysFile != "" {
+		data, readErr := os.ReadFile(cfg.KeysFile)
+		if readErr != nil {
+			return nil, fmt.Errorf("reading session keys file: %w", readErr)
+		}
+		kr.Keys, err = ParseKeys(data)
+	} else if cfg.Keys != "" {
+		kr.Keys, err = ParseKeys([]byte(cfg.Keys))
+	} else if cfg.Secret != "" {
+		kr.Keys = []Key{{HashKey: []byte(cfg.Secret)}}
+	} else {
+		return nil, errors.New("no session keys: set session.keys_file, session.keys or session.secret (SESSION_KEYS_FILE, SESSION_KEYS or SESSION_SECRET)")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Codecs returns cookie codecs for the keyring's keys, current key first.
+// Codecs for retired keys only decode, and stop doing so once the grace
+// period is over.
+func (kr *Keyring) Codecs() []securecookie.Codec {
+	now := kr.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	codecs := make([]securecookie.Codec, 0, len(kr.Keys))
+	for _, k := range kr.Keys {
+		sc := securecookie.New(k.HashKey, k.BlockKey)
+		if k.RetiredAt.IsZero() {
+			codecs = append(codecs, sc)
+			continue
+		}
+		codecs = append(codecs, &retiredCodec{SecureCookie: sc, notAfter: k.RetiredAt.Add(kr.GracePeriod), now: now})
+	}
+	return codecs
+}
+
+// retiredCodec accepts cookies signed with a retired key until the end of its
+// grace period, but never signs new ones.
+type retiredCodec struct {
+	*securecookie.SecureCookie
+	notAfter time.Time
+	now      func() time.Time
+}
+
+func (c *retiredCodec) Encode(name string, value interface{}) (string, error) {
+	return "", errKeyRetired
+}
+
+func (c *retiredCodec) Decode(name, value string, dst interface{}) error {
+	if c.now().After(c.notAfter) {
+		return errKeyRetired
+	}
+	return c.SecureCookie.Decode(name, value, dst)
+}
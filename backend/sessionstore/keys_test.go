@@ -0,0 +1,162 @@
+package sessionstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+func newKey() Key {
+	return Key{
+		HashKey:  securecookie.GenerateRandomKey(64),
+		BlockKey: securecookie.GenerateRandomKey(32),
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	oldKey := newKey()
+	before := &Keyring{Keys: []Key{oldKey}, GracePeriod: 24 * time.Hour, Now: clock}
+	oldCookie, err := securecookie.EncodeMulti("pool-party-session", "token-1", before.Codecs()...)
+	if err != nil {
+		t.Fatalf("encoding with the old key: %v", err)
+	}
+
+	// Rotate: a new current key, with the old one retired now.
+	newCurrent := newKey()
+	oldKey.RetiredAt = now
+	after := &Keyring{Keys: []Key{newCurrent, oldKey}, GracePeriod: 24 * time.Hour, Now: clock}
+	codecs := after.Codecs()
+
+	var token string
+	if err := securecookie.DecodeMulti("pool-party-session", oldCookie, &token, codecs...); err != nil || token != "token-1" {
+		t.Fatalf("old cookie during grace period: token %q, err %v", token, err)
+	}
+
+	newCookie, err := securecookie.EncodeMulti("pool-party-session", "token-2", codecs...)
+	if err != nil {
+		t.Fatalf("encoding after rotation: %v", err)
+	}
+	onlyNew := (&Keyring{Keys: []Key{newCurrent}}).Codecs()
+	if err := securecookie.DecodeMulti("pool-party-session", newCookie, &token, onlyNew...); err != nil || token != "token-2" {
+		t.Fatalf("new cookies should be signed with the newest key: token %q, err %v", token, err)
+	}
+	if err := securecookie.DecodeMulti("pool-party-session", newCookie, &token, before.Codecs()...); err == nil {
+		t.Fatal("new cookie decoded with the old key alone")
+	}
+
+	// After the grace period, the old key is no longer accepted.
+	now = now.Add(25 * time.Hour)
+	if err := securecookie.DecodeMulti("pool-party-session", oldCookie, &token, codecs...); err == nil {
+		t.Fatal("old cookie accepted after the grace period")
+	}
+	if err := securecookie.DecodeMulti("pool-party-session", newCookie, &token, codecs...); err != nil {
+		t.Fatalf("new cookie rejected after the grace period: %v", err)
+	}
+}
+
+func encodeKeys(keys ...Key) string {
+	var parts []string
+	for _, k := range keys {
+		entry := fmt.Sprintf(`{"hash_key": %q, "block_key": %q`,
+			base64.StdEncoding.EncodeToString(k.HashKey), base64.StdEncoding.EncodeToString(k.BlockKey))
+		if !k.RetiredAt.IsZero() {
+			entry += fmt.Sprintf(`, "retired_at": %q`, k.RetiredAt.Format(time.RFC3339))
+		}
+		parts = append(parts, entry+"}")
+	}
+	return `{"keys": [` + strings.Join(parts, ", ") + `]}`
+}
+
+func TestParseKeys(t *testing.T) {
+	current, retired := newKey(), newKey()
+	retired.RetiredAt = time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	keys, err := ParseKeys([]byte(encodeKeys(current, retired)))
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[0].HashKey, current.HashKey) || !keys[1].RetiredAt.Equal(retired.RetiredAt) {
+		t.Fatalf("ParseKeys returned %+v", keys)
+	}
+
+	short := Key{HashKey: []byte("too short")}
+	badBlock := Key{HashKey: current.HashKey, BlockKey: []byte("12345")}
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"not JSON", "hunter2"},
+		{"no keys", `{"keys": []}`},
+		{"bad base64", `{"keys": [{"hash_key": "!!"}]}`},
+		{"short hash key", encodeKeys(short)},
+		{"bad block key size", encodeKeys(badBlock)},
+		{"current key retired", encodeKeys(retired, current)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeys([]byte(tt.json)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	current := newKey()
+	path := filepath.Join(t.TempDir(), "session-keys.json")
+	if err := os.WriteFile(path, []byte(encodeKeys(current)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		kr, err := LoadKeyring(KeyConfig{KeysFile: path, Secret: "ignored"})
+		if err != nil {
+			t.Fatalf("LoadKeyring: %v", err)
+		}
+		if len(kr.Keys) != 1 || !bytes.Equal(kr.Keys[0].BlockKey, current.BlockKey) {
+			t.Errorf("keys not loaded from file: %+v", kr.Keys)
+		}
+	})
+
+	t.Run("inline", func(t *testing.T) {
+		kr, err := LoadKeyring(KeyConfig{Keys: encodeKeys(current), GracePeriod: 48 * time.Hour})
+		if err != nil {
+			t.Fatalf("LoadKeyring: %v", err)
+		}
+		if len(kr.Keys) != 1 || kr.GracePeriod != 48*time.Hour {
+			t.Errorf("unexpected keyring %+v", kr)
+		}
+	})
+
+	t.Run("legacy secret", func(t *testing.T) {
+		kr, err := LoadKeyring(KeyConfig{Secret: "a-long-random-string"})
+		if err != nil {
+			t.Fatalf("LoadKeyring: %v", err)
+		}
+		if len(kr.Keys) != 1 || string(kr.Keys[0].HashKey) != "a-long-random-string" || kr.Keys[0].BlockKey != nil {
+			t.Errorf("unexpected keyring %+v", kr)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := LoadKeyring(KeyConfig{}); err == nil {
+			t.Error("expected an error without any keys")
+		}
+	})
+
+	t.Run("negative grace period", func(t *testing.T) {
+		if _, err := LoadKeyring(KeyConfig{Secret: "a-long-random-string", GracePeriod: -time.Hour}); err == nil {
+			t.Error("expected an error for a negative grace period")
+		}
+	})
+}
@@ -0,0 +1,251 @@
+// Package sessionstore provides a gorilla/sessions store that keeps session
+// data in the database (Postgres or SQLite), so sessions can be listed and
+// revoked on the server.
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/gob"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// DefaultIdleTimeout is how long a session may go unused before it expires.
+const DefaultIdleTimeout = 7 * 24 * time.Hour
+
+// PGStore stores session data in the sessions table. The cookie only carries
+// the session's random token, signed with the store's codecs, so deleting a
+// row logs that browser out immediately.
+//
+// A session expires when its Options.MaxAge has passed since it was last
+// saved, or when it has not been used for IdleTimeout.
+type PGStore struct {
+	DB          *sql.DB
+	Codecs      []securecookie.Codec
+	Options     *sessions.Options // default configuration
+	IdleTimeout time.Duration
+
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+}
+
+// New returns a PGStore using the given cookie options. codecs sign, and
+// optionally encrypt, the session cookie; see Keyring.Codecs.
+func New(db *sql.DB, options *sessions.Options, codecs []securecookie.Codec) *PGStore {
+	opts := *options
+	s := &PGStore{
+		DB:          db,
+		Codecs:      codecs,
+		Options:     &opts,
+		IdleTimeout: DefaultIdleTimeout,
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// MaxAge sets the maximum age of sessions and of their signed cookies.
+func (s *PGStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(interface {
+			MaxAge(int) *securecookie.SecureCookie
+		}); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *PGStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session named by the request's cookie, or a new session if
+// there is none or it has expired or been revoked.
+func (s *PGStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, c.Value, &token, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	found, err := s.load(r.Context(), token, session)
+	if err != nil {
+		return session, err
+	}
+	if found {
+		session.ID = token
+		session.IsNew = false
+	}
+	return session, nil
+}
+
+// load reads an active session's values and marks it as used. It reports
+// false if the session does not exist, has expired or has been idle too long.
+func (s *PGStore) load(ctx context.Context, token string, session *sessions.Session) (bool, error) {
+	var data []byte
+	now := s.now()
+	query := `
+		UPDATE sessions SET last_seen_at = $2
+		WHERE token = $1 AND expires_at > $2
+		AND last_seen_at > $3
+		RETURNING data`
+	err := s.DB.QueryRowContext(ctx, query, token, now, now.Add(-s.IdleTimeout)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, gob.NewDecoder(bytes.NewReader(data)).Decode(&session.Values)
+}
+
+// Save writes the session to the database and sets its cookie. A session
+// with a negative MaxAge is deleted.
+func (s *PGStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE token = $1`, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		session.ID = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	var userID sql.NullString
+	if id, ok := session.Values["google_id"].(string); ok && id != "" {
+		userID = sql.NullString{String: id, Valid: true}
+	}
+
+	now := s.now()
+	query := `
+		INSERT INTO sessions (token, user_google_id, data, created_at, last_seen_at, expires_at, user_agent, ip_address)
+		VALUES ($1, $2, $3, $4, $4, $5, $6, $7)
+		ON CONFLICT (token) DO UPDATE
+		SET user_google_id = EXCLUDED.user_google_id, data = EXCLUDED.data,
+			expires_at = EXCLUDED.expires_at, last_seen_at = EXCLUDED.last_seen_at`
+	_, err := s.DB.ExecContext(r.Context(), query, session.ID, userID, data.Bytes(),
+		now, now.Add(time.Duration(session.Options.MaxAge)*time.Second), truncate(r.UserAgent(), 255), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Renew deletes the session's row and clears its ID, so that the next Save
+// stores the session's values under a new token. Call it before signing a
+// user in, so that a token planted in the browser beforehand, e.g. by an
+// attacker, is not signed in with them.
+func (s *PGStore) Renew(r *http.Request, session *sessions.Session) error {
+	if session.ID != "" {
+		if _, err := s.DB.ExecContext(r.Context(), `DELETE FROM sessions WHERE token = $1`, session.ID); err != nil {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// DeleteExpired removes sessions that have expired or been idle too long.
+func (s *PGStore) DeleteExpired(ctx context.Context) (int64, error) {
+	now := s.now()
+	query := `
+		DELETE FROM sessions
+		WHERE expires_at <= $1
+		OR last_seen_at <= $2`
+	res, err := s.DB.ExecContext(ctx, query, now, now.Add(-s.IdleTimeout))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// now returns the current time in UTC. Times are passed to the database
+// rather than computed by it, so the same queries work on Postgres and SQLite.
+func (s *PGStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// StartCleanup deletes expired sessions every interval until ctx is done.
+func (s *PGStore) StartCleanup(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := s.DeleteExpired(ctx)
+				if err != nil {
+					log.Printf("Error deleting expired sessions: %v", err)
+				} else if n > 0 {
+					log.Printf("Deleted %d expired sessions", n)
+				}
+			}
+		}
+	}()
+}
+
+// clientIP returns the address of the client, preferring the first address
+// in X-Forwarded-For as set by Cloud Run's load balancer.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		return truncate(strings.TrimSpace(first), 45)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return truncate(r.RemoteAddr, 45)
+	}
+	return host
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+package sessionstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"pool-party-api/database"
+	"pool-party-api/migrations"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// newSQLiteStore returns a store backed by a fresh SQLite database, with a
+// clock the test controls.
+func newSQLiteStore(t *testing.T, now *time.Time) *PGStore {
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeSQLite
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "sessions.db")
+	db, err := database.Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("database.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(context.Background(), db, migrations.SQLite); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (google_id, email) VALUES ('g1', 'ada@example.com')`); err != nil {
+		t.Fatalf("adding a user: %v", err)
+	}
+
+	s := New(db, &sessions.Options{Path: "/", MaxAge: 3600}, (&Keyring{Keys: []Key{newKey()}}).Codecs())
+	s.IdleTimeout = 30 * time.Minute
+	s.Now = func() time.Time { return *now }
+	return s
+}
+
+// roundTrip saves a new session and returns its cookie.
+func roundTrip(t *testing.T, s *PGStore) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := s.New(r, "pool-party-session")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	session.Values["google_id"] = "g1"
+	rec := httptest.NewRecorder()
+	if err := s.Save(r, rec, session); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+// load reads the session named by cookie, reporting whether it is active.
+func load(t *testing.T, s *PGStore, cookie *http.Cookie) bool {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	session, err := s.New(r, "pool-party-session")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if !session.IsNew && session.Values["google_id"] != "g1" {
+		t.Errorf("session values = %v", session.Values)
+	}
+	return !session.IsNew
+}
+
+func TestSessionExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newSQLiteStore(t, &now)
+
+	cookie := roundTrip(t, s)
+	if !load(t, s, cookie) {
+		t.Fatal("new session not found")
+	}
+
+	// Each use pushes back the idle timeout, up to the session's MaxAge.
+	for i := 0; i < 2; i++ {
+		now = now.Add(20 * time.Minute)
+		if !load(t, s, cookie) {
+			t.Fatalf("session expired after %d uses", i+1)
+		}
+	}
+	now = now.Add(20 * time.Minute)
+	if load(t, s, cookie) {
+		t.Error("session still active after MaxAge")
+	}
+
+	idle := roundTrip(t, s)
+	now = now.Add(31 * time.Minute)
+	if load(t, s, idle) {
+		t.Error("session still active after the idle timeout")
+	}
+
+	active := roundTrip(t, s)
+	n, err := s.DeleteExpired(context.Background())
+	if err != nil || n != 2 {
+		t.Errorf("DeleteExpired = %d, %v; want 2", n, err)
+	}
+	if !load(t, s, active) {
+		t.Error("DeleteExpired removed an active session")
+	}
+}
+
+func TestRenew(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := newSQLiteStore(t, &now)
+	old := roundTrip(t, s)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(old)
+	session, err := s.New(r, "pool-party-session")
+	if err != nil || session.IsNew {
+		t.Fatalf("New = %v, new %v; want the saved session", err, session.IsNew)
+	}
+	if err := s.Renew(r, session); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if err := s.Save(r, rec, session); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	renewed := rec.Result().Cookies()[0]
+
+	if renewed.Value == old.Value {
+		t.Error("Renew kept the session's token")
+	}
+	if load(t, s, old) {
+		t.Error("the old token is still active after Renew")
+	}
+	if !load(t, s, renewed) {
+		t.Error("the renewed session was not saved")
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LocalStorage is a Storage backed by a directory on the local disk.
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage creates a LocalStorage rooted at dir, creating the
+// directory if it does not already exist.
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %s: %w", dir, err)
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+// Put writes the object to a temporary file first and renames it into place,
+// so readers never observe a partially written object.
+func (s *LocalStorage) Put(_ context.Context, key string, r io.Reader) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded.
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write object %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close object %s: %w", key, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(s.Dir, key)); err != nil {
+		return fmt.Errorf("failed to store object %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get opens the object stored under key.
+func (s *LocalStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.Dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// Delete removes the object stored under key.
+func (s *LocalStorage) Delete(_ context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
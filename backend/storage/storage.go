@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned when an object key contains characters that are
+// not allowed, such as path separators.
+var ErrInvalidKey = errors.New("storage: invalid object key")
+
+// validKey restricts keys to a flat namespace so they can never escape the
+// storage root or be interpreted as a path by an implementation.
+var validKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Storage persists binary objects, such as uploaded funding pool images.
+// Implementations must be safe for concurrent use.
+type Storage interface {
+	// Put stores the contents of r under the given key, replacing any
+	// existing object with the same key.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the object stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a missing
+	// object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidateKey reports whether key is acceptable for use with a Storage.
+func ValidateKey(key string) error {
+	if len(key) > 255 || !validKey.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{"cover-1.jpg", nil},
+		{"a", nil},
+		{"pool_42.thumb.png", nil},
+		{strings.Repeat("a", 255), nil},
+		{"", ErrInvalidKey},
+		{strings.Repeat("a", 256), ErrInvalidKey},
+		{"..", ErrInvalidKey},
+		{".hidden", ErrInvalidKey},
+		{"../etc/passwd", ErrInvalidKey},
+		{"/etc/passwd", ErrInvalidKey},
+		{"dir/file.png", ErrInvalidKey},
+		{`dir\file.png`, ErrInvalidKey},
+		{"C:file.png", ErrInvalidKey},
+		{"file.png\x00", ErrInvalidKey},
+		{"file name.png", ErrInvalidKey},
+	}
+	for _, tt := range tests {
+		if err := ValidateKey(tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("ValidateKey(%q) = %v, want %v", tt.key, err, tt.want)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+
+	if err := s.Put(ctx, "../escape.png", strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put outside the root = %v, want ErrInvalidKey", err)
+	}
+	if _, err := s.Get(ctx, "missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Put(ctx, "cover.png", strings.NewReader("image")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, err := s.Get(ctx, "cover.png")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("reading object: %v", err)
+	}
+	if string(got) != "image" {
+		t.Errorf("Get = %q, want %q", got, "image")
+	}
+
+	if err := s.Delete(ctx, "cover.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete(ctx, "cover.png"); err != nil {
+		t.Errorf("Delete missing = %v, want nil", err)
+	}
+	if _, err := s.Get(ctx, "cover.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
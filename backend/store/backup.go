@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"pool-party-api/models"
+	"time"
+)
+
+func isEmpty(ctx context.Context, q dbtx) (bool, error) {
+	var empty bool
+	err := q.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM users)
+			AND NOT EXISTS (SELECT 1 FROM funding_pool)
+			AND NOT EXISTS (SELECT 1 FROM ledger)`).Scan(&empty)
+	return empty, err
+}
+
+func backupUsers(ctx context.Context, q dbtx) ([]models.BackupUser, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT google_id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), donate_only, created_at,
+			display_name, default_anonymous, show_full_name
+		FROM users
+		ORDER BY google_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]models.BackupUser, 0)
+	for rows.Next() {
+		var u models.BackupUser
+		var displayName sql.NullString
+		if err := rows.Scan(&u.GoogleID, &u.Email, &u.FirstName, &u.LastName, &u.DonateOnly, &u.CreatedAt,
+			&displayName, &u.DefaultAnonymous, &u.ShowFullName); err != nil {
+			return nil, err
+		}
+		if displayName.Valid {
+			u.DisplayName = &displayName.String
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Identities and roles are read once all users are, since a
+	// transaction's connection runs one query at a time.
+	for i := range users {
+		if users[i].Identities, err = userIdentities(ctx, q, users[i].GoogleID); err != nil {
+			return nil, err
+		}
+		if users[i].Roles, err = roleGrants(ctx, q, users[i].GoogleID); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// roleGrants returns the roles granted to a user with who granted them and
+// when, by role.
+func roleGrants(ctx context.Context, q dbtx, googleID string) ([]models.BackupRole, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT role, granted_by, granted_at
+		FROM user_role
+		WHERE user_google_id = $1
+		ORDER BY role`, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]models.BackupRole, 0)
+	for rows.Next() {
+		var r models.BackupRole
+		var grantedBy sql.NullString
+		if err := rows.Scan(&r.Role, &grantedBy, &r.GrantedAt); err != nil {
+			return nil, err
+		}
+		if grantedBy.Valid {
+			r.GrantedBy = &grantedBy.String
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// restore inserts an archive's rows with their IDs and timestamps, then runs
+// the dialect's statements that rebuild derived tables and sequences. Times
+// are written in UTC, as SQLite compares them as text.
+func restore(ctx context.Context, q dbtx, a *models.BackupArchive, rebuild []string) error {
+	if _, err := q.ExecContext(ctx, `UPDATE site_instance SET site_title = $1, site_headline = $2 WHERE id = 1`, a.Site.SiteTitle, a.Site.SiteHeadline); err != nil {
+		return err
+	}
+
+	for _, p := range a.Pools {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO funding_pool (id, name, description, goal_amount, cap_amount, overflow_policy, image_key, thumbnail_key, archived_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			p.ID, p.Name, p.Description, roundCents(p.GoalAmount), roundCentsPtr(p.CapAmount), p.OverflowPolicy, p.ImageKey, p.ThumbnailKey, utcPtr(p.ArchivedAt))
+		if err != nil {
+			return err
+		}
+	}
+	// A pool's overflow pool may come after it, so they are linked once all exist.
+	for _, p := range a.Pools {
+		if p.OverflowPoolID != nil {
+			if _, err := q.ExecContext(ctx, `UPDATE funding_pool SET overflow_pool_id = $1 WHERE id = $2`, *p.OverflowPoolID, p.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, u := range a.Users {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO users (google_id, email, first_name, last_name, donate_only, created_at, display_name, default_anonymous, show_full_name)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			u.GoogleID, u.Email, u.FirstName, u.LastName, u.DonateOnly, u.CreatedAt.UTC(), u.DisplayName, u.DefaultAnonymous, u.ShowFullName)
+		if err != nil {
+			return err
+		}
+		for _, i := range u.Identities {
+			_, err := q.ExecContext(ctx, `
+				INSERT INTO user_identity (provider, subject, user_google_id, email, created_at, last_login_at)
+				VALUES ($1, $2, $3, $4, $5, $6)`,
+				i.Provider, i.Subject, u.GoogleID, i.Email, i.CreatedAt.UTC(), i.LastLoginAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+		for _, r := range u.Roles {
+			_, err := q.ExecContext(ctx, `
+				INSERT INTO user_role (user_google_id, role, granted_by, granted_at)
+				VALUES ($1, $2, $3, $4)`,
+				u.GoogleID, r.Role, r.GrantedBy, r.GrantedAt.UTC())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range a.Ledger {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO ledger (id, transaction_id, amount, timestamp, transaction_type, user_google_id, first_name, last_initial, description, anonymous, prev_hash, hash,
+				donor_salt, anonymized_ledger_id, donor_commitment)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+			e.ID, e.TransactionID, roundCents(e.Amount), e.Timestamp.UTC(), e.TransactionType, e.UserGoogleID, e.FirstName, e.LastInitial,
+			e.Description, e.Anonymous, nullString(e.PrevHash), nullString(e.Hash),
+			nullString(e.DonorSalt), e.AnonymizedLedgerID, e.DonorCommitment)
+		if err != nil {
+			return err
+		}
+		for _, alloc := range e.Allocations {
+			_, err := q.ExecContext(ctx, `INSERT INTO allocation (id, ledger_id, funding_pool_id, amount) VALUES ($1, $2, $3, $4)`,
+				alloc.ID, e.ID, alloc.FundingPoolID, roundCents(alloc.Amount))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, stmt := range rebuild {
+		if _, err := q.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
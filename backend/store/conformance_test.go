@@ -0,0 +1,1051 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"pool-party-api/auth"
+	"pool-party-api/database"
+	"pool-party-api/database/pgtest"
+	"pool-party-api/ledgerchain"
+	"pool-party-api/migrations"
+	"pool-party-api/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The conformance tests run against every Store implementation, so that the
+// in-memory fake used by the handler tests, SQLite and Postgres agree on
+// balances, ordering and errors. Postgres comes from package pgtest, and
+// each test runs in a schema of its own.
+
+type storeImpl struct {
+	name string
+	// start prepares the implementation for the tests run under t, and
+	// returns a function that opens an empty store for each of them.
+	start func(t *testing.T) func(t *testing.T) Store
+}
+
+var storeImpls = []storeImpl{
+	{"Memory", func(*testing.T) func(*testing.T) Store {
+		return func(*testing.T) Store { return NewMemory() }
+	}},
+	{"SQLite", func(*testing.T) func(*testing.T) Store { return openSQLite }},
+	{"Postgres", startPostgres},
+}
+
+func openSQLite(t *testing.T) Store {
+	cfg := database.DefaultConfig()
+	cfg.Mode = database.ModeSQLite
+	cfg.SQLitePath = filepath.Join(t.TempDir(), "pool-party.db")
+	db := openDB(t, cfg, migrations.SQLite)
+	return NewSQLite(db)
+}
+
+// startPostgres starts a Postgres server for the tests, if TEST_DATABASE_URL
+// does not name one, and opens each store in a new schema on it.
+func startPostgres(t *testing.T) func(t *testing.T) Store {
+	server := pgtest.Server(t)
+	return func(t *testing.T) Store {
+		cfg := database.DefaultConfig()
+		cfg.Mode = database.ModeURL
+		cfg.URL = pgtest.Schema(t, server)
+		return NewPostgres(openDB(t, cfg, migrations.Postgres))
+	}
+}
+
+func openDB(t *testing.T, cfg database.Config, dialect migrations.Dialect) *sql.DB {
+	t.Helper()
+	db, err := database.Open(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("database.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrations.Up(context.Background(), db, dialect); err != nil {
+		t.Fatalf("migrations.Up: %v", err)
+	}
+	return db
+}
+
+var conformanceTests = []struct {
+	name string
+	fn   func(t *testing.T, s Store)
+}{
+	{"Pools", testPools},
+	{"Balances", testBalances},
+	{"BalanceDrift", testBalanceDrift},
+	{"HashChain", testHashChain},
+	{"Withdrawals", testWithdrawals},
+	{"Transactions", testTransactions},
+	{"Revisions", testRevisions},
+	{"Users", testUsers},
+	{"Donations", testDonations},
+	{"DeleteUser", testDeleteUser},
+	{"Tokens", testTokens},
+	{"AuditLog", testAuditLog},
+	{"Site", testSite},
+	{"Backup", testBackup},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, impl := range storeImpls {
+		t.Run(impl.name, func(t *testing.T) {
+			open := impl.start(t)
+			for _, c := range conformanceTests {
+				t.Run(c.name, func(t *testing.T) {
+					c.fn(t, open(t))
+				})
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func createPool(t *testing.T, s Store, name string, goal float64) int {
+	t.Helper()
+	id, err := s.CreatePool(context.Background(), &models.CreateFundingPoolRequest{
+		Name: name, GoalAmount: goal, OverflowPolicy: models.OverflowReject,
+	})
+	if err != nil {
+		t.Fatalf("CreatePool: %v", err)
+	}
+	return id
+}
+
+func createUser(t *testing.T, s Store, googleID, email string) {
+	t.Helper()
+	if _, err := s.UpsertUser(context.Background(), User{GoogleID: googleID, Email: email, FirstName: "Ada", LastName: "Lovelace"}); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+}
+
+func record(t *testing.T, s Store, txType string, user string, allocs ...models.AllocationRequest) int {
+	t.Helper()
+	var total float64
+	for _, a := range allocs {
+		total += a.Amount
+	}
+	data := LedgerEntryData{Amount: total, TransactionType: txType, Allocations: allocs}
+	if user != "" {
+		data.UserGoogleID = sql.NullString{String: user, Valid: true}
+		data.FirstName = sql.NullString{String: "Ada", Valid: true}
+		data.LastInitial = sql.NullString{String: "L", Valid: true}
+		if txType == "deposit" {
+			data.TransactionID = sql.NullString{String: "PAY-" + time.Now().Format("150405.000000000"), Valid: true}
+		}
+	}
+	id, err := s.CreateLedgerEntry(context.Background(), data)
+	if err != nil {
+		t.Fatalf("CreateLedgerEntry: %v", err)
+	}
+	return id
+}
+
+func alloc(poolID int, amount float64) models.AllocationRequest {
+	return models.AllocationRequest{FundingPoolID: poolID, Amount: amount}
+}
+
+func wantBalance(t *testing.T, s Store, poolID int, want float64) {
+	t.Helper()
+	ctx := context.Background()
+	if got, err := s.PoolBalance(ctx, poolID); err != nil || got != want {
+		t.Errorf("PoolBalance(%d) = %v, %v; want %v", poolID, got, err, want)
+	}
+	if p, err := s.GetPool(ctx, poolID); err != nil || p.CurrentAmount != want {
+		t.Errorf("GetPool(%d).CurrentAmount = %v, %v; want %v", poolID, p, err, want)
+	}
+}
+
+func testPools(t *testing.T, s Store) {
+	ctx := context.Background()
+	a := createPool(t, s, "Books", 100)
+	b := createPool(t, s, "Snacks", 50.5)
+
+	p, err := s.GetPool(ctx, b)
+	if err != nil {
+		t.Fatalf("GetPool: %v", err)
+	}
+	if p.Name != "Snacks" || p.GoalAmount != 50.5 || p.CurrentAmount != 0 || p.ArchivedAt != nil {
+		t.Errorf("GetPool = %+v", p.FundingPool)
+	}
+	if ok, err := s.PoolExists(ctx, a); err != nil || !ok {
+		t.Errorf("PoolExists(%d) = %v, %v", a, ok, err)
+	}
+	if ok, err := s.PoolExists(ctx, 999); err != nil || ok {
+		t.Errorf("PoolExists(999) = %v, %v", ok, err)
+	}
+	if _, err := s.GetPool(ctx, 999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPool(999) error = %v, want ErrNotFound", err)
+	}
+
+	err = s.UpdatePool(ctx, a, &models.CreateFundingPoolRequest{
+		Name: "Library", Description: ptr("Shelves"), GoalAmount: 120, CapAmount: ptr(150.0),
+		OverflowPolicy: models.OverflowRedirect, OverflowPoolID: &b,
+	})
+	if err != nil {
+		t.Fatalf("UpdatePool: %v", err)
+	}
+	p, err = s.LockPool(ctx, a)
+	if err != nil {
+		t.Fatalf("LockPool: %v", err)
+	}
+	if p.Name != "Library" || *p.Description != "Shelves" || *p.CapAmount != 150 || *p.OverflowPoolID != b {
+		t.Errorf("LockPool after update = %+v", p.FundingPool)
+	}
+	if err := s.UpdatePool(ctx, 999, &models.CreateFundingPoolRequest{Name: "x", OverflowPolicy: models.OverflowReject}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdatePool(999) error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.SetPoolArchived(ctx, b, true); err != nil {
+		t.Fatalf("SetPoolArchived: %v", err)
+	}
+	if pools, _ := s.ListPools(ctx, false); len(pools) != 1 || pools[0].ID != a {
+		t.Errorf("ListPools(false) = %+v, want only pool %d", pools, a)
+	}
+	pools, err := s.ListPools(ctx, true)
+	if err != nil || len(pools) != 2 || pools[0].ID != a || pools[1].ArchivedAt == nil {
+		t.Errorf("ListPools(true) = %+v, %v", pools, err)
+	}
+	if err := s.SetPoolArchived(ctx, b, false); err != nil {
+		t.Fatalf("SetPoolArchived: %v", err)
+	}
+	if p, _ := s.GetPool(ctx, b); p.ArchivedAt != nil {
+		t.Errorf("unarchived pool has ArchivedAt %v", p.ArchivedAt)
+	}
+
+	if err := s.SetPoolImage(ctx, a, ptr("cover.jpg"), ptr("thumb.jpg")); err != nil {
+		t.Fatalf("SetPoolImage: %v", err)
+	}
+	if p, _ := s.GetPool(ctx, a); p.ImageKey == nil || *p.ImageKey != "cover.jpg" || *p.ThumbnailKey != "thumb.jpg" {
+		t.Errorf("pool image keys = %v, %v", p.ImageKey, p.ThumbnailKey)
+	}
+
+	if err := s.DeletePool(ctx, b); err != nil {
+		t.Fatalf("DeletePool: %v", err)
+	}
+	if err := s.DeletePool(ctx, b); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeletePool error = %v, want ErrNotFound", err)
+	}
+}
+
+func testBalances(t *testing.T, s Store) {
+	ctx := context.Background()
+	a := createPool(t, s, "Books", 100)
+	b := createPool(t, s, "Snacks", 50)
+
+	// Amounts that are not exact in binary floating point must still add up
+	// to whole cents.
+	for i := 0; i < 10; i++ {
+		record(t, s, "deposit", "", alloc(a, 0.1))
+	}
+	record(t, s, "deposit", "", alloc(a, 10.10), alloc(b, 20.20))
+	record(t, s, "deposit", "", alloc(a, 5), alloc(b, 0))
+	record(t, s, "withdrawal", "", alloc(b, 0.3))
+
+	wantBalance(t, s, a, 16.1)
+	wantBalance(t, s, b, 19.9)
+
+	deposits, withdrawals, err := s.LedgerTotals(ctx)
+	if err != nil || deposits != 36.3 || withdrawals != 0.3 {
+		t.Errorf("LedgerTotals = %v, %v, %v; want 36.3, 0.3", deposits, withdrawals, err)
+	}
+
+	pools, err := s.ListPools(ctx, false)
+	if err != nil || len(pools) != 2 || pools[0].CurrentAmount != 16.1 || pools[1].CurrentAmount != 19.9 {
+		t.Errorf("ListPools = %+v, %v", pools, err)
+	}
+
+	entries, err := s.ListLedgerEntries(ctx)
+	if err != nil {
+		t.Fatalf("ListLedgerEntries: %v", err)
+	}
+	if len(entries) != 13 {
+		t.Fatalf("got %d ledger entries, want 13", len(entries))
+	}
+	latest := entries[0]
+	if latest.TransactionType != "withdrawal" || latest.Amount != 0.3 || len(latest.Allocations) != 1 {
+		t.Errorf("newest entry = %+v", latest)
+	}
+	// The zero allocation to b is not stored.
+	if got := entries[1].Allocations; len(got) != 1 || got[0].FundingPoolID != a || got[0].Amount != 5 {
+		t.Errorf("allocations = %+v, want only %d", got, a)
+	}
+
+	totals, err := s.PoolDailyTotals(ctx, b)
+	if err != nil || len(totals) != 1 {
+		t.Fatalf("PoolDailyTotals = %+v, %v", totals, err)
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if got := totals[0]; !got.Day.Equal(today) || got.Deposits != 20.2 || got.Withdrawals != 0.3 {
+		t.Errorf("PoolDailyTotals = %+v, want %v 20.2 in, 0.3 out", got, today)
+	}
+
+	if ok, err := s.PoolHasAllocations(ctx, b); err != nil || !ok {
+		t.Errorf("PoolHasAllocations = %v, %v", ok, err)
+	}
+	c := createPool(t, s, "Empty", 10)
+	if ok, err := s.PoolHasAllocations(ctx, c); err != nil || ok {
+		t.Errorf("PoolHasAllocations(empty) = %v, %v", ok, err)
+	}
+}
+
+// withdraw makes the same check as the withdrawal handler: every pool must
+// hold at least the amount taken from it.
+func withdraw(ctx context.Context, s Store, allocs ...models.AllocationRequest) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		for _, a := range allocs {
+			if _, err := tx.LockPool(ctx, a.FundingPoolID); err != nil {
+				return err
+			}
+			balance, err := tx.PoolBalance(ctx, a.FundingPoolID)
+			if err != nil {
+				return err
+			}
+			if a.Amount > balance {
+				return errInsufficient
+			}
+		}
+		var total float64
+		for _, a := range allocs {
+			total += a.Amount
+		}
+		_, err := tx.CreateLedgerEntry(ctx, LedgerEntryData{Amount: total, TransactionType: "withdrawal", Allocations: allocs})
+		return err
+	})
+}
+
+var errInsufficient = errors.New("insufficient balance")
+
+// setStoredBalance overwrites a pool's maintained balance behind the store's
+// back.
+func setStoredBalance(t *testing.T, s Store, poolID int, balance float64) {
+	t.Helper()
+	var q dbtx
+	switch s := s.(type) {
+	case *Memory:
+		s.SetStoredBalance(poolID, balance)
+		return
+	case *SQLite:
+		q = s.q
+	case *Postgres:
+		q = s.q
+	}
+	if _, err := q.ExecContext(context.Background(), `UPDATE pool_balance SET balance = $1 WHERE funding_pool_id = $2`, balance, poolID); err != nil {
+		t.Fatalf("setting the stored balance: %v", err)
+	}
+}
+
+func testBalanceDrift(t *testing.T, s Store) {
+	ctx := context.Background()
+	a := createPool(t, s, "Books", 100)
+	b := createPool(t, s, "Snacks", 50)
+	createPool(t, s, "Empty", 10)
+	for i := 0; i < 10; i++ {
+		record(t, s, "deposit", "", alloc(a, 0.1), alloc(b, 0.7))
+	}
+	record(t, s, "withdrawal", "", alloc(b, 2.3))
+
+	if drift, err := s.CheckPoolBalances(ctx); err != nil || len(drift) != 0 {
+		t.Fatalf("CheckPoolBalances = %+v, %v; want no drift", drift, err)
+	}
+
+	setStoredBalance(t, s, b, 99)
+	wantBalance(t, s, b, 99) // Reads use the maintained balance.
+	want := []models.PoolBalanceDrift{{FundingPoolID: b, Stored: 99, Ledger: 4.7}}
+	if drift, err := s.CheckPoolBalances(ctx); err != nil || !reflect.DeepEqual(drift, want) {
+		t.Errorf("CheckPoolBalances = %+v, %v; want %+v", drift, err, want)
+	}
+	wantBalance(t, s, b, 99) // Checking changes nothing.
+
+	var drift []models.PoolBalanceDrift
+	err := s.WithTx(ctx, func(tx Store) error {
+		if err := tx.LockAllPools(ctx); err != nil {
+			return err
+		}
+		var err error
+		drift, err = tx.ReconcilePoolBalances(ctx)
+		return err
+	})
+	if err != nil || !reflect.DeepEqual(drift, want) {
+		t.Errorf("ReconcilePoolBalances = %+v, %v; want %+v", drift, err, want)
+	}
+	wantBalance(t, s, a, 1)
+	wantBalance(t, s, b, 4.7)
+	if drift, err := s.CheckPoolBalances(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("CheckPoolBalances after reconciling = %+v, %v; want no drift", drift, err)
+	}
+
+	// New entries keep adding to the reconciled balance.
+	record(t, s, "deposit", "", alloc(b, 0.3))
+	wantBalance(t, s, b, 5)
+}
+
+// execSQL runs a statement behind a SQL store's back, or skips the test for
+// the in-memory store.
+// sqlDB returns a SQL store's connection, skipping the rest of the test for
+// other stores.
+func sqlDB(t *testing.T, s Store) dbtx {
+	t.Helper()
+	switch s := s.(type) {
+	case *SQLite:
+		return s.q
+	case *Postgres:
+		return s.q
+	}
+	t.Skip("not a SQL store")
+	return nil
+}
+
+func execSQL(t *testing.T, s Store, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := sqlDB(t, s).ExecContext(context.Background(), query, args...); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+}
+
+func verifyChain(t *testing.T, s Store) models.LedgerVerification {
+	t.Helper()
+	entries, err := s.ListLedgerEntries(context.Background())
+	if err != nil {
+		t.Fatalf("ListLedgerEntries: %v", err)
+	}
+	return ledgerchain.Verify(entries)
+}
+
+func testHashChain(t *testing.T, s Store) {
+	ctx := context.Background()
+	createUser(t, s, "g1", "ada@example.com")
+	a := createPool(t, s, "Books", 100)
+	b := createPool(t, s, "Snacks", 50)
+	first := record(t, s, "deposit", "g1", alloc(a, 10.1), alloc(b, 0.2))
+	second := record(t, s, "deposit", "", alloc(b, 5))
+	last := record(t, s, "withdrawal", "", alloc(a, 3.3))
+
+	v := verifyChain(t, s)
+	if !v.Valid || v.Checked != 3 {
+		t.Fatalf("Verify = %+v, want 3 valid entries", v)
+	}
+	entries, _ := s.ListLedgerEntries(ctx)
+	if entries[0].ID != last || v.HeadHash != entries[0].Hash || entries[2].PrevHash != ledgerchain.Genesis {
+		t.Errorf("entries = %+v, head hash %s", entries, v.HeadHash)
+	}
+
+	// Deleting an account anonymizes its entries without breaking the chain.
+	if _, err := s.AnonymizeUserLedger(ctx, "g1"); err != nil {
+		t.Fatalf("AnonymizeUserLedger: %v", err)
+	}
+	if v := verifyChain(t, s); !v.Valid || v.Checked != 4 {
+		t.Errorf("Verify after anonymizing = %+v, want 4 valid entries", v)
+	}
+	entries, _ = s.ListLedgerEntries(ctx)
+	if e := entries[0]; e.TransactionType != "anonymized" || e.AnonymizedLedgerID == nil || *e.AnonymizedLedgerID != first ||
+		e.DonorCommitment == nil || e.UserGoogleID != nil || len(e.Allocations) != 0 {
+		t.Errorf("newest entry = %+v, want one recording that entry %d was anonymized", e, first)
+	}
+	if e := entries[len(entries)-1]; e.ID != first || e.DonorSalt != "" {
+		t.Errorf("anonymized entry = %+v, want its salt erased", e)
+	}
+	if n, err := s.SealLedger(ctx); err != nil || n != 0 {
+		t.Errorf("SealLedger = %d, %v; want nothing to hash", n, err)
+	}
+
+	if m, ok := s.(*Memory); ok {
+		m.SetLedgerAmount(second, 500)
+	} else {
+		execSQL(t, s, `UPDATE allocation SET amount = 500 WHERE ledger_id = $1`, second)
+	}
+	v = verifyChain(t, s)
+	if v.Valid || v.Checked != 1 || v.Broken == nil || v.Broken.LedgerID != second {
+		t.Errorf("Verify after an edit = %+v, want broken at %d", v, second)
+	}
+
+	// Entries from before hashing existed are hashed in order, once. The
+	// anonymized entry keeps the commitment recorded for it.
+	execSQL(t, s, `UPDATE allocation SET amount = 5 WHERE ledger_id = $1`, second)
+	execSQL(t, s, `UPDATE ledger SET prev_hash = NULL, hash = NULL`)
+	execSQL(t, s, `UPDATE ledger_chain SET legacy_through = (SELECT MAX(id) FROM ledger), sealed = FALSE`)
+	if v := verifyChain(t, s); v.Valid || v.Broken.LedgerID != first {
+		t.Errorf("Verify of unhashed entries = %+v, want broken at %d", v, first)
+	}
+	if n, err := s.SealLedger(ctx); err != nil || n != 4 {
+		t.Errorf("SealLedger = %d, %v; want 4", n, err)
+	}
+	fourth := record(t, s, "deposit", "", alloc(a, 1))
+	if v := verifyChain(t, s); !v.Valid || v.Checked != 5 {
+		t.Errorf("Verify after sealing = %+v, want 5 valid entries", v)
+	}
+
+	// After that, an entry that loses its hash is not sealed again.
+	execSQL(t, s, `UPDATE ledger SET prev_hash = NULL, hash = NULL WHERE id = $1`, fourth)
+	if n, err := s.SealLedger(ctx); err != nil || n != 0 {
+		t.Errorf("SealLedger = %d, %v; want nothing to hash", n, err)
+	}
+	record(t, s, "deposit", "", alloc(a, 1))
+	if v := verifyChain(t, s); v.Valid || v.Checked != 4 || v.Broken.LedgerID != fourth {
+		t.Errorf("Verify with an unhashed entry = %+v, want broken at %d", v, fourth)
+	}
+}
+
+func testWithdrawals(t *testing.T, s Store) {
+	ctx := context.Background()
+	a := createPool(t, s, "Books", 100)
+	b := createPool(t, s, "Snacks", 50)
+	record(t, s, "deposit", "", alloc(a, 0.1))
+	record(t, s, "deposit", "", alloc(a, 0.2))
+	record(t, s, "deposit", "", alloc(b, 1))
+
+	// 0.1 + 0.2 is the whole balance, not a fraction of a cent short of it.
+	if err := withdraw(ctx, s, alloc(a, 0.3)); err != nil {
+		t.Fatalf("withdrawing the whole balance: %v", err)
+	}
+	wantBalance(t, s, a, 0)
+
+	// One pool short rolls back the whole withdrawal.
+	if err := withdraw(ctx, s, alloc(b, 0.5), alloc(a, 0.01)); !errors.Is(err, errInsufficient) {
+		t.Fatalf("overdrawing error = %v, want errInsufficient", err)
+	}
+	wantBalance(t, s, b, 1)
+	if entries, _ := s.ListLedgerEntries(ctx); len(entries) != 4 {
+		t.Errorf("got %d ledger entries after a failed withdrawal, want 4", len(entries))
+	}
+
+	if err := withdraw(ctx, s, alloc(999, 1)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("withdrawing from a missing pool: %v, want ErrNotFound", err)
+	}
+}
+
+func testTransactions(t *testing.T, s Store) {
+	ctx := context.Background()
+	a := createPool(t, s, "Books", 100)
+
+	failed := errors.New("failed")
+	err := s.WithTx(ctx, func(tx Store) error {
+		record(t, tx, "deposit", "", alloc(a, 5))
+		// Nested calls join the transaction.
+		return tx.WithTx(ctx, func(tx Store) error {
+			if _, err := tx.CreatePool(ctx, &models.CreateFundingPoolRequest{Name: "Snacks", OverflowPolicy: models.OverflowReject}); err != nil {
+				return err
+			}
+			return failed
+		})
+	})
+	if err != failed {
+		t.Fatalf("WithTx error = %v, want %v", err, failed)
+	}
+	wantBalance(t, s, a, 0)
+	if pools, _ := s.ListPools(ctx, true); len(pools) != 1 {
+		t.Errorf("got %d pools after rollback, want 1", len(pools))
+	}
+
+	err = s.WithTx(ctx, func(tx Store) error {
+		record(t, tx, "deposit", "", alloc(a, 5))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	err = s.WithSnapshot(ctx, func(tx Store) error {
+		balance, err := tx.PoolBalance(ctx, a)
+		if err == nil && balance != 5 {
+			t.Errorf("snapshot balance = %v, want 5", balance)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithSnapshot: %v", err)
+	}
+}
+
+func testRevisions(t *testing.T, s Store) {
+	ctx := context.Background()
+	createUser(t, s, "mod", "mod@example.com")
+	a := createPool(t, s, "Books", 100)
+
+	changes := map[string]models.FieldChange{"name": {Old: "Books", New: "Library"}}
+	for _, rev := range []NewPoolRevision{
+		{FundingPoolID: a, Action: "create", ModeratorGoogleID: "mod"},
+		{FundingPoolID: a, Action: "update", ModeratorGoogleID: "mod", Changes: changes},
+		{FundingPoolID: a, Action: "update"},
+	} {
+		if err := s.RecordPoolRevision(ctx, rev); err != nil {
+			t.Fatalf("RecordPoolRevision: %v", err)
+		}
+	}
+
+	revs, err := s.ListPoolRevisions(ctx, a)
+	if err != nil || len(revs) != 3 {
+		t.Fatalf("ListPoolRevisions = %+v, %v", revs, err)
+	}
+	if revs[0].Moderator != nil || revs[2].Action != "create" {
+		t.Errorf("revisions not newest first: %+v", revs)
+	}
+	if m := revs[1].Moderator; m == nil || m.FirstName.String != "Ada" || m.Email != "mod@example.com" || !reflect.DeepEqual(revs[1].Changes, changes) {
+		t.Errorf("revision = %+v", revs[1])
+	}
+
+	mine, err := s.ModeratorRevisions(ctx, "mod")
+	if err != nil || len(mine) != 2 || mine[0].Action != "create" {
+		t.Errorf("ModeratorRevisions = %+v, %v", mine, err)
+	}
+
+	if n, err := s.AnonymizeModeratorRevisions(ctx, "mod"); err != nil || n != 2 {
+		t.Errorf("AnonymizeModeratorRevisions = %d, %v; want 2", n, err)
+	}
+	if mine, _ := s.ModeratorRevisions(ctx, "mod"); len(mine) != 0 {
+		t.Errorf("%d revisions still tied to the moderator", len(mine))
+	}
+	revs, err = s.ListPoolRevisions(ctx, a)
+	if err != nil || len(revs) != 3 || revs[1].Moderator != nil || !reflect.DeepEqual(revs[1].Changes, changes) {
+		t.Errorf("ListPoolRevisions after anonymizing = %+v, %v", revs, err)
+	}
+
+	// Clearing the moderator is the only change the history allows.
+	db := sqlDB(t, s)
+	for _, query := range []string{
+		`UPDATE funding_pool_revision SET action = 'delete' WHERE id = $1`,
+		`UPDATE funding_pool_revision SET moderator_google_id = 'someone' WHERE id = $1`,
+		`DELETE FROM funding_pool_revision WHERE id = $1`,
+	} {
+		if _, err := db.ExecContext(ctx, query, revs[1].ID); err == nil {
+			t.Errorf("%s succeeded on an append-only revision", query)
+		}
+	}
+	if err := s.RecordPoolRevision(ctx, NewPoolRevision{FundingPoolID: a, Action: "archive", ModeratorGoogleID: "mod"}); err != nil {
+		t.Fatalf("RecordPoolRevision: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE funding_pool_revision SET moderator_google_id = NULL, action = 'delete' WHERE action = 'archive'`); err == nil {
+		t.Error("clearing the moderator along with another column succeeded")
+	}
+}
+
+func testUsers(t *testing.T, s Store) {
+	ctx := context.Background()
+	u, err := s.UpsertUser(ctx, User{GoogleID: "g1", Email: "Zoe@Example.com", FirstName: "Zoe"})
+	if err != nil || u.GoogleID != "g1" || u.CreatedAt.IsZero() {
+		t.Fatalf("UpsertUser = %+v, %v", u, err)
+	}
+	created := u.CreatedAt
+	u, err = s.UpsertUser(ctx, User{GoogleID: "g1", Email: "zoe@example.com", FirstName: "Zoë", DonateOnly: true})
+	if err != nil || u.FirstName != "Zoë" || !u.DonateOnly || !u.CreatedAt.Equal(created) {
+		t.Errorf("second UpsertUser = %+v, %v; want the name updated and CreatedAt %v kept", u, err, created)
+	}
+	createUser(t, s, "g2", "ada@example.com")
+
+	if id, err := s.UserIDForEmail(ctx, "ZOE@example.COM"); err != nil || id != "g1" {
+		t.Errorf("UserIDForEmail = %q, %v", id, err)
+	}
+	if _, err := s.UserIDForEmail(ctx, "nobody@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UserIDForEmail(unknown) error = %v", err)
+	}
+	if _, err := s.GetUser(ctx, "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUser(unknown) error = %v", err)
+	}
+	if u, err := s.LockUser(ctx, "g2"); err != nil || u.Email != "ada@example.com" {
+		t.Errorf("LockUser = %+v, %v", u, err)
+	}
+
+	if err := s.UpsertIdentity(ctx, "google", "sub-1", "g1", "zoe@example.com"); err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if err := s.UpsertIdentity(ctx, "okta", "sub-2", "g1", ""); err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if err := s.UpsertIdentity(ctx, "google", "sub-1", "g1", "zoe@new.example.com"); err != nil {
+		t.Fatalf("UpsertIdentity again: %v", err)
+	}
+	if id, err := s.UserIDForIdentity(ctx, "okta", "sub-2"); err != nil || id != "g1" {
+		t.Errorf("UserIDForIdentity = %q, %v", id, err)
+	}
+	if _, err := s.UserIDForIdentity(ctx, "okta", "sub-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UserIDForIdentity(unknown) error = %v", err)
+	}
+	identities, err := s.UserIdentities(ctx, "g1")
+	if err != nil || len(identities) != 2 {
+		t.Fatalf("UserIdentities = %+v, %v", identities, err)
+	}
+	if i := identities[0]; i.Provider != "google" || i.Email == nil || *i.Email != "zoe@new.example.com" {
+		t.Errorf("identity = %+v", i)
+	}
+
+	if ok, err := s.GrantRole(ctx, "g2", "treasurer", "g1"); err != nil || !ok {
+		t.Errorf("GrantRole = %v, %v", ok, err)
+	}
+	if ok, err := s.GrantRole(ctx, "g2", "treasurer", "g1"); err != nil || ok {
+		t.Errorf("GrantRole again = %v, %v; want false", ok, err)
+	}
+	for _, role := range []string{"admin", "viewer"} {
+		if _, err := s.GrantRole(ctx, "g2", role, ""); err != nil {
+			t.Fatalf("GrantRole: %v", err)
+		}
+	}
+	if _, err := s.GrantRole(ctx, "g1", "admin", ""); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	if ok, err := s.RevokeRole(ctx, "g2", "viewer"); err != nil || !ok {
+		t.Errorf("RevokeRole = %v, %v", ok, err)
+	}
+	if ok, err := s.RevokeRole(ctx, "g2", "viewer"); err != nil || ok {
+		t.Errorf("RevokeRole again = %v, %v; want false", ok, err)
+	}
+	if roles, err := s.GrantedRoles(ctx, "g2"); err != nil || !reflect.DeepEqual(roles, []string{"admin", "treasurer"}) {
+		t.Errorf("GrantedRoles = %v, %v", roles, err)
+	}
+	if roles, err := s.UserRoles(ctx, "g2"); err != nil || !reflect.DeepEqual(roles, []auth.Role{"admin", "treasurer"}) {
+		t.Errorf("UserRoles = %v, %v", roles, err)
+	}
+	// Donate-only accounts hold no roles.
+	if roles, err := s.UserRoles(ctx, "g1"); err != nil || len(roles) != 0 {
+		t.Errorf("UserRoles(donate-only) = %v, %v", roles, err)
+	}
+	if holders, err := s.LockRoleHolders(ctx, "admin"); err != nil || !reflect.DeepEqual(holders, []string{"g2"}) {
+		t.Errorf("LockRoleHolders = %v, %v", holders, err)
+	}
+
+	users, err := s.ListUsers(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("ListUsers = %+v, %v", users, err)
+	}
+	if users[0].GoogleID != "g2" || !reflect.DeepEqual(users[0].Roles, []string{"admin", "treasurer"}) || users[1].GoogleID != "g1" {
+		t.Errorf("ListUsers = %+v, want by email with roles", users)
+	}
+
+	if err := s.UpdateProfile(ctx, "g2", ptr("Countess"), true, false); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	p, err := s.DonorProfile(ctx, "g2")
+	if err != nil || p.DisplayName.String != "Countess" || !p.DefaultAnonymous || p.ShowFullName || p.FirstName.String != "Ada" {
+		t.Errorf("DonorProfile = %+v, %v", p, err)
+	}
+	if err := s.UpdateProfile(ctx, "nobody", nil, false, false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateProfile(unknown) error = %v", err)
+	}
+	if _, err := s.DonorProfile(ctx, "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DonorProfile(unknown) error = %v", err)
+	}
+}
+
+func testDonations(t *testing.T, s Store) {
+	ctx := context.Background()
+	createUser(t, s, "g1", "ada@example.com")
+	a := createPool(t, s, "Books", 100)
+	b := createPool(t, s, "Snacks", 50)
+
+	record(t, s, "deposit", "g1", alloc(a, 0.1), alloc(b, 0.2))
+	record(t, s, "deposit", "g1", alloc(a, 0.2))
+	// Withdrawals and deposits without a PayPal transaction are not donations.
+	record(t, s, "withdrawal", "g1", alloc(a, 0.1))
+	if _, err := s.CreateLedgerEntry(ctx, LedgerEntryData{
+		Amount: 3, TransactionType: "deposit", UserGoogleID: sql.NullString{String: "g1", Valid: true},
+		Allocations: []models.AllocationRequest{alloc(b, 3)},
+	}); err != nil {
+		t.Fatalf("CreateLedgerEntry: %v", err)
+	}
+
+	donations, err := s.UserDonations(ctx, "g1", nil)
+	if err != nil || len(donations) != 2 {
+		t.Fatalf("UserDonations = %+v, %v", donations, err)
+	}
+	if d := donations[0]; d.Amount != 0.2 || len(d.Allocations) != 1 {
+		t.Errorf("newest donation = %+v", d)
+	}
+	if d := donations[1]; len(d.Allocations) != 2 || d.Allocations[1].FundingPoolName != "Snacks" || d.Allocations[1].Amount != 0.2 {
+		t.Errorf("oldest donation = %+v", d)
+	}
+
+	year := time.Now().UTC().Year()
+	if got, err := s.UserDonations(ctx, "g1", &year); err != nil || len(got) != 2 {
+		t.Errorf("UserDonations(%d) = %d donations, %v; want 2", year, len(got), err)
+	}
+	lastYear := year - 1
+	if got, err := s.UserDonations(ctx, "g1", &lastYear); err != nil || len(got) != 0 {
+		t.Errorf("UserDonations(%d) = %d donations, %v; want none", lastYear, len(got), err)
+	}
+
+	years, err := s.UserDonationYears(ctx, "g1")
+	want := []models.YearDonationTotal{{Year: year, Count: 2, Total: 0.5}}
+	if err != nil || !reflect.DeepEqual(years, want) {
+		t.Errorf("UserDonationYears = %+v, %v; want %+v", years, err, want)
+	}
+
+	entries, err := s.UserLedgerEntries(ctx, "g1")
+	if err != nil || len(entries) != 4 || entries[0].Amount != 0.3 || len(entries[0].Allocations) != 2 {
+		t.Errorf("UserLedgerEntries = %+v, %v", entries, err)
+	}
+
+	n, err := s.AnonymizeUserLedger(ctx, "g1")
+	if err != nil || n != 4 {
+		t.Errorf("AnonymizeUserLedger = %d, %v; want 4", n, err)
+	}
+	if entries, _ := s.UserLedgerEntries(ctx, "g1"); len(entries) != 0 {
+		t.Errorf("%d entries still tied to the user", len(entries))
+	}
+	// Donations become anonymous; the withdrawal the user recorded is only
+	// detached, so it does not turn into an anonymous donation.
+	entries, _ = s.ListLedgerEntries(ctx)
+	for _, e := range entries {
+		if e.UserGoogleID != nil || e.FirstName != nil || e.LastInitial != nil {
+			t.Errorf("entry %d still names the user: %+v", e.ID, e)
+		}
+		if wantAnonymous := e.TransactionType == "deposit"; e.Anonymous != wantAnonymous || e.TransactionType == "" {
+			t.Errorf("entry %d (%s) anonymous = %v, want %v", e.ID, e.TransactionType, e.Anonymous, wantAnonymous)
+		}
+	}
+	wantBalance(t, s, a, 0.2)
+	wantBalance(t, s, b, 3.2)
+}
+
+func testDeleteUser(t *testing.T, s Store) {
+	ctx := context.Background()
+	createUser(t, s, "g1", "ada@example.com")
+	createUser(t, s, "g2", "bob@example.com")
+	if err := s.UpsertIdentity(ctx, "google", "sub-1", "g1", "ada@example.com"); err != nil {
+		t.Fatalf("UpsertIdentity: %v", err)
+	}
+	if _, err := s.GrantRole(ctx, "g2", "viewer", "g1"); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	if _, err := s.GrantRole(ctx, "g1", "admin", ""); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	if _, err := s.CreateAPIToken(ctx, "g1", NewAPIToken{Name: "bot", Hash: "h1", Prefix: "pp_1", Scopes: []string{"withdraw"}}); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if err := s.RecordAudit(ctx, "g1", "role.grant", "g2", map[string]string{"role": "viewer"}); err != nil {
+		t.Fatalf("RecordAudit: %v", err)
+	}
+
+	if err := s.DeleteUser(ctx, "g1"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if err := s.DeleteUser(ctx, "g1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeleteUser error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.UserIDForIdentity(ctx, "google", "sub-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("identity kept after DeleteUser: %v", err)
+	}
+	if _, _, err := s.UseAPIToken(ctx, "h1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("token kept after DeleteUser: %v", err)
+	}
+	if holders, _ := s.LockRoleHolders(ctx, "admin"); len(holders) != 0 {
+		t.Errorf("admin role kept after DeleteUser: %v", holders)
+	}
+	if roles, _ := s.GrantedRoles(ctx, "g2"); !reflect.DeepEqual(roles, []string{"viewer"}) {
+		t.Errorf("roles granted by the deleted user = %v, want kept", roles)
+	}
+	log, err := s.UserAuditLog(ctx, "g2")
+	if err != nil || len(log) != 1 || log[0].ActorGoogleID != nil {
+		t.Errorf("UserAuditLog = %+v, %v; want the entry kept without its actor", log, err)
+	}
+	if sessions, err := s.ListUserSessions(ctx, "g1"); err != nil || len(sessions) != 0 {
+		t.Errorf("ListUserSessions = %+v, %v", sessions, err)
+	}
+}
+
+func testTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+	createUser(t, s, "g1", "ada@example.com")
+
+	token, err := s.CreateAPIToken(ctx, "g1", NewAPIToken{Name: "bot", Hash: "h1", Prefix: "pp_1", Scopes: []string{"withdraw", "external_donation"}})
+	if err != nil || token.ID == 0 || token.CreatedAt.IsZero() {
+		t.Fatalf("CreateAPIToken = %+v, %v", token, err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, err := s.CreateAPIToken(ctx, "g1", NewAPIToken{Name: "old", Hash: "h2", Prefix: "pp_2", Scopes: []string{"withdraw"}, ExpiresAt: &past}); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	revoked, err := s.CreateAPIToken(ctx, "g1", NewAPIToken{Name: "gone", Hash: "h3", Prefix: "pp_3", Scopes: []string{}, ExpiresAt: &future})
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+
+	googleID, scopes, err := s.UseAPIToken(ctx, "h1")
+	if err != nil || googleID != "g1" || !reflect.DeepEqual(scopes, []string{"withdraw", "external_donation"}) {
+		t.Errorf("UseAPIToken = %q, %v, %v", googleID, scopes, err)
+	}
+	if _, _, err := s.UseAPIToken(ctx, "h2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UseAPIToken(expired) error = %v", err)
+	}
+	if ok, err := s.RevokeAPIToken(ctx, "g1", revoked.ID); err != nil || !ok {
+		t.Errorf("RevokeAPIToken = %v, %v", ok, err)
+	}
+	if ok, err := s.RevokeAPIToken(ctx, "g1", revoked.ID); err != nil || ok {
+		t.Errorf("RevokeAPIToken again = %v, %v; want false", ok, err)
+	}
+	if _, _, err := s.UseAPIToken(ctx, "h3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UseAPIToken(revoked) error = %v", err)
+	}
+
+	tokens, err := s.ListAPITokens(ctx, "g1")
+	if err != nil || len(tokens) != 3 {
+		t.Fatalf("ListAPITokens = %+v, %v", tokens, err)
+	}
+	if tokens[0].ID != revoked.ID || tokens[0].RevokedAt == nil || tokens[2].LastUsedAt == nil || tokens[1].LastUsedAt != nil {
+		t.Errorf("ListAPITokens = %+v", tokens)
+	}
+	if len(tokens[0].Scopes) != 0 || tokens[2].Prefix != "pp_1" {
+		t.Errorf("ListAPITokens = %+v", tokens)
+	}
+}
+
+func testAuditLog(t *testing.T, s Store) {
+	ctx := context.Background()
+	createUser(t, s, "g1", "ada@example.com")
+	for i := 0; i < 3; i++ {
+		if err := s.RecordAudit(ctx, "g1", "role.grant", "", map[string]int{"n": i}); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+
+	page, err := s.AuditLog(ctx, nil, 2)
+	if err != nil || len(page) != 2 || page[0].ID < page[1].ID {
+		t.Fatalf("AuditLog = %+v, %v", page, err)
+	}
+	if page[0].TargetGoogleID != nil || *page[0].ActorGoogleID != "g1" || string(page[0].Details) != `{"n":2}` {
+		t.Errorf("newest entry = %+v", page[0])
+	}
+	before := int64(page[1].ID)
+	rest, err := s.AuditLog(ctx, &before, 2)
+	if err != nil || len(rest) != 1 || string(rest[0].Details) != `{"n":0}` {
+		t.Errorf("AuditLog(before %d) = %+v, %v", before, rest, err)
+	}
+	if mine, err := s.UserAuditLog(ctx, "g1"); err != nil || len(mine) != 3 || mine[0].ID > mine[2].ID {
+		t.Errorf("UserAuditLog = %+v, %v", mine, err)
+	}
+}
+
+func testSite(t *testing.T, s Store) {
+	ctx := context.Background()
+	site, err := s.GetSite(ctx)
+	if err != nil || site.SiteTitle != "Pool Party" {
+		t.Errorf("GetSite = %+v, %v", site, err)
+	}
+	if err := s.SetSiteTitle(ctx, "Office Fund"); err != nil {
+		t.Fatalf("SetSiteTitle: %v", err)
+	}
+	if site, err := s.GetSite(ctx); err != nil || site.SiteTitle != "Office Fund" {
+		t.Errorf("GetSite after SetSiteTitle = %+v, %v", site, err)
+	}
+}
+
+// testArchive returns an archive with two linked pools, two users and a
+// hashed ledger, with IDs a fresh database would not have assigned.
+func testArchive() *models.BackupArchive {
+	day := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+	a := &models.BackupArchive{
+		Format:  models.BackupFormat,
+		Version: models.BackupVersion,
+		Site:    models.SiteInstance{SiteTitle: "Restored", SiteHeadline: ptr("Back again")},
+		Pools: []models.BackupPool{
+			{FundingPool: models.FundingPool{ID: 7, Name: "Books", GoalAmount: 100, OverflowPolicy: models.OverflowRedirect, OverflowPoolID: ptr(9), CurrentAmount: 6.9}, ImageKey: ptr("books.png")},
+			{FundingPool: models.FundingPool{ID: 9, Name: "Snacks", GoalAmount: 50, CapAmount: ptr(80.0), OverflowPolicy: models.OverflowReject, CurrentAmount: 0.2}},
+		},
+		Users: []models.BackupUser{
+			{
+				GoogleID: "g1", Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace", CreatedAt: day,
+				Identities: []models.UserIdentity{{Provider: "google", Subject: "g1", Email: ptr("ada@example.com"), CreatedAt: day, LastLoginAt: day}},
+				Roles:      []models.BackupRole{{Role: "admin", GrantedAt: day}},
+			},
+			{
+				GoogleID: "g2", Email: "bob@example.com", FirstName: "Bob", DonateOnly: true, CreatedAt: day, DisplayName: ptr("B"), DefaultAnonymous: true,
+				Identities: []models.UserIdentity{},
+				Roles:      []models.BackupRole{},
+			},
+		},
+		// The first entry commits to its donor; the second predates commitments.
+		Ledger: []models.BackupLedgerEntry{
+			{LedgerEntry: models.LedgerEntry{ID: 40, TransactionID: ptr("PAY-1"), Amount: 10.2, Timestamp: day, TransactionType: "deposit", UserGoogleID: ptr("g1"), FirstName: ptr("Ada"), LastInitial: ptr("L"),
+				Allocations: []models.Allocation{{ID: 80, FundingPoolID: 7, Amount: 10}, {ID: 81, FundingPoolID: 9, Amount: 0.2}}},
+				DonorSalt: "5a17"},
+			{LedgerEntry: models.LedgerEntry{ID: 42, Amount: 3.1, Timestamp: day.Add(24 * time.Hour), TransactionType: "withdrawal", Description: ptr("Paperbacks"),
+				Allocations: []models.Allocation{{ID: 85, FundingPoolID: 7, Amount: 3.1}}}},
+		},
+	}
+	prev := ledgerchain.Genesis
+	for i := range a.Ledger {
+		entry := a.Ledger[i].LedgerEntry
+		entry.DonorSalt = a.Ledger[i].DonorSalt
+		a.Ledger[i].PrevHash = prev
+		a.Ledger[i].Hash = ledgerchain.Hash(prev, entry)
+		prev = a.Ledger[i].Hash
+	}
+	return a
+}
+
+func testBackup(t *testing.T, s Store) {
+	ctx := context.Background()
+	if empty, err := s.IsEmpty(ctx); err != nil || !empty {
+		t.Fatalf("IsEmpty = %v, %v; want a fresh store to be empty", empty, err)
+	}
+
+	a := testArchive()
+	if err := s.Restore(ctx, a); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if empty, err := s.IsEmpty(ctx); err != nil || empty {
+		t.Errorf("IsEmpty after restoring = %v, %v", empty, err)
+	}
+
+	if site, err := s.GetSite(ctx); err != nil || site.SiteTitle != "Restored" || site.SiteHeadline == nil || *site.SiteHeadline != "Back again" {
+		t.Errorf("GetSite = %+v, %v", site, err)
+	}
+	wantBalance(t, s, 7, 6.9)
+	wantBalance(t, s, 9, 0.2)
+	if p, err := s.GetPool(ctx, 7); err != nil || p.OverflowPoolID == nil || *p.OverflowPoolID != 9 || p.ImageKey == nil || *p.ImageKey != "books.png" {
+		t.Errorf("GetPool(7) = %+v, %v", p, err)
+	}
+	if drift, err := s.CheckPoolBalances(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("CheckPoolBalances = %+v, %v", drift, err)
+	}
+	if totals, err := s.PoolDailyTotals(ctx, 7); err != nil || len(totals) != 2 || totals[0].Deposits != 10 || totals[1].Withdrawals != 3.1 {
+		t.Errorf("PoolDailyTotals = %+v, %v", totals, err)
+	}
+
+	users, err := s.BackupUsers(ctx)
+	if err != nil || len(users) != 2 {
+		t.Fatalf("BackupUsers = %+v, %v", users, err)
+	}
+	for i := range users {
+		want := a.Users[i]
+		got := users[i]
+		if !got.CreatedAt.Equal(want.CreatedAt) || len(got.Identities) != len(want.Identities) || len(got.Roles) != len(want.Roles) {
+			t.Errorf("BackupUsers[%d] = %+v, want %+v", i, got, want)
+			continue
+		}
+		for j := range got.Roles {
+			if got.Roles[j].Role != want.Roles[j].Role || !got.Roles[j].GrantedAt.Equal(want.Roles[j].GrantedAt) {
+				t.Errorf("role = %+v, want %+v", got.Roles[j], want.Roles[j])
+			}
+		}
+		got.CreatedAt, got.Identities, got.Roles = want.CreatedAt, want.Identities, want.Roles
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("BackupUsers[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if roles, err := s.UserRoles(ctx, "g1"); err != nil || !reflect.DeepEqual(roles, []auth.Role{"admin"}) {
+		t.Errorf("UserRoles = %v, %v", roles, err)
+	}
+
+	// The restored chain is intact, and new records continue it and the IDs.
+	if v := verifyChain(t, s); !v.Valid || v.Checked != 2 || v.HeadHash != a.Ledger[1].Hash {
+		t.Errorf("Verify = %+v, want the archived chain", v)
+	}
+	if id := createPool(t, s, "Games", 10); id <= 9 {
+		t.Errorf("CreatePool = %d, want an ID after the restored ones", id)
+	}
+	if id := record(t, s, "deposit", "", alloc(9, 1)); id <= 42 {
+		t.Errorf("CreateLedgerEntry = %d, want an ID after the restored ones", id)
+	}
+	if v := verifyChain(t, s); !v.Valid || v.Checked != 3 {
+		t.Errorf("Verify after a new entry = %+v", v)
+	}
+	entries, _ := s.ListLedgerEntries(ctx)
+	for _, alloc := range entries[0].Allocations {
+		if alloc.ID <= 85 {
+			t.Errorf("new allocation ID = %d, want one after the restored ones", alloc.ID)
+		}
+	}
+}
@@ -0,0 +1,1363 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pool-party-api/auth"
+	"pool-party-api/ledgerchain"
+	"pool-party-api/models"
+)
+
+// Memory is a Store that keeps everything in memory, for tests. A single lock
+// serializes access: transactions hold it until they end and work on a copy
+// of the data, which replaces the original when they commit.
+type Memory struct {
+	db *memDB
+	tx *memData // Set inside a transaction.
+}
+
+var _ Store = (*Memory)(nil)
+
+type memDB struct {
+	mu   sync.Mutex
+	data *memData
+}
+
+// memData holds the rows of each table. Rows are values, so copying the
+// slices copies the data.
+type memData struct {
+	site        models.SiteInstance
+	pools       []Pool
+	ledger      []models.LedgerEntry // Without allocations.
+	allocations []models.Allocation
+	balances    map[int]int64 // Maintained balance in cents, by pool ID.
+	revisions   []memRevision
+	users       []memUser
+	identities  []memIdentity
+	roles       []memRole
+	audit       []memAudit
+	tokens      []memToken
+	sessions    []memSession
+	lastID      int
+}
+
+type memRevision struct {
+	models.FundingPoolRevision
+	moderator string
+	changes   []byte
+}
+
+type memUser struct {
+	User
+	displayName      *string
+	defaultAnonymous bool
+	showFullName     bool
+}
+
+type memIdentity struct {
+	models.UserIdentity
+	googleID string
+}
+
+type memRole struct {
+	googleID, role, grantedBy string
+	grantedAt                 time.Time
+}
+
+type memAudit struct {
+	models.AuditLogEntry
+}
+
+type memToken struct {
+	models.APIToken
+	googleID, hash string
+}
+
+type memSession struct {
+	models.UserSession
+	googleID string
+}
+
+// NewMemory returns an empty Store with the default site configuration.
+func NewMemory() *Memory {
+	return &Memory{db: &memDB{data: &memData{
+		site:     models.SiteInstance{SiteTitle: "Pool Party"},
+		balances: make(map[int]int64),
+	}}}
+}
+
+func (d *memData) clone() *memData {
+	c := *d
+	c.pools = append([]Pool(nil), d.pools...)
+	c.ledger = append([]models.LedgerEntry(nil), d.ledger...)
+	c.allocations = append([]models.Allocation(nil), d.allocations...)
+	c.balances = make(map[int]int64, len(d.balances))
+	for id, cents := range d.balances {
+		c.balances[id] = cents
+	}
+	c.revisions = append([]memRevision(nil), d.revisions...)
+	c.users = append([]memUser(nil), d.users...)
+	c.identities = append([]memIdentity(nil), d.identities...)
+	c.roles = append([]memRole(nil), d.roles...)
+	c.audit = append([]memAudit(nil), d.audit...)
+	c.tokens = append([]memToken(nil), d.tokens...)
+	c.sessions = append([]memSession(nil), d.sessions...)
+	return &c
+}
+
+// nextID returns a new ID. IDs are unique across tables, which is enough for
+// a fake.
+func (d *memData) nextID() int {
+	d.lastID++
+	return d.lastID
+}
+
+// begin returns the data to work on and a function to call when done.
+func (s *Memory) begin() (*memData, func()) {
+	if s.tx != nil {
+		return s.tx, func() {}
+	}
+	s.db.mu.Lock()
+	return s.db.data, s.db.mu.Unlock
+}
+
+func (s *Memory) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(fn, true)
+}
+
+func (s *Memory) WithSnapshot(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(fn, false)
+}
+
+func (s *Memory) withTx(fn func(tx Store) error, commit bool) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	data := s.db.data.clone()
+	if err := fn(&Memory{db: s.db, tx: data}); err != nil {
+		return err
+	}
+	if commit {
+		s.db.data = data
+	}
+	return nil
+}
+
+// roundCents rounds an amount the way a DECIMAL(15, 2) column stores it.
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func roundCentsPtr(amount *float64) *float64 {
+	if amount == nil {
+		return nil
+	}
+	v := roundCents(*amount)
+	return &v
+}
+
+// AddSession records a sign-in session for a user, as the session store
+// would, and returns its ID. It exists so tests can list and revoke sessions.
+func (s *Memory) AddSession(googleID string, us models.UserSession) int {
+	d, done := s.begin()
+	defer done()
+	us.ID = d.nextID()
+	d.sessions = append(d.sessions, memSession{UserSession: us, googleID: googleID})
+	return us.ID
+}
+
+// SetStoredBalance overwrites a pool's maintained balance without touching
+// the ledger, as a stray manual edit would. It exists so tests can check and
+// reconcile balances.
+func (s *Memory) SetStoredBalance(poolID int, balance float64) {
+	d, done := s.begin()
+	defer done()
+	d.balances[poolID] = toCents(balance)
+}
+
+// SetLedgerAmount overwrites a ledger entry's amount without rehashing it,
+// as a stray manual edit would. It exists so tests can check the hash chain.
+func (s *Memory) SetLedgerAmount(ledgerID int, amount float64) {
+	d, done := s.begin()
+	defer done()
+	for i := range d.ledger {
+		if d.ledger[i].ID == ledgerID {
+			d.ledger[i].Amount = amount
+		}
+	}
+}
+
+// --- Site ---
+
+func (s *Memory) GetSite(ctx context.Context) (*models.SiteInstance, error) {
+	d, done := s.begin()
+	defer done()
+	site := d.site
+	return &site, nil
+}
+
+func (s *Memory) SetSiteTitle(ctx context.Context, title string) error {
+	d, done := s.begin()
+	defer done()
+	d.site.SiteTitle = title
+	return nil
+}
+
+// --- Pools ---
+
+func (d *memData) pool(id int) (int, bool) {
+	for i, p := range d.pools {
+		if p.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (d *memData) ledgerEntry(id int) (models.LedgerEntry, bool) {
+	for _, e := range d.ledger {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return models.LedgerEntry{}, false
+}
+
+// balance returns a pool's maintained balance.
+func (d *memData) balance(poolID int) float64 {
+	return float64(d.balances[poolID]) / 100
+}
+
+// ledgerBalance sums a pool's balance from its allocations.
+func (d *memData) ledgerBalance(poolID int) float64 {
+	var cents int64
+	for _, a := range d.allocations {
+		if a.FundingPoolID != poolID {
+			continue
+		}
+		e, _ := d.ledgerEntry(a.LedgerID)
+		switch e.TransactionType {
+		case "deposit":
+			cents += toCents(a.Amount)
+		case "withdrawal":
+			cents -= toCents(a.Amount)
+		}
+	}
+	return float64(cents) / 100
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func (s *Memory) ListPools(ctx context.Context, includeArchived bool) ([]Pool, error) {
+	d, done := s.begin()
+	defer done()
+	pools := make([]Pool, 0)
+	for _, p := range d.pools {
+		if p.ArchivedAt != nil && !includeArchived {
+			continue
+		}
+		pool := p
+		pool.CurrentAmount = d.balance(p.ID)
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].ID < pools[j].ID })
+	return pools, nil
+}
+
+func (s *Memory) GetPool(ctx context.Context, id int) (*Pool, error) {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.pool(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	pool := d.pools[i]
+	pool.CurrentAmount = d.balance(id)
+	return &pool, nil
+}
+
+func (s *Memory) PoolExists(ctx context.Context, id int) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	_, ok := d.pool(id)
+	return ok, nil
+}
+
+func (s *Memory) LockPool(ctx context.Context, id int) (*Pool, error) {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.pool(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	pool := d.pools[i]
+	return &pool, nil
+}
+
+// LockAllPools does nothing: transactions already run one at a time.
+func (s *Memory) LockAllPools(ctx context.Context) error {
+	return nil
+}
+
+func poolFromRequest(id int, req *models.CreateFundingPoolRequest) models.FundingPool {
+	return models.FundingPool{
+		ID:             id,
+		Name:           req.Name,
+		Description:    req.Description,
+		GoalAmount:     roundCents(req.GoalAmount),
+		CapAmount:      roundCentsPtr(req.CapAmount),
+		OverflowPolicy: req.OverflowPolicy,
+		OverflowPoolID: req.OverflowPoolID,
+	}
+}
+
+// nameTaken reports whether a pool other than id has the given name.
+func (d *memData) nameTaken(name string, id int) bool {
+	for _, p := range d.pools {
+		if p.Name == name && p.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Memory) CreatePool(ctx context.Context, req *models.CreateFundingPoolRequest) (int, error) {
+	d, done := s.begin()
+	defer done()
+	if d.nameTaken(req.Name, 0) {
+		return 0, constraintError("duplicate funding pool name")
+	}
+	id := d.nextID()
+	d.pools = append(d.pools, Pool{FundingPool: poolFromRequest(id, req)})
+	return id, nil
+}
+
+// constraintError stands in for the constraint violations the database
+// would report.
+type constraintError string
+
+func (e constraintError) Error() string {
+	return "store: " + string(e)
+}
+
+func (s *Memory) UpdatePool(ctx context.Context, id int, req *models.CreateFundingPoolRequest) error {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.pool(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if d.nameTaken(req.Name, id) {
+		return constraintError("duplicate funding pool name")
+	}
+	p := d.pools[i]
+	updated := poolFromRequest(id, req)
+	updated.ArchivedAt = p.ArchivedAt
+	p.FundingPool = updated
+	d.pools[i] = p
+	return nil
+}
+
+func (s *Memory) DeletePool(ctx context.Context, id int) error {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.pool(id)
+	if !ok {
+		return ErrNotFound
+	}
+	d.pools = append(d.pools[:i:i], d.pools[i+1:]...)
+	delete(d.balances, id)
+	// Pools redirecting to this one stop pointing at it.
+	for j, p := range d.pools {
+		if p.OverflowPoolID != nil && *p.OverflowPoolID == id {
+			d.pools[j].OverflowPoolID = nil
+		}
+	}
+	return nil
+}
+
+func (s *Memory) SetPoolArchived(ctx context.Context, id int, archived bool) error {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.pool(id)
+	if !ok {
+		return ErrNotFound
+	}
+	d.pools[i].ArchivedAt = nil
+	if archived {
+		now := time.Now()
+		d.pools[i].ArchivedAt = &now
+	}
+	return nil
+}
+
+func (s *Memory) SetPoolImage(ctx context.Context, id int, imageKey, thumbnailKey *string) error {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.pool(id)
+	if !ok {
+		return ErrNotFound
+	}
+	d.pools[i].ImageKey = imageKey
+	d.pools[i].ThumbnailKey = thumbnailKey
+	return nil
+}
+
+func (s *Memory) PoolHasAllocations(ctx context.Context, id int) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	for _, a := range d.allocations {
+		if a.FundingPoolID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Memory) PoolBalance(ctx context.Context, id int) (float64, error) {
+	d, done := s.begin()
+	defer done()
+	return d.balance(id), nil
+}
+
+func (s *Memory) PoolDailyTotals(ctx context.Context, id int) ([]DailyTotal, error) {
+	d, done := s.begin()
+	defer done()
+
+	type cents struct{ deposits, withdrawals int64 }
+	byDay := make(map[time.Time]*cents)
+	for _, a := range d.allocations {
+		if a.FundingPoolID != id {
+			continue
+		}
+		e, _ := d.ledgerEntry(a.LedgerID)
+		t := e.Timestamp.UTC()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		c, ok := byDay[day]
+		if !ok {
+			c = &cents{}
+			byDay[day] = c
+		}
+		if e.TransactionType == "withdrawal" {
+			c.withdrawals += toCents(a.Amount)
+		} else {
+			c.deposits += toCents(a.Amount)
+		}
+	}
+
+	totals := make([]DailyTotal, 0, len(byDay))
+	for day, c := range byDay {
+		totals = append(totals, DailyTotal{Day: day, Deposits: float64(c.deposits) / 100, Withdrawals: float64(c.withdrawals) / 100})
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Day.Before(totals[j].Day) })
+	return totals, nil
+}
+
+func (s *Memory) RecordPoolRevision(ctx context.Context, rev NewPoolRevision) error {
+	changes, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return err
+	}
+
+	d, done := s.begin()
+	defer done()
+	r := memRevision{moderator: rev.ModeratorGoogleID, changes: changes}
+	r.ID = d.nextID()
+	r.FundingPoolID = rev.FundingPoolID
+	r.Action = rev.Action
+	r.CreatedAt = time.Now()
+	d.revisions = append(d.revisions, r)
+	return nil
+}
+
+// revision returns a stored revision with its changes decoded, as they would
+// be read back from a JSON column.
+func (r memRevision) revision() (models.FundingPoolRevision, error) {
+	rev := r.FundingPoolRevision
+	err := json.Unmarshal(r.changes, &rev.Changes)
+	return rev, err
+}
+
+func (s *Memory) ListPoolRevisions(ctx context.Context, poolID int) ([]PoolRevision, error) {
+	d, done := s.begin()
+	defer done()
+	revisions := make([]PoolRevision, 0)
+	for i := len(d.revisions) - 1; i >= 0; i-- {
+		if d.revisions[i].FundingPoolID != poolID {
+			continue
+		}
+		rev, err := d.revisions[i].revision()
+		if err != nil {
+			return nil, err
+		}
+		r := PoolRevision{FundingPoolRevision: rev}
+		if u, ok := d.user(d.revisions[i].moderator); ok {
+			r.Moderator = d.users[u].donorProfile()
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func (s *Memory) ModeratorRevisions(ctx context.Context, googleID string) ([]models.FundingPoolRevision, error) {
+	d, done := s.begin()
+	defer done()
+	revisions := make([]models.FundingPoolRevision, 0)
+	for _, r := range d.revisions {
+		if r.moderator != googleID {
+			continue
+		}
+		rev, err := r.revision()
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
+
+func (s *Memory) AnonymizeModeratorRevisions(ctx context.Context, googleID string) (int64, error) {
+	d, done := s.begin()
+	defer done()
+	var n int64
+	for i := range d.revisions {
+		if d.revisions[i].moderator == googleID {
+			d.revisions[i].moderator = ""
+			n++
+		}
+	}
+	return n, nil
+}
+
+// --- Ledger ---
+
+func (s *Memory) CreateLedgerEntry(ctx context.Context, data LedgerEntryData) (int, error) {
+	d, done := s.begin()
+	defer done()
+	return d.createLedgerEntry(data)
+}
+
+// createLedgerEntry records an entry and its allocations, and hashes it.
+func (d *memData) createLedgerEntry(data LedgerEntryData) (int, error) {
+	for _, alloc := range data.Allocations {
+		if _, ok := d.pool(alloc.FundingPoolID); !ok && alloc.Amount > 0 {
+			return 0, constraintError("unknown funding pool")
+		}
+	}
+
+	salt, err := ledgerchain.NewSalt()
+	if err != nil {
+		return 0, err
+	}
+	e := models.LedgerEntry{
+		ID:              d.nextID(),
+		Amount:          roundCents(data.Amount),
+		Timestamp:       time.Now(),
+		TransactionType: data.TransactionType,
+		Anonymous:       data.Anonymous,
+		DonorSalt:       salt,
+	}
+	if data.TransactionID.Valid {
+		e.TransactionID = &data.TransactionID.String
+	}
+	if data.UserGoogleID.Valid {
+		e.UserGoogleID = &data.UserGoogleID.String
+	}
+	if data.FirstName.Valid {
+		e.FirstName = &data.FirstName.String
+	}
+	if data.LastInitial.Valid {
+		e.LastInitial = &data.LastInitial.String
+	}
+	if data.Description.Valid {
+		e.Description = &data.Description.String
+	}
+	if data.AnonymizedLedgerID.Valid {
+		id := int(data.AnonymizedLedgerID.Int64)
+		e.AnonymizedLedgerID = &id
+	}
+	if data.DonorCommitment.Valid {
+		e.DonorCommitment = &data.DonorCommitment.String
+	}
+	d.ledger = append(d.ledger, e)
+
+	for _, alloc := range data.Allocations {
+		if alloc.Amount > 0 {
+			d.allocations = append(d.allocations, models.Allocation{
+				ID:            d.nextID(),
+				LedgerID:      e.ID,
+				FundingPoolID: alloc.FundingPoolID,
+				Amount:        roundCents(alloc.Amount),
+			})
+			if data.TransactionType == "withdrawal" {
+				d.balances[alloc.FundingPoolID] -= toCents(alloc.Amount)
+			} else {
+				d.balances[alloc.FundingPoolID] += toCents(alloc.Amount)
+			}
+		}
+	}
+
+	// Entries are appended in ID order, so the previous one is the last.
+	last := len(d.ledger) - 1
+	e.PrevHash = ledgerchain.Genesis
+	if last > 0 {
+		e.PrevHash = d.ledger[last-1].Hash
+	}
+	e.Hash = ledgerchain.Hash(e.PrevHash, d.withAllocations([]models.LedgerEntry{e})[0])
+	d.ledger[last].PrevHash, d.ledger[last].Hash = e.PrevHash, e.Hash
+	return e.ID, nil
+}
+
+// SealLedger has nothing to do: entries are hashed as they are recorded.
+func (s *Memory) SealLedger(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// withAllocations returns entries with their allocations attached.
+func (d *memData) withAllocations(entries []models.LedgerEntry) []models.LedgerEntry {
+	index := make(map[int]int, len(entries))
+	for i := range entries {
+		entries[i].Allocations = []models.Allocation{}
+		index[entries[i].ID] = i
+	}
+	for _, a := range d.allocations {
+		if i, ok := index[a.LedgerID]; ok {
+			entries[i].Allocations = append(entries[i].Allocations, a)
+		}
+	}
+	return entries
+}
+
+func (s *Memory) CheckPoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error) {
+	d, done := s.begin()
+	defer done()
+	return d.balanceDrift(), nil
+}
+
+func (s *Memory) ReconcilePoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error) {
+	d, done := s.begin()
+	defer done()
+	drift := d.balanceDrift()
+	for _, p := range drift {
+		d.balances[p.FundingPoolID] = toCents(p.Ledger)
+	}
+	return drift, nil
+}
+
+// balanceDrift compares every pool's maintained balance with the one summed
+// from the ledger, by pool ID.
+func (d *memData) balanceDrift() []models.PoolBalanceDrift {
+	drift := make([]models.PoolBalanceDrift, 0)
+	ids := make([]int, 0, len(d.pools))
+	for _, p := range d.pools {
+		ids = append(ids, p.ID)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		stored, ledger := d.balance(id), d.ledgerBalance(id)
+		if toCents(stored) != toCents(ledger) {
+			drift = append(drift, models.PoolBalanceDrift{FundingPoolID: id, Stored: stored, Ledger: ledger})
+		}
+	}
+	return drift
+}
+
+func (s *Memory) ListLedgerEntries(ctx context.Context) ([]models.LedgerEntry, error) {
+	d, done := s.begin()
+	defer done()
+	entries := append([]models.LedgerEntry{}, d.ledger...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Timestamp.Equal(entries[j].Timestamp) {
+			return entries[i].Timestamp.After(entries[j].Timestamp)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+	return d.withAllocations(entries), nil
+}
+
+func (s *Memory) LedgerTotals(ctx context.Context) (float64, float64, error) {
+	d, done := s.begin()
+	defer done()
+	var deposits, withdrawals int64
+	for _, e := range d.ledger {
+		switch e.TransactionType {
+		case "deposit":
+			deposits += toCents(e.Amount)
+		case "withdrawal":
+			withdrawals += toCents(e.Amount)
+		}
+	}
+	return float64(deposits) / 100, float64(withdrawals) / 100, nil
+}
+
+func (s *Memory) UserLedgerEntries(ctx context.Context, googleID string) ([]models.LedgerEntry, error) {
+	d, done := s.begin()
+	defer done()
+	entries := make([]models.LedgerEntry, 0)
+	for _, e := range d.ledger {
+		if e.UserGoogleID != nil && *e.UserGoogleID == googleID {
+			entries = append(entries, e)
+		}
+	}
+	return d.withAllocations(entries), nil
+}
+
+// userDonationEntries returns a user's PayPal donations, newest first.
+func (d *memData) userDonationEntries(googleID string) []models.LedgerEntry {
+	var entries []models.LedgerEntry
+	for _, e := range d.ledger {
+		if e.UserGoogleID != nil && *e.UserGoogleID == googleID && e.TransactionType == "deposit" && e.TransactionID != nil {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Timestamp.Equal(entries[j].Timestamp) {
+			return entries[i].Timestamp.After(entries[j].Timestamp)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+	return entries
+}
+
+func (s *Memory) UserDonations(ctx context.Context, googleID string, year *int) ([]models.UserDonation, error) {
+	d, done := s.begin()
+	defer done()
+	from, to := yearRange(year)
+
+	donations := make([]models.UserDonation, 0)
+	for _, e := range d.withAllocations(d.userDonationEntries(googleID)) {
+		if from.Valid && (e.Timestamp.Before(from.Time) || !e.Timestamp.Before(to.Time)) {
+			continue
+		}
+		if len(e.Allocations) == 0 {
+			continue // Matches the inner join on allocations.
+		}
+		donation := models.UserDonation{
+			ID:            e.ID,
+			TransactionID: *e.TransactionID,
+			Amount:        e.Amount,
+			Timestamp:     e.Timestamp,
+			Anonymous:     e.Anonymous,
+			Description:   e.Description,
+		}
+		for _, a := range e.Allocations {
+			alloc := models.DonationAllocation{FundingPoolID: a.FundingPoolID, Amount: a.Amount}
+			if i, ok := d.pool(a.FundingPoolID); ok {
+				alloc.FundingPoolName = d.pools[i].Name
+			}
+			donation.Allocations = append(donation.Allocations, alloc)
+		}
+		donations = append(donations, donation)
+	}
+	return donations, nil
+}
+
+func (s *Memory) UserDonationYears(ctx context.Context, googleID string) ([]models.YearDonationTotal, error) {
+	d, done := s.begin()
+	defer done()
+	years := make([]models.YearDonationTotal, 0)
+	for _, e := range d.userDonationEntries(googleID) {
+		year := e.Timestamp.UTC().Year()
+		if n := len(years); n == 0 || years[n-1].Year != year {
+			years = append(years, models.YearDonationTotal{Year: year})
+		}
+		y := &years[len(years)-1]
+		y.Count++
+		y.Total = float64(toCents(y.Total)+toCents(e.Amount)) / 100
+	}
+	return years, nil
+}
+
+func (s *Memory) AnonymizeUserLedger(ctx context.Context, googleID string) (int64, error) {
+	d, done := s.begin()
+	defer done()
+	var erased []models.LedgerEntry
+	for i, e := range d.ledger {
+		if e.UserGoogleID != nil && *e.UserGoogleID == googleID {
+			erased = append(erased, e)
+			e.UserGoogleID, e.FirstName, e.LastInitial, e.DonorSalt = nil, nil, nil, ""
+			e.Anonymous = e.Anonymous || e.TransactionType == "deposit"
+			d.ledger[i] = e
+		}
+	}
+	for _, e := range erased {
+		if _, err := d.createLedgerEntry(anonymizedEntry(e)); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(erased)), nil
+}
+
+// --- Users ---
+
+func (d *memData) user(googleID string) (int, bool) {
+	for i, u := range d.users {
+		if u.GoogleID == googleID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Memory) UserIDForIdentity(ctx context.Context, provider, subject string) (string, error) {
+	d, done := s.begin()
+	defer done()
+	for _, i := range d.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i.googleID, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (s *Memory) UserIDForEmail(ctx context.Context, email string) (string, error) {
+	d, done := s.begin()
+	defer done()
+	for _, u := range d.users {
+		if strings.EqualFold(u.Email, email) {
+			return u.GoogleID, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (s *Memory) UpsertUser(ctx context.Context, u User) (*User, error) {
+	d, done := s.begin()
+	defer done()
+	for _, other := range d.users {
+		if other.Email == u.Email && other.GoogleID != u.GoogleID {
+			return nil, constraintError("duplicate user email")
+		}
+	}
+	if i, ok := d.user(u.GoogleID); ok {
+		u.CreatedAt = d.users[i].CreatedAt
+		d.users[i].User = u
+	} else {
+		u.CreatedAt = time.Now()
+		d.users = append(d.users, memUser{User: u})
+	}
+	return &u, nil
+}
+
+func (s *Memory) UpsertIdentity(ctx context.Context, provider, subject, googleID, email string) error {
+	d, done := s.begin()
+	defer done()
+	now := time.Now()
+	for i, identity := range d.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			identity.Email = &email
+			identity.LastLoginAt = now
+			d.identities[i] = identity
+			return nil
+		}
+	}
+	if _, ok := d.user(googleID); !ok {
+		return ErrNotFound
+	}
+	identity := memIdentity{googleID: googleID}
+	identity.Provider = provider
+	identity.Subject = subject
+	identity.Email = &email
+	identity.CreatedAt = now
+	identity.LastLoginAt = now
+	d.identities = append(d.identities, identity)
+	return nil
+}
+
+func (s *Memory) GetUser(ctx context.Context, googleID string) (*User, error) {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.user(googleID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	u := d.users[i].User
+	return &u, nil
+}
+
+func (s *Memory) LockUser(ctx context.Context, googleID string) (*User, error) {
+	return s.GetUser(ctx, googleID)
+}
+
+func (s *Memory) ListUsers(ctx context.Context) ([]models.AdminUser, error) {
+	d, done := s.begin()
+	defer done()
+	users := make([]models.AdminUser, 0, len(d.users))
+	for _, u := range d.users {
+		users = append(users, models.AdminUser{
+			GoogleID:   u.GoogleID,
+			Email:      u.Email,
+			FirstName:  u.FirstName,
+			LastName:   u.LastName,
+			DonateOnly: u.DonateOnly,
+			CreatedAt:  u.CreatedAt,
+			Roles:      d.grantedRoles(u.GoogleID),
+		})
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Email) < strings.ToLower(users[j].Email)
+	})
+	return users, nil
+}
+
+func (s *Memory) UserIdentities(ctx context.Context, googleID string) ([]models.UserIdentity, error) {
+	d, done := s.begin()
+	defer done()
+	identities := make([]models.UserIdentity, 0)
+	for _, i := range d.identities {
+		if i.googleID == googleID {
+			identities = append(identities, i.UserIdentity)
+		}
+	}
+	return identities, nil
+}
+
+func (s *Memory) DeleteUser(ctx context.Context, googleID string) error {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.user(googleID)
+	if !ok {
+		return ErrNotFound
+	}
+	d.users = append(d.users[:i:i], d.users[i+1:]...)
+
+	for j, a := range d.audit {
+		if a.ActorGoogleID != nil && *a.ActorGoogleID == googleID {
+			a.ActorGoogleID = nil
+		}
+		if a.TargetGoogleID != nil && *a.TargetGoogleID == googleID {
+			a.TargetGoogleID = nil
+		}
+		d.audit[j] = a
+	}
+	for j, e := range d.ledger {
+		if e.UserGoogleID != nil && *e.UserGoogleID == googleID {
+			e.UserGoogleID = nil
+			d.ledger[j] = e
+		}
+	}
+
+	var roles []memRole
+	for _, r := range d.roles {
+		if r.googleID == googleID {
+			continue
+		}
+		if r.grantedBy == googleID {
+			r.grantedBy = ""
+		}
+		roles = append(roles, r)
+	}
+	d.roles = roles
+
+	var identities []memIdentity
+	for _, identity := range d.identities {
+		if identity.googleID != googleID {
+			identities = append(identities, identity)
+		}
+	}
+	d.identities = identities
+
+	var tokens []memToken
+	for _, t := range d.tokens {
+		if t.googleID != googleID {
+			tokens = append(tokens, t)
+		}
+	}
+	d.tokens = tokens
+
+	var sessions []memSession
+	for _, us := range d.sessions {
+		if us.googleID != googleID {
+			sessions = append(sessions, us)
+		}
+	}
+	d.sessions = sessions
+	return nil
+}
+
+func (d *memData) grantedRoles(googleID string) []string {
+	roles := []string{}
+	for _, r := range d.roles {
+		if r.googleID == googleID {
+			roles = append(roles, r.role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func (s *Memory) UserRoles(ctx context.Context, googleID string) ([]auth.Role, error) {
+	d, done := s.begin()
+	defer done()
+	roles := []auth.Role{}
+	if i, ok := d.user(googleID); !ok || d.users[i].DonateOnly {
+		return roles, nil
+	}
+	for _, role := range d.grantedRoles(googleID) {
+		roles = append(roles, auth.Role(role))
+	}
+	return roles, nil
+}
+
+func (s *Memory) GrantedRoles(ctx context.Context, googleID string) ([]string, error) {
+	d, done := s.begin()
+	defer done()
+	return d.grantedRoles(googleID), nil
+}
+
+func (s *Memory) GrantRole(ctx context.Context, googleID, role, grantedBy string) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	if _, ok := d.user(googleID); !ok {
+		return false, ErrNotFound
+	}
+	for _, r := range d.roles {
+		if r.googleID == googleID && r.role == role {
+			return false, nil
+		}
+	}
+	d.roles = append(d.roles, memRole{googleID: googleID, role: role, grantedBy: grantedBy, grantedAt: time.Now()})
+	return true, nil
+}
+
+func (s *Memory) RevokeRole(ctx context.Context, googleID, role string) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	for i, r := range d.roles {
+		if r.googleID == googleID && r.role == role {
+			d.roles = append(d.roles[:i:i], d.roles[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Memory) LockRoleHolders(ctx context.Context, role string) ([]string, error) {
+	d, done := s.begin()
+	defer done()
+	holders := []string{}
+	for _, r := range d.roles {
+		if r.role != role {
+			continue
+		}
+		if i, ok := d.user(r.googleID); ok && !d.users[i].DonateOnly {
+			holders = append(holders, r.googleID)
+		}
+	}
+	sort.Strings(holders)
+	return holders, nil
+}
+
+func (s *Memory) DonorProfile(ctx context.Context, googleID string) (*DonorProfile, error) {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.user(googleID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return d.users[i].donorProfile(), nil
+}
+
+func (u memUser) donorProfile() *DonorProfile {
+	p := &DonorProfile{
+		Email:            u.Email,
+		FirstName:        nullString(u.FirstName),
+		LastName:         nullString(u.LastName),
+		DefaultAnonymous: u.defaultAnonymous,
+		ShowFullName:     u.showFullName,
+	}
+	if u.displayName != nil {
+		p.DisplayName.String, p.DisplayName.Valid = *u.displayName, true
+	}
+	return p
+}
+
+func (s *Memory) UpdateProfile(ctx context.Context, googleID string, displayName *string, defaultAnonymous, showFullName bool) error {
+	d, done := s.begin()
+	defer done()
+	i, ok := d.user(googleID)
+	if !ok {
+		return ErrNotFound
+	}
+	d.users[i].displayName = displayName
+	d.users[i].defaultAnonymous = defaultAnonymous
+	d.users[i].showFullName = showFullName
+	return nil
+}
+
+// --- API tokens ---
+
+func (s *Memory) CreateAPIToken(ctx context.Context, googleID string, t NewAPIToken) (*models.APIToken, error) {
+	d, done := s.begin()
+	defer done()
+	if _, ok := d.user(googleID); !ok {
+		return nil, ErrNotFound
+	}
+	token := models.APIToken{
+		ID:        d.nextID(),
+		Name:      t.Name,
+		Prefix:    t.Prefix,
+		Scopes:    append([]string{}, t.Scopes...),
+		CreatedAt: time.Now(),
+		ExpiresAt: t.ExpiresAt,
+	}
+	d.tokens = append(d.tokens, memToken{APIToken: token, googleID: googleID, hash: t.Hash})
+	return &token, nil
+}
+
+func (s *Memory) ListAPITokens(ctx context.Context, googleID string) ([]models.APIToken, error) {
+	d, done := s.begin()
+	defer done()
+	tokens := make([]models.APIToken, 0)
+	for i := len(d.tokens) - 1; i >= 0; i-- {
+		if d.tokens[i].googleID == googleID {
+			tokens = append(tokens, d.tokens[i].APIToken)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *Memory) RevokeAPIToken(ctx context.Context, googleID string, id int) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	for i, t := range d.tokens {
+		if t.ID == id && t.googleID == googleID && t.RevokedAt == nil {
+			now := time.Now()
+			d.tokens[i].RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Memory) UseAPIToken(ctx context.Context, hash string) (string, []string, error) {
+	d, done := s.begin()
+	defer done()
+	now := time.Now()
+	for i, t := range d.tokens {
+		if t.hash != hash || t.RevokedAt != nil || (t.ExpiresAt != nil && !t.ExpiresAt.After(now)) {
+			continue
+		}
+		d.tokens[i].LastUsedAt = &now
+		return t.googleID, append([]string{}, t.Scopes...), nil
+	}
+	return "", nil, ErrNotFound
+}
+
+// --- Sessions ---
+
+func (s *Memory) ListUserSessions(ctx context.Context, googleID string) ([]models.UserSession, error) {
+	d, done := s.begin()
+	defer done()
+	now := time.Now()
+	userSessions := make([]models.UserSession, 0)
+	for _, us := range d.sessions {
+		if us.googleID == googleID && us.ExpiresAt.After(now) {
+			userSessions = append(userSessions, us.UserSession)
+		}
+	}
+	sort.SliceStable(userSessions, func(i, j int) bool {
+		return userSessions[i].LastSeenAt.After(userSessions[j].LastSeenAt)
+	})
+	return userSessions, nil
+}
+
+func (s *Memory) RevokeUserSessions(ctx context.Context, googleID string) (int64, error) {
+	d, done := s.begin()
+	defer done()
+	var kept []memSession
+	for _, us := range d.sessions {
+		if us.googleID != googleID {
+			kept = append(kept, us)
+		}
+	}
+	n := int64(len(d.sessions) - len(kept))
+	d.sessions = kept
+	return n, nil
+}
+
+func (s *Memory) RevokeUserSession(ctx context.Context, googleID string, id int) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	for i, us := range d.sessions {
+		if us.ID == id && us.googleID == googleID {
+			d.sessions = append(d.sessions[:i:i], d.sessions[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// --- Audit log ---
+
+func (s *Memory) RecordAudit(ctx context.Context, actorGoogleID, action, targetGoogleID string, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	d, done := s.begin()
+	defer done()
+	e := models.AuditLogEntry{ID: d.nextID(), Action: action, Details: detailsJSON, CreatedAt: time.Now()}
+	if actorGoogleID != "" {
+		e.ActorGoogleID = &actorGoogleID
+	}
+	if targetGoogleID != "" {
+		e.TargetGoogleID = &targetGoogleID
+	}
+	d.audit = append(d.audit, memAudit{e})
+	return nil
+}
+
+func (s *Memory) AuditLog(ctx context.Context, before *int64, limit int) ([]models.AuditLogEntry, error) {
+	d, done := s.begin()
+	defer done()
+	entries := make([]models.AuditLogEntry, 0)
+	for i := len(d.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		if before != nil && int64(d.audit[i].ID) >= *before {
+			continue
+		}
+		entries = append(entries, d.audit[i].AuditLogEntry)
+	}
+	return entries, nil
+}
+
+func (s *Memory) UserAuditLog(ctx context.Context, googleID string) ([]models.AuditLogEntry, error) {
+	d, done := s.begin()
+	defer done()
+	entries := make([]models.AuditLogEntry, 0)
+	for _, e := range d.audit {
+		if (e.ActorGoogleID != nil && *e.ActorGoogleID == googleID) || (e.TargetGoogleID != nil && *e.TargetGoogleID == googleID) {
+			entries = append(entries, e.AuditLogEntry)
+		}
+	}
+	return entries, nil
+}
+
+// --- Backups ---
+
+func (s *Memory) IsEmpty(ctx context.Context) (bool, error) {
+	d, done := s.begin()
+	defer done()
+	return len(d.users) == 0 && len(d.pools) == 0 && len(d.ledger) == 0, nil
+}
+
+func (s *Memory) BackupUsers(ctx context.Context) ([]models.BackupUser, error) {
+	d, done := s.begin()
+	defer done()
+	users := make([]models.BackupUser, 0, len(d.users))
+	for _, u := range d.users {
+		b := models.BackupUser{
+			GoogleID:         u.GoogleID,
+			Email:            u.Email,
+			FirstName:        u.FirstName,
+			LastName:         u.LastName,
+			DonateOnly:       u.DonateOnly,
+			CreatedAt:        u.CreatedAt,
+			DisplayName:      u.displayName,
+			DefaultAnonymous: u.defaultAnonymous,
+			ShowFullName:     u.showFullName,
+			Identities:       []models.UserIdentity{},
+			Roles:            []models.BackupRole{},
+		}
+		for _, i := range d.identities {
+			if i.googleID == u.GoogleID {
+				b.Identities = append(b.Identities, i.UserIdentity)
+			}
+		}
+		sort.SliceStable(b.Identities, func(i, j int) bool { return b.Identities[i].CreatedAt.Before(b.Identities[j].CreatedAt) })
+		for _, r := range d.roles {
+			if r.googleID == u.GoogleID {
+				role := models.BackupRole{Role: r.role, GrantedAt: r.grantedAt}
+				if r.grantedBy != "" {
+					grantedBy := r.grantedBy
+					role.GrantedBy = &grantedBy
+				}
+				b.Roles = append(b.Roles, role)
+			}
+		}
+		sort.Slice(b.Roles, func(i, j int) bool { return b.Roles[i].Role < b.Roles[j].Role })
+		users = append(users, b)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].GoogleID < users[j].GoogleID })
+	return users, nil
+}
+
+func (s *Memory) Restore(ctx context.Context, a *models.BackupArchive) error {
+	d, done := s.begin()
+	defer done()
+	seen := func(id int) {
+		if id > d.lastID {
+			d.lastID = id
+		}
+	}
+
+	d.site = a.Site
+	for _, p := range a.Pools {
+		pool := Pool{FundingPool: p.FundingPool, ImageKey: p.ImageKey, ThumbnailKey: p.ThumbnailKey}
+		pool.CurrentAmount = 0
+		pool.DescriptionHTML, pool.ImageURL, pool.ThumbnailURL = nil, nil, nil
+		pool.GoalAmount = roundCents(pool.GoalAmount)
+		pool.CapAmount = roundCentsPtr(pool.CapAmount)
+		d.pools = append(d.pools, pool)
+		seen(p.ID)
+	}
+
+	for _, u := range a.Users {
+		d.users = append(d.users, memUser{
+			User: User{
+				GoogleID:   u.GoogleID,
+				Email:      u.Email,
+				FirstName:  u.FirstName,
+				LastName:   u.LastName,
+				DonateOnly: u.DonateOnly,
+				CreatedAt:  u.CreatedAt,
+			},
+			displayName:      u.DisplayName,
+			defaultAnonymous: u.DefaultAnonymous,
+			showFullName:     u.ShowFullName,
+		})
+		for _, i := range u.Identities {
+			d.identities = append(d.identities, memIdentity{UserIdentity: i, googleID: u.GoogleID})
+		}
+		for _, r := range u.Roles {
+			role := memRole{googleID: u.GoogleID, role: r.Role, grantedAt: r.GrantedAt}
+			if r.GrantedBy != nil {
+				role.grantedBy = *r.GrantedBy
+			}
+			d.roles = append(d.roles, role)
+		}
+	}
+
+	for _, e := range a.Ledger {
+		entry := e.LedgerEntry
+		entry.DonorSalt = e.DonorSalt
+		entry.Amount = roundCents(e.Amount)
+		entry.Allocations = nil
+		d.ledger = append(d.ledger, entry)
+		seen(e.ID)
+		for _, alloc := range e.Allocations {
+			alloc.LedgerID = e.ID
+			alloc.Amount = roundCents(alloc.Amount)
+			d.allocations = append(d.allocations, alloc)
+			seen(alloc.ID)
+		}
+	}
+	sort.SliceStable(d.ledger, func(i, j int) bool { return d.ledger[i].ID < d.ledger[j].ID })
+
+	for _, p := range d.pools {
+		d.balances[p.ID] = toCents(d.ledgerBalance(p.ID))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"pool-party-api/models"
+)
+
+// Postgres is the Store backed by a Postgres database.
+type Postgres struct {
+	db *sql.DB
+	q  dbtx
+	tx *sql.Tx // Set inside a transaction.
+}
+
+var _ Store = (*Postgres)(nil)
+
+// NewPostgres returns a Store using db.
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db, q: db}
+}
+
+func (s *Postgres) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(ctx, nil, fn)
+}
+
+func (s *Postgres) WithSnapshot(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, fn)
+}
+
+func (s *Postgres) withTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Postgres{db: s.db, q: tx, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// --- Site ---
+
+func (s *Postgres) GetSite(ctx context.Context) (*models.SiteInstance, error) {
+	var site models.SiteInstance
+	var headline sql.NullString
+	query := `SELECT site_title, site_headline FROM site_instance WHERE id = 1`
+	if err := s.q.QueryRowContext(ctx, query).Scan(&site.SiteTitle, &headline); err != nil {
+		return nil, notFound(err)
+	}
+	if headline.Valid {
+		site.SiteHeadline = &headline.String
+	}
+	return &site, nil
+}
+
+func (s *Postgres) SetSiteTitle(ctx context.Context, title string) error {
+	return execOne(ctx, s.q, `UPDATE site_instance SET site_title = $1 WHERE id = 1`, title)
+}
+
+// --- Audit log ---
+
+func (s *Postgres) RecordAudit(ctx context.Context, actorGoogleID, action, targetGoogleID string, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_google_id, action, target_google_id, details)
+		VALUES ($1, $2, $3, $4)`
+	_, err = s.q.ExecContext(ctx, query, nullString(actorGoogleID), action, nullString(targetGoogleID), string(detailsJSON))
+	return err
+}
+
+func (s *Postgres) AuditLog(ctx context.Context, before *int64, limit int) ([]models.AuditLogEntry, error) {
+	query := `
+		SELECT id, actor_google_id, action, target_google_id, details, created_at
+		FROM audit_log
+		WHERE $1::bigint IS NULL OR id < $1
+		ORDER BY id DESC
+		LIMIT $2`
+	rows, err := s.q.QueryContext(ctx, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuditLog(rows)
+}
+
+func (s *Postgres) UserAuditLog(ctx context.Context, googleID string) ([]models.AuditLogEntry, error) {
+	query := `
+		SELECT id, actor_google_id, action, target_google_id, details, created_at
+		FROM audit_log
+		WHERE actor_google_id = $1 OR target_google_id = $1
+		ORDER BY id`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuditLog(rows)
+}
+
+// scanAuditLog reads audit log entries selected as id, actor_google_id,
+// action, target_google_id, details, created_at.
+func scanAuditLog(rows *sql.Rows) ([]models.AuditLogEntry, error) {
+	entries := make([]models.AuditLogEntry, 0)
+	for rows.Next() {
+		var e models.AuditLogEntry
+		var actor, target sql.NullString
+		var details []byte
+		if err := rows.Scan(&e.ID, &actor, &e.Action, &target, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if actor.Valid {
+			e.ActorGoogleID = &actor.String
+		}
+		if target.Valid {
+			e.TargetGoogleID = &target.String
+		}
+		e.Details = json.RawMessage(details)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// --- Sessions ---
+
+func (s *Postgres) ListUserSessions(ctx context.Context, googleID string) ([]models.UserSession, error) {
+	query := `
+		SELECT id, created_at, last_seen_at, expires_at, COALESCE(user_agent, ''), COALESCE(ip_address, '')
+		FROM sessions
+		WHERE user_google_id = $1 AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_seen_at DESC`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userSessions := make([]models.UserSession, 0)
+	for rows.Next() {
+		var us models.UserSession
+		if err := rows.Scan(&us.ID, &us.CreatedAt, &us.LastSeenAt, &us.ExpiresAt, &us.UserAgent, &us.IPAddress); err != nil {
+			return nil, err
+		}
+		userSessions = append(userSessions, us)
+	}
+	return userSessions, rows.Err()
+}
+
+func (s *Postgres) RevokeUserSessions(ctx context.Context, googleID string) (int64, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM sessions WHERE user_google_id = $1`, googleID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Postgres) RevokeUserSession(ctx context.Context, googleID string, id int) (bool, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND user_google_id = $2`, id, googleID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// --- Backups ---
+
+func (s *Postgres) IsEmpty(ctx context.Context) (bool, error) {
+	return isEmpty(ctx, s.q)
+}
+
+func (s *Postgres) BackupUsers(ctx context.Context) ([]models.BackupUser, error) {
+	return backupUsers(ctx, s.q)
+}
+
+// postgresRebuild recomputes the tables derived from the ledger, and moves
+// the ID sequences past the restored IDs.
+var postgresRebuild = []string{
+	`INSERT INTO pool_balance (funding_pool_id, balance)
+	SELECT
+		a.funding_pool_id,
+		SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END)
+	FROM allocation a
+	JOIN ledger l ON a.ledger_id = l.id
+	GROUP BY a.funding_pool_id`,
+	`INSERT INTO pool_daily_rollup (funding_pool_id, day, deposits, withdrawals)
+	SELECT
+		a.funding_pool_id,
+		(l.timestamp AT TIME ZONE 'UTC')::date,
+		SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount ELSE 0 END),
+		SUM(CASE WHEN l.transaction_type = 'withdrawal' THEN a.amount ELSE 0 END)
+	FROM allocation a
+	JOIN ledger l ON a.ledger_id = l.id
+	GROUP BY 1, 2`,
+	`SELECT setval(pg_get_serial_sequence('funding_pool', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM funding_pool`,
+	`SELECT setval(pg_get_serial_sequence('ledger', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM ledger`,
+	`SELECT setval(pg_get_serial_sequence('allocation', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM allocation`,
+}
+
+func (s *Postgres) Restore(ctx context.Context, a *models.BackupArchive) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		return restore(ctx, tx.(*Postgres).q, a, postgresRebuild)
+	})
+}
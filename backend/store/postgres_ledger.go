@@ -0,0 +1,515 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"pool-party-api/ledgerchain"
+	"pool-party-api/models"
+	"time"
+)
+
+// ledgerLockID is the Postgres advisory lock held while appending to the
+// ledger, so that entries get IDs and join the hash chain in the same order.
+const ledgerLockID int64 = 7_301_994_125_011_043
+
+func (s *Postgres) CreateLedgerEntry(ctx context.Context, data LedgerEntryData) (int, error) {
+	var ledgerID int
+	err := s.WithTx(ctx, func(tx Store) error {
+		t := tx.(*Postgres)
+		if _, err := t.q.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+			return err
+		}
+		if _, err := sealLedger(ctx, t.q); err != nil {
+			return err
+		}
+		var err error
+		if ledgerID, err = t.insertLedgerEntry(ctx, data); err != nil {
+			return err
+		}
+		return hashLedgerEntry(ctx, t.q, ledgerID)
+	})
+	return ledgerID, err
+}
+
+func (s *Postgres) SealLedger(ctx context.Context) (int, error) {
+	var n int
+	err := s.WithTx(ctx, func(tx Store) error {
+		t := tx.(*Postgres)
+		if _, err := t.q.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+			return err
+		}
+		var err error
+		n, err = sealLedger(ctx, t.q)
+		return err
+	})
+	return n, err
+}
+
+// ledgerEntryColumns selects a ledger entry for scanLedgerEntries.
+const ledgerEntryColumns = `
+	id, transaction_id, amount, timestamp, transaction_type,
+	user_google_id, first_name, last_initial, description, anonymous, prev_hash, hash,
+	donor_salt, anonymized_ledger_id, donor_commitment`
+
+// sealLedger hashes the entries recorded before the hash chain existed,
+// oldest first, the first time it is called after migration 0003; they are
+// the entries up to ledger_chain.legacy_through. Later calls hash nothing, so
+// an entry whose hash goes missing afterwards stays unhashed and fails
+// verification. Callers must hold the ledger lock.
+func sealLedger(ctx context.Context, q dbtx) (int, error) {
+	var legacyThrough int
+	var sealed bool
+	err := q.QueryRowContext(ctx, `SELECT legacy_through, sealed FROM ledger_chain WHERE id = 1`).Scan(&legacyThrough, &sealed)
+	if err == sql.ErrNoRows || sealed {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := listLedgerEntries(ctx, q, `
+		SELECT `+ledgerEntryColumns+`
+		FROM ledger
+		WHERE id <= $1
+		ORDER BY id`, `
+		SELECT a.id, a.ledger_id, a.funding_pool_id, a.amount
+		FROM allocation a
+		WHERE a.ledger_id <= $1
+		ORDER BY a.id`, legacyThrough)
+	if err != nil {
+		return 0, err
+	}
+	ledgerchain.Seal(entries)
+	for _, e := range entries {
+		if _, err := q.ExecContext(ctx, `UPDATE ledger SET prev_hash = $1, hash = $2 WHERE id = $3`, e.PrevHash, e.Hash, e.ID); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := q.ExecContext(ctx, `UPDATE ledger_chain SET sealed = TRUE WHERE id = 1`); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// hashLedgerEntry adds a newly recorded entry to the hash chain, after the
+// last entry that has a hash. Callers must hold the ledger lock.
+func hashLedgerEntry(ctx context.Context, q dbtx, ledgerID int) error {
+	prevHash := ledgerchain.Genesis
+	err := q.QueryRowContext(ctx, `SELECT hash FROM ledger WHERE hash IS NOT NULL AND id < $1 ORDER BY id DESC LIMIT 1`, ledgerID).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	entries, err := listLedgerEntries(ctx, q, `
+		SELECT `+ledgerEntryColumns+`
+		FROM ledger
+		WHERE id = $1`,
+		`SELECT id, ledger_id, funding_pool_id, amount FROM allocation WHERE ledger_id = $1 ORDER BY id`, ledgerID)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 {
+		return ErrNotFound
+	}
+	hash := ledgerchain.Hash(prevHash, entries[0])
+	_, err = q.ExecContext(ctx, `UPDATE ledger SET prev_hash = $1, hash = $2 WHERE id = $3`, prevHash, hash, ledgerID)
+	return err
+}
+
+// insertLedgerEntry records an entry and its allocations, without hashing it.
+func (s *Postgres) insertLedgerEntry(ctx context.Context, data LedgerEntryData) (int, error) {
+	var ledgerID int
+	var timestamp time.Time
+	salt, err := ledgerchain.NewSalt()
+	if err != nil {
+		return 0, err
+	}
+	ledgerQuery := `
+		INSERT INTO ledger (transaction_id, amount, transaction_type, user_google_id, first_name, last_initial, anonymous, description,
+			donor_salt, anonymized_ledger_id, donor_commitment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, timestamp`
+	err = s.q.QueryRowContext(ctx, ledgerQuery, data.TransactionID, data.Amount, data.TransactionType, data.UserGoogleID, data.FirstName, data.LastInitial, data.Anonymous, data.Description,
+		salt, data.AnonymizedLedgerID, data.DonorCommitment).Scan(&ledgerID, &timestamp)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the pools' balances, and the daily rollup used by the pool history
+	// endpoint, in step with the ledger.
+	var deposits, withdrawals float64
+	rollupQuery := `
+		INSERT INTO pool_daily_rollup (funding_pool_id, day, deposits, withdrawals)
+		VALUES ($1, $2::date, $3, $4)
+		ON CONFLICT (funding_pool_id, day) DO UPDATE
+		SET deposits = pool_daily_rollup.deposits + EXCLUDED.deposits,
+			withdrawals = pool_daily_rollup.withdrawals + EXCLUDED.withdrawals`
+	balanceQuery := `
+		INSERT INTO pool_balance (funding_pool_id, balance)
+		VALUES ($1, $2)
+		ON CONFLICT (funding_pool_id) DO UPDATE
+		SET balance = pool_balance.balance + EXCLUDED.balance`
+	day := timestamp.UTC().Format(time.DateOnly)
+
+	for _, alloc := range data.Allocations {
+		if alloc.Amount > 0 {
+			if _, err := s.q.ExecContext(ctx, "INSERT INTO allocation (ledger_id, funding_pool_id, amount) VALUES ($1, $2, $3)", ledgerID, alloc.FundingPoolID, alloc.Amount); err != nil {
+				return 0, err
+			}
+
+			deposits, withdrawals = 0, 0
+			if data.TransactionType == "withdrawal" {
+				withdrawals = alloc.Amount
+			} else {
+				deposits = alloc.Amount
+			}
+			if _, err := s.q.ExecContext(ctx, rollupQuery, alloc.FundingPoolID, day, deposits, withdrawals); err != nil {
+				return 0, err
+			}
+			if _, err := s.q.ExecContext(ctx, balanceQuery, alloc.FundingPoolID, deposits-withdrawals); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return ledgerID, nil
+}
+
+// ledgerBalances selects every pool's maintained balance next to the one
+// summed from its allocations, as id, stored, ledger.
+const ledgerBalances = `
+	SELECT
+		fp.id,
+		COALESCE(pb.balance, 0),
+		COALESCE(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END), 0)
+	FROM funding_pool fp
+	LEFT JOIN pool_balance pb ON pb.funding_pool_id = fp.id
+	LEFT JOIN allocation a ON a.funding_pool_id = fp.id
+	LEFT JOIN ledger l ON a.ledger_id = l.id
+	GROUP BY fp.id, pb.balance
+	ORDER BY fp.id`
+
+func (s *Postgres) CheckPoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error) {
+	return checkPoolBalances(ctx, s.q, ledgerBalances)
+}
+
+func (s *Postgres) ReconcilePoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error) {
+	return reconcilePoolBalances(ctx, s.q, ledgerBalances)
+}
+
+// checkPoolBalances runs a query selecting id, stored balance, ledger balance
+// for every pool and returns the pools where the two differ.
+func checkPoolBalances(ctx context.Context, q dbtx, query string) ([]models.PoolBalanceDrift, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drift := make([]models.PoolBalanceDrift, 0)
+	for rows.Next() {
+		var d models.PoolBalanceDrift
+		if err := rows.Scan(&d.FundingPoolID, &d.Stored, &d.Ledger); err != nil {
+			return nil, err
+		}
+		if toCents(d.Stored) != toCents(d.Ledger) {
+			drift = append(drift, d)
+		}
+	}
+	return drift, rows.Err()
+}
+
+// reconcilePoolBalances is checkPoolBalances, and then overwrites each
+// drifted balance with the ledger's.
+func reconcilePoolBalances(ctx context.Context, q dbtx, query string) ([]models.PoolBalanceDrift, error) {
+	drift, err := checkPoolBalances(ctx, q, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drift {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO pool_balance (funding_pool_id, balance)
+			VALUES ($1, $2)
+			ON CONFLICT (funding_pool_id) DO UPDATE SET balance = EXCLUDED.balance`, d.FundingPoolID, roundCents(d.Ledger))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return drift, nil
+}
+
+// scanLedgerEntries reads ledger entries selected as id, transaction_id,
+// amount, timestamp, transaction_type, user_google_id, first_name,
+// last_initial, description, anonymous, prev_hash, hash, donor_salt,
+// anonymized_ledger_id, donor_commitment, keeping their order. It returns
+// the entries and an index from entry ID to position.
+func scanLedgerEntries(rows *sql.Rows) ([]models.LedgerEntry, map[int]int, error) {
+	entries := make([]models.LedgerEntry, 0)
+	index := make(map[int]int)
+	for rows.Next() {
+		var e models.LedgerEntry
+		var transactionID, userGoogleID, firstName, lastInitial, description, prevHash, hash, donorSalt, donorCommitment sql.NullString
+		var anonymizedLedgerID sql.NullInt64
+		if err := rows.Scan(&e.ID, &transactionID, &e.Amount, &e.Timestamp, &e.TransactionType,
+			&userGoogleID, &firstName, &lastInitial, &description, &e.Anonymous, &prevHash, &hash,
+			&donorSalt, &anonymizedLedgerID, &donorCommitment); err != nil {
+			return nil, nil, err
+		}
+		if transactionID.Valid {
+			e.TransactionID = &transactionID.String
+		}
+		if userGoogleID.Valid {
+			e.UserGoogleID = &userGoogleID.String
+		}
+		if firstName.Valid {
+			e.FirstName = &firstName.String
+		}
+		if lastInitial.Valid {
+			e.LastInitial = &lastInitial.String
+		}
+		if description.Valid {
+			e.Description = &description.String
+		}
+		e.PrevHash, e.Hash, e.DonorSalt = prevHash.String, hash.String, donorSalt.String
+		if anonymizedLedgerID.Valid {
+			id := int(anonymizedLedgerID.Int64)
+			e.AnonymizedLedgerID = &id
+		}
+		if donorCommitment.Valid {
+			e.DonorCommitment = &donorCommitment.String
+		}
+		e.Allocations = []models.Allocation{} // Initialize to ensure an empty array, not null, in JSON.
+		index[e.ID] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries, index, rows.Err()
+}
+
+// attachAllocations adds allocations selected as id, ledger_id,
+// funding_pool_id, amount to their entries.
+func attachAllocations(rows *sql.Rows, entries []models.LedgerEntry, index map[int]int) error {
+	for rows.Next() {
+		var a models.Allocation
+		if err := rows.Scan(&a.ID, &a.LedgerID, &a.FundingPoolID, &a.Amount); err != nil {
+			return err
+		}
+		if i, ok := index[a.LedgerID]; ok {
+			entries[i].Allocations = append(entries[i].Allocations, a)
+		}
+	}
+	return rows.Err()
+}
+
+// listLedgerEntries runs two queries, one for the entries and one for their
+// allocations, and stitches them together to avoid N+1 queries.
+func listLedgerEntries(ctx context.Context, q dbtx, ledgerQuery, allocQuery string, args ...interface{}) ([]models.LedgerEntry, error) {
+	rows, err := q.QueryContext(ctx, ledgerQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries, index, err := scanLedgerEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	allocRows, err := q.QueryContext(ctx, allocQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer allocRows.Close()
+	if err := attachAllocations(allocRows, entries, index); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *Postgres) ListLedgerEntries(ctx context.Context) ([]models.LedgerEntry, error) {
+	return listLedgerEntries(ctx, s.q, `
+		SELECT
+			id, transaction_id, amount, timestamp, transaction_type,
+			user_google_id, first_name, last_initial, description, anonymous, prev_hash, hash,
+			donor_salt, anonymized_ledger_id, donor_commitment
+		FROM ledger
+		ORDER BY timestamp DESC, id DESC`,
+		`SELECT id, ledger_id, funding_pool_id, amount FROM allocation ORDER BY id`)
+}
+
+func (s *Postgres) LedgerTotals(ctx context.Context) (float64, float64, error) {
+	var deposits, withdrawals float64
+	query := `
+		SELECT
+			COALESCE(SUM(CASE WHEN transaction_type = 'deposit' THEN amount ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN transaction_type = 'withdrawal' THEN amount ELSE 0 END), 0)
+		FROM ledger`
+	err := s.q.QueryRowContext(ctx, query).Scan(&deposits, &withdrawals)
+	return deposits, withdrawals, err
+}
+
+func (s *Postgres) UserLedgerEntries(ctx context.Context, googleID string) ([]models.LedgerEntry, error) {
+	return listLedgerEntries(ctx, s.q, `
+		SELECT
+			id, transaction_id, amount, timestamp, transaction_type,
+			user_google_id, first_name, last_initial, description, anonymous, prev_hash, hash,
+			donor_salt, anonymized_ledger_id, donor_commitment
+		FROM ledger
+		WHERE user_google_id = $1
+		ORDER BY id`, `
+		SELECT a.id, a.ledger_id, a.funding_pool_id, a.amount
+		FROM allocation a
+		JOIN ledger l ON l.id = a.ledger_id
+		WHERE l.user_google_id = $1
+		ORDER BY a.id`, googleID)
+}
+
+// yearRange returns the start and end of a calendar year (UTC), or nulls.
+func yearRange(year *int) (from, to sql.NullTime) {
+	if year != nil {
+		from = sql.NullTime{Time: time.Date(*year, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+		to = sql.NullTime{Time: from.Time.AddDate(1, 0, 0), Valid: true}
+	}
+	return from, to
+}
+
+func (s *Postgres) UserDonations(ctx context.Context, googleID string, year *int) ([]models.UserDonation, error) {
+	from, to := yearRange(year)
+	query := `
+		SELECT l.id, l.transaction_id, l.amount, l.timestamp, l.anonymous, l.description,
+			a.funding_pool_id, COALESCE(p.name, ''), a.amount
+		FROM ledger l
+		JOIN allocation a ON a.ledger_id = l.id
+		LEFT JOIN funding_pool p ON p.id = a.funding_pool_id
+		WHERE l.user_google_id = $1 AND l.transaction_type = 'deposit' AND l.transaction_id IS NOT NULL
+		AND ($2::timestamptz IS NULL OR l.timestamp >= $2)
+		AND ($3::timestamptz IS NULL OR l.timestamp < $3)
+		ORDER BY l.timestamp DESC, l.id DESC, a.id`
+	rows, err := s.q.QueryContext(ctx, query, googleID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUserDonations(rows)
+}
+
+// scanUserDonations reads donations selected as ledger id, transaction_id,
+// amount, timestamp, anonymous, description, then one allocation per row as
+// funding_pool_id, pool name, amount, ordered by ledger entry.
+func scanUserDonations(rows *sql.Rows) ([]models.UserDonation, error) {
+	donations := make([]models.UserDonation, 0)
+	for rows.Next() {
+		var d models.UserDonation
+		var description sql.NullString
+		var alloc models.DonationAllocation
+		if err := rows.Scan(&d.ID, &d.TransactionID, &d.Amount, &d.Timestamp, &d.Anonymous, &description,
+			&alloc.FundingPoolID, &alloc.FundingPoolName, &alloc.Amount); err != nil {
+			return nil, err
+		}
+		// Rows are ordered by ledger entry, so allocations of the same entry are adjacent.
+		if n := len(donations); n > 0 && donations[n-1].ID == d.ID {
+			donations[n-1].Allocations = append(donations[n-1].Allocations, alloc)
+			continue
+		}
+		if description.Valid {
+			d.Description = &description.String
+		}
+		d.Allocations = []models.DonationAllocation{alloc}
+		donations = append(donations, d)
+	}
+	return donations, rows.Err()
+}
+
+func (s *Postgres) UserDonationYears(ctx context.Context, googleID string) ([]models.YearDonationTotal, error) {
+	query := `
+		SELECT EXTRACT(YEAR FROM timestamp AT TIME ZONE 'UTC')::int AS year, COUNT(*), SUM(amount)
+		FROM ledger
+		WHERE user_google_id = $1 AND transaction_type = 'deposit' AND transaction_id IS NOT NULL
+		GROUP BY year
+		ORDER BY year DESC`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanYearTotals(rows)
+}
+
+// scanYearTotals reads yearly totals selected as year, count, total.
+func scanYearTotals(rows *sql.Rows) ([]models.YearDonationTotal, error) {
+	years := make([]models.YearDonationTotal, 0)
+	for rows.Next() {
+		var y models.YearDonationTotal
+		if err := rows.Scan(&y.Year, &y.Count, &y.Total); err != nil {
+			return nil, err
+		}
+		years = append(years, y)
+	}
+	return years, rows.Err()
+}
+
+func (s *Postgres) AnonymizeUserLedger(ctx context.Context, googleID string) (int64, error) {
+	var n int64
+	err := s.WithTx(ctx, func(tx Store) error {
+		t := tx.(*Postgres)
+		if _, err := t.q.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, ledgerLockID); err != nil {
+			return err
+		}
+		if _, err := sealLedger(ctx, t.q); err != nil {
+			return err
+		}
+		var err error
+		n, err = anonymizeUserLedger(ctx, t.q, googleID, t.insertLedgerEntry)
+		return err
+	})
+	return n, err
+}
+
+// anonymizeUserLedger erases a user from their entries, then records an
+// "anonymized" entry for each with the donor commitment its hash covers,
+// using insert, and adds those to the hash chain. Callers must hold the
+// ledger lock.
+func anonymizeUserLedger(ctx context.Context, q dbtx, googleID string, insert func(context.Context, LedgerEntryData) (int, error)) (int64, error) {
+	entries, err := listLedgerEntries(ctx, q, `
+		SELECT `+ledgerEntryColumns+`
+		FROM ledger
+		WHERE user_google_id = $1
+		ORDER BY id`, `
+		SELECT a.id, a.ledger_id, a.funding_pool_id, a.amount
+		FROM allocation a
+		JOIN ledger l ON l.id = a.ledger_id
+		WHERE l.user_google_id = $1
+		ORDER BY a.id`, googleID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = q.ExecContext(ctx, `
+		UPDATE ledger
+		SET user_google_id = NULL, first_name = NULL, last_initial = NULL, donor_salt = NULL,
+			anonymous = anonymous OR transaction_type = 'deposit'
+		WHERE user_google_id = $1`, googleID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		ledgerID, err := insert(ctx, anonymizedEntry(e))
+		if err != nil {
+			return 0, err
+		}
+		if err := hashLedgerEntry(ctx, q, ledgerID); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(entries)), nil
+}
+
+// anonymizedEntry returns the "anonymized" entry recorded when the donor of
+// e is erased, carrying the commitment to that donor e's hash covers.
+func anonymizedEntry(e models.LedgerEntry) LedgerEntryData {
+	commitment := ledgerchain.Commitment(e)
+	return LedgerEntryData{
+		TransactionType:    "anonymized",
+		AnonymizedLedgerID: sql.NullInt64{Int64: int64(e.ID), Valid: true},
+		DonorCommitment:    sql.NullString{String: commitment, Valid: commitment != ""},
+	}
+}
@@ -0,0 +1,289 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"pool-party-api/models"
+	"time"
+)
+
+// scanPool reads a pool selected by poolColumns.
+func scanPool(row interface{ Scan(...interface{}) error }, withBalance bool) (*Pool, error) {
+	var p Pool
+	var description, imageKey, thumbnailKey sql.NullString
+	var capAmount sql.NullFloat64
+	var overflowPoolID sql.NullInt64
+	var archivedAt sql.NullTime
+	dest := []interface{}{&p.ID, &p.Name, &description, &p.GoalAmount, &capAmount, &p.OverflowPolicy, &overflowPoolID, &imageKey, &thumbnailKey, &archivedAt}
+	if withBalance {
+		dest = append(dest, &p.CurrentAmount)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		p.Description = &description.String
+	}
+	if capAmount.Valid {
+		p.CapAmount = &capAmount.Float64
+	}
+	if overflowPoolID.Valid {
+		id := int(overflowPoolID.Int64)
+		p.OverflowPoolID = &id
+	}
+	if imageKey.Valid {
+		p.ImageKey = &imageKey.String
+	}
+	if thumbnailKey.Valid {
+		p.ThumbnailKey = &thumbnailKey.String
+	}
+	if archivedAt.Valid {
+		p.ArchivedAt = &archivedAt.Time
+	}
+	return &p, nil
+}
+
+// poolsWithBalance selects pools and their maintained balance. Callers add a
+// WHERE clause.
+const poolsWithBalance = `
+	SELECT
+		fp.id,
+		fp.name,
+		fp.description,
+		fp.goal_amount,
+		fp.cap_amount,
+		fp.overflow_policy,
+		fp.overflow_pool_id,
+		fp.image_key,
+		fp.thumbnail_key,
+		fp.archived_at,
+		COALESCE(pb.balance, 0) as current_amount
+	FROM
+		funding_pool fp
+	LEFT JOIN
+		pool_balance pb ON pb.funding_pool_id = fp.id
+	`
+
+func (s *Postgres) ListPools(ctx context.Context, includeArchived bool) ([]Pool, error) {
+	query := poolsWithBalance
+	if !includeArchived {
+		query += ` WHERE fp.archived_at IS NULL`
+	}
+	query += ` ORDER BY fp.id`
+
+	rows, err := s.q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pools := make([]Pool, 0)
+	for rows.Next() {
+		p, err := scanPool(rows, true)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, *p)
+	}
+	return pools, rows.Err()
+}
+
+func (s *Postgres) GetPool(ctx context.Context, id int) (*Pool, error) {
+	query := poolsWithBalance + ` WHERE fp.id = $1`
+	p, err := scanPool(s.q.QueryRowContext(ctx, query, id), true)
+	return p, notFound(err)
+}
+
+func (s *Postgres) PoolExists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := s.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM funding_pool WHERE id = $1)`, id).Scan(&exists)
+	return exists, err
+}
+
+func (s *Postgres) LockPool(ctx context.Context, id int) (*Pool, error) {
+	query := `
+		SELECT id, name, description, goal_amount, cap_amount, overflow_policy, overflow_pool_id, image_key, thumbnail_key, archived_at
+		FROM funding_pool
+		WHERE id = $1
+		FOR UPDATE`
+	p, err := scanPool(s.q.QueryRowContext(ctx, query, id), false)
+	return p, notFound(err)
+}
+
+func (s *Postgres) LockAllPools(ctx context.Context) error {
+	_, err := s.q.ExecContext(ctx, `SELECT id FROM funding_pool ORDER BY id FOR UPDATE`)
+	return err
+}
+
+func (s *Postgres) CreatePool(ctx context.Context, req *models.CreateFundingPoolRequest) (int, error) {
+	query := `
+		INSERT INTO funding_pool (name, description, goal_amount, cap_amount, overflow_policy, overflow_pool_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`
+	var id int
+	err := s.q.QueryRowContext(ctx, query, req.Name, req.Description, req.GoalAmount, req.CapAmount, req.OverflowPolicy, req.OverflowPoolID).Scan(&id)
+	return id, err
+}
+
+func (s *Postgres) UpdatePool(ctx context.Context, id int, req *models.CreateFundingPoolRequest) error {
+	query := `
+		UPDATE funding_pool
+		SET name = $1, description = $2, goal_amount = $3, cap_amount = $4, overflow_policy = $5, overflow_pool_id = $6
+		WHERE id = $7`
+	return execOne(ctx, s.q, query, req.Name, req.Description, req.GoalAmount, req.CapAmount, req.OverflowPolicy, req.OverflowPoolID, id)
+}
+
+func (s *Postgres) DeletePool(ctx context.Context, id int) error {
+	return execOne(ctx, s.q, `DELETE FROM funding_pool WHERE id = $1`, id)
+}
+
+func (s *Postgres) SetPoolArchived(ctx context.Context, id int, archived bool) error {
+	query := `UPDATE funding_pool SET archived_at = NULL WHERE id = $1`
+	if archived {
+		query = `UPDATE funding_pool SET archived_at = CURRENT_TIMESTAMP WHERE id = $1`
+	}
+	return execOne(ctx, s.q, query, id)
+}
+
+func (s *Postgres) SetPoolImage(ctx context.Context, id int, imageKey, thumbnailKey *string) error {
+	return execOne(ctx, s.q, `UPDATE funding_pool SET image_key = $1, thumbnail_key = $2 WHERE id = $3`, imageKey, thumbnailKey, id)
+}
+
+func (s *Postgres) PoolHasAllocations(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := s.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM allocation WHERE funding_pool_id = $1)`, id).Scan(&exists)
+	return exists, err
+}
+
+func (s *Postgres) PoolBalance(ctx context.Context, id int) (float64, error) {
+	return poolBalance(ctx, s.q, id)
+}
+
+// poolBalance reads a pool's maintained balance.
+func poolBalance(ctx context.Context, q dbtx, id int) (float64, error) {
+	var balance float64
+	err := q.QueryRowContext(ctx, `SELECT COALESCE((SELECT balance FROM pool_balance WHERE funding_pool_id = $1), 0)`, id).Scan(&balance)
+	return balance, err
+}
+
+func (s *Postgres) PoolDailyTotals(ctx context.Context, id int) ([]DailyTotal, error) {
+	query := `
+		SELECT day, deposits, withdrawals
+		FROM pool_daily_rollup
+		WHERE funding_pool_id = $1
+		ORDER BY day`
+	rows, err := s.q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make([]DailyTotal, 0)
+	for rows.Next() {
+		var t DailyTotal
+		if err := rows.Scan(&t.Day, &t.Deposits, &t.Withdrawals); err != nil {
+			return nil, err
+		}
+		t.Day = time.Date(t.Day.Year(), t.Day.Month(), t.Day.Day(), 0, 0, 0, 0, time.UTC)
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+func (s *Postgres) RecordPoolRevision(ctx context.Context, rev NewPoolRevision) error {
+	changesJSON, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO funding_pool_revision (funding_pool_id, action, moderator_google_id, changes)
+		VALUES ($1, $2, $3, $4)`
+	_, err = s.q.ExecContext(ctx, query, rev.FundingPoolID, rev.Action, nullString(rev.ModeratorGoogleID), string(changesJSON))
+	return err
+}
+
+func (s *Postgres) ListPoolRevisions(ctx context.Context, poolID int) ([]PoolRevision, error) {
+	query := `
+		SELECT r.id, r.funding_pool_id, r.action, u.google_id IS NOT NULL, COALESCE(u.email, ''),
+			u.first_name, u.last_name, u.display_name, COALESCE(u.default_anonymous, FALSE), COALESCE(u.show_full_name, FALSE),
+			r.created_at, r.changes
+		FROM funding_pool_revision r
+		LEFT JOIN users u ON u.google_id = r.moderator_google_id
+		WHERE r.funding_pool_id = $1
+		ORDER BY r.id DESC`
+	rows, err := s.q.QueryContext(ctx, query, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPoolRevisions(rows)
+}
+
+// scanPoolRevisions reads revisions selected as id, funding_pool_id, action,
+// whether the moderator has an account, the moderator's donor profile,
+// created_at, changes.
+func scanPoolRevisions(rows *sql.Rows) ([]PoolRevision, error) {
+	revisions := make([]PoolRevision, 0)
+	for rows.Next() {
+		var rev PoolRevision
+		var hasModerator bool
+		var p DonorProfile
+		var changes []byte
+		err := rows.Scan(&rev.ID, &rev.FundingPoolID, &rev.Action, &hasModerator, &p.Email,
+			&p.FirstName, &p.LastName, &p.DisplayName, &p.DefaultAnonymous, &p.ShowFullName,
+			&rev.CreatedAt, &changes)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &rev.Changes); err != nil {
+			return nil, err
+		}
+		if hasModerator {
+			rev.Moderator = &p
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *Postgres) ModeratorRevisions(ctx context.Context, googleID string) ([]models.FundingPoolRevision, error) {
+	query := `
+		SELECT id, funding_pool_id, action, created_at, changes
+		FROM funding_pool_revision
+		WHERE moderator_google_id = $1
+		ORDER BY id`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanModeratorRevisions(rows)
+}
+
+// scanModeratorRevisions reads revisions selected as id, funding_pool_id,
+// action, created_at, changes.
+func scanModeratorRevisions(rows *sql.Rows) ([]models.FundingPoolRevision, error) {
+	revisions := make([]models.FundingPoolRevision, 0)
+	for rows.Next() {
+		var rev models.FundingPoolRevision
+		var changes []byte
+		if err := rows.Scan(&rev.ID, &rev.FundingPoolID, &rev.Action, &rev.CreatedAt, &changes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &rev.Changes); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *Postgres) AnonymizeModeratorRevisions(ctx context.Context, googleID string) (int64, error) {
+	res, err := s.q.ExecContext(ctx, `UPDATE funding_pool_revision SET moderator_google_id = NULL WHERE moderator_google_id = $1`, googleID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,362 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"strings"
+	"time"
+)
+
+func (s *Postgres) UserIDForIdentity(ctx context.Context, provider, subject string) (string, error) {
+	var googleID string
+	query := `SELECT user_google_id FROM user_identity WHERE provider = $1 AND subject = $2`
+	err := s.q.QueryRowContext(ctx, query, provider, subject).Scan(&googleID)
+	return googleID, notFound(err)
+}
+
+func (s *Postgres) UserIDForEmail(ctx context.Context, email string) (string, error) {
+	var googleID string
+	err := s.q.QueryRowContext(ctx, `SELECT google_id FROM users WHERE lower(email) = lower($1)`, email).Scan(&googleID)
+	return googleID, notFound(err)
+}
+
+// userColumns selects a User for scanUser.
+const userColumns = `google_id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), donate_only, created_at`
+
+func scanUser(row *sql.Row) (*User, error) {
+	var u User
+	if err := row.Scan(&u.GoogleID, &u.Email, &u.FirstName, &u.LastName, &u.DonateOnly, &u.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *Postgres) UpsertUser(ctx context.Context, u User) (*User, error) {
+	query := `
+		INSERT INTO users (google_id, email, first_name, last_name, donate_only)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (google_id) DO UPDATE
+		SET email = EXCLUDED.email, first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name, donate_only = EXCLUDED.donate_only
+		RETURNING ` + userColumns
+	return scanUser(s.q.QueryRowContext(ctx, query, u.GoogleID, u.Email, u.FirstName, u.LastName, u.DonateOnly))
+}
+
+func (s *Postgres) UpsertIdentity(ctx context.Context, provider, subject, googleID, email string) error {
+	query := `
+		INSERT INTO user_identity (provider, subject, user_google_id, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO UPDATE
+		SET email = EXCLUDED.email, last_login_at = CURRENT_TIMESTAMP`
+	_, err := s.q.ExecContext(ctx, query, provider, subject, googleID, email)
+	return err
+}
+
+func (s *Postgres) GetUser(ctx context.Context, googleID string) (*User, error) {
+	return scanUser(s.q.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE google_id = $1`, googleID))
+}
+
+func (s *Postgres) LockUser(ctx context.Context, googleID string) (*User, error) {
+	return scanUser(s.q.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE google_id = $1 FOR UPDATE`, googleID))
+}
+
+func (s *Postgres) ListUsers(ctx context.Context) ([]models.AdminUser, error) {
+	return listUsers(ctx, s.q)
+}
+
+// listUsers lists every user with their granted roles.
+func listUsers(ctx context.Context, q dbtx) ([]models.AdminUser, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT google_id, email, COALESCE(first_name, ''), COALESCE(last_name, ''), donate_only, created_at
+		FROM users
+		ORDER BY lower(email)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]models.AdminUser, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		u := models.AdminUser{Roles: []string{}}
+		if err := rows.Scan(&u.GoogleID, &u.Email, &u.FirstName, &u.LastName, &u.DonateOnly, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		index[u.GoogleID] = len(users)
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roleRows, err := q.QueryContext(ctx, `SELECT user_google_id, role FROM user_role ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+	defer roleRows.Close()
+
+	for roleRows.Next() {
+		var googleID, role string
+		if err := roleRows.Scan(&googleID, &role); err != nil {
+			return nil, err
+		}
+		if i, ok := index[googleID]; ok {
+			users[i].Roles = append(users[i].Roles, role)
+		}
+	}
+	return users, roleRows.Err()
+}
+
+func (s *Postgres) UserIdentities(ctx context.Context, googleID string) ([]models.UserIdentity, error) {
+	return userIdentities(ctx, s.q, googleID)
+}
+
+func userIdentities(ctx context.Context, q dbtx, googleID string) ([]models.UserIdentity, error) {
+	query := `
+		SELECT provider, subject, email, created_at, last_login_at
+		FROM user_identity
+		WHERE user_google_id = $1
+		ORDER BY created_at`
+	rows, err := q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]models.UserIdentity, 0)
+	for rows.Next() {
+		var i models.UserIdentity
+		var email sql.NullString
+		if err := rows.Scan(&i.Provider, &i.Subject, &email, &i.CreatedAt, &i.LastLoginAt); err != nil {
+			return nil, err
+		}
+		if email.Valid {
+			i.Email = &email.String
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (s *Postgres) DeleteUser(ctx context.Context, googleID string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		return deleteUser(ctx, tx.(*Postgres).q, googleID)
+	})
+}
+
+// deleteUser removes a user, keeping the audit log and roles they granted
+// but detaching them from the account.
+func deleteUser(ctx context.Context, q dbtx, googleID string) error {
+	_, err := q.ExecContext(ctx, `
+		UPDATE audit_log
+		SET actor_google_id = CASE WHEN actor_google_id = $1 THEN NULL ELSE actor_google_id END,
+			target_google_id = CASE WHEN target_google_id = $1 THEN NULL ELSE target_google_id END
+		WHERE actor_google_id = $1 OR target_google_id = $1`, googleID)
+	if err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `UPDATE user_role SET granted_by = NULL WHERE granted_by = $1`, googleID); err != nil {
+		return err
+	}
+	// Identities, roles, tokens and sessions are deleted by cascade.
+	return execOne(ctx, q, `DELETE FROM users WHERE google_id = $1`, googleID)
+}
+
+func (s *Postgres) UserRoles(ctx context.Context, googleID string) ([]auth.Role, error) {
+	return userRoles(ctx, s.q, googleID)
+}
+
+// userRoles returns the roles in effect for a user: none for donate-only accounts.
+func userRoles(ctx context.Context, q dbtx, googleID string) ([]auth.Role, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT r.role
+		FROM user_role r
+		JOIN users u ON u.google_id = r.user_google_id
+		WHERE r.user_google_id = $1 AND NOT u.donate_only
+		ORDER BY r.role`, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []auth.Role{}
+	for rows.Next() {
+		var role auth.Role
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// scanStrings reads a single string column.
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (s *Postgres) GrantedRoles(ctx context.Context, googleID string) ([]string, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT role FROM user_role WHERE user_google_id = $1 ORDER BY role`, googleID)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+func (s *Postgres) GrantRole(ctx context.Context, googleID, role, grantedBy string) (bool, error) {
+	query := `
+		INSERT INTO user_role (user_google_id, role, granted_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_google_id, role) DO NOTHING`
+	res, err := s.q.ExecContext(ctx, query, googleID, role, nullString(grantedBy))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Postgres) RevokeRole(ctx context.Context, googleID, role string) (bool, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM user_role WHERE user_google_id = $1 AND role = $2`, googleID, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Postgres) LockRoleHolders(ctx context.Context, role string) ([]string, error) {
+	rows, err := s.q.QueryContext(ctx, `
+		SELECT r.user_google_id
+		FROM user_role r
+		JOIN users u ON u.google_id = r.user_google_id
+		WHERE r.role = $1 AND NOT u.donate_only
+		ORDER BY r.user_google_id
+		FOR UPDATE`, role)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+func (s *Postgres) DonorProfile(ctx context.Context, googleID string) (*DonorProfile, error) {
+	var p DonorProfile
+	query := `
+		SELECT email, first_name, last_name, display_name, default_anonymous, show_full_name
+		FROM users WHERE google_id = $1`
+	err := s.q.QueryRowContext(ctx, query, googleID).Scan(
+		&p.Email, &p.FirstName, &p.LastName, &p.DisplayName, &p.DefaultAnonymous, &p.ShowFullName,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (s *Postgres) UpdateProfile(ctx context.Context, googleID string, displayName *string, defaultAnonymous, showFullName bool) error {
+	query := `
+		UPDATE users SET display_name = $2, default_anonymous = $3, show_full_name = $4
+		WHERE google_id = $1`
+	return execOne(ctx, s.q, query, googleID, displayName, defaultAnonymous, showFullName)
+}
+
+// --- API tokens ---
+
+func (s *Postgres) CreateAPIToken(ctx context.Context, googleID string, t NewAPIToken) (*models.APIToken, error) {
+	token := &models.APIToken{Name: t.Name, Prefix: t.Prefix, Scopes: t.Scopes, ExpiresAt: t.ExpiresAt}
+	query := `
+		INSERT INTO api_token (user_google_id, name, token_hash, display_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	err := s.q.QueryRowContext(ctx, query, googleID, t.Name, t.Hash, t.Prefix, strings.Join(t.Scopes, ","), t.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// splitScopes parses the comma-separated scopes stored with a token.
+func splitScopes(s string) []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func (s *Postgres) ListAPITokens(ctx context.Context, googleID string) ([]models.APIToken, error) {
+	return listAPITokens(ctx, s.q, googleID)
+}
+
+func listAPITokens(ctx context.Context, q dbtx, googleID string) ([]models.APIToken, error) {
+	query := `
+		SELECT id, name, display_prefix, scopes, created_at, expires_at, last_used_at, revoked_at
+		FROM api_token
+		WHERE user_google_id = $1
+		ORDER BY id DESC`
+	rows, err := q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]models.APIToken, 0)
+	for rows.Next() {
+		var t models.APIToken
+		var scopes string
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = splitScopes(scopes)
+		t.ExpiresAt = timePtr(expiresAt)
+		t.LastUsedAt = timePtr(lastUsedAt)
+		t.RevokedAt = timePtr(revokedAt)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (s *Postgres) RevokeAPIToken(ctx context.Context, googleID string, id int) (bool, error) {
+	query := `
+		UPDATE api_token SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_google_id = $2 AND revoked_at IS NULL`
+	res, err := s.q.ExecContext(ctx, query, id, googleID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Postgres) UseAPIToken(ctx context.Context, hash string) (string, []string, error) {
+	var googleID, scopes string
+	query := `
+		UPDATE api_token SET last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		RETURNING user_google_id, scopes`
+	if err := s.q.QueryRowContext(ctx, query, hash).Scan(&googleID, &scopes); err != nil {
+		return "", nil, notFound(err)
+	}
+	return googleID, splitScopes(scopes), nil
+}
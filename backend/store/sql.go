@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so queries can run either
+// inside or outside of a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// nullString returns NULL for an empty string.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// execOne runs a statement that should affect exactly one row, returning
+// ErrNotFound if it affected none.
+func execOne(ctx context.Context, q dbtx, query string, args ...interface{}) error {
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
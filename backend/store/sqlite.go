@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"pool-party-api/models"
+	"time"
+)
+
+// SQLite is the Store backed by a SQLite database, for small deployments
+// without a database server. It expects the connection settings made by
+// database.Open: foreign keys on, transactions that take the write lock when
+// they begin, and times written as UTC text.
+//
+// SQLite serializes writers, so the Lock methods only read: a transaction
+// already excludes every other writer until it ends.
+type SQLite struct {
+	db *sql.DB
+	q  dbtx
+	tx *sql.Tx // Set inside a transaction.
+}
+
+var _ Store = (*SQLite)(nil)
+
+// NewSQLite returns a Store using db.
+func NewSQLite(db *sql.DB) *SQLite {
+	return &SQLite{db: db, q: db}
+}
+
+// sqliteNow is the current time as stored: in UTC, so stored times compare as text.
+func sqliteNow() time.Time {
+	return time.Now().UTC()
+}
+
+func (s *SQLite) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return s.withTx(ctx, nil, fn)
+}
+
+func (s *SQLite) WithSnapshot(ctx context.Context, fn func(tx Store) error) error {
+	// A read-only transaction starts without the write lock and reads from
+	// one snapshot of the database.
+	return s.withTx(ctx, &sql.TxOptions{ReadOnly: true}, fn)
+}
+
+func (s *SQLite) withTx(ctx context.Context, opts *sql.TxOptions, fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&SQLite{db: s.db, q: tx, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// --- Site ---
+
+func (s *SQLite) GetSite(ctx context.Context) (*models.SiteInstance, error) {
+	var site models.SiteInstance
+	var headline sql.NullString
+	query := `SELECT site_title, site_headline FROM site_instance WHERE id = 1`
+	if err := s.q.QueryRowContext(ctx, query).Scan(&site.SiteTitle, &headline); err != nil {
+		return nil, notFound(err)
+	}
+	if headline.Valid {
+		site.SiteHeadline = &headline.String
+	}
+	return &site, nil
+}
+
+func (s *SQLite) SetSiteTitle(ctx context.Context, title string) error {
+	return execOne(ctx, s.q, `UPDATE site_instance SET site_title = $1 WHERE id = 1`, title)
+}
+
+// --- Audit log ---
+
+func (s *SQLite) RecordAudit(ctx context.Context, actorGoogleID, action, targetGoogleID string, details interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_google_id, action, target_google_id, details, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = s.q.ExecContext(ctx, query, nullString(actorGoogleID), action, nullString(targetGoogleID), string(detailsJSON), sqliteNow())
+	return err
+}
+
+func (s *SQLite) AuditLog(ctx context.Context, before *int64, limit int) ([]models.AuditLogEntry, error) {
+	query := `
+		SELECT id, actor_google_id, action, target_google_id, details, created_at
+		FROM audit_log
+		WHERE $1 IS NULL OR id < $1
+		ORDER BY id DESC
+		LIMIT $2`
+	rows, err := s.q.QueryContext(ctx, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuditLog(rows)
+}
+
+func (s *SQLite) UserAuditLog(ctx context.Context, googleID string) ([]models.AuditLogEntry, error) {
+	query := `
+		SELECT id, actor_google_id, action, target_google_id, details, created_at
+		FROM audit_log
+		WHERE actor_google_id = $1 OR target_google_id = $1
+		ORDER BY id`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuditLog(rows)
+}
+
+// --- Sessions ---
+
+func (s *SQLite) ListUserSessions(ctx context.Context, googleID string) ([]models.UserSession, error) {
+	query := `
+		SELECT id, created_at, last_seen_at, expires_at, COALESCE(user_agent, ''), COALESCE(ip_address, '')
+		FROM sessions
+		WHERE user_google_id = $1 AND expires_at > $2
+		ORDER BY last_seen_at DESC`
+	rows, err := s.q.QueryContext(ctx, query, googleID, sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userSessions := make([]models.UserSession, 0)
+	for rows.Next() {
+		var us models.UserSession
+		if err := rows.Scan(&us.ID, &us.CreatedAt, &us.LastSeenAt, &us.ExpiresAt, &us.UserAgent, &us.IPAddress); err != nil {
+			return nil, err
+		}
+		userSessions = append(userSessions, us)
+	}
+	return userSessions, rows.Err()
+}
+
+func (s *SQLite) RevokeUserSessions(ctx context.Context, googleID string) (int64, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM sessions WHERE user_google_id = $1`, googleID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLite) RevokeUserSession(ctx context.Context, googleID string, id int) (bool, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND user_google_id = $2`, id, googleID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// --- Backups ---
+
+func (s *SQLite) IsEmpty(ctx context.Context) (bool, error) {
+	return isEmpty(ctx, s.q)
+}
+
+func (s *SQLite) BackupUsers(ctx context.Context) ([]models.BackupUser, error) {
+	return backupUsers(ctx, s.q)
+}
+
+// sqliteRebuild recomputes the tables derived from the ledger. SQLite moves
+// its ID sequences past inserted IDs by itself.
+var sqliteRebuild = []string{
+	`INSERT INTO pool_balance (funding_pool_id, balance)
+	SELECT
+		a.funding_pool_id,
+		ROUND(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END), 2)
+	FROM allocation a
+	JOIN ledger l ON a.ledger_id = l.id
+	GROUP BY a.funding_pool_id`,
+	`INSERT INTO pool_daily_rollup (funding_pool_id, day, deposits, withdrawals)
+	SELECT
+		a.funding_pool_id,
+		date(l.timestamp),
+		ROUND(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount ELSE 0 END), 2),
+		ROUND(SUM(CASE WHEN l.transaction_type = 'withdrawal' THEN a.amount ELSE 0 END), 2)
+	FROM allocation a
+	JOIN ledger l ON a.ledger_id = l.id
+	GROUP BY 1, 2`,
+}
+
+func (s *SQLite) Restore(ctx context.Context, a *models.BackupArchive) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		return restore(ctx, tx.(*SQLite).q, a, sqliteRebuild)
+	})
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"pool-party-api/ledgerchain"
+	"pool-party-api/models"
+	"time"
+)
+
+// CreateLedgerEntry needs no lock beyond the transaction's: SQLite
+// transactions that write run one at a time.
+func (s *SQLite) CreateLedgerEntry(ctx context.Context, data LedgerEntryData) (int, error) {
+	var ledgerID int
+	err := s.WithTx(ctx, func(tx Store) error {
+		t := tx.(*SQLite)
+		if _, err := sealLedger(ctx, t.q); err != nil {
+			return err
+		}
+		var err error
+		if ledgerID, err = t.insertLedgerEntry(ctx, data); err != nil {
+			return err
+		}
+		return hashLedgerEntry(ctx, t.q, ledgerID)
+	})
+	return ledgerID, err
+}
+
+func (s *SQLite) SealLedger(ctx context.Context) (int, error) {
+	var n int
+	err := s.WithTx(ctx, func(tx Store) error {
+		var err error
+		n, err = sealLedger(ctx, tx.(*SQLite).q)
+		return err
+	})
+	return n, err
+}
+
+// insertLedgerEntry records an entry and its allocations, without hashing it.
+func (s *SQLite) insertLedgerEntry(ctx context.Context, data LedgerEntryData) (int, error) {
+	var ledgerID int
+	timestamp := sqliteNow()
+	salt, err := ledgerchain.NewSalt()
+	if err != nil {
+		return 0, err
+	}
+	ledgerQuery := `
+		INSERT INTO ledger (transaction_id, amount, timestamp, transaction_type, user_google_id, first_name, last_initial, anonymous, description,
+			donor_salt, anonymized_ledger_id, donor_commitment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id`
+	err = s.q.QueryRowContext(ctx, ledgerQuery, data.TransactionID, roundCents(data.Amount), timestamp, data.TransactionType,
+		data.UserGoogleID, data.FirstName, data.LastInitial, data.Anonymous, data.Description,
+		salt, data.AnonymizedLedgerID, data.DonorCommitment).Scan(&ledgerID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the pools' balances, and the daily rollup used by the pool history
+	// endpoint, in step with the ledger.
+	var deposits, withdrawals float64
+	rollupQuery := `
+		INSERT INTO pool_daily_rollup (funding_pool_id, day, deposits, withdrawals)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (funding_pool_id, day) DO UPDATE
+		SET deposits = ROUND(pool_daily_rollup.deposits + EXCLUDED.deposits, 2),
+			withdrawals = ROUND(pool_daily_rollup.withdrawals + EXCLUDED.withdrawals, 2)`
+	balanceQuery := `
+		INSERT INTO pool_balance (funding_pool_id, balance)
+		VALUES ($1, $2)
+		ON CONFLICT (funding_pool_id) DO UPDATE
+		SET balance = ROUND(pool_balance.balance + EXCLUDED.balance, 2)`
+	day := timestamp.Format(time.DateOnly)
+
+	for _, alloc := range data.Allocations {
+		if alloc.Amount > 0 {
+			amount := roundCents(alloc.Amount)
+			if _, err := s.q.ExecContext(ctx, "INSERT INTO allocation (ledger_id, funding_pool_id, amount) VALUES ($1, $2, $3)", ledgerID, alloc.FundingPoolID, amount); err != nil {
+				return 0, err
+			}
+
+			deposits, withdrawals = 0, 0
+			if data.TransactionType == "withdrawal" {
+				withdrawals = amount
+			} else {
+				deposits = amount
+			}
+			if _, err := s.q.ExecContext(ctx, rollupQuery, alloc.FundingPoolID, day, deposits, withdrawals); err != nil {
+				return 0, err
+			}
+			if _, err := s.q.ExecContext(ctx, balanceQuery, alloc.FundingPoolID, deposits-withdrawals); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return ledgerID, nil
+}
+
+// sqliteLedgerBalances is ledgerBalances for SQLite, which sums amounts as
+// floating point and so rounds the sums back to cents.
+const sqliteLedgerBalances = `
+	SELECT
+		fp.id,
+		COALESCE(pb.balance, 0),
+		ROUND(COALESCE(SUM(CASE WHEN l.transaction_type = 'deposit' THEN a.amount WHEN l.transaction_type = 'withdrawal' THEN -a.amount ELSE 0 END), 0), 2)
+	FROM funding_pool fp
+	LEFT JOIN pool_balance pb ON pb.funding_pool_id = fp.id
+	LEFT JOIN allocation a ON a.funding_pool_id = fp.id
+	LEFT JOIN ledger l ON a.ledger_id = l.id
+	GROUP BY fp.id, pb.balance
+	ORDER BY fp.id`
+
+func (s *SQLite) CheckPoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error) {
+	return checkPoolBalances(ctx, s.q, sqliteLedgerBalances)
+}
+
+func (s *SQLite) ReconcilePoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error) {
+	return reconcilePoolBalances(ctx, s.q, sqliteLedgerBalances)
+}
+
+func (s *SQLite) ListLedgerEntries(ctx context.Context) ([]models.LedgerEntry, error) {
+	return listLedgerEntries(ctx, s.q, `
+		SELECT
+			id, transaction_id, amount, timestamp, transaction_type,
+			user_google_id, first_name, last_initial, description, anonymous, prev_hash, hash,
+			donor_salt, anonymized_ledger_id, donor_commitment
+		FROM ledger
+		ORDER BY timestamp DESC, id DESC`,
+		`SELECT id, ledger_id, funding_pool_id, amount FROM allocation ORDER BY id`)
+}
+
+func (s *SQLite) LedgerTotals(ctx context.Context) (float64, float64, error) {
+	var deposits, withdrawals float64
+	query := `
+		SELECT
+			ROUND(COALESCE(SUM(CASE WHEN transaction_type = 'deposit' THEN amount ELSE 0 END), 0), 2),
+			ROUND(COALESCE(SUM(CASE WHEN transaction_type = 'withdrawal' THEN amount ELSE 0 END), 0), 2)
+		FROM ledger`
+	err := s.q.QueryRowContext(ctx, query).Scan(&deposits, &withdrawals)
+	return deposits, withdrawals, err
+}
+
+func (s *SQLite) UserLedgerEntries(ctx context.Context, googleID string) ([]models.LedgerEntry, error) {
+	return listLedgerEntries(ctx, s.q, `
+		SELECT
+			id, transaction_id, amount, timestamp, transaction_type,
+			user_google_id, first_name, last_initial, description, anonymous, prev_hash, hash,
+			donor_salt, anonymized_ledger_id, donor_commitment
+		FROM ledger
+		WHERE user_google_id = $1
+		ORDER BY id`, `
+		SELECT a.id, a.ledger_id, a.funding_pool_id, a.amount
+		FROM allocation a
+		JOIN ledger l ON l.id = a.ledger_id
+		WHERE l.user_google_id = $1
+		ORDER BY a.id`, googleID)
+}
+
+func (s *SQLite) UserDonations(ctx context.Context, googleID string, year *int) ([]models.UserDonation, error) {
+	from, to := yearRange(year)
+	query := `
+		SELECT l.id, l.transaction_id, l.amount, l.timestamp, l.anonymous, l.description,
+			a.funding_pool_id, COALESCE(p.name, ''), a.amount
+		FROM ledger l
+		JOIN allocation a ON a.ledger_id = l.id
+		LEFT JOIN funding_pool p ON p.id = a.funding_pool_id
+		WHERE l.user_google_id = $1 AND l.transaction_type = 'deposit' AND l.transaction_id IS NOT NULL
+		AND ($2 IS NULL OR l.timestamp >= $2)
+		AND ($3 IS NULL OR l.timestamp < $3)
+		ORDER BY l.timestamp DESC, l.id DESC, a.id`
+	rows, err := s.q.QueryContext(ctx, query, googleID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUserDonations(rows)
+}
+
+func (s *SQLite) UserDonationYears(ctx context.Context, googleID string) ([]models.YearDonationTotal, error) {
+	query := `
+		SELECT CAST(strftime('%Y', timestamp) AS INTEGER) AS year, COUNT(*), ROUND(SUM(amount), 2)
+		FROM ledger
+		WHERE user_google_id = $1 AND transaction_type = 'deposit' AND transaction_id IS NOT NULL
+		GROUP BY year
+		ORDER BY year DESC`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanYearTotals(rows)
+}
+
+func (s *SQLite) AnonymizeUserLedger(ctx context.Context, googleID string) (int64, error) {
+	var n int64
+	err := s.WithTx(ctx, func(tx Store) error {
+		t := tx.(*SQLite)
+		if _, err := sealLedger(ctx, t.q); err != nil {
+			return err
+		}
+		var err error
+		n, err = anonymizeUserLedger(ctx, t.q, googleID, t.insertLedgerEntry)
+		return err
+	})
+	return n, err
+}
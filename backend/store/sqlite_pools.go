@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"pool-party-api/models"
+	"time"
+)
+
+func (s *SQLite) ListPools(ctx context.Context, includeArchived bool) ([]Pool, error) {
+	query := poolsWithBalance
+	if !includeArchived {
+		query += ` WHERE fp.archived_at IS NULL`
+	}
+	query += ` ORDER BY fp.id`
+
+	rows, err := s.q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pools := make([]Pool, 0)
+	for rows.Next() {
+		p, err := scanPool(rows, true)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, *p)
+	}
+	return pools, rows.Err()
+}
+
+func (s *SQLite) GetPool(ctx context.Context, id int) (*Pool, error) {
+	query := poolsWithBalance + ` WHERE fp.id = $1`
+	p, err := scanPool(s.q.QueryRowContext(ctx, query, id), true)
+	return p, notFound(err)
+}
+
+func (s *SQLite) PoolExists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := s.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM funding_pool WHERE id = $1)`, id).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLite) LockPool(ctx context.Context, id int) (*Pool, error) {
+	query := `
+		SELECT id, name, description, goal_amount, cap_amount, overflow_policy, overflow_pool_id, image_key, thumbnail_key, archived_at
+		FROM funding_pool
+		WHERE id = $1`
+	p, err := scanPool(s.q.QueryRowContext(ctx, query, id), false)
+	return p, notFound(err)
+}
+
+func (s *SQLite) LockAllPools(ctx context.Context) error {
+	return nil
+}
+
+func (s *SQLite) CreatePool(ctx context.Context, req *models.CreateFundingPoolRequest) (int, error) {
+	query := `
+		INSERT INTO funding_pool (name, description, goal_amount, cap_amount, overflow_policy, overflow_pool_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`
+	var id int
+	err := s.q.QueryRowContext(ctx, query, req.Name, req.Description, roundCents(req.GoalAmount), roundCentsPtr(req.CapAmount), req.OverflowPolicy, req.OverflowPoolID).Scan(&id)
+	return id, err
+}
+
+func (s *SQLite) UpdatePool(ctx context.Context, id int, req *models.CreateFundingPoolRequest) error {
+	query := `
+		UPDATE funding_pool
+		SET name = $1, description = $2, goal_amount = $3, cap_amount = $4, overflow_policy = $5, overflow_pool_id = $6
+		WHERE id = $7`
+	return execOne(ctx, s.q, query, req.Name, req.Description, roundCents(req.GoalAmount), roundCentsPtr(req.CapAmount), req.OverflowPolicy, req.OverflowPoolID, id)
+}
+
+func (s *SQLite) DeletePool(ctx context.Context, id int) error {
+	return execOne(ctx, s.q, `DELETE FROM funding_pool WHERE id = $1`, id)
+}
+
+func (s *SQLite) SetPoolArchived(ctx context.Context, id int, archived bool) error {
+	var archivedAt *time.Time
+	if archived {
+		t := sqliteNow()
+		archivedAt = &t
+	}
+	return execOne(ctx, s.q, `UPDATE funding_pool SET archived_at = $1 WHERE id = $2`, archivedAt, id)
+}
+
+func (s *SQLite) SetPoolImage(ctx context.Context, id int, imageKey, thumbnailKey *string) error {
+	return execOne(ctx, s.q, `UPDATE funding_pool SET image_key = $1, thumbnail_key = $2 WHERE id = $3`, imageKey, thumbnailKey, id)
+}
+
+func (s *SQLite) PoolHasAllocations(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := s.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM allocation WHERE funding_pool_id = $1)`, id).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLite) PoolBalance(ctx context.Context, id int) (float64, error) {
+	return poolBalance(ctx, s.q, id)
+}
+
+func (s *SQLite) PoolDailyTotals(ctx context.Context, id int) ([]DailyTotal, error) {
+	query := `
+		SELECT day, deposits, withdrawals
+		FROM pool_daily_rollup
+		WHERE funding_pool_id = $1
+		ORDER BY day`
+	rows, err := s.q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make([]DailyTotal, 0)
+	for rows.Next() {
+		var t DailyTotal
+		if err := rows.Scan(&t.Day, &t.Deposits, &t.Withdrawals); err != nil {
+			return nil, err
+		}
+		t.Day = time.Date(t.Day.Year(), t.Day.Month(), t.Day.Day(), 0, 0, 0, 0, time.UTC)
+		t.Deposits = roundCents(t.Deposits)
+		t.Withdrawals = roundCents(t.Withdrawals)
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+func (s *SQLite) RecordPoolRevision(ctx context.Context, rev NewPoolRevision) error {
+	changesJSON, err := json.Marshal(rev.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO funding_pool_revision (funding_pool_id, action, moderator_google_id, changes, created_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = s.q.ExecContext(ctx, query, rev.FundingPoolID, rev.Action, nullString(rev.ModeratorGoogleID), string(changesJSON), sqliteNow())
+	return err
+}
+
+func (s *SQLite) ListPoolRevisions(ctx context.Context, poolID int) ([]PoolRevision, error) {
+	query := `
+		SELECT r.id, r.funding_pool_id, r.action, u.google_id IS NOT NULL, COALESCE(u.email, ''),
+			u.first_name, u.last_name, u.display_name, COALESCE(u.default_anonymous, FALSE), COALESCE(u.show_full_name, FALSE),
+			r.created_at, r.changes
+		FROM funding_pool_revision r
+		LEFT JOIN users u ON u.google_id = r.moderator_google_id
+		WHERE r.funding_pool_id = $1
+		ORDER BY r.id DESC`
+	rows, err := s.q.QueryContext(ctx, query, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPoolRevisions(rows)
+}
+
+func (s *SQLite) ModeratorRevisions(ctx context.Context, googleID string) ([]models.FundingPoolRevision, error) {
+	query := `
+		SELECT id, funding_pool_id, action, created_at, changes
+		FROM funding_pool_revision
+		WHERE moderator_google_id = $1
+		ORDER BY id`
+	rows, err := s.q.QueryContext(ctx, query, googleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanModeratorRevisions(rows)
+}
+
+func (s *SQLite) AnonymizeModeratorRevisions(ctx context.Context, googleID string) (int64, error) {
+	res, err := s.q.ExecContext(ctx, `UPDATE funding_pool_revision SET moderator_google_id = NULL WHERE moderator_google_id = $1`, googleID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
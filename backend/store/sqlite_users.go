@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"strings"
+)
+
+func (s *SQLite) UserIDForIdentity(ctx context.Context, provider, subject string) (string, error) {
+	var googleID string
+	query := `SELECT user_google_id FROM user_identity WHERE provider = $1 AND subject = $2`
+	err := s.q.QueryRowContext(ctx, query, provider, subject).Scan(&googleID)
+	return googleID, notFound(err)
+}
+
+func (s *SQLite) UserIDForEmail(ctx context.Context, email string) (string, error) {
+	var googleID string
+	err := s.q.QueryRowContext(ctx, `SELECT google_id FROM users WHERE lower(email) = lower($1)`, email).Scan(&googleID)
+	return googleID, notFound(err)
+}
+
+func (s *SQLite) UpsertUser(ctx context.Context, u User) (*User, error) {
+	query := `
+		INSERT INTO users (google_id, email, first_name, last_name, donate_only, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (google_id) DO UPDATE
+		SET email = EXCLUDED.email, first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name, donate_only = EXCLUDED.donate_only
+		RETURNING ` + userColumns
+	return scanUser(s.q.QueryRowContext(ctx, query, u.GoogleID, u.Email, u.FirstName, u.LastName, u.DonateOnly, sqliteNow()))
+}
+
+func (s *SQLite) UpsertIdentity(ctx context.Context, provider, subject, googleID, email string) error {
+	now := sqliteNow()
+	query := `
+		INSERT INTO user_identity (provider, subject, user_google_id, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (provider, subject) DO UPDATE
+		SET email = EXCLUDED.email, last_login_at = EXCLUDED.last_login_at`
+	_, err := s.q.ExecContext(ctx, query, provider, subject, googleID, email, now)
+	return err
+}
+
+func (s *SQLite) GetUser(ctx context.Context, googleID string) (*User, error) {
+	return scanUser(s.q.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE google_id = $1`, googleID))
+}
+
+func (s *SQLite) LockUser(ctx context.Context, googleID string) (*User, error) {
+	return s.GetUser(ctx, googleID)
+}
+
+func (s *SQLite) ListUsers(ctx context.Context) ([]models.AdminUser, error) {
+	return listUsers(ctx, s.q)
+}
+
+func (s *SQLite) UserIdentities(ctx context.Context, googleID string) ([]models.UserIdentity, error) {
+	return userIdentities(ctx, s.q, googleID)
+}
+
+func (s *SQLite) DeleteUser(ctx context.Context, googleID string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		return deleteUser(ctx, tx.(*SQLite).q, googleID)
+	})
+}
+
+func (s *SQLite) UserRoles(ctx context.Context, googleID string) ([]auth.Role, error) {
+	return userRoles(ctx, s.q, googleID)
+}
+
+func (s *SQLite) GrantedRoles(ctx context.Context, googleID string) ([]string, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT role FROM user_role WHERE user_google_id = $1 ORDER BY role`, googleID)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+func (s *SQLite) GrantRole(ctx context.Context, googleID, role, grantedBy string) (bool, error) {
+	query := `
+		INSERT INTO user_role (user_google_id, role, granted_by, granted_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_google_id, role) DO NOTHING`
+	res, err := s.q.ExecContext(ctx, query, googleID, role, nullString(grantedBy), sqliteNow())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLite) RevokeRole(ctx context.Context, googleID, role string) (bool, error) {
+	res, err := s.q.ExecContext(ctx, `DELETE FROM user_role WHERE user_google_id = $1 AND role = $2`, googleID, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLite) LockRoleHolders(ctx context.Context, role string) ([]string, error) {
+	rows, err := s.q.QueryContext(ctx, `
+		SELECT r.user_google_id
+		FROM user_role r
+		JOIN users u ON u.google_id = r.user_google_id
+		WHERE r.role = $1 AND NOT u.donate_only
+		ORDER BY r.user_google_id`, role)
+	if err != nil {
+		return nil, err
+	}
+	return scanStrings(rows)
+}
+
+func (s *SQLite) DonorProfile(ctx context.Context, googleID string) (*DonorProfile, error) {
+	var p DonorProfile
+	query := `
+		SELECT email, first_name, last_name, display_name, default_anonymous, show_full_name
+		FROM users WHERE google_id = $1`
+	err := s.q.QueryRowContext(ctx, query, googleID).Scan(
+		&p.Email, &p.FirstName, &p.LastName, &p.DisplayName, &p.DefaultAnonymous, &p.ShowFullName,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (s *SQLite) UpdateProfile(ctx context.Context, googleID string, displayName *string, defaultAnonymous, showFullName bool) error {
+	query := `
+		UPDATE users SET display_name = $2, default_anonymous = $3, show_full_name = $4
+		WHERE google_id = $1`
+	return execOne(ctx, s.q, query, googleID, displayName, defaultAnonymous, showFullName)
+}
+
+// --- API tokens ---
+
+func (s *SQLite) CreateAPIToken(ctx context.Context, googleID string, t NewAPIToken) (*models.APIToken, error) {
+	token := &models.APIToken{Name: t.Name, Prefix: t.Prefix, Scopes: t.Scopes, ExpiresAt: t.ExpiresAt, CreatedAt: sqliteNow()}
+	query := `
+		INSERT INTO api_token (user_google_id, name, token_hash, display_prefix, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+	err := s.q.QueryRowContext(ctx, query, googleID, t.Name, t.Hash, t.Prefix, strings.Join(t.Scopes, ","), t.ExpiresAt, token.CreatedAt).Scan(&token.ID)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *SQLite) ListAPITokens(ctx context.Context, googleID string) ([]models.APIToken, error) {
+	return listAPITokens(ctx, s.q, googleID)
+}
+
+func (s *SQLite) RevokeAPIToken(ctx context.Context, googleID string, id int) (bool, error) {
+	query := `
+		UPDATE api_token SET revoked_at = $3
+		WHERE id = $1 AND user_google_id = $2 AND revoked_at IS NULL`
+	res, err := s.q.ExecContext(ctx, query, id, googleID, sqliteNow())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLite) UseAPIToken(ctx context.Context, hash string) (string, []string, error) {
+	var googleID, scopes string
+	query := `
+		UPDATE api_token SET last_used_at = $2
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > $2)
+		RETURNING user_google_id, scopes`
+	if err := s.q.QueryRowContext(ctx, query, hash, sqliteNow()).Scan(&googleID, &scopes); err != nil {
+		return "", nil, notFound(err)
+	}
+	return googleID, splitScopes(scopes), nil
+}
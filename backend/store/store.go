@@ -0,0 +1,296 @@
+// Package store is the data access layer behind the API handlers. Store is
+// implemented for Postgres, for SQLite, and in memory for tests.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"pool-party-api/auth"
+	"pool-party-api/models"
+	"time"
+)
+
+// ErrNotFound is returned when the requested record does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// Store provides all of the application's data. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	PoolStore
+	LedgerStore
+	UserStore
+	TokenStore
+	UserSessionStore
+	AuditStore
+	SiteStore
+	BackupStore
+
+	// WithTx runs fn in a transaction, committing it if fn returns nil and
+	// rolling it back otherwise. The Store passed to fn works inside the
+	// transaction; calling WithTx on it again joins the same transaction.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+	// WithSnapshot runs fn in a read-only transaction that sees one
+	// consistent snapshot of the data.
+	WithSnapshot(ctx context.Context, fn func(tx Store) error) error
+}
+
+// Pool is a funding pool as stored, with the keys of its images.
+// Presentation fields such as DescriptionHTML and ImageURL are left empty.
+type Pool struct {
+	models.FundingPool
+	ImageKey     *string
+	ThumbnailKey *string
+}
+
+// PoolRevision is an entry in a pool's revision history, with the profile of
+// the moderator who made it if they still have an account.
+type PoolRevision struct {
+	models.FundingPoolRevision
+	Moderator *DonorProfile // Nil if unknown or deleted.
+}
+
+// NewPoolRevision is a revision to append to a pool's history.
+type NewPoolRevision struct {
+	FundingPoolID     int
+	Action            string
+	ModeratorGoogleID string // Empty if unknown.
+	Changes           map[string]models.FieldChange
+}
+
+// DailyTotal is the money moved in and out of a pool on one day (UTC).
+type DailyTotal struct {
+	Day         time.Time
+	Deposits    float64
+	Withdrawals float64
+}
+
+// PoolStore manages funding pools and their revision history.
+type PoolStore interface {
+	// ListPools returns pools with their current balance, ordered by ID.
+	ListPools(ctx context.Context, includeArchived bool) ([]Pool, error)
+	// GetPool returns a pool with its current balance.
+	GetPool(ctx context.Context, id int) (*Pool, error)
+	PoolExists(ctx context.Context, id int) (bool, error)
+	// LockPool returns a pool, without its balance, and locks it until the
+	// transaction ends.
+	LockPool(ctx context.Context, id int) (*Pool, error)
+	// LockAllPools locks every pool until the transaction ends, so that
+	// concurrent donations see each other's effect on balances.
+	LockAllPools(ctx context.Context) error
+	CreatePool(ctx context.Context, req *models.CreateFundingPoolRequest) (int, error)
+	UpdatePool(ctx context.Context, id int, req *models.CreateFundingPoolRequest) error
+	DeletePool(ctx context.Context, id int) error
+	SetPoolArchived(ctx context.Context, id int, archived bool) error
+	// SetPoolImage points a pool at new image keys, or clears them when nil.
+	SetPoolImage(ctx context.Context, id int, imageKey, thumbnailKey *string) error
+	PoolHasAllocations(ctx context.Context, id int) (bool, error)
+	// PoolBalance returns a pool's deposits less its withdrawals.
+	PoolBalance(ctx context.Context, id int) (float64, error)
+	// PoolDailyTotals returns a pool's deposits and withdrawals per day,
+	// oldest first, for the days with activity.
+	PoolDailyTotals(ctx context.Context, id int) ([]DailyTotal, error)
+
+	RecordPoolRevision(ctx context.Context, rev NewPoolRevision) error
+	// ListPoolRevisions returns a pool's revisions, newest first. They are
+	// kept after the pool is deleted.
+	ListPoolRevisions(ctx context.Context, poolID int) ([]PoolRevision, error)
+	// ModeratorRevisions returns the revisions a user made, oldest first.
+	ModeratorRevisions(ctx context.Context, googleID string) ([]models.FundingPoolRevision, error)
+	// AnonymizeModeratorRevisions clears a user's ID from the revisions they
+	// made, the one change the revision history allows, returning how many
+	// revisions changed.
+	AnonymizeModeratorRevisions(ctx context.Context, googleID string) (int64, error)
+}
+
+// LedgerEntryData is a struct for passing all necessary data to create a ledger entry and its allocations.
+type LedgerEntryData struct {
+	TransactionID   sql.NullString
+	Amount          float64
+	TransactionType string
+	UserGoogleID    sql.NullString
+	FirstName       sql.NullString
+	LastInitial     sql.NullString
+	Anonymous       bool
+	Description     sql.NullString
+	Allocations     []models.AllocationRequest
+	// AnonymizedLedgerID and DonorCommitment are only set on the
+	// "anonymized" entries recorded by AnonymizeUserLedger.
+	AnonymizedLedgerID sql.NullInt64
+	DonorCommitment    sql.NullString
+}
+
+// LedgerStore records money moving in and out of the pools.
+type LedgerStore interface {
+	// CreateLedgerEntry records a transaction and its allocations, keeping
+	// the pools' balances and daily totals up to date, and adds it to the
+	// ledger's hash chain. Allocations that are not positive are skipped.
+	CreateLedgerEntry(ctx context.Context, data LedgerEntryData) (int, error)
+	// SealLedger adds the entries recorded before hashing existed to the
+	// hash chain in ID order, once: it hashes nothing after the first time,
+	// so that an entry whose hash is later removed is reported by
+	// verification rather than sealed again. It returns how many it hashed.
+	SealLedger(ctx context.Context) (int, error)
+	// ListLedgerEntries returns every entry with its allocations, newest first.
+	ListLedgerEntries(ctx context.Context) ([]models.LedgerEntry, error)
+	// LedgerTotals returns the total of all deposits and all withdrawals.
+	LedgerTotals(ctx context.Context) (deposits, withdrawals float64, err error)
+	// UserLedgerEntries returns every entry recorded under a user's ID, with
+	// its allocations, oldest first.
+	UserLedgerEntries(ctx context.Context, googleID string) ([]models.LedgerEntry, error)
+	// UserDonations returns a user's PayPal donations, newest first, limited
+	// to a calendar year (UTC) if year is set. Anonymous donations are
+	// included, since they are still tied to the donor's ID. External
+	// donations are recorded under the moderator who entered them, so they
+	// are left out.
+	UserDonations(ctx context.Context, googleID string, year *int) ([]models.UserDonation, error)
+	// UserDonationYears returns a user's PayPal donation totals per year,
+	// newest first.
+	UserDonationYears(ctx context.Context, googleID string) ([]models.YearDonationTotal, error)
+	// CheckPoolBalances recomputes every pool's balance from the ledger and
+	// returns the pools whose maintained balance differs, by ID.
+	CheckPoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error)
+	// ReconcilePoolBalances does the same check and replaces each drifted
+	// balance with the ledger's. Call it in a transaction after LockAllPools.
+	ReconcilePoolBalances(ctx context.Context) ([]models.PoolBalanceDrift, error)
+	// AnonymizeUserLedger detaches a user's entries from them and removes
+	// their name, returning how many entries changed. Their donations become
+	// anonymous; withdrawals and other entries they recorded keep their type
+	// and are only detached. Each changed entry gets an "anonymized" entry
+	// after it in the hash chain, carrying the commitment to its erased
+	// donor (see package ledgerchain).
+	AnonymizeUserLedger(ctx context.Context, googleID string) (int64, error)
+}
+
+// User is a user's account.
+type User struct {
+	GoogleID   string
+	Email      string
+	FirstName  string
+	LastName   string
+	DonateOnly bool
+	CreatedAt  time.Time
+}
+
+// DonorProfile is the part of a user's profile that decides how they are
+// named on the ledger.
+type DonorProfile struct {
+	Email            string
+	FirstName        sql.NullString
+	LastName         sql.NullString
+	DisplayName      sql.NullString
+	DefaultAnonymous bool
+	ShowFullName     bool
+}
+
+// UserStore manages users, their login identities and their roles.
+type UserStore interface {
+	// UserIDForIdentity returns the user a provider's subject is linked to.
+	UserIDForIdentity(ctx context.Context, provider, subject string) (string, error)
+	// UserIDForEmail returns the user with an email address, ignoring case.
+	UserIDForEmail(ctx context.Context, email string) (string, error)
+	// UpsertUser creates a user or updates their email, name and
+	// donate-only flag, returning the stored user.
+	UpsertUser(ctx context.Context, u User) (*User, error)
+	// UpsertIdentity links a login identity to a user, or records a new
+	// login for an existing one.
+	UpsertIdentity(ctx context.Context, provider, subject, googleID, email string) error
+	GetUser(ctx context.Context, googleID string) (*User, error)
+	// LockUser returns a user and locks them until the transaction ends.
+	LockUser(ctx context.Context, googleID string) (*User, error)
+	// ListUsers returns every user with their granted roles, by email.
+	ListUsers(ctx context.Context) ([]models.AdminUser, error)
+	UserIdentities(ctx context.Context, googleID string) ([]models.UserIdentity, error)
+	// DeleteUser deletes a user with their identities, roles, tokens and
+	// sessions, and clears their ID from the audit log and role grants.
+	DeleteUser(ctx context.Context, googleID string) error
+
+	// UserRoles returns the roles in effect for a user. Donate-only
+	// accounts hold no roles whatever has been granted.
+	UserRoles(ctx context.Context, googleID string) ([]auth.Role, error)
+	// GrantedRoles returns the roles granted to a user, sorted.
+	GrantedRoles(ctx context.Context, googleID string) ([]string, error)
+	// GrantRole grants a role, reporting false if the user already had it.
+	GrantRole(ctx context.Context, googleID, role, grantedBy string) (bool, error)
+	// RevokeRole revokes a role, reporting false if the user did not have it.
+	RevokeRole(ctx context.Context, googleID, role string) (bool, error)
+	// LockRoleHolders returns the users a role is in effect for, leaving out
+	// donate-only accounts, and locks their grants until the transaction
+	// ends.
+	LockRoleHolders(ctx context.Context, role string) ([]string, error)
+
+	DonorProfile(ctx context.Context, googleID string) (*DonorProfile, error)
+	// UpdateProfile replaces a user's ledger name settings. A nil
+	// displayName clears it.
+	UpdateProfile(ctx context.Context, googleID string, displayName *string, defaultAnonymous, showFullName bool) error
+}
+
+// NewAPIToken is a personal access token to store. Only its hash is kept.
+type NewAPIToken struct {
+	Name      string
+	Hash      string
+	Prefix    string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// TokenStore manages personal access tokens.
+type TokenStore interface {
+	CreateAPIToken(ctx context.Context, googleID string, t NewAPIToken) (*models.APIToken, error)
+	// ListAPITokens returns a user's tokens, including revoked and expired
+	// ones, newest first.
+	ListAPITokens(ctx context.Context, googleID string) ([]models.APIToken, error)
+	// RevokeAPIToken revokes one of a user's active tokens, reporting false
+	// if there was none with that ID.
+	RevokeAPIToken(ctx context.Context, googleID string, id int) (bool, error)
+	// UseAPIToken finds the active, unexpired token with the given hash,
+	// records that it was used, and returns its owner and scopes.
+	UseAPIToken(ctx context.Context, hash string) (googleID string, scopes []string, err error)
+}
+
+// UserSessionStore lists and revokes the sign-in sessions that the session
+// store keeps for each user.
+type UserSessionStore interface {
+	// ListUserSessions returns a user's unexpired sessions, most recently
+	// used first.
+	ListUserSessions(ctx context.Context, googleID string) ([]models.UserSession, error)
+	// RevokeUserSessions deletes all of a user's sessions, returning how
+	// many there were.
+	RevokeUserSessions(ctx context.Context, googleID string) (int64, error)
+	// RevokeUserSession deletes one of a user's sessions, reporting false
+	// if there was none with that ID.
+	RevokeUserSession(ctx context.Context, googleID string, id int) (bool, error)
+}
+
+// AuditStore keeps the log of administrative changes.
+type AuditStore interface {
+	// RecordAudit appends an entry to the audit log. details is stored as
+	// JSON, and empty user IDs as null.
+	RecordAudit(ctx context.Context, actorGoogleID, action, targetGoogleID string, details interface{}) error
+	// AuditLog returns up to limit entries older than before, if set,
+	// newest first.
+	AuditLog(ctx context.Context, before *int64, limit int) ([]models.AuditLogEntry, error)
+	// UserAuditLog returns the entries a user made or was the target of,
+	// oldest first.
+	UserAuditLog(ctx context.Context, googleID string) ([]models.AuditLogEntry, error)
+}
+
+// SiteStore holds the site's configuration.
+type SiteStore interface {
+	GetSite(ctx context.Context) (*models.SiteInstance, error)
+	SetSiteTitle(ctx context.Context, title string) error
+}
+
+// BackupStore reads an instance's data for a backup and restores one.
+type BackupStore interface {
+	// IsEmpty reports whether there are no users, pools or ledger entries,
+	// so that a backup can be restored.
+	IsEmpty(ctx context.Context) (bool, error)
+	// BackupUsers returns every user with their profile, login identities
+	// and role grants, by ID.
+	BackupUsers(ctx context.Context) ([]models.BackupUser, error)
+	// Restore copies an archive into an empty store, keeping its IDs,
+	// timestamps and ledger hashes, and rebuilds the pools' balances and
+	// daily totals from its ledger. It does not validate the archive.
+	Restore(ctx context.Context, a *models.BackupArchive) error
+}
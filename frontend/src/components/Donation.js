@@ -24,7 +24,10 @@ function Donation({ user }) {
   const [fundingPools, setFundingPools] = useState([]);
   const [donationAmounts, setDonationAmounts] = useState({});
   const [description, setDescription] = useState('');
-  const [isAnonymous, setIsAnonymous] = useState(false);
+  // Null until the donor ticks or unticks the box, so that their profile's
+  // default-anonymous preference applies otherwise.
+  const [isAnonymous, setIsAnonymous] = useState(null);
+  const [defaultAnonymous, setDefaultAnonymous] = useState(false);
 
   // Status State
   const [pageLoading, setPageLoading] = useState(true);
@@ -56,6 +59,19 @@ function Donation({ user }) {
       });
   }, []);
 
+  useEffect(() => {
+    if (!user) return;
+    fetch('/api/me/profile')
+      .then(response => (response.ok ? response.json() : null))
+      .then(profile => {
+        if (profile) setDefaultAnonymous(Boolean(profile.default_anonymous));
+      })
+      .catch(() => {
+        // Without the profile the checkbox starts unticked; the server still
+        // applies the preference when no choice is sent.
+      });
+  }, [user]);
+
   const handleDonationChange = (poolId, amount) => {
     // Allow empty string to clear the input, otherwise parse as float
     const numericAmount = amount === '' ? '' : parseFloat(amount);
@@ -104,7 +120,8 @@ function Donation({ user }) {
         orderID: data.orderID,
         allocations: allocations,
         description: description,
-        isAnonymous: isAnonymous,
+        // Left out unless the donor chose, so the server applies their default.
+        isAnonymous: isAnonymous ?? undefined,
       }),
     })
     .then(res => {
@@ -163,7 +180,7 @@ function Donation({ user }) {
       // and prepare for the next entry.
       setDonationAmounts({});
       setDescription('');
-      setIsAnonymous(false);
+      setIsAnonymous(null);
     } catch (err) {
       setMessage({ text: err.message, severity: 'error' });
     } finally {
@@ -240,7 +257,7 @@ function Donation({ user }) {
             <FormControlLabel
               control={
                 <Checkbox
-                  checked={isAnonymous}
+                  checked={isAnonymous ?? defaultAnonymous}
                   onChange={(e) => setIsAnonymous(e.target.checked)}
                 />
               }
@@ -265,7 +282,7 @@ function Donation({ user }) {
                   createOrder={createOrder}
                   onApprove={onApprove}
                   onError={(err) => setMessage({ text: err.message, severity: 'error' })}
-                  forceReRender={[paypalKey]}
+                  forceReRender={[paypalKey, isAnonymous]}
                 />
               )}
             </Box>
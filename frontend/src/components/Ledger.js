@@ -65,8 +65,10 @@ function Ledger() {
     return 'User';
   };
 
+  // The ledger also holds 'anonymized' entries, which record that a deleted
+  // account's donor details were erased; they move no money.
   const filteredTransactions = transactions.filter(tx => {
-    if (filter === 'all') return true;
+    if (filter === 'all') return tx.transaction_type === 'deposit' || tx.transaction_type === 'withdrawal';
     return tx.transaction_type === filter;
   });
 